@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,9 +9,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"os/user"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/e2llm/rpmrepo-update/pkg/backend"
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 	"github.com/e2llm/rpmrepo-update/pkg/repo"
 )
 
@@ -33,25 +39,61 @@ func run(ctx context.Context, args []string) error {
 	var outputFormat string
 	var showVersion bool
 	var signRepodata bool
-	var gpgKey string
+	var gpg gpgOptions
 	var signRPMs bool
 	var s3Endpoint string
+	var proxy string
 	var s3Region string
 	var s3DisableETag bool
-	root.StringVar(&backendType, "backend", "fs", "backend to use (fs, s3)")
+	var repodataDir string
+	var rateLimit int64
+	var followSymlinks bool
+	var fsFileMode string
+	var fsDirMode string
+	var fsChown string
+	var s3PartSize int64
+	var s3UploadConcurrency int
+	var listConcurrency int
+	var webdavUser string
+	var webdavPasswordFile string
+	var cacheControl string
+	var repomdCacheControl string
+	var timeout time.Duration
+	var configPath string
+	root.StringVar(&configPath, "config", "", "file of global flag values (key = value, TOML); command-line flags override it")
+	root.StringVar(&backendType, "backend", "fs", "backend to use (fs, s3, webdav)")
 	root.StringVar(&repoRoot, "repo-root", "", "repository root path or URI")
 	root.StringVar(&logLevel, "log-level", "info", "log level (info, debug)")
 	root.StringVar(&outputFormat, "output", "text", "output format for commands that support it (text, json)")
 	root.BoolVar(&showVersion, "version", false, "print version and exit")
 	root.BoolVar(&signRepodata, "sign-repodata", false, "sign repomd.xml with gpg (requires --gpg-key or default key)")
-	root.StringVar(&gpgKey, "gpg-key", "", "GPG key ID to use when signing (default: gpg defaults)")
+	root.BoolVar(&gpg.signMetadataFiles, "sign-metadata-files", false, "write a detached gpg signature (.asc) next to each core metadata file")
+	root.StringVar(&gpg.key, "gpg-key", "", "GPG key ID to use when signing (default: gpg defaults)")
+	root.StringVar(&gpg.homedir, "gpg-homedir", "", "GnuPG home directory for signing (sets GNUPGHOME)")
+	root.StringVar(&gpg.passphraseFile, "gpg-passphrase-file", "", "file containing the signing key passphrase (uses loopback pinentry)")
 	root.BoolVar(&signRPMs, "sign-rpms", false, "re-sign RPMs before adding (GPG)")
 	root.StringVar(&s3Endpoint, "s3-endpoint", "", "S3 endpoint URL for S3-compatible storage (e.g., MinIO)")
+	root.StringVar(&proxy, "proxy", "", "proxy URL for S3 and WebDAV requests (default: HTTPS_PROXY/HTTP_PROXY/NO_PROXY)")
 	root.StringVar(&s3Region, "s3-region", "", "S3 region (default: AWS_REGION env or us-east-1)")
 	root.BoolVar(&s3DisableETag, "s3-disable-etag", false, "disable ETag-based conflict detection (for R2, etc.)")
+	root.Int64Var(&s3PartSize, "s3-part-size", 0, "S3 multipart upload part size in bytes (default: SDK default, 5 MiB)")
+	root.IntVar(&s3UploadConcurrency, "s3-upload-concurrency", 0, "number of S3 multipart parts uploaded in parallel (default: SDK default)")
+	root.IntVar(&listConcurrency, "list-concurrency", 1, "S3: top-level directories to list in parallel when enumerating RPMs (1: serial)")
+	root.StringVar(&webdavUser, "webdav-user", "", "WebDAV basic auth user (default: user in --repo-root)")
+	root.StringVar(&webdavPasswordFile, "webdav-password-file", "", "file containing the WebDAV password")
+	root.StringVar(&cacheControl, "cache-control", backend.DefaultCacheControl, "S3 Cache-Control for RPMs and checksum-named metadata")
+	root.StringVar(&repomdCacheControl, "repomd-cache-control", backend.DefaultRepomdCacheControl, "S3 Cache-Control for repomd.xml, its signature, and plain-named metadata")
+	root.StringVar(&repodataDir, "repodata-dir", backend.DefaultRepodataDir, "metadata directory relative to the repo root")
+	root.Int64Var(&rateLimit, "rate-limit", 0, "limit RPM upload bandwidth in bytes/sec (0: unlimited)")
+	root.BoolVar(&followSymlinks, "follow-symlinks", false, "fs backend: follow symlinked directories when listing RPMs")
+	root.StringVar(&fsFileMode, "fs-file-mode", "0644", "fs backend: octal permissions for written files")
+	root.StringVar(&fsDirMode, "fs-dir-mode", "0755", "fs backend: octal permissions for created directories")
+	root.StringVar(&fsChown, "fs-chown", "", "fs backend: user:group (names or ids) to own written files and created directories")
+	root.DurationVar(&timeout, "timeout", 0, "abort the command if it runs longer than this (e.g. 10m; 0: no limit)")
 	root.Usage = func() {
 		fmt.Fprintf(root.Output(), "Usage: rpmrepo-update [global flags] <command> [args]\n")
-		fmt.Fprintf(root.Output(), "Commands: init, add, remove, check\n\n")
+		fmt.Fprintf(root.Output(), "Commands: init, add, remove, relocate, list, check, repair, rebuild, rollback, snapshot, prune, manifest, verify-manifest, diff, fingerprint, whatprovides, whatrequires\n")
+		fmt.Fprintf(root.Output(), "Any flag except --version, --force, --yes and --delete-files can also be set through RPMREPO_<FLAG> (e.g. RPMREPO_S3_ENDPOINT).\n\n")
 		root.PrintDefaults()
 	}
 
@@ -61,6 +103,14 @@ func run(ctx context.Context, args []string) error {
 		}
 		return err
 	}
+	if err := bindEnv(root); err != nil {
+		return err
+	}
+	if configPath != "" {
+		if err := applyConfigFile(root, configPath); err != nil {
+			return err
+		}
+	}
 	if showVersion {
 		fmt.Fprintf(os.Stdout, "%s\n", version)
 		return nil
@@ -72,45 +122,507 @@ func run(ctx context.Context, args []string) error {
 		return fmt.Errorf("missing command")
 	}
 
-	s3Opts := s3Options{endpoint: s3Endpoint, region: s3Region, disableETag: s3DisableETag}
+	fileMode, err := parseFileMode(fsFileMode)
+	if err != nil {
+		return fmt.Errorf("invalid --fs-file-mode: %w", err)
+	}
+	dirMode, err := parseFileMode(fsDirMode)
+	if err != nil {
+		return fmt.Errorf("invalid --fs-dir-mode: %w", err)
+	}
+	uid, gid, err := parseOwner(fsChown)
+	if err != nil {
+		return fmt.Errorf("invalid --fs-chown: %w", err)
+	}
+	webdavPassword, err := readSecretFile(webdavPasswordFile)
+	if err != nil {
+		return fmt.Errorf("invalid --webdav-password-file: %w", err)
+	}
+	opts := backendOptions{
+		repodataDir:    repodataDir,
+		rateLimit:      rateLimit,
+		followSymlinks: followSymlinks,
+		fileMode:       fileMode,
+		dirMode:        dirMode,
+		uid:            uid,
+		gid:            gid,
+		s3: backend.S3Options{
+			Endpoint:           s3Endpoint,
+			Region:             s3Region,
+			DisableETag:        s3DisableETag,
+			PartSize:           s3PartSize,
+			UploadConcurrency:  s3UploadConcurrency,
+			ListConcurrency:    listConcurrency,
+			CacheControl:       cacheControl,
+			RepomdCacheControl: repomdCacheControl,
+			Proxy:              proxy,
+		},
+		webdav: backend.WebDAVOptions{
+			Username: webdavUser,
+			Password: webdavPassword,
+			Proxy:    proxy,
+		},
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return contextError(ctx, runCommand(ctx, remaining, backendType, repoRoot, opts, logLevel, outputFormat, signRepodata, signRPMs, gpg), timeout)
+}
+
+func runCommand(ctx context.Context, remaining []string, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, signRepodata, signRPMs bool, gpg gpgOptions) error {
+	if err := preflightSigning(ctx, remaining[0], signRepodata, signRPMs, gpg); err != nil {
+		return err
+	}
 	switch remaining[0] {
 	case "init":
-		return runInit(ctx, backendType, repoRoot, s3Opts, logLevel, signRepodata, gpgKey, remaining[1:])
+		return runInit(ctx, backendType, repoRoot, opts, logLevel, signRepodata, gpg, remaining[1:])
 	case "add":
-		return runAdd(ctx, backendType, repoRoot, s3Opts, logLevel, signRPMs, gpgKey, remaining[1:])
+		return runAdd(ctx, backendType, repoRoot, opts, logLevel, outputFormat, signRPMs, gpg, remaining[1:])
 	case "remove":
-		return runRemove(ctx, backendType, repoRoot, s3Opts, logLevel, remaining[1:])
+		return runRemove(ctx, backendType, repoRoot, opts, logLevel, outputFormat, gpg, remaining[1:])
+	case "relocate":
+		return runRelocate(ctx, backendType, repoRoot, opts, logLevel, outputFormat, gpg, remaining[1:])
+	case "list":
+		return runList(ctx, backendType, repoRoot, opts, logLevel, outputFormat, remaining[1:])
 	case "check":
-		return runCheck(ctx, backendType, repoRoot, s3Opts, logLevel, outputFormat, remaining[1:])
+		return runCheck(ctx, backendType, repoRoot, opts, logLevel, outputFormat, gpg, remaining[1:])
+	case "repair":
+		return runRepair(ctx, backendType, repoRoot, opts, logLevel, gpg, remaining[1:])
+	case "rebuild":
+		return runRebuild(ctx, backendType, repoRoot, opts, logLevel, signRepodata, gpg, remaining[1:])
+	case "manifest":
+		return runManifest(ctx, backendType, repoRoot, opts, logLevel, remaining[1:])
+	case "rollback":
+		return runRollback(ctx, backendType, repoRoot, opts, logLevel, outputFormat, signRepodata, gpg, remaining[1:])
+	case "snapshot":
+		return runSnapshot(ctx, backendType, repoRoot, opts, logLevel, outputFormat, signRepodata, gpg, remaining[1:])
+	case "prune":
+		return runPrune(ctx, backendType, repoRoot, opts, logLevel, outputFormat, remaining[1:])
+	case "fingerprint":
+		return runFingerprint(ctx, backendType, repoRoot, opts, logLevel, outputFormat, remaining[1:])
+	case "whatprovides", "whatrequires":
+		return runWhat(ctx, remaining[0], backendType, repoRoot, opts, logLevel, outputFormat, remaining[1:])
+	case "diff":
+		return runDiff(ctx, backendType, repoRoot, opts, logLevel, outputFormat, remaining[1:])
+	case "verify-manifest":
+		return runVerifyManifest(ctx, backendType, repoRoot, opts, logLevel, outputFormat, remaining[1:])
 	default:
 		return fmt.Errorf("unknown command %q", remaining[0])
 	}
 }
 
-type s3Options struct {
-	endpoint    string
-	region      string
-	disableETag bool
+// preflightSigning checks, before command touches the repository, that the signing it was asked
+// for can run, so a missing gpg or rpmsign does not surface halfway through a write.
+func preflightSigning(ctx context.Context, command string, signRepodata, signRPMs bool, gpg gpgOptions) error {
+	var flags []string
+	switch command {
+	case "init", "rollback", "rebuild", "snapshot":
+		if signRepodata {
+			flags = append(flags, "--sign-repodata")
+		}
+	case "add":
+		if signRPMs {
+			flags = append(flags, "--sign-rpms")
+		}
+	}
+	switch command {
+	case "init", "add", "remove", "relocate", "check", "repair", "rebuild", "rollback", "snapshot":
+		if gpg.signMetadataFiles {
+			flags = append(flags, "--sign-metadata-files")
+		}
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	drop := strings.Join(flags, " and ")
+	err := repo.CheckSigningTools(ctx, gpg.key, gpg.homedir, command == "add" && signRPMs)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repo.ErrGPGNotFound):
+		return fmt.Errorf("%w; install gnupg or drop %s", err, drop)
+	case errors.Is(err, repo.ErrRPMSignNotFound):
+		return fmt.Errorf("%w; install rpm-sign or drop --sign-rpms", err)
+	default:
+		return fmt.Errorf("%w; check --gpg-key and --gpg-homedir, or drop %s", err, drop)
+	}
+}
+
+// contextError labels an error caused by the command's context, so a --timeout expiry reads
+// differently from a cancellation. Other errors are returned unchanged.
+func contextError(ctx context.Context, err error, timeout time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("timed out after %s: %w", timeout, err)
+	case errors.Is(err, context.Canceled) || errors.Is(ctx.Err(), context.Canceled):
+		return fmt.Errorf("canceled: %w", err)
+	default:
+		return err
+	}
+}
+
+// gpgOptions configures gpg and rpmsign invocations.
+type gpgOptions struct {
+	key               string
+	homedir           string
+	passphraseFile    string
+	signMetadataFiles bool
+}
+
+// apply copies the signing settings onto r.
+func (g gpgOptions) apply(r *repo.Repo) {
+	r.GPGKey = g.key
+	r.GPGHomedir = g.homedir
+	r.GPGPassphraseFile = g.passphraseFile
+	r.SignMetadataFiles = g.signMetadataFiles
+}
+
+// metadataWriteFlags holds the flags shared by every command that writes core metadata.
+type metadataWriteFlags struct {
+	compression        string
+	filenameStyle      string
+	noOther            bool
+	changelogLimit     int
+	filelistsExt       bool
+	archRepodata       bool
+	primaryFilePattern string
+	repodataRevision   string
+	noBackup           bool
+	keepOldMetadata    int
+}
+
+// register defines the metadata layout and revision flags on fs.
+func (f *metadataWriteFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.compression, "compression", metadata.CompressionGzip, "core metadata compression (gzip or bzip2)")
+	fs.StringVar(&f.filenameStyle, "metadata-filename-style", metadata.FilenameStyleHashed, "core metadata filenames: hashed (<checksum>-primary.xml.gz) or plain (primary.xml.gz)")
+	fs.BoolVar(&f.noOther, "no-other", false, "omit the other (changelog) metadata file")
+	fs.IntVar(&f.changelogLimit, "changelog-limit", 0, "keep only the newest N changelog entries per package in other.xml (0 keeps all)")
+	fs.BoolVar(&f.filelistsExt, "filelists-ext", false, "also write filelists-ext (filelists with per-file digests)")
+	fs.BoolVar(&f.archRepodata, "arch-specific-repodata", false, "also publish a per-arch repository under <arch>/repodata/ for each arch")
+	fs.StringVar(&f.primaryFilePattern, "primary-file-pattern", "", "regexp selecting files listed in primary.xml (default: "+metadata.DefaultPrimaryFilePattern+")")
+	fs.StringVar(&f.repodataRevision, "repodata-revision", "", "repomd.xml revision to write, e.g. a timestamp or git SHA (default: current unix time, kept increasing)")
+}
+
+// registerRetention defines the flags controlling what is kept of the metadata being replaced,
+// for commands that rewrite an existing repository.
+func (f *metadataWriteFlags) registerRetention(fs *flag.FlagSet) {
+	fs.BoolVar(&f.noBackup, "no-backup", false, "do not keep the replaced repomd.xml as repomd.xml.bak")
+	fs.IntVar(&f.keepOldMetadata, "keep-old-metadata", 0, "keep the core files of this many superseded metadata generations instead of deleting them")
+}
+
+// apply validates the flags and copies them onto r.
+func (f *metadataWriteFlags) apply(r *repo.Repo) error {
+	if f.changelogLimit < 0 {
+		return fmt.Errorf("--changelog-limit must not be negative")
+	}
+	if f.keepOldMetadata < 0 {
+		return fmt.Errorf("--keep-old-metadata must not be negative")
+	}
+	if err := metadata.ValidatePrimaryFilePattern(f.primaryFilePattern); err != nil {
+		return err
+	}
+	if err := repo.ValidateRevision(f.repodataRevision); err != nil {
+		return err
+	}
+	r.Compression = f.compression
+	r.MetadataFilenameStyle = f.filenameStyle
+	r.NoOther = f.noOther
+	r.ChangelogLimit = f.changelogLimit
+	r.FilelistsExt = f.filelistsExt
+	r.ArchRepodata = f.archRepodata
+	r.PrimaryFilePattern = f.primaryFilePattern
+	r.RepodataRevision = f.repodataRevision
+	r.NoBackup = f.noBackup
+	r.KeepOldMetadata = f.keepOldMetadata
+	return nil
+}
+
+// stringList is a flag that may be given more than once, collecting every value.
+type stringList []string
+
+func (l *stringList) String() string { return strings.Join(*l, ",") }
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+type backendOptions struct {
+	repodataDir    string
+	rateLimit      int64
+	followSymlinks bool
+	fileMode       os.FileMode
+	dirMode        os.FileMode
+	uid, gid       int
+	s3             backend.S3Options
+	webdav         backend.WebDAVOptions
+}
+
+// parseFileMode parses an octal permission string such as "0664".
+func parseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("%q is not an octal mode like 0644", s)
+	}
+	return os.FileMode(n), nil
+}
+
+// envPrefix namespaces the environment variables that supply flag defaults.
+const envPrefix = "RPMREPO_"
+
+// envName returns the environment variable for a flag: --s3-endpoint reads RPMREPO_S3_ENDPOINT.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// commandLineOnly lists flags that neither the environment nor a config file may set: they act on a single
+// invocation (printing the version, forcing a rewrite, confirming deletion) rather than configuring the tool.
+var commandLineOnly = map[string]bool{
+	"version":      true,
+	"force":        true,
+	"yes":          true,
+	"delete-files": true,
+}
+
+// bindEnv sets each flag not given on the command line from its environment variable, if present.
+// It runs after parsing and before the config file is applied, giving flag > env > config > default.
+// Flags in commandLineOnly are never read from the environment.
+func bindEnv(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] || commandLineOnly[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// applyConfigFile sets global flags from a config file, skipping flags already given on the command line
+// or through the environment.
+// Keys are global flag names without dashes, e.g. s3-region = "eu-west-1".
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	values, err := readConfigFile(path)
+	if err != nil {
+		return err
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, kv := range values {
+		if kv.key == "config" || fs.Lookup(kv.key) == nil {
+			return fmt.Errorf("%s:%d: unknown key %q", path, kv.line, kv.key)
+		}
+		if commandLineOnly[kv.key] {
+			return fmt.Errorf("%s:%d: %s can only be given on the command line", path, kv.line, kv.key)
+		}
+		if explicit[kv.key] {
+			continue
+		}
+		if err := fs.Set(kv.key, kv.value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", path, kv.line, kv.key, err)
+		}
+	}
+	return nil
+}
+
+type configValue struct {
+	key, value string
+	line       int
+}
+
+// readConfigFile parses the flat TOML subset used for config files: `key = value` lines with
+// quoted strings, integers or booleans, and # comments. Tables and arrays are rejected.
+func readConfigFile(path string) ([]configValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open config: %w", err)
+	}
+	defer f.Close()
+
+	var values []configValue
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		value, err := parseConfigValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s: %w", path, n, key, err)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("%s:%d: duplicate key %q", path, n, key)
+		}
+		seen[key] = true
+		values = append(values, configValue{key: key, value: value, line: n})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	return values, nil
+}
+
+// parseConfigValue decodes a TOML scalar, dropping a trailing comment.
+func parseConfigValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		end := closingQuote(raw)
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(raw[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return strconv.Unquote(raw[:end+1])
+	case strings.HasPrefix(raw, "'"):
+		end := strings.IndexByte(raw[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if rest := strings.TrimSpace(raw[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after string", rest)
+		}
+		return raw[1 : end+1], nil
+	}
+	value, _, _ := strings.Cut(raw, "#")
+	value = strings.TrimSpace(value)
+	if value == "true" || value == "false" {
+		return value, nil
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 10, 64); err == nil {
+		return strings.ReplaceAll(value, "_", ""), nil
+	}
+	return "", fmt.Errorf("unsupported value %q (quote strings)", value)
+}
+
+// closingQuote returns the index of the quote ending the basic string at the start of s, or -1.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTimeBound parses an RFC3339 timestamp, a YYYY-MM-DD date (midnight UTC), or unix seconds.
+func parseTimeBound(s string) (time.Time, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0).UTC(), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not RFC3339, YYYY-MM-DD, or unix seconds", s)
+}
+
+// parseCutoff turns an --older-than value into an absolute time: a duration (with an optional
+// "d" suffix for days) is subtracted from now, anything else is parsed by parseTimeBound.
+func parseCutoff(s string, now time.Time) (time.Time, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("negative duration %s", s)
+		}
+		return now.Add(-d), nil
+	}
+	return parseTimeBound(s)
+}
+
+// parseOwner resolves "user:group", "user" or ":group" to numeric ids; -1 means unchanged.
+func parseOwner(s string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if s == "" {
+		return uid, gid, nil
+	}
+	userName, groupName, _ := strings.Cut(s, ":")
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, lookupErr := user.Lookup(userName)
+			if lookupErr != nil {
+				return -1, -1, lookupErr
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, fmt.Errorf("user %s has non-numeric uid %q", userName, u.Uid)
+			}
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, lookupErr := user.LookupGroup(groupName)
+			if lookupErr != nil {
+				return -1, -1, lookupErr
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, fmt.Errorf("group %s has non-numeric gid %q", groupName, g.Gid)
+			}
+		}
+	}
+	return uid, gid, nil
 }
 
-func runInit(ctx context.Context, backendType, repoRoot string, s3Opts s3Options, logLevel string, signRepodata bool, gpgKey string, args []string) error {
+func runInit(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel string, signRepodata bool, gpg gpgOptions, args []string) error {
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
 
+	var writeFlags metadataWriteFlags
 	var checksum string
 	var force bool
+	var dryRun bool
+	var fromExisting bool
+	var checksumRPM string
+	var locationPrefix string
+	var managedPaths string
 	fs.StringVar(&checksum, "checksum", "sha256", "checksum algorithm (sha256 or sha512)")
+	fs.BoolVar(&fromExisting, "from-existing", false, "index the RPMs already in the repo root instead of writing empty metadata")
+	fs.StringVar(&checksumRPM, "checksum-rpm", "", "with --from-existing: checksum algorithm for package pkgids (default: metadata checksum)")
+	fs.StringVar(&locationPrefix, "location-prefix", "", "with --from-existing: prefix prepended to package location hrefs in metadata")
+	fs.StringVar(&managedPaths, "managed-paths", "", "with --from-existing: comma-separated directories to index instead of the whole root")
+	writeFlags.register(fs)
 	fs.BoolVar(&force, "force", false, "overwrite existing repomd.xml")
+	fs.BoolVar(&dryRun, "dry-run", false, "show planned files without writing")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
 		return err
 	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
 	if repoRoot == "" {
 		return fmt.Errorf("--repo-root is required")
 	}
-	b, err := buildBackend(ctx, backendType, repoRoot, s3Opts)
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
 	if err != nil {
 		return err
 	}
@@ -118,40 +630,207 @@ func runInit(ctx context.Context, backendType, repoRoot string, s3Opts s3Options
 	if err != nil {
 		return err
 	}
-	if err := r.InitRepo(ctx, checksum, force, signRepodata, gpgKey); err != nil {
+	if err := writeFlags.apply(r); err != nil {
+		return err
+	}
+	if fromExisting {
+		if err := repo.ValidateLocationPrefix(locationPrefix); err != nil {
+			return err
+		}
+		if checksumRPM != "" && !metadata.SupportedChecksum(checksumRPM) {
+			return fmt.Errorf("unsupported --checksum-rpm %q", checksumRPM)
+		}
+		r.LocationPrefix = locationPrefix
+		r.RPMChecksum = checksumRPM
+		if r.ManagedPaths, err = repo.ParseManagedPaths(managedPaths); err != nil {
+			return err
+		}
+		gpg.apply(r)
+		count, err := r.InitFromExisting(ctx, checksum, force, signRepodata, gpg.key, dryRun)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			fmt.Fprintf(os.Stdout, "would initialize repo at %s with %d packages (checksum: %s)\n", repoRoot, count, checksum)
+			return nil
+		}
+		fmt.Fprintf(os.Stdout, "initialized repo at %s with %d packages (checksum: %s)\n", repoRoot, count, checksum)
+		return nil
+	}
+	if checksumRPM != "" || locationPrefix != "" || managedPaths != "" {
+		return fmt.Errorf("--checksum-rpm, --location-prefix and --managed-paths require --from-existing")
+	}
+	if dryRun {
+		plan, err := r.PlanInit(ctx, checksum, force)
+		if err != nil {
+			return err
+		}
+		for _, f := range plan.CoreFiles {
+			fmt.Fprintf(os.Stdout, "would write %s (%s %s)\n", f.Path, plan.ChecksumAlg, f.Checksum)
+		}
+		fmt.Fprintf(os.Stdout, "would write %s\n", plan.RepomdPath)
+		if plan.Overwrite {
+			fmt.Fprintf(os.Stdout, "would overwrite existing %s\n", plan.RepomdPath)
+		}
+		return nil
+	}
+	gpg.apply(r)
+	if err := r.InitRepo(ctx, checksum, force, signRepodata, gpg.key); err != nil {
 		return err
 	}
 	fmt.Fprintf(os.Stdout, "initialized repo at %s (checksum: %s)\n", repoRoot, checksum)
 	return nil
 }
 
-func runAdd(ctx context.Context, backendType, repoRoot string, s3Opts s3Options, logLevel string, signRPMs bool, gpgKey string, args []string) error {
+func runAdd(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, signRPMs bool, gpg gpgOptions, args []string) error {
 	fs := flag.NewFlagSet("add", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
+	var locationPrefix string
+	var writeFlags metadataWriteFlags
+	var forceRewrite bool
 	var replaceExisting bool
 	var dryRun bool
 	var duplicatePolicy string
 	var allowUnknown bool
+	var initIfMissing bool
 	var destPrefix string
+	var preserveDir bool
+	var baseDir string
+	var verifyFilename string
+	var allowArch string
+	var checksum string
+	var checksumRPM string
+	var metadataOnly bool
+	var onlyIfNewer bool
+	var resume bool
+	var noarchRoot string
+	var fromFile string
+	var destName string
+	var dedupeStore string
+	var includePatterns, excludePatterns stringList
+	fs.Var(&includePatterns, "include-pattern", "only add RPMs whose filename matches this glob (repeatable)")
+	fs.Var(&excludePatterns, "exclude-pattern", "skip RPMs whose filename matches this glob (repeatable; wins over --include-pattern)")
+	fs.StringVar(&fromFile, "from-file", "", "read additional RPM paths from a file, one per line (- for stdin)")
+	fs.StringVar(&dedupeStore, "dedupe-store", "", "directory of content-addressed RPMs (<dir>/<pkgid>/<file>) shared with other repos under the root")
+	fs.StringVar(&destName, "dest-name", "", "filename for the RPM read from stdin with - (default: name-version-release.arch.rpm)")
 	fs.BoolVar(&replaceExisting, "replace-existing", false, "replace packages with the same NEVRA")
 	fs.BoolVar(&dryRun, "dry-run", false, "show planned changes without writing")
 	fs.StringVar(&duplicatePolicy, "on-duplicate", "error", "behavior when NEVRA exists (error|replace)")
 	fs.BoolVar(&allowUnknown, "allow-unknown", true, "preserve unknown metadata types instead of error")
+	fs.BoolVar(&initIfMissing, "init-if-missing", false, "initialize the repository (sha256) if repomd.xml does not exist")
+	writeFlags.register(fs)
+	writeFlags.registerRetention(fs)
+	fs.BoolVar(&forceRewrite, "force", false, "rewrite metadata even when it would be identical (default: skip, keeping the revision)")
 	fs.StringVar(&destPrefix, "dest-prefix", "", "destination prefix for RPMs inside repo (default: basename in root)")
+	fs.BoolVar(&preserveDir, "preserve-dir", false, "store each RPM at its path relative to --base-dir instead of its basename")
+	fs.StringVar(&baseDir, "base-dir", "", "with --preserve-dir, directory the RPM paths are taken relative to (default: current directory)")
+	fs.StringVar(&verifyFilename, "verify-filename", "off", "check RPM filenames match their NEVRA (off|warn|error)")
+	fs.StringVar(&allowArch, "allow-arch", "", "comma-separated custom architectures to accept without an unrecognized-arch warning")
+	fs.StringVar(&checksum, "checksum", repo.ChecksumAuto, "metadata checksum algorithm: auto (keep existing, error if undetectable), or sha256 or sha512 to migrate")
+	fs.StringVar(&checksumRPM, "checksum-rpm", "", "checksum algorithm for package pkgids (sha256 or sha512; default: metadata checksum)")
+	fs.BoolVar(&onlyIfNewer, "only-if-newer", false, "skip packages whose EVR is not greater than the newest existing package of the same name and arch")
+	fs.BoolVar(&resume, "resume", false, "re-run an interrupted add: skip RPMs already uploaded and packages already indexed")
+	fs.StringVar(&noarchRoot, "duplicate-noarch-to", "", "also publish noarch RPMs into this second repo root (same backend type)")
+	fs.BoolVar(&metadataOnly, "metadata-only", false, "arguments are JSON package metadata files; merge them without reading or uploading RPMs")
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
 		return err
 	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
 	if repoRoot == "" {
 		return fmt.Errorf("--repo-root is required")
 	}
+	if err := repo.ValidateDestPrefix(destPrefix); err != nil {
+		return err
+	}
+	if err := repo.ValidateLocationPrefix(locationPrefix); err != nil {
+		return err
+	}
+	if err := validateChecksumFlag(checksum); err != nil {
+		return err
+	}
+	if checksumRPM != "" && !metadata.SupportedChecksum(checksumRPM) {
+		return fmt.Errorf("unsupported --checksum-rpm %q", checksumRPM)
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
 	rpmPaths := fs.Args()
+	if fromFile != "" {
+		listed, err := readListFile(fromFile)
+		if err != nil {
+			return err
+		}
+		rpmPaths = append(rpmPaths, listed...)
+	}
 	if len(rpmPaths) == 0 {
 		return fmt.Errorf("add requires at least one RPM path")
 	}
-	b, err := buildBackend(ctx, backendType, repoRoot, s3Opts)
+	if len(includePatterns) > 0 || len(excludePatterns) > 0 {
+		var err error
+		if rpmPaths, err = repo.FilterRPMPaths(rpmPaths, includePatterns, excludePatterns); err != nil {
+			return err
+		}
+		if len(rpmPaths) == 0 {
+			return fmt.Errorf("no RPM paths left after --include-pattern/--exclude-pattern")
+		}
+	}
+	if slices.Contains(rpmPaths, repo.StdinPath) {
+		if fromFile == "-" {
+			return fmt.Errorf("cannot read both the RPM and --from-file from stdin")
+		}
+		if metadataOnly {
+			return fmt.Errorf("--metadata-only cannot read from stdin")
+		}
+	} else if destName != "" {
+		return fmt.Errorf("--dest-name requires - (an RPM read from stdin)")
+	}
+	if err := repo.ValidateStdinName(destName); err != nil {
+		return err
+	}
+	if dedupeStore != "" && destPrefix != "" {
+		return fmt.Errorf("--dedupe-store and --dest-prefix are mutually exclusive")
+	}
+	if baseDir != "" && !preserveDir {
+		return fmt.Errorf("--base-dir requires --preserve-dir")
+	}
+	if preserveDir && (dedupeStore != "" || metadataOnly) {
+		return fmt.Errorf("--preserve-dir cannot be used with --dedupe-store or --metadata-only")
+	}
+	if preserveDir && baseDir == "" {
+		baseDir = "."
+	}
+	if dedupeStore != "" && (signRPMs || metadataOnly) {
+		return fmt.Errorf("--dedupe-store cannot be used with --sign-rpms or --metadata-only")
+	}
+	if metadataOnly && signRPMs {
+		return fmt.Errorf("--sign-rpms cannot be used with --metadata-only")
+	}
+	if metadataOnly && resume {
+		return fmt.Errorf("--resume cannot be used with --metadata-only")
+	}
+	if metadataOnly && noarchRoot != "" {
+		return fmt.Errorf("--duplicate-noarch-to cannot be used with --metadata-only")
+	}
+	if noarchRoot != "" && noarchRoot == repoRoot {
+		return fmt.Errorf("--duplicate-noarch-to must differ from --repo-root")
+	}
+	var pkgs []metadata.Package
+	if metadataOnly {
+		for _, p := range rpmPaths {
+			pkg, err := readPackageJSON(p)
+			if err != nil {
+				return err
+			}
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
 	if err != nil {
 		return err
 	}
@@ -164,48 +843,200 @@ func runAdd(ctx context.Context, backendType, repoRoot string, s3Opts s3Options,
 	} else if duplicatePolicy != "error" {
 		return fmt.Errorf("invalid --on-duplicate %q", duplicatePolicy)
 	}
-	r.AllowUnknown = allowUnknown
-	r.DestPrefix = destPrefix
-	if err := r.AddRPMs(ctx, rpmPaths, replaceExisting, dryRun, signRPMs, gpgKey); err != nil {
+	switch verifyFilename {
+	case "off", "warn", "error":
+	default:
+		return fmt.Errorf("invalid --verify-filename %q", verifyFilename)
+	}
+	if err := repo.ValidateDedupeStore(dedupeStore, b.RepodataDir()); err != nil {
 		return err
 	}
-	if dryRun {
-		for _, p := range rpmPaths {
-			fmt.Fprintf(os.Stdout, "would add %s\n", p)
+	configure := func(r *repo.Repo) error {
+		r.LocationPrefix = locationPrefix
+		r.Checksum = checksum
+		r.RPMChecksum = checksumRPM
+		r.AllowUnknown = allowUnknown
+		r.InitIfMissing = initIfMissing
+		if err := writeFlags.apply(r); err != nil {
+			return err
 		}
-	} else {
-		for _, p := range rpmPaths {
-			fmt.Fprintf(os.Stdout, "added %s\n", p)
+		r.ForceRewrite = forceRewrite
+		r.DestPrefix = destPrefix
+		r.PreserveDirBase = baseDir
+		r.VerifyFilename = verifyFilename
+		r.AllowedArches = repo.ParseArches(allowArch)
+		r.OnlyIfNewer = onlyIfNewer
+		r.Resume = resume
+		r.StdinName = destName
+		r.DedupeStore = dedupeStore
+		gpg.apply(r)
+		return nil
+	}
+	if err := configure(r); err != nil {
+		return err
+	}
+	if noarchRoot != "" {
+		mb, err := buildBackend(ctx, backendType, noarchRoot, opts)
+		if err != nil {
+			return fmt.Errorf("--duplicate-noarch-to: %w", err)
 		}
+		mirror, err := newRepoWithLogger(mb, logLevel)
+		if err != nil {
+			return err
+		}
+		if err := configure(mirror); err != nil {
+			return err
+		}
+		r.NoarchMirror = mirror
+	}
+	var result repo.ChangeResult
+	if metadataOnly {
+		result, err = r.AddPackages(ctx, pkgs, rpmPaths, replaceExisting, dryRun)
+	} else {
+		result, err = r.AddRPMs(ctx, rpmPaths, replaceExisting, dryRun, signRPMs, gpg.key)
+	}
+	// A noarch mirror failure comes after the primary repo was written, so report what was added there.
+	if err != nil && (result.NoarchMirror == nil || outputFormat == "json") {
+		return err
+	}
+	if outputFormat == "json" {
+		return writeJSON(result)
+	}
+	skipReason := "not newer"
+	if resume {
+		skipReason = "already present or not newer"
+	}
+	printAdded(result, dryRun, "", skipReason)
+	if err != nil {
+		return err
+	}
+	if result.NoarchMirror != nil {
+		printAdded(*result.NoarchMirror, dryRun, " to "+noarchRoot, skipReason)
 	}
 	return nil
 }
 
-func runRemove(ctx context.Context, backendType, repoRoot string, s3Opts s3Options, logLevel string, args []string) error {
+// validateChecksumFlag accepts the values of the add and remove --checksum flag.
+func validateChecksumFlag(checksum string) error {
+	if checksum != repo.ChecksumAuto && !metadata.SupportedChecksum(checksum) {
+		return fmt.Errorf("unsupported --checksum %q (auto, sha256 or sha512)", checksum)
+	}
+	return nil
+}
+
+// printAdded prints one line per package in an add result; suffix names a non-default target repo
+// and skipReason explains skipped packages.
+func printAdded(result repo.ChangeResult, dryRun bool, suffix, skipReason string) {
+	for _, p := range result.Packages {
+		source := p.Source
+		if source == repo.StdinPath {
+			source = "stdin as " + p.Location
+		}
+		switch {
+		case p.Status == repo.ChangeSkipped:
+			fmt.Fprintf(os.Stdout, "skipped %s%s (%s)\n", source, suffix, skipReason)
+		case dryRun:
+			fmt.Fprintf(os.Stdout, "would add %s%s\n", source, suffix)
+		default:
+			fmt.Fprintf(os.Stdout, "added %s%s\n", source, suffix)
+		}
+	}
+}
+
+func runRemove(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, gpg gpgOptions, args []string) error {
 	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
+	var locationPrefix string
+	var writeFlags metadataWriteFlags
+	var forceRewrite bool
 	var deleteFiles bool
 	var byNEVRA bool
+	var all bool
+	var olderThan string
+	var ageBy string
+	var allowRemoveLatest bool
+	var fromFile string
+	var yes bool
+	var trashPrefix string
+	var dedupeStore string
+	var checksum string
 	var dryRun bool
 	var allowUnknown bool
+	fs.StringVar(&checksum, "checksum", repo.ChecksumAuto, "metadata checksum algorithm: auto (keep existing, error if undetectable), or sha256 or sha512 to migrate")
 	fs.BoolVar(&deleteFiles, "delete-files", false, "delete matching RPM files")
+	fs.BoolVar(&yes, "yes", false, "with --delete-files, delete without asking for confirmation")
+	fs.StringVar(&trashPrefix, "trash-prefix", "", "with --delete-files, move RPMs under this directory (e.g. "+repo.DefaultTrashPrefix+") instead of deleting them")
+	fs.StringVar(&dedupeStore, "dedupe-store", "", "with --delete-files, keep RPMs in this content-addressed store, which other repos may share")
 	fs.BoolVar(&byNEVRA, "by-nevra", false, "treat identifiers as NEVRA instead of filenames")
+	fs.StringVar(&fromFile, "from-file", "", "read additional identifiers from a file, one per line (- for stdin)")
+	fs.BoolVar(&all, "all", false, "remove every package, keeping the repo initialized")
+	fs.StringVar(&olderThan, "older-than", "", "remove packages older than a duration (e.g. 2160h, 90d) or before a date (RFC3339, YYYY-MM-DD)")
+	fs.StringVar(&ageBy, "age-by", "build", "time --older-than compares: build (package build time) or file (RPM file time when added)")
+	fs.BoolVar(&allowRemoveLatest, "allow-remove-latest", false, "with --older-than, also remove the newest version of a name and arch")
 	fs.BoolVar(&dryRun, "dry-run", false, "show planned changes without writing")
 	fs.BoolVar(&allowUnknown, "allow-unknown", true, "preserve unknown metadata types instead of error")
+	writeFlags.register(fs)
+	writeFlags.registerRetention(fs)
+	fs.BoolVar(&forceRewrite, "force", false, "rewrite metadata even when it would be identical (default: skip, keeping the revision)")
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
 		return err
 	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
 	if repoRoot == "" {
 		return fmt.Errorf("--repo-root is required")
 	}
+	if err := repo.ValidateLocationPrefix(locationPrefix); err != nil {
+		return err
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
 	ids := fs.Args()
-	if len(ids) == 0 {
-		return fmt.Errorf("remove requires at least one identifier")
+	if fromFile != "" {
+		listed, err := readListFile(fromFile)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, listed...)
+	}
+	byAge := olderThan != ""
+	if all && len(ids) > 0 {
+		return fmt.Errorf("--all cannot be combined with identifiers")
+	}
+	if byAge && (all || len(ids) > 0) {
+		return fmt.Errorf("--older-than cannot be combined with --all or identifiers")
+	}
+	if !all && !byAge && len(ids) == 0 {
+		return fmt.Errorf("remove requires at least one identifier (or --all, --older-than)")
+	}
+	if trashPrefix != "" && !deleteFiles {
+		return fmt.Errorf("--trash-prefix requires --delete-files")
+	}
+	if err := validateChecksumFlag(checksum); err != nil {
+		return err
+	}
+	var filter repo.PackageFilter
+	if byAge {
+		cutoff, err := parseCutoff(olderThan, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+		switch ageBy {
+		case "build":
+			filter.BuiltBefore = cutoff
+		case "file":
+			filter.AddedBefore = cutoff
+		default:
+			return fmt.Errorf("invalid --age-by %q (build or file)", ageBy)
+		}
 	}
-	b, err := buildBackend(ctx, backendType, repoRoot, s3Opts)
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
 	if err != nil {
 		return err
 	}
@@ -213,10 +1044,78 @@ func runRemove(ctx context.Context, backendType, repoRoot string, s3Opts s3Optio
 	if err != nil {
 		return err
 	}
+	r.LocationPrefix = locationPrefix
+	r.Checksum = checksum
 	r.AllowUnknown = allowUnknown
-	if err := r.RemoveRPMs(ctx, ids, byNEVRA, deleteFiles, dryRun); err != nil {
+	if err := writeFlags.apply(r); err != nil {
+		return err
+	}
+	r.ForceRewrite = forceRewrite
+	if err := repo.ValidateTrashPrefix(trashPrefix, b.RepodataDir()); err != nil {
+		return err
+	}
+	r.TrashPrefix = trashPrefix
+	if err := repo.ValidateDedupeStore(dedupeStore, b.RepodataDir()); err != nil {
+		return err
+	}
+	r.DedupeStore = dedupeStore
+	gpg.apply(r)
+	remove := func(dryRun bool) (repo.ChangeResult, error) {
+		switch {
+		case all:
+			return r.RemoveAll(ctx, deleteFiles, dryRun)
+		case byAge:
+			return r.RemoveMatching(ctx, filter, allowRemoveLatest, deleteFiles, dryRun)
+		default:
+			return r.RemoveRPMs(ctx, ids, byNEVRA, deleteFiles, dryRun)
+		}
+	}
+	if deleteFiles && trashPrefix == "" && !dryRun && !yes {
+		if fromFile == "-" {
+			return fmt.Errorf("--delete-files with --from-file - requires --yes (stdin cannot also answer the confirmation)")
+		}
+		planned, err := remove(true)
+		if err != nil {
+			return err
+		}
+		var paths []string
+		for _, p := range planned.Packages {
+			if p.Status == repo.ChangeRemoved {
+				paths = append(paths, p.Location)
+			}
+		}
+		if err := confirmDeletion(paths); err != nil {
+			return err
+		}
+	}
+	result, err := remove(dryRun)
+	if err != nil {
 		return err
 	}
+	if outputFormat == "json" {
+		return writeJSON(result)
+	}
+	if all {
+		if dryRun {
+			fmt.Fprintf(os.Stdout, "would remove %d packages\n", len(result.Packages))
+		} else {
+			fmt.Fprintf(os.Stdout, "removed %d packages\n", len(result.Packages))
+		}
+		return nil
+	}
+	if byAge {
+		for _, p := range result.Packages {
+			switch {
+			case p.Status == repo.ChangeSkipped:
+				fmt.Fprintf(os.Stdout, "kept %s (newest version)\n", p.NEVRA)
+			case dryRun:
+				fmt.Fprintf(os.Stdout, "would remove %s\n", p.NEVRA)
+			default:
+				fmt.Fprintf(os.Stdout, "removed %s\n", p.NEVRA)
+			}
+		}
+		return nil
+	}
 	if dryRun {
 		for _, id := range ids {
 			fmt.Fprintf(os.Stdout, "would remove %s\n", id)
@@ -229,19 +1128,415 @@ func runRemove(ctx context.Context, backendType, repoRoot string, s3Opts s3Optio
 	return nil
 }
 
-func runCheck(ctx context.Context, backendType, repoRoot string, s3Opts s3Options, logLevel, outputFormat string, args []string) error {
-	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+// confirmDeletion lists files about to be deleted for good and asks on the terminal before going
+// ahead. Without a terminal it refuses, so unattended runs must pass --yes.
+func confirmDeletion(paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("refusing to permanently delete %d RPMs without confirmation; pass --yes", len(paths))
+	}
+	for _, p := range paths {
+		fmt.Fprintf(os.Stderr, "will delete %s\n", p)
+	}
+	fmt.Fprintf(os.Stderr, "Permanently delete %d RPM files? [y/N] ", len(paths))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("aborted; nothing was deleted")
+}
+
+func runRollback(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, signRepodata bool, gpg gpgOptions, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var noBackup bool
+	var keepOldMetadata int
+	var dryRun bool
+	fs.BoolVar(&noBackup, "no-backup", false, "do not keep the replaced repomd.xml as the new repomd.xml.bak")
+	fs.IntVar(&keepOldMetadata, "keep-old-metadata", 0, "keep the core files of this many superseded metadata generations instead of deleting them")
+	fs.BoolVar(&dryRun, "dry-run", false, "check the backup and show the revisions without writing")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.NoBackup = noBackup
+	if keepOldMetadata < 0 {
+		return fmt.Errorf("--keep-old-metadata must not be negative")
+	}
+	r.KeepOldMetadata = keepOldMetadata
+	gpg.apply(r)
+	result, err := r.Rollback(ctx, signRepodata, gpg.key, dryRun)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "json" {
+		return writeJSON(result)
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stdout, "would roll back from revision %s to revision %s\n", result.ReplacedRevision, result.RestoredRevision)
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "rolled back from revision %s to revision %s (written as revision %s)\n", result.ReplacedRevision, result.RestoredRevision, result.Revision)
+	return nil
+}
+
+func runPrune(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var emptyTrash bool
+	var trashPrefix string
+	var yes bool
+	var dryRun bool
+	fs.BoolVar(&emptyTrash, "empty-trash", false, "permanently delete the RPMs moved to the trash by remove --trash-prefix")
+	fs.StringVar(&trashPrefix, "trash-prefix", repo.DefaultTrashPrefix, "trash directory to empty")
+	fs.BoolVar(&yes, "yes", false, "delete without asking for confirmation")
+	fs.BoolVar(&dryRun, "dry-run", false, "show what would be deleted without deleting")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if !emptyTrash {
+		return fmt.Errorf("prune requires --empty-trash")
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	if err := repo.ValidateTrashPrefix(trashPrefix, b.RepodataDir()); err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.TrashPrefix = trashPrefix
+	if !dryRun && !yes {
+		planned, err := r.EmptyTrash(ctx, true)
+		if err != nil {
+			return err
+		}
+		if err := confirmDeletion(planned); err != nil {
+			return err
+		}
+	}
+	deleted, err := r.EmptyTrash(ctx, dryRun)
+	if outputFormat == "json" {
+		if jsonErr := writeJSON(struct {
+			Deleted []string `json:"deleted"`
+			DryRun  bool     `json:"dry_run"`
+		}{deleted, dryRun}); jsonErr != nil && err == nil {
+			err = jsonErr
+		}
+		return err
+	}
+	for _, p := range deleted {
+		if dryRun {
+			fmt.Fprintf(os.Stdout, "would delete %s\n", p)
+		} else {
+			fmt.Fprintf(os.Stdout, "deleted %s\n", p)
+		}
+	}
+	return err
+}
+
+func runRelocate(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, gpg gpgOptions, args []string) error {
+	fs := flag.NewFlagSet("relocate", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var layout string
+	var destPrefix string
+	var locationPrefix string
+	var writeFlags metadataWriteFlags
+	var dryRun bool
+	var allowUnknown bool
+	fs.StringVar(&layout, "layout", "", "target package layout (flat, letter, pool)")
+	fs.StringVar(&destPrefix, "dest-prefix", "", "directory under the repo root to place the layout in")
+	fs.BoolVar(&dryRun, "dry-run", false, "show planned moves without writing")
+	fs.BoolVar(&allowUnknown, "allow-unknown", true, "preserve unknown metadata types instead of error")
+	writeFlags.register(fs)
+	writeFlags.registerRetention(fs)
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if !repo.SupportedLayout(layout) {
+		return fmt.Errorf("--layout must be one of flat, letter, pool")
+	}
+	if err := repo.ValidateDestPrefix(destPrefix); err != nil {
+		return err
+	}
+	if err := repo.ValidateLocationPrefix(locationPrefix); err != nil {
+		return err
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.DestPrefix = destPrefix
+	r.LocationPrefix = locationPrefix
+	r.AllowUnknown = allowUnknown
+	if err := writeFlags.apply(r); err != nil {
+		return err
+	}
+	gpg.apply(r)
+	result, err := r.Relocate(ctx, layout, dryRun)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "json" {
+		return writeJSON(result)
+	}
+	verb := "moved"
+	if dryRun {
+		verb = "would move"
+	}
+	for _, p := range result.Packages {
+		fmt.Fprintf(os.Stdout, "%s %s -> %s\n", verb, p.Source, p.Location)
+	}
+	return nil
+}
+
+func runList(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var jsonl bool
+	var signatures bool
+	var locationPrefix string
+	var builtAfter, builtBefore, addedAfter, addedBefore string
+	fs.BoolVar(&jsonl, "jsonl", false, "stream one JSON package object per line (for large repos)")
+	fs.BoolVar(&signatures, "signatures", false, "read each RPM's signature header and show whether it is signed, and by which key")
+	fs.StringVar(&builtAfter, "built-after", "", "only packages built at or after this time (RFC3339, YYYY-MM-DD, or unix seconds)")
+	fs.StringVar(&builtBefore, "built-before", "", "only packages built before this time")
+	fs.StringVar(&addedAfter, "added-after", "", "only packages whose RPM file time is at or after this time")
+	fs.StringVar(&addedBefore, "added-before", "", "only packages whose RPM file time is before this time")
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	var filter repo.PackageFilter
+	for _, bound := range []struct {
+		flag  string
+		value string
+		dst   *time.Time
+	}{
+		{"built-after", builtAfter, &filter.BuiltAfter},
+		{"built-before", builtBefore, &filter.BuiltBefore},
+		{"added-after", addedAfter, &filter.AddedAfter},
+		{"added-before", addedBefore, &filter.AddedBefore},
+	} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := parseTimeBound(bound.value)
+		if err != nil {
+			return fmt.Errorf("invalid --%s: %w", bound.flag, err)
+		}
+		*bound.dst = t
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.LocationPrefix = locationPrefix
+	pkgs, err := r.ListPackages(ctx)
+	if err != nil {
+		return err
+	}
+	pkgs = filter.Filter(pkgs)
+	if signatures {
+		if err := r.ReadSignatures(ctx, pkgs); err != nil {
+			return err
+		}
+	}
+	out := bufio.NewWriter(os.Stdout)
+	switch {
+	case jsonl:
+		// Encode package by package so the full document is never built in memory.
+		enc := json.NewEncoder(out)
+		for _, p := range pkgs {
+			if err := enc.Encode(p); err != nil {
+				return fmt.Errorf("encode json: %w", err)
+			}
+		}
+	case outputFormat == "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(pkgs); err != nil {
+			return fmt.Errorf("encode json: %w", err)
+		}
+	default:
+		for _, p := range pkgs {
+			if !signatures {
+				fmt.Fprintf(out, "%s\t%s\n", p.NEVRA(), p.Location)
+				continue
+			}
+			sig := "unsigned"
+			if p.Signed {
+				sig = "signed"
+				if p.SigKeyID != "" {
+					sig += " " + p.SigKeyID
+				}
+			}
+			fmt.Fprintf(out, "%s\t%s\t%s\n", p.NEVRA(), p.Location, sig)
+		}
+	}
+	return out.Flush()
+}
+
+func runCheck(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, gpg gpgOptions, args []string) error {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
 	fs.SetOutput(os.Stderr)
+	var locationPrefix string
+	var allowArch string
+	var managedPaths string
+	var dedupeStore string
+	var compression string
+	var filenameStyle string
+	var assumeProvided string
+	var skip string
+	var checkOpts repo.CheckOptions
+	fs.BoolVar(&checkOpts.MetadataOnly, "metadata-only", false, "only verify repomd.xml and core metadata; do not list or stat RPMs")
+	fs.BoolVar(&checkOpts.OrphansOnly, "orphans-only", false, "only report RPMs present but not referenced by metadata")
+	fs.BoolVar(&checkOpts.MissingOnly, "missing-only", false, "only report RPMs referenced by metadata but missing")
+	fs.BoolVar(&checkOpts.Fix, "fix", false, "rewrite stale checksums/sizes in repomd.xml when the core files themselves are intact")
+	fs.BoolVar(&checkOpts.RepomdSignature, "repomd-signature", false, "fail unless repomd.xml.asc exists and verifies")
+	fs.StringVar(&checkOpts.GPGKeyring, "gpg-keyring", "", "public key file to verify the repomd.xml signature against (default: gpg keyring)")
+	fs.StringVar(&managedPaths, "managed-paths", "", "comma-separated directories holding this repo's RPMs; others under the root are ignored")
+	fs.StringVar(&dedupeStore, "dedupe-store", "", "directory of content-addressed RPMs: verify their pkgids and do not report them as orphans")
+	fs.BoolVar(&checkOpts.IndexOrphans, "index-orphans", false, "add orphaned RPMs to metadata where they are stored instead of reporting them")
+	fs.BoolVar(&checkOpts.ReplaceExisting, "replace-existing", false, "with --index-orphans, replace packages with the same NEVRA")
+	fs.StringVar(&compression, "compression", metadata.CompressionGzip, "with --index-orphans, core metadata compression (gzip or bzip2)")
+	fs.StringVar(&filenameStyle, "metadata-filename-style", metadata.FilenameStyleHashed, "with --index-orphans, core metadata filenames: hashed or plain")
+	fs.BoolVar(&checkOpts.NoSource, "no-source", false, "leave source packages (arch src, .src.rpm files) out of the RPM checks")
+	fs.BoolVar(&checkOpts.VerifyRPMs, "verify-rpms", false, "stream each referenced RPM and check its checksum and size against metadata")
+	fs.BoolVar(&checkOpts.VerifyHeaderRange, "verify-header-range", false, "fetch each package's recorded header byte range and check it holds a valid RPM header")
+	fs.BoolVar(&checkOpts.RequireSigned, "require-all-signed", false, "fail if any referenced RPM has no OpenPGP signature (signatures are not verified)")
+	fs.BoolVar(&checkOpts.Deps, "deps", false, "check that every package's hard requirements are provided within the repo")
+	fs.StringVar(&assumeProvided, "assume-provided", "", "with --deps, file of dependencies provided outside the repo, one per line")
+	fs.StringVar(&skip, "skip", "", "comma-separated metadata types not to read or verify (filelists, filelists_ext, other)")
+	fs.StringVar(&allowArch, "allow-arch", "", "comma-separated custom architectures to accept without an unrecognized-arch warning")
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			return nil
 		}
 		return err
 	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
 	if repoRoot == "" {
 		return fmt.Errorf("--repo-root is required")
 	}
-	b, err := buildBackend(ctx, backendType, repoRoot, s3Opts)
+	if err := repo.ValidateLocationPrefix(locationPrefix); err != nil {
+		return err
+	}
+	if checkOpts.OrphansOnly && checkOpts.MissingOnly {
+		return fmt.Errorf("--orphans-only and --missing-only are mutually exclusive")
+	}
+	if checkOpts.IndexOrphans && checkOpts.MissingOnly {
+		return fmt.Errorf("--index-orphans and --missing-only are mutually exclusive")
+	}
+	if checkOpts.VerifyRPMs && checkOpts.OrphansOnly {
+		return fmt.Errorf("--verify-rpms and --orphans-only are mutually exclusive")
+	}
+	if checkOpts.VerifyHeaderRange && checkOpts.OrphansOnly {
+		return fmt.Errorf("--verify-header-range and --orphans-only are mutually exclusive")
+	}
+	if checkOpts.RequireSigned && checkOpts.OrphansOnly {
+		return fmt.Errorf("--require-all-signed and --orphans-only are mutually exclusive")
+	}
+	if checkOpts.ReplaceExisting && !checkOpts.IndexOrphans {
+		return fmt.Errorf("--replace-existing requires --index-orphans")
+	}
+	if assumeProvided != "" {
+		if !checkOpts.Deps {
+			return fmt.Errorf("--assume-provided requires --deps")
+		}
+		data, err := os.ReadFile(assumeProvided)
+		if err != nil {
+			return fmt.Errorf("read --assume-provided: %w", err)
+		}
+		if checkOpts.AssumeProvided, err = repo.ParseAssumeProvided(data); err != nil {
+			return fmt.Errorf("parse %s: %w", assumeProvided, err)
+		}
+	}
+	skipTypes, err := repo.ParseCheckSkip(skip)
+	if err != nil {
+		return fmt.Errorf("--skip: %w", err)
+	}
+	checkOpts.Skip = skipTypes
+	if _, ok := checkOpts.Skip["filelists"]; ok && checkOpts.Deps {
+		return fmt.Errorf("--deps needs the file lists; it cannot be used with --skip filelists")
+	}
+	if checkOpts.MetadataOnly && (checkOpts.OrphansOnly || checkOpts.MissingOnly || checkOpts.IndexOrphans || checkOpts.VerifyRPMs || checkOpts.VerifyHeaderRange || checkOpts.RequireSigned) {
+		return fmt.Errorf("--metadata-only cannot be combined with --orphans-only, --missing-only, --index-orphans, --verify-rpms, --verify-header-range or --require-all-signed")
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
 	if err != nil {
 		return err
 	}
@@ -249,32 +1544,643 @@ func runCheck(ctx context.Context, backendType, repoRoot string, s3Opts s3Option
 	if err != nil {
 		return err
 	}
-	result := r.CheckDetailed(ctx)
+	r.LocationPrefix = locationPrefix
+	if r.ManagedPaths, err = repo.ParseManagedPaths(managedPaths); err != nil {
+		return err
+	}
+	if err := repo.ValidateDedupeStore(dedupeStore, b.RepodataDir()); err != nil {
+		return err
+	}
+	r.DedupeStore = dedupeStore
+	r.Compression = compression
+	r.MetadataFilenameStyle = filenameStyle
+	r.AllowedArches = repo.ParseArches(allowArch)
+	gpg.apply(r)
+	result := r.CheckDetailed(ctx, checkOpts)
 	if result.Err != nil {
 		return result.Err
 	}
 	switch outputFormat {
 	case "text":
+		for _, f := range result.Fixed {
+			fmt.Fprintf(os.Stdout, "fixed: %s\n", f)
+		}
 		for _, w := range result.Warnings {
 			fmt.Fprintf(os.Stdout, "warn: %s\n", w)
 		}
-		fmt.Fprintf(os.Stdout, "repo ok at %s\n", repoRoot)
+		for _, p := range result.Missing {
+			fmt.Fprintf(os.Stdout, "missing: %s\n", p)
+		}
+		for _, p := range result.Orphaned {
+			fmt.Fprintf(os.Stdout, "orphaned: %s\n", p)
+		}
+		for _, u := range result.Unresolved {
+			fmt.Fprintf(os.Stdout, "unresolved: %s\n", u)
+		}
+		for _, p := range result.Unsigned {
+			fmt.Fprintf(os.Stdout, "unsigned: %s\n", p)
+		}
+		for _, p := range result.Indexed {
+			fmt.Fprintf(os.Stdout, "indexed: %s (%s)\n", p.Source, p.NEVRA)
+		}
+		if len(result.Indexed) > 0 {
+			fmt.Fprintf(os.Stdout, "indexed %d orphaned RPMs\n", len(result.Indexed))
+		}
+		if result.Signature != nil {
+			fmt.Fprintf(os.Stdout, "%s\n", result.Signature)
+		}
+		if len(result.Missing) == 0 && len(result.Orphaned) == 0 && len(result.Unresolved) == 0 && len(result.Unsigned) == 0 && result.Signature.OK() {
+			fmt.Fprintf(os.Stdout, "repo ok at %s\n", repoRoot)
+		}
 	case "json":
-		if err := json.NewEncoder(os.Stdout).Encode(result); err != nil {
-			return fmt.Errorf("encode json: %w", err)
+		if err := writeJSON(result); err != nil {
+			return err
 		}
 	default:
 		return fmt.Errorf("unknown output format %q", outputFormat)
 	}
+	if len(result.Missing) > 0 || len(result.Orphaned) > 0 {
+		return fmt.Errorf("check failed: %d missing, %d orphaned RPMs", len(result.Missing), len(result.Orphaned))
+	}
+	if len(result.Unresolved) > 0 {
+		return fmt.Errorf("check failed: %d unresolved dependencies", len(result.Unresolved))
+	}
+	if len(result.Unsigned) > 0 {
+		return fmt.Errorf("check failed: %d unsigned packages", len(result.Unsigned))
+	}
+	if !result.Signature.OK() {
+		return fmt.Errorf("check failed: repomd.xml signature %s", result.Signature.Status)
+	}
+	return nil
+}
+
+func runRepair(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel string, gpg gpgOptions, args []string) error {
+	fs := flag.NewFlagSet("repair", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var locationPrefix string
+	var writeFlags metadataWriteFlags
+	var checksum string
+	var checksumRPM string
+	var dryRun bool
+	var allowUnknown bool
+	var managedPaths string
+	var writeRepomdOnly bool
+	var allowArch string
+	fs.StringVar(&checksum, "checksum", "", "checksum algorithm (sha256 or sha512; default: keep existing)")
+	fs.BoolVar(&writeRepomdOnly, "write-repomd-only", false, "rewrite repomd.xml from the core metadata files already in repodata, without reading RPMs")
+	fs.StringVar(&allowArch, "allow-arch", "", "comma-separated custom architectures to accept without an unrecognized-arch warning")
+	fs.StringVar(&managedPaths, "managed-paths", "", "comma-separated directories holding this repo's RPMs; others under the root are ignored")
+	fs.StringVar(&checksumRPM, "checksum-rpm", "", "checksum algorithm for package pkgids (sha256 or sha512; default: metadata checksum)")
+	fs.BoolVar(&dryRun, "dry-run", false, "show planned changes without writing")
+	fs.BoolVar(&allowUnknown, "allow-unknown", true, "preserve unknown metadata types instead of error")
+	writeFlags.register(fs)
+	writeFlags.registerRetention(fs)
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if err := repo.ValidateLocationPrefix(locationPrefix); err != nil {
+		return err
+	}
+	if checksumRPM != "" && !metadata.SupportedChecksum(checksumRPM) {
+		return fmt.Errorf("unsupported --checksum-rpm %q", checksumRPM)
+	}
+	if writeRepomdOnly && (checksumRPM != "" || managedPaths != "" || locationPrefix != "" || writeFlags.noOther || writeFlags.filelistsExt || writeFlags.archRepodata || writeFlags.primaryFilePattern != "") {
+		return fmt.Errorf("--write-repomd-only keeps the core files as they are; --checksum-rpm, --managed-paths, --location-prefix, --no-other, --filelists-ext, --arch-specific-repodata and --primary-file-pattern do not apply")
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.LocationPrefix = locationPrefix
+	r.RPMChecksum = checksumRPM
+	r.AllowUnknown = allowUnknown
+	if r.ManagedPaths, err = repo.ParseManagedPaths(managedPaths); err != nil {
+		return err
+	}
+	r.AllowedArches = repo.ParseArches(allowArch)
+	if err := writeFlags.apply(r); err != nil {
+		return err
+	}
+	gpg.apply(r)
+	if writeRepomdOnly {
+		data, err := r.RebuildRepomd(ctx, checksum, dryRun)
+		if err != nil {
+			return err
+		}
+		verb := "rewrote"
+		if dryRun {
+			verb = "would rewrite"
+		}
+		fmt.Fprintf(os.Stdout, "%s repomd.xml at %s from %d core files\n", verb, repoRoot, len(data))
+		for _, d := range data {
+			fmt.Fprintf(os.Stdout, "  %s: %s\n", d.Type, d.Location.Href)
+		}
+		return nil
+	}
+	n, err := r.RepairRepo(ctx, checksum, dryRun)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stdout, "would rebuild metadata at %s (%d packages)\n", repoRoot, n)
+	} else {
+		fmt.Fprintf(os.Stdout, "rebuilt metadata at %s (%d packages)\n", repoRoot, n)
+	}
 	return nil
 }
 
-func buildBackend(ctx context.Context, backendType, repoRoot string, s3Opts s3Options) (backend.Backend, error) {
+func runRebuild(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel string, signRepodata bool, gpg gpgOptions, args []string) error {
+	fs := flag.NewFlagSet("rebuild", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var writeFlags metadataWriteFlags
+	var forceRewrite bool
+	var checksum string
+	var dryRun bool
+	var allowUnknown bool
+	fs.StringVar(&checksum, "checksum", "", "checksum algorithm (sha256 or sha512; default: keep existing)")
+	fs.BoolVar(&dryRun, "dry-run", false, "show planned changes without writing")
+	fs.BoolVar(&allowUnknown, "allow-unknown", true, "preserve unknown metadata types instead of error")
+	writeFlags.register(fs)
+	writeFlags.registerRetention(fs)
+	fs.BoolVar(&forceRewrite, "force", false, "rewrite metadata even when it would be identical (default: skip, keeping the revision)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.AllowUnknown = allowUnknown
+	if err := writeFlags.apply(r); err != nil {
+		return err
+	}
+	r.ForceRewrite = forceRewrite
+	gpg.apply(r)
+	n, err := r.Rebuild(ctx, checksum, signRepodata, gpg.key, dryRun)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stdout, "would rebuild metadata at %s (%d packages)\n", repoRoot, n)
+	} else {
+		fmt.Fprintf(os.Stdout, "rebuilt metadata at %s (%d packages)\n", repoRoot, n)
+	}
+	return nil
+}
+
+func runSnapshot(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, signRepodata bool, gpg gpgOptions, args []string) error {
+	fs := flag.NewFlagSet("snapshot", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var name string
+	var dryRun bool
+	fs.StringVar(&name, "name", "", "snapshot name; the snapshot is published under "+repo.SnapshotsDir+"/<name>/ (e.g. 2024-01-15)")
+	fs.BoolVar(&dryRun, "dry-run", false, "show planned changes without writing")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("snapshot takes no arguments")
+	}
+	if name == "" {
+		return fmt.Errorf("snapshot requires --name")
+	}
+	if err := repo.ValidateSnapshotName(name); err != nil {
+		return err
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	gpg.apply(r)
+	result, err := r.Snapshot(ctx, name, signRepodata, gpg.key, dryRun)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "json" {
+		return writeJSON(result)
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stdout, "would snapshot %d packages to %s/\n", result.Packages, result.Path)
+	} else {
+		fmt.Fprintf(os.Stdout, "snapshot %s: %d packages at %s/ (revision %s)\n", result.Name, result.Packages, result.Path, result.Revision)
+	}
+	return nil
+}
+
+func runManifest(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel string, args []string) error {
+	fs := flag.NewFlagSet("manifest", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var outPath string
+	var locationPrefix string
+	fs.StringVar(&outPath, "o", "", "write the manifest to this file instead of stdout")
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.LocationPrefix = locationPrefix
+	m, err := r.BuildManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if outPath == "" {
+		return writeJSON(m)
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outPath, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	fmt.Fprintf(os.Stdout, "wrote manifest of %d packages to %s\n", len(m.Packages), outPath)
+	return nil
+}
+
+// runWhat runs whatprovides or whatrequires. The query may be one quoted argument or several
+// ("bar >= 1.0" and bar '>=' 1.0 are the same).
+func runWhat(ctx context.Context, command, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, args []string) error {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("%s requires a dependency, e.g. %s 'bar >= 1.0'", command, command)
+	}
+	query := strings.Join(fs.Args(), " ")
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	lookup := r.WhatProvides
+	if command == "whatrequires" {
+		lookup = r.WhatRequires
+	}
+	matches, err := lookup(ctx, query)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "json" {
+		return writeJSON(matches)
+	}
+	for _, m := range matches {
+		fmt.Fprintf(os.Stdout, "%s\t%s\n", m.NEVRA, m.Match)
+	}
+	return nil
+}
+
+func runFingerprint(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, args []string) error {
+	fs := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("fingerprint takes no arguments")
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	fp, err := r.Fingerprint(ctx)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "json" {
+		return writeJSON(fp)
+	}
+	fmt.Fprintln(os.Stdout, fp.Fingerprint)
+	return nil
+}
+
+func runDiff(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	sides := fs.Args()
+	switch {
+	case len(sides) == 1 && repoRoot != "":
+		sides = []string{repoRoot, sides[0]}
+	case len(sides) != 2:
+		return fmt.Errorf("diff requires two repo roots or manifest files (or one, compared against --repo-root)")
+	}
+	var pkgs [2][]metadata.Package
+	for i, side := range sides {
+		var err error
+		if pkgs[i], err = loadDiffSide(ctx, backendType, side, opts, logLevel); err != nil {
+			return fmt.Errorf("%s: %w", side, err)
+		}
+	}
+	diff := repo.DiffPackages(pkgs[0], pkgs[1])
+	if outputFormat == "json" {
+		return writeJSON(diff)
+	}
+	for _, e := range diff.Added {
+		fmt.Fprintf(os.Stdout, "added: %s.%s %s\n", e.Name, e.Arch, e.To)
+	}
+	for _, e := range diff.Removed {
+		fmt.Fprintf(os.Stdout, "removed: %s.%s %s\n", e.Name, e.Arch, e.From)
+	}
+	for _, e := range diff.Changed {
+		suffix := ""
+		if e.Downgrade {
+			suffix = " (downgrade)"
+		}
+		fmt.Fprintf(os.Stdout, "changed: %s.%s %s -> %s%s\n", e.Name, e.Arch, e.From, e.To, suffix)
+	}
+	if diff.Empty() {
+		fmt.Fprintf(os.Stdout, "no differences\n")
+	}
+	return nil
+}
+
+// loadDiffSide reads the packages of one diff side: a local manifest file, or else a repo root
+// on the configured backend.
+func loadDiffSide(ctx context.Context, backendType, side string, opts backendOptions, logLevel string) ([]metadata.Package, error) {
+	if info, err := os.Stat(side); err == nil && info.Mode().IsRegular() {
+		data, err := os.ReadFile(side)
+		if err != nil {
+			return nil, fmt.Errorf("read manifest: %w", err)
+		}
+		var m repo.Manifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("parse manifest: %w", err)
+		}
+		return repo.ManifestPackages(m)
+	}
+	b, err := buildBackend(ctx, backendType, side, opts)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return nil, err
+	}
+	return r.ListPackages(ctx)
+}
+
+func runVerifyManifest(ctx context.Context, backendType, repoRoot string, opts backendOptions, logLevel, outputFormat string, args []string) error {
+	fs := flag.NewFlagSet("verify-manifest", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	var locationPrefix string
+	fs.StringVar(&locationPrefix, "location-prefix", "", "prefix prepended to package location hrefs in metadata (files are stored without it)")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if err := bindEnv(fs); err != nil {
+		return err
+	}
+	if repoRoot == "" {
+		return fmt.Errorf("--repo-root is required")
+	}
+	if outputFormat != "text" && outputFormat != "json" {
+		return fmt.Errorf("unknown output format %q", outputFormat)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("verify-manifest requires exactly one manifest file")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	var m repo.Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("parse manifest %s: %w", fs.Arg(0), err)
+	}
+	b, err := buildBackend(ctx, backendType, repoRoot, opts)
+	if err != nil {
+		return err
+	}
+	r, err := newRepoWithLogger(b, logLevel)
+	if err != nil {
+		return err
+	}
+	r.LocationPrefix = locationPrefix
+	diff, err := r.VerifyManifest(ctx, m)
+	if err != nil {
+		return err
+	}
+	if outputFormat == "json" {
+		if err := writeJSON(diff); err != nil {
+			return err
+		}
+	} else {
+		for _, e := range diff.Added {
+			fmt.Fprintf(os.Stdout, "added: %s (%s)\n", e.NEVRA, e.Location)
+		}
+		for _, e := range diff.Removed {
+			fmt.Fprintf(os.Stdout, "removed: %s (%s)\n", e.NEVRA, e.Location)
+		}
+		for _, e := range diff.Changed {
+			fmt.Fprintf(os.Stdout, "changed: %s (%s)\n", e.NEVRA, e.Location)
+		}
+		if diff.Empty() {
+			fmt.Fprintf(os.Stdout, "repo matches manifest (%d packages)\n", len(m.Packages))
+		}
+	}
+	if !diff.Empty() {
+		return fmt.Errorf("repo does not match manifest: %d added, %d removed, %d changed", len(diff.Added), len(diff.Removed), len(diff.Changed))
+	}
+	return nil
+}
+
+// readListFile returns the non-blank lines of path ("-" reads stdin), skipping # comments.
+// Surrounding whitespace is trimmed; paths and NEVRAs never contain a newline.
+func readListFile(path string) ([]string, error) {
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("open list: %w", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	var out []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		out = append(out, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	return out, nil
+}
+
+// readSecretFile returns the contents of path without its trailing newline, or "" if path is empty.
+func readSecretFile(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readPackageJSON decodes a single metadata.Package from a JSON file. Unknown fields are rejected
+// so a misspelled field fails loudly instead of publishing incomplete metadata.
+func readPackageJSON(p string) (metadata.Package, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return metadata.Package{}, fmt.Errorf("read %s: %w", p, err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	var pkg metadata.Package
+	if err := dec.Decode(&pkg); err != nil {
+		return metadata.Package{}, fmt.Errorf("parse %s: %w", p, err)
+	}
+	return pkg, nil
+}
+
+// writeJSON encodes v to stdout as a single JSON document.
+func writeJSON(v any) error {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		return fmt.Errorf("encode json: %w", err)
+	}
+	return nil
+}
+
+func buildBackend(ctx context.Context, backendType, repoRoot string, opts backendOptions) (backend.Backend, error) {
 	switch backendType {
 	case "fs":
-		return backend.NewFSBackend(repoRoot), nil
+		b := backend.NewFSBackend(repoRoot)
+		b.SetRepodataDir(opts.repodataDir)
+		b.SetRateLimit(opts.rateLimit)
+		b.SetFollowSymlinks(opts.followSymlinks)
+		b.SetFileMode(opts.fileMode)
+		b.SetDirMode(opts.dirMode)
+		b.SetOwner(opts.uid, opts.gid)
+		return b, nil
 	case "s3":
-		return backend.NewS3Backend(ctx, repoRoot, s3Opts.endpoint, s3Opts.region, s3Opts.disableETag)
+		b, err := backend.NewS3Backend(ctx, repoRoot, opts.s3)
+		if err != nil {
+			return nil, err
+		}
+		b.SetRepodataDir(opts.repodataDir)
+		b.SetRateLimit(opts.rateLimit)
+		return b, nil
+	case "webdav":
+		b, err := backend.NewWebDAVBackend(repoRoot, opts.webdav)
+		if err != nil {
+			return nil, err
+		}
+		b.SetRepodataDir(opts.repodataDir)
+		b.SetRateLimit(opts.rateLimit)
+		return b, nil
 	default:
 		return nil, fmt.Errorf("backend %q not implemented", backendType)
 	}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newEnvFlagSet(t *testing.T, args ...string) (*flag.FlagSet, *bool, *string) {
+	t.Helper()
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	yes := fs.Bool("yes", false, "")
+	repoRoot := fs.String("repo-root", "", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	return fs, yes, repoRoot
+}
+
+func TestBindEnvIgnoresCommandLineOnlyFlags(t *testing.T) {
+	t.Setenv("RPMREPO_YES", "true")
+	t.Setenv("RPMREPO_REPO_ROOT", "/srv/repo")
+	fs, yes, repoRoot := newEnvFlagSet(t)
+	if err := bindEnv(fs); err != nil {
+		t.Fatalf("bindEnv: %v", err)
+	}
+	if *yes {
+		t.Fatal("RPMREPO_YES set --yes")
+	}
+	if *repoRoot != "/srv/repo" {
+		t.Fatalf("repo-root = %q, want /srv/repo", *repoRoot)
+	}
+
+	fs, yes, _ = newEnvFlagSet(t, "--yes")
+	if err := bindEnv(fs); err != nil {
+		t.Fatalf("bindEnv: %v", err)
+	}
+	if !*yes {
+		t.Fatal("--yes on the command line was dropped")
+	}
+}
+
+func TestApplyConfigFileRejectsCommandLineOnlyFlags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rpmrepo.toml")
+	if err := os.WriteFile(path, []byte("repo-root = \"/srv/repo\"\nyes = true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs, yes, _ := newEnvFlagSet(t)
+	err := applyConfigFile(fs, path)
+	if err == nil || !strings.Contains(err.Error(), "yes can only be given on the command line") {
+		t.Fatalf("applyConfigFile error = %v, want command-line-only rejection", err)
+	}
+	if *yes {
+		t.Fatal("config file set --yes")
+	}
+}
@@ -1,15 +1,117 @@
 package backend
 
-import "context"
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// ErrInvalidPath is returned for paths that are absolute or escape the repository root.
+var ErrInvalidPath = errors.New("path escapes repository root")
+
+// DefaultRepodataDir is the conventional metadata directory relative to the repository root.
+const DefaultRepodataDir = "repodata"
 
 // Backend abstracts storage for a single repository root.
 // Paths are always relative to the repository root (e.g. "repodata/repomd.xml").
+// ReadFile returns an error matching fs.ErrNotExist when the path does not exist;
+// other failures such as permission errors are returned as-is.
 type Backend interface {
 	ListRepodata(ctx context.Context) ([]string, error)
 	ReadFile(ctx context.Context, path string) ([]byte, error)
+	// Open returns a reader that streams path without holding it in memory; the caller closes it.
+	// It fails like ReadFile when the path does not exist. See OpenReadFile for the generic fallback.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
 	WriteFile(ctx context.Context, path string, data []byte) error
 	DeleteFile(ctx context.Context, path string) error
+	// Copy copies srcPath to dstPath within the backend, replacing dstPath if it exists.
+	// Implementations copy server-side where they can; see ReadWriteCopy for the generic fallback.
+	Copy(ctx context.Context, srcPath, dstPath string) error
+	// ReadRange returns length bytes of path starting at offset, fewer if the file ends first.
+	// Implementations fetch only the range where they can; see ReadFileRange for the generic fallback.
+	ReadRange(ctx context.Context, path string, offset, length int64) ([]byte, error)
 	Exists(ctx context.Context, path string) (bool, error)
 	ListRPMs(ctx context.Context) ([]string, error)
 	RepoRoot() string
+	// RepodataDir returns the metadata directory relative to the repository root.
+	RepodataDir() string
+}
+
+// ReadWriteCopy copies srcPath to dstPath by reading it through the client and writing it back.
+// It is the fallback for backends without a native copy.
+func ReadWriteCopy(ctx context.Context, b Backend, srcPath, dstPath string) error {
+	data, err := b.ReadFile(ctx, srcPath)
+	if err != nil {
+		return err
+	}
+	return b.WriteFile(ctx, dstPath, data)
+}
+
+// OpenReadFile returns a reader over path after reading it whole through the client. It is the
+// fallback for backends without streaming reads.
+func OpenReadFile(ctx context.Context, b Backend, path string) (io.ReadCloser, error) {
+	data, err := b.ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ReadFileRange returns length bytes of path starting at offset by reading the whole file through
+// the client and slicing it. It is the fallback for backends without ranged reads.
+func ReadFileRange(ctx context.Context, b Backend, path string, offset, length int64) ([]byte, error) {
+	if err := checkRange(offset, length); err != nil {
+		return nil, err
+	}
+	data, err := b.ReadFile(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return sliceRange(data, offset, length), nil
+}
+
+// checkRange rejects negative offsets and lengths.
+func checkRange(offset, length int64) error {
+	if offset < 0 || length < 0 {
+		return fmt.Errorf("invalid byte range: offset %d, length %d", offset, length)
+	}
+	return nil
+}
+
+// sliceRange returns up to length bytes of data starting at offset.
+func sliceRange(data []byte, offset, length int64) []byte {
+	size := int64(len(data))
+	if offset > size {
+		offset = size
+	}
+	return data[offset : offset+min(length, size-offset)]
+}
+
+// ChecksumPrefix returns the hex digest prefix of a checksum-named metadata file
+// ("<checksum>-<type>.xml.*"), or "" for a plain name such as primary.xml.gz.
+func ChecksumPrefix(href string) string {
+	base := path.Base(href)
+	i := strings.IndexByte(base, '-')
+	if i < 32 {
+		return ""
+	}
+	for _, c := range base[:i] {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return ""
+		}
+	}
+	return base[:i]
+}
+
+// cleanRepodataDir normalizes a user-supplied metadata directory, falling back to the default.
+func cleanRepodataDir(dir string) string {
+	dir = strings.Trim(path.Clean("/"+dir), "/")
+	if dir == "" {
+		return DefaultRepodataDir
+	}
+	return dir
 }
@@ -1,54 +1,160 @@
 package backend
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Default permissions for files and directories created by FSBackend.
+const (
+	DefaultFileMode os.FileMode = 0o644
+	DefaultDirMode  os.FileMode = 0o755
 )
 
 type FSBackend struct {
-	root string
+	root           string
+	repodataDir    string
+	limiter        *rate.Limiter
+	followSymlinks bool
+	fileMode       os.FileMode
+	dirMode        os.FileMode
+	uid, gid       int
 }
 
 func NewFSBackend(root string) *FSBackend {
-	return &FSBackend{root: root}
+	return &FSBackend{root: root, repodataDir: DefaultRepodataDir, fileMode: DefaultFileMode, dirMode: DefaultDirMode, uid: -1, gid: -1}
 }
 
 func (b *FSBackend) RepoRoot() string {
 	return b.root
 }
 
+func (b *FSBackend) RepodataDir() string {
+	return b.repodataDir
+}
+
+// SetRepodataDir overrides the metadata directory (default "repodata").
+func (b *FSBackend) SetRepodataDir(dir string) {
+	b.repodataDir = cleanRepodataDir(dir)
+}
+
+// SetRateLimit throttles RPM writes to bytesPerSec; metadata writes are not throttled. Zero disables throttling.
+func (b *FSBackend) SetRateLimit(bytesPerSec int64) {
+	b.limiter = newRateLimiter(bytesPerSec)
+}
+
+// SetFollowSymlinks makes ListRPMs descend into symlinked directories and skip dangling RPM symlinks.
+// Symlink cycles are detected and not re-entered. Default is false.
+func (b *FSBackend) SetFollowSymlinks(follow bool) {
+	b.followSymlinks = follow
+}
+
+// SetFileMode sets the permissions of written files (default 0644). The mode is applied with
+// chmod, so it is not reduced by the process umask.
+func (b *FSBackend) SetFileMode(mode os.FileMode) {
+	b.fileMode = mode.Perm()
+}
+
+// SetDirMode sets the permissions of directories created for written files (default 0755).
+// Existing directories are left unchanged.
+func (b *FSBackend) SetDirMode(mode os.FileMode) {
+	b.dirMode = mode.Perm()
+}
+
+// SetOwner chowns written files and created directories to uid and gid. -1 leaves that id
+// unchanged, which is the default.
+func (b *FSBackend) SetOwner(uid, gid int) {
+	b.uid, b.gid = uid, gid
+}
+
 func (b *FSBackend) ListRepodata(ctx context.Context) ([]string, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	dirPath := filepath.Join(b.root, "repodata")
+	dirPath := filepath.Join(b.root, filepath.FromSlash(b.repodataDir))
 	entries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
 	}
 	paths := make([]string, 0, len(entries))
 	for _, entry := range entries {
-		paths = append(paths, filepath.ToSlash(filepath.Join("repodata", entry.Name())))
+		paths = append(paths, b.repodataDir+"/"+entry.Name())
 	}
 	return paths, nil
 }
 
+// resolve maps a repository-relative path onto the filesystem, refusing paths that are
+// absolute or contain ".." components that would escape the repository root.
+func (b *FSBackend) resolve(path string) (string, error) {
+	rel := filepath.FromSlash(path)
+	if !filepath.IsLocal(rel) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return filepath.Join(b.root, rel), nil
+}
+
 func (b *FSBackend) ReadFile(ctx context.Context, path string) ([]byte, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	return os.ReadFile(filepath.Join(b.root, filepath.FromSlash(path)))
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(absPath)
+}
+
+func (b *FSBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(absPath)
+}
+
+func (b *FSBackend) ReadRange(ctx context.Context, path string, offset, length int64) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkRange(offset, length); err != nil {
+		return nil, err
+	}
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.NewSectionReader(f, offset, length))
 }
 
 func (b *FSBackend) Exists(ctx context.Context, path string) (bool, error) {
 	if err := ctx.Err(); err != nil {
 		return false, err
 	}
-	_, err := os.Stat(filepath.Join(b.root, filepath.FromSlash(path)))
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return false, err
+	}
+	_, err = os.Stat(absPath)
 	if err == nil {
 		return true, nil
 	}
@@ -58,10 +164,29 @@ func (b *FSBackend) Exists(ctx context.Context, path string) (bool, error) {
 	return false, err
 }
 
+// ModTime returns when path was last modified.
+func (b *FSBackend) ModTime(ctx context.Context, path string) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
 func (b *FSBackend) ListRPMs(ctx context.Context) ([]string, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
+	if b.followSymlinks {
+		return b.listRPMsFollow(ctx)
+	}
 	var rpms []string
 	err := filepath.WalkDir(b.root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -76,7 +201,7 @@ func (b *FSBackend) ListRPMs(ctx context.Context) ([]string, error) {
 			return nil
 		}
 		// Skip metadata directory when looking for RPMs.
-		if d.IsDir() && rel == "repodata" {
+		if d.IsDir() && rel == b.repodataDir {
 			return filepath.SkipDir
 		}
 		if !d.IsDir() && strings.HasSuffix(d.Name(), ".rpm") {
@@ -90,13 +215,78 @@ func (b *FSBackend) ListRPMs(ctx context.Context) ([]string, error) {
 	return rpms, nil
 }
 
+// listRPMsFollow walks the root like ListRPMs but resolves symlinks. Directories already on the
+// current descent path (by resolved location) are skipped, so link cycles terminate.
+func (b *FSBackend) listRPMsFollow(ctx context.Context) ([]string, error) {
+	var rpms []string
+	active := make(map[string]bool)
+	var walk func(dir, rel string) error
+	walk = func(dir, rel string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if active[real] {
+			return nil
+		}
+		active[real] = true
+		defer delete(active, real)
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			childPath := filepath.Join(dir, entry.Name())
+			childRel := entry.Name()
+			if rel != "" {
+				childRel = rel + "/" + entry.Name()
+			}
+			isDir := entry.IsDir()
+			if entry.Type()&fs.ModeSymlink != 0 {
+				info, err := os.Stat(childPath)
+				if errors.Is(err, fs.ErrNotExist) {
+					continue // dangling link
+				}
+				if err != nil {
+					return err
+				}
+				isDir = info.IsDir()
+			}
+			if isDir {
+				if childRel == b.repodataDir {
+					continue
+				}
+				if err := walk(childPath, childRel); err != nil {
+					return err
+				}
+				continue
+			}
+			if strings.HasSuffix(entry.Name(), ".rpm") {
+				rpms = append(rpms, childRel)
+			}
+		}
+		return nil
+	}
+	if err := walk(b.root, ""); err != nil {
+		return nil, err
+	}
+	return rpms, nil
+}
+
 func (b *FSBackend) WriteFile(ctx context.Context, path string, data []byte) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
-	absPath := filepath.Join(b.root, filepath.FromSlash(path))
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return err
+	}
 	dir := filepath.Dir(absPath)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := b.mkdirAll(dir); err != nil {
 		return err
 	}
 	tmp, err := os.CreateTemp(dir, ".tmp-rpmrepo-*")
@@ -112,13 +302,21 @@ func (b *FSBackend) WriteFile(ctx context.Context, path string, data []byte) err
 		}
 	}()
 
-	if _, err := tmp.Write(data); err != nil {
+	var src io.Reader = bytes.NewReader(data)
+	if !strings.HasPrefix(path, b.repodataDir+"/") {
+		src = throttleReader(ctx, src, b.limiter)
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
 		return err
 	}
 	if err := tmp.Sync(); err != nil {
 		return err
 	}
-	if err := tmp.Chmod(0o644); err != nil {
+	// Chmod rather than relying on the create mode, which the process umask would reduce.
+	if err := tmp.Chmod(b.fileMode); err != nil {
+		return err
+	}
+	if err := b.chown(tmp); err != nil {
 		return err
 	}
 	if err := tmp.Close(); err != nil {
@@ -129,14 +327,115 @@ func (b *FSBackend) WriteFile(ctx context.Context, path string, data []byte) err
 	if err := os.Rename(tmpName, absPath); err != nil {
 		return err
 	}
+	return syncDir(dir)
+}
+
+// Copy hardlinks srcPath to dstPath, replacing dstPath atomically. Files are only ever replaced by
+// rename, never modified in place, so sharing an inode is safe. Where hardlinks are unavailable
+// (e.g. across devices) it falls back to ReadWriteCopy.
+func (b *FSBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	src, err := b.resolve(srcPath)
+	if err != nil {
+		return err
+	}
+	dst, err := b.resolve(dstPath)
+	if err != nil {
+		return err
+	}
+	if src == dst {
+		_, err := os.Stat(src)
+		return err
+	}
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	dir := filepath.Dir(dst)
+	if err := b.mkdirAll(dir); err != nil {
+		return err
+	}
+	// Link to a fresh temp name first: os.Link refuses to overwrite an existing dstPath.
+	tmp, err := os.CreateTemp(dir, ".tmp-rpmrepo-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	_ = tmp.Close()
+	_ = os.Remove(tmpName)
+	if err := os.Link(src, tmpName); err != nil {
+		return ReadWriteCopy(ctx, b, srcPath, dstPath)
+	}
+	if err := os.Rename(tmpName, dst); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return syncDir(dir)
+}
+
+// mkdirAll creates dir and any missing parents with the configured mode and owner.
+func (b *FSBackend) mkdirAll(dir string) error {
+	if info, err := os.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := b.mkdirAll(parent); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, b.dirMode); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil
+		}
+		return err
+	}
+	// Mkdir is subject to the umask; chmod applies the mode exactly.
+	if err := os.Chmod(dir, b.dirMode); err != nil {
+		return err
+	}
+	if b.uid != -1 || b.gid != -1 {
+		return os.Chown(dir, b.uid, b.gid)
+	}
 	return nil
 }
 
+// syncDir fsyncs a directory so a rename into it survives a crash. Windows cannot sync
+// directory handles, so it is a no-op there.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", dir, err)
+	}
+	return nil
+}
+
+func (b *FSBackend) chown(f *os.File) error {
+	if b.uid == -1 && b.gid == -1 {
+		return nil
+	}
+	return f.Chown(b.uid, b.gid)
+}
+
 func (b *FSBackend) DeleteFile(ctx context.Context, path string) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
-	err := os.Remove(filepath.Join(b.root, filepath.FromSlash(path)))
+	absPath, err := b.resolve(path)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(absPath)
 	if errors.Is(err, fs.ErrNotExist) {
 		return nil
 	}
@@ -0,0 +1,251 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemBackend keeps a repository in memory. It is meant for tests of code built on this module:
+// it implements Backend, is safe for concurrent use, and detects concurrent repomd.xml updates
+// the way S3Backend does with ETags.
+type MemBackend struct {
+	mu          sync.Mutex
+	files       map[string][]byte
+	repodataDir string
+	// repomdGen counts writes and deletes of repomd.xml; readGen is the generation last returned
+	// by ReadFile, or 0 if repomd.xml has not been read.
+	repomdGen uint64
+	readGen   uint64
+}
+
+func NewMemBackend() *MemBackend {
+	return &MemBackend{files: make(map[string][]byte), repodataDir: DefaultRepodataDir}
+}
+
+func (b *MemBackend) RepoRoot() string {
+	return "mem"
+}
+
+func (b *MemBackend) RepodataDir() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.repodataDir
+}
+
+// SetRepodataDir overrides the metadata directory (default "repodata").
+func (b *MemBackend) SetRepodataDir(dir string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.repodataDir = cleanRepodataDir(dir)
+}
+
+// Files returns a copy of every stored path and its contents.
+func (b *MemBackend) Files() map[string][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make(map[string][]byte, len(b.files))
+	for p, data := range b.files {
+		out[p] = append([]byte(nil), data...)
+	}
+	return out
+}
+
+// memKey normalizes a repository-relative path, refusing the same paths FSBackend does.
+func memKey(p string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(p)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, p)
+	}
+	return path.Clean(p), nil
+}
+
+func (b *MemBackend) repomdKey() string {
+	return b.repodataDir + "/repomd.xml"
+}
+
+func (b *MemBackend) ListRepodata(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prefix := b.repodataDir + "/"
+	var out []string
+	for p := range b.files {
+		if rest, ok := strings.CutPrefix(p, prefix); ok && !strings.Contains(rest, "/") {
+			out = append(out, p)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (b *MemBackend) ReadFile(ctx context.Context, p string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key, err := memKey(p)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	if key == b.repomdKey() {
+		b.readGen = b.repomdGen
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Open returns a reader over the current contents of p; later writes do not affect it.
+func (b *MemBackend) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	key, err := memKey(p)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	// Writes replace the slice rather than modifying it, so no copy is needed.
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *MemBackend) ReadRange(ctx context.Context, p string, offset, length int64) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := checkRange(offset, length); err != nil {
+		return nil, err
+	}
+	key, err := memKey(p)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.files[key]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), sliceRange(data, offset, length)...), nil
+}
+
+// WriteFile stores a copy of data. Like a conditional S3 put, a repomd.xml write fails if
+// repomd.xml was read and has changed since, or was never read but exists.
+func (b *MemBackend) WriteFile(ctx context.Context, p string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, err := memKey(p)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if key == b.repomdKey() {
+		if _, exists := b.files[key]; exists && b.readGen == 0 {
+			return fmt.Errorf("conflict: %s was created by another writer", p)
+		}
+		if err := b.checkRepomdLocked(); err != nil {
+			return err
+		}
+		b.repomdGen++
+		b.readGen = b.repomdGen
+	}
+	b.files[key] = append([]byte(nil), data...)
+	return nil
+}
+
+func (b *MemBackend) DeleteFile(ctx context.Context, p string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key, err := memKey(p)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.files[key]; ok && key == b.repomdKey() {
+		b.repomdGen++
+	}
+	delete(b.files, key)
+	return nil
+}
+
+func (b *MemBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return ReadWriteCopy(ctx, b, srcPath, dstPath)
+}
+
+func (b *MemBackend) Exists(ctx context.Context, p string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	key, err := memKey(p)
+	if err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.files[key]
+	return ok, nil
+}
+
+func (b *MemBackend) ListRPMs(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []string
+	for p := range b.files {
+		if strings.HasPrefix(p, b.repodataDir+"/") || !strings.HasSuffix(p, ".rpm") {
+			continue
+		}
+		out = append(out, p)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// CheckRepomdUnchanged reports a conflict if repomd.xml was written or deleted by anyone else
+// since this backend last read it. It is a no-op before the first read.
+func (b *MemBackend) CheckRepomdUnchanged(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.checkRepomdLocked()
+}
+
+// TouchRepomd marks repomd.xml as changed without altering it, as a write from another client would.
+// Tests use it to exercise conflict handling.
+func (b *MemBackend) TouchRepomd() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.repomdGen++
+}
+
+func (b *MemBackend) checkRepomdLocked() error {
+	if b.readGen == 0 || b.readGen == b.repomdGen {
+		return nil
+	}
+	return fmt.Errorf("conflict: repomd.xml changed since read (generation %d -> %d)", b.readGen, b.repomdGen)
+}
@@ -3,17 +3,30 @@ package backend
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
 	"path"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"golang.org/x/time/rate"
 )
 
 type S3Backend struct {
@@ -21,54 +34,187 @@ type S3Backend struct {
 	uploader    *manager.Uploader
 	bucket      string
 	prefix      string
-	repomdETag  string
 	repomdKey   string
 	disableETag bool
 	tempPrefix  string
-	ifMatchETag string
+	repodataDir string
+	limiter     *rate.Limiter
+
+	listConcurrency int
+	// listRetryer is shared by concurrent listings so that throttling seen by one slows them all.
+	listRetryer aws.Retryer
+
+	// etagMu guards the repomd.xml ETag, which ReadFile and WriteFile update while other
+	// goroutines may be using the backend.
+	etagMu     sync.Mutex
+	repomdETag string
+
+	cacheControl       string
+	repomdCacheControl string
+}
+
+// S3Options configures an S3 backend.
+type S3Options struct {
+	// Endpoint, if non-empty, configures the client for S3-compatible storage
+	// (e.g., MinIO) with path-style addressing.
+	Endpoint string
+	// Region, if non-empty, overrides the default AWS region.
+	Region string
+	// DisableETag disables ETag-based conflict detection (for R2, etc.).
+	DisableETag bool
+	// PartSize is the multipart upload part size in bytes (0: SDK default of 5 MiB).
+	PartSize int64
+	// UploadConcurrency is the number of parts uploaded in parallel (0: SDK default).
+	UploadConcurrency int
+	// ListConcurrency is the number of top-level directories ListRPMs lists in parallel
+	// (0 or 1: list serially).
+	ListConcurrency int
+	// CacheControl is set on RPMs and checksum-named metadata (default: DefaultCacheControl).
+	CacheControl string
+	// RepomdCacheControl is set on repomd.xml and its signature (default: DefaultRepomdCacheControl).
+	RepomdCacheControl string
+	// Proxy, if non-empty, is the proxy URL (http, https or socks5) for every S3 request, including
+	// requests to Endpoint. Empty uses HTTPS_PROXY, HTTP_PROXY and NO_PROXY from the environment.
+	Proxy string
 }
 
+// Default Cache-Control values: content-addressed objects are immutable, while repomd.xml
+// changes on every update and must always be revalidated.
+const (
+	DefaultCacheControl       = "public, max-age=31536000, immutable"
+	DefaultRepomdCacheControl = "no-cache"
+)
+
 // NewS3Backend creates an S3 backend for the provided s3://bucket/prefix root.
-// If endpoint is non-empty, it configures the client for S3-compatible storage
-// (e.g., MinIO) with path-style addressing.
-// If region is non-empty, it overrides the default AWS region.
-// If disableETag is true, ETag-based conflict detection is disabled (for R2, etc.).
-func NewS3Backend(ctx context.Context, root, endpoint, region string, disableETag bool) (*S3Backend, error) {
+func NewS3Backend(ctx context.Context, root string, opts S3Options) (*S3Backend, error) {
 	bucket, prefix, err := parseS3URI(root)
 	if err != nil {
 		return nil, err
 	}
+	if opts.PartSize != 0 && opts.PartSize < manager.MinUploadPartSize {
+		return nil, fmt.Errorf("s3 part size %d below minimum %d", opts.PartSize, manager.MinUploadPartSize)
+	}
+	if opts.UploadConcurrency < 0 {
+		return nil, fmt.Errorf("invalid s3 upload concurrency %d", opts.UploadConcurrency)
+	}
+	if opts.ListConcurrency < 0 {
+		return nil, fmt.Errorf("invalid s3 list concurrency %d", opts.ListConcurrency)
+	}
 	var cfgOpts []func(*config.LoadOptions) error
-	if region != "" {
-		cfgOpts = append(cfgOpts, config.WithRegion(region))
+	if opts.Region != "" {
+		cfgOpts = append(cfgOpts, config.WithRegion(opts.Region))
 	}
 	cfg, err := config.LoadDefaultConfig(ctx, cfgOpts...)
 	if err != nil {
 		return nil, fmt.Errorf("load AWS config: %w", err)
 	}
+	if err := applyProxy(&cfg, opts.Proxy); err != nil {
+		return nil, err
+	}
 
 	// Configure client options for S3-compatible storage (MinIO, etc.)
 	var clientOpts []func(*s3.Options)
-	if endpoint != "" {
+	if opts.Endpoint != "" {
 		clientOpts = append(clientOpts, func(o *s3.Options) {
-			o.BaseEndpoint = aws.String(endpoint)
+			o.BaseEndpoint = aws.String(opts.Endpoint)
 			o.UsePathStyle = true // Required for MinIO and most S3-compatible storage
 		})
 	}
 
 	client := s3.NewFromConfig(cfg, clientOpts...)
-	uploader := manager.NewUploader(client)
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		if opts.PartSize > 0 {
+			u.PartSize = opts.PartSize
+		}
+		if opts.UploadConcurrency > 0 {
+			u.Concurrency = opts.UploadConcurrency
+		}
+	})
 	return &S3Backend{
 		client:      client,
 		uploader:    uploader,
 		bucket:      bucket,
 		prefix:      prefix,
-		repomdKey:   keyJoin(prefix, "repodata/repomd.xml"),
-		tempPrefix:  keyJoin(prefix, "repodata/.tmp"),
-		disableETag: disableETag,
+		repomdKey:   keyJoin(prefix, DefaultRepodataDir+"/repomd.xml"),
+		tempPrefix:  keyJoin(prefix, DefaultRepodataDir+"/.tmp"),
+		disableETag: opts.DisableETag,
+		repodataDir: DefaultRepodataDir,
+
+		listConcurrency: opts.ListConcurrency,
+		listRetryer:     retry.NewAdaptiveMode(),
+
+		cacheControl:       defaultString(opts.CacheControl, DefaultCacheControl),
+		repomdCacheControl: defaultString(opts.RepomdCacheControl, DefaultRepomdCacheControl),
 	}, nil
 }
 
+// applyProxy routes the config's HTTP client through proxy. The SDK's buildable client is extended
+// rather than replaced, so a custom CA bundle (AWS_CA_BUNDLE) loaded with the config still applies.
+func applyProxy(cfg *aws.Config, proxy string) error {
+	if proxy == "" {
+		return nil
+	}
+	proxyURL, err := parseProxyURL(proxy)
+	if err != nil {
+		return err
+	}
+	client, ok := cfg.HTTPClient.(*awshttp.BuildableClient)
+	if !ok {
+		if cfg.HTTPClient != nil {
+			return fmt.Errorf("cannot set proxy on HTTP client %T", cfg.HTTPClient)
+		}
+		client = awshttp.NewBuildableClient()
+	}
+	cfg.HTTPClient = client.WithTransportOptions(func(tr *http.Transport) {
+		tr.Proxy = http.ProxyURL(proxyURL)
+	})
+	return nil
+}
+
+// parseProxyURL validates a proxy URL. A bare host:port is taken as an http proxy.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", proxy, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %q", proxy, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", proxy)
+	}
+	return u, nil
+}
+
+func defaultString(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// SetRateLimit throttles RPM uploads to bytesPerSec; metadata uploads are not throttled. Zero disables throttling.
+func (b *S3Backend) SetRateLimit(bytesPerSec int64) {
+	b.limiter = newRateLimiter(bytesPerSec)
+}
+
+func (b *S3Backend) RepodataDir() string {
+	return b.repodataDir
+}
+
+// SetRepodataDir overrides the metadata directory (default "repodata").
+// It must be called before any repomd.xml read so the ETag is tracked on the right key.
+func (b *S3Backend) SetRepodataDir(dir string) {
+	b.repodataDir = cleanRepodataDir(dir)
+	b.repomdKey = keyJoin(b.prefix, b.repodataDir+"/repomd.xml")
+	b.tempPrefix = keyJoin(b.prefix, b.repodataDir+"/.tmp")
+}
+
 func (b *S3Backend) RepoRoot() string {
 	if b.prefix == "" {
 		return fmt.Sprintf("s3://%s", b.bucket)
@@ -113,7 +259,7 @@ func parseS3URI(uri string) (bucket, prefix string, err error) {
 
 func (b *S3Backend) ListRepodata(ctx context.Context) ([]string, error) {
 	var out []string
-	prefix := keyJoin(b.prefix, "repodata/")
+	prefix := keyJoin(b.prefix, b.repodataDir) + "/"
 	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(b.bucket),
 		Prefix: aws.String(prefix),
@@ -142,6 +288,10 @@ func (b *S3Backend) ReadFile(ctx context.Context, path string) ([]byte, error) {
 		Key:    aws.String(key),
 	})
 	if err != nil {
+		var nsk *s3types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+		}
 		return nil, err
 	}
 	defer obj.Body.Close()
@@ -150,41 +300,146 @@ func (b *S3Backend) ReadFile(ctx context.Context, path string) ([]byte, error) {
 		return nil, err
 	}
 	if !b.disableETag && key == b.repomdKey && obj.ETag != nil {
-		b.repomdETag = strings.Trim(*obj.ETag, "\"")
-		b.ifMatchETag = b.repomdETag
+		b.setRepomdETag(*obj.ETag)
 	}
 	return data, nil
 }
 
+// Open streams path from a GET response body. Unlike ReadFile it does not record the repomd.xml
+// ETag, so it must not be used to read metadata that is later rewritten.
+func (b *S3Backend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	obj, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(path)),
+	})
+	if err != nil {
+		var nsk *s3types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+		}
+		return nil, err
+	}
+	return obj.Body, nil
+}
+
+// isPreconditionFailed reports whether err is S3 rejecting a conditional write. Some providers
+// answer 409 rather than 412 when the condition loses to a concurrent write.
+func isPreconditionFailed(err error) bool {
+	var re *awshttp.ResponseError
+	if !errors.As(err, &re) {
+		return false
+	}
+	status := re.HTTPStatusCode()
+	return status == http.StatusPreconditionFailed || status == http.StatusConflict
+}
+
+// ReadRange fetches length bytes of path starting at offset with a ranged GET.
+func (b *S3Backend) ReadRange(ctx context.Context, path string, offset, length int64) ([]byte, error) {
+	if err := checkRange(offset, length); err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		// An empty HTTP range cannot be expressed; only report whether the object exists.
+		if ok, err := b.Exists(ctx, path); err != nil || !ok {
+			if err == nil {
+				err = &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+			}
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+	obj, err := b.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(b.key(path)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		var nsk *s3types.NoSuchKey
+		if errors.As(err, &nsk) {
+			return nil, &fs.PathError{Op: "read", Path: path, Err: fs.ErrNotExist}
+		}
+		// A range starting past the end of the object is answered with 416.
+		var re *awshttp.ResponseError
+		if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable {
+			return []byte{}, nil
+		}
+		return nil, err
+	}
+	defer obj.Body.Close()
+	return io.ReadAll(obj.Body)
+}
+
+// cachedRepomdETag returns the ETag of the repomd.xml last read or written, or "" if none.
+func (b *S3Backend) cachedRepomdETag() string {
+	b.etagMu.Lock()
+	defer b.etagMu.Unlock()
+	return b.repomdETag
+}
+
+func (b *S3Backend) setRepomdETag(etag string) {
+	b.etagMu.Lock()
+	defer b.etagMu.Unlock()
+	b.repomdETag = strings.Trim(etag, "\"")
+}
+
 func (b *S3Backend) WriteFile(ctx context.Context, path string, data []byte) error {
 	key := b.key(path)
 	// If writing repodata assets, stage under temp prefix before final put.
-	if strings.HasPrefix(path, "repodata/") && !strings.HasSuffix(path, "repomd.xml") {
-		tmpKey := b.stageKey(path)
-		if err := b.putObject(ctx, tmpKey, data); err != nil {
+	if strings.HasPrefix(path, b.repodataDir+"/") && !strings.HasSuffix(path, "repomd.xml") {
+		tmpKey, err := b.stageKey(path)
+		if err != nil {
 			return err
 		}
-		if err := b.copyObject(ctx, tmpKey, key); err != nil {
+		// CopyObject carries Content-Type and Cache-Control over from the staged object.
+		if err := b.putObject(ctx, tmpKey, path, bytes.NewReader(data)); err != nil {
 			return err
 		}
-		// Clean up temp file after successful copy
-		_, _ = b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(b.bucket),
-			Key:    aws.String(tmpKey),
-		})
+		// Remove exactly the staged object this write created, whether or not the copy succeeded.
+		defer func() {
+			_, _ = b.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(b.bucket),
+				Key:    aws.String(tmpKey),
+			})
+		}()
+		return b.copyObject(ctx, tmpKey, key)
+	}
+	// repomd.xml is always written conditionally: on the ETag from our read, or, if it was never
+	// read, on it not existing yet, so of two racing inits only the first succeeds.
+	if !b.disableETag && key == b.repomdKey {
+		input := &s3.PutObjectInput{
+			Bucket:       aws.String(b.bucket),
+			Key:          aws.String(key),
+			Body:         bytes.NewReader(data),
+			ContentType:  aws.String(contentTypeFor(path)),
+			CacheControl: aws.String(b.cacheControlFor(path)),
+		}
+		etag := b.cachedRepomdETag()
+		if etag != "" {
+			input.IfMatch = aws.String(etag)
+		} else {
+			input.IfNoneMatch = aws.String("*")
+		}
+		out, err := b.client.PutObject(ctx, input)
+		if isPreconditionFailed(err) {
+			if etag == "" {
+				return fmt.Errorf("conflict: %s was created by another writer", path)
+			}
+			return fmt.Errorf("conflict: %s changed since read (etag %s)", path, etag)
+		}
+		if err != nil {
+			return err
+		}
+		// Later writes in this process are conditional on our own version, not the one we replaced.
+		if out.ETag != nil {
+			b.setRepomdETag(*out.ETag)
+		}
 		return nil
 	}
-	// For repomd.xml apply conditional put if we have an ETag from read.
-	if !b.disableETag && strings.HasSuffix(path, "repomd.xml") && b.ifMatchETag != "" {
-		_, err := b.client.PutObject(ctx, &s3.PutObjectInput{
-			Bucket:  aws.String(b.bucket),
-			Key:     aws.String(key),
-			Body:    bytes.NewReader(data),
-			IfMatch: aws.String(b.ifMatchETag),
-		})
-		return err
+	var body io.Reader = bytes.NewReader(data)
+	if !strings.HasPrefix(path, b.repodataDir+"/") {
+		body = throttleReader(ctx, body, b.limiter)
 	}
-	return b.putObject(ctx, key, data)
+	return b.putObject(ctx, key, path, body)
 }
 
 func (b *S3Backend) DeleteFile(ctx context.Context, path string) error {
@@ -196,6 +451,23 @@ func (b *S3Backend) DeleteFile(ctx context.Context, path string) error {
 	return err
 }
 
+// Copy copies srcPath to dstPath server-side with CopyObject; object bytes never pass through the client.
+// Content-Type and Cache-Control are carried over from the source object.
+func (b *S3Backend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	err := b.copyObject(ctx, b.key(srcPath), b.key(dstPath))
+	if isNoSuchKey(err) {
+		return &fs.PathError{Op: "copy", Path: srcPath, Err: fs.ErrNotExist}
+	}
+	return err
+}
+
+// isNoSuchKey reports whether err is S3 answering NoSuchKey. CopyObject does not model the error,
+// so it arrives as a generic smithy.APIError rather than *s3types.NoSuchKey.
+func isNoSuchKey(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey"
+}
+
 func (b *S3Backend) Exists(ctx context.Context, path string) (bool, error) {
 	key := b.key(path)
 	_, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
@@ -212,38 +484,147 @@ func (b *S3Backend) Exists(ctx context.Context, path string) (bool, error) {
 	return false, err
 }
 
-func (b *S3Backend) ListRPMs(ctx context.Context) ([]string, error) {
-	var out []string
-	paginator := s3.NewListObjectsV2Paginator(b.client, &s3.ListObjectsV2Input{
+// ModTime returns the object's LastModified time, which S3 records to the second.
+func (b *S3Backend) ModTime(ctx context.Context, path string) (time.Time, error) {
+	out, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(b.bucket),
-		Prefix: aws.String(keyJoin(b.prefix, "")),
+		Key:    aws.String(b.key(path)),
 	})
+	var nfe *s3types.NotFound
+	if errors.As(err, &nfe) {
+		return time.Time{}, &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return aws.ToTime(out.LastModified), nil
+}
+
+// ListRPMs lists the RPMs under the repo root. With a list concurrency above one, the top level
+// is listed with a delimiter and each directory found is then listed in parallel, which pays off
+// for name- or letter-sharded layouts; a flat layout is fully listed by the first request.
+// Parallel listings use the SDK's adaptive retry mode, which adds jittered backoff and slows the
+// request rate when S3 answers SlowDown.
+func (b *S3Backend) ListRPMs(ctx context.Context) ([]string, error) {
+	listPrefix := rootListPrefix(b.prefix)
+	if b.listConcurrency <= 1 {
+		out, _, err := b.listRPMKeys(ctx, listPrefix, listPrefix, "")
+		return out, err
+	}
+	out, dirs, err := b.listRPMKeys(ctx, listPrefix, listPrefix, "/")
+	if err != nil {
+		return nil, err
+	}
+	repodataPrefix := listPrefix + b.repodataDir + "/"
+	dirs = slices.DeleteFunc(dirs, func(dir string) bool { return dir == repodataPrefix })
+	if len(dirs) == 0 {
+		return out, nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu       sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	work := make(chan string)
+	for i := 0; i < min(b.listConcurrency, len(dirs)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dir := range work {
+				rels, _, err := b.listRPMKeys(ctx, dir, listPrefix, "")
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("list %s: %w", dir, err)
+					cancel()
+				}
+				out = append(out, rels...)
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, dir := range dirs {
+		select {
+		case work <- dir:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// listRPMKeys lists keys under prefix and returns the repo-relative RPM paths among them. With a
+// delimiter it also returns the common prefixes (subdirectories) found.
+func (b *S3Backend) listRPMKeys(ctx context.Context, prefix, listPrefix, delimiter string) ([]string, []string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(prefix),
+	}
+	var optFns []func(*s3.Options)
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	if b.listConcurrency > 1 {
+		optFns = append(optFns, func(o *s3.Options) { o.Retryer = b.listRetryer })
+	}
+	var out, dirs []string
+	paginator := s3.NewListObjectsV2Paginator(b.client, input)
 	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+		page, err := paginator.NextPage(ctx, optFns...)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		for _, obj := range page.Contents {
 			if obj.Key == nil {
 				continue
 			}
-			key := *obj.Key
-			rel := strings.TrimPrefix(key, keyJoin(b.prefix, ""))
-			rel = strings.TrimPrefix(rel, "/")
-			if strings.HasPrefix(rel, "repodata/") {
-				continue
-			}
-			if strings.HasSuffix(rel, ".rpm") {
+			if rel, ok := rpmKeyRel(*obj.Key, listPrefix, b.repodataDir); ok {
 				out = append(out, rel)
 			}
 		}
+		for _, cp := range page.CommonPrefixes {
+			if cp.Prefix != nil {
+				dirs = append(dirs, *cp.Prefix)
+			}
+		}
 	}
-	return out, nil
+	return out, dirs, nil
+}
+
+// rootListPrefix returns the key prefix covering everything under the repo root. It ends in "/"
+// so a repo at "repos/el9" does not list the objects of a sibling such as "repos/el9-debug".
+func rootListPrefix(prefix string) string {
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// rpmKeyRel maps a listed key to its repo-relative RPM path. It reports false for keys outside
+// listPrefix, under the repodata directory, or not ending in .rpm.
+func rpmKeyRel(key, listPrefix, repodataDir string) (string, bool) {
+	rel, ok := strings.CutPrefix(key, listPrefix)
+	if !ok || rel == "" {
+		return "", false
+	}
+	if strings.HasPrefix(rel, repodataDir+"/") || !strings.HasSuffix(rel, ".rpm") {
+		return "", false
+	}
+	return rel, true
 }
 
 // CheckRepomdUnchanged compares the current repomd ETag with the cached one.
 func (b *S3Backend) CheckRepomdUnchanged(ctx context.Context) error {
-	if b.disableETag || b.repomdETag == "" {
+	cached := b.cachedRepomdETag()
+	if b.disableETag || cached == "" {
 		return nil
 	}
 	head, err := b.client.HeadObject(ctx, &s3.HeadObjectInput{
@@ -254,31 +635,80 @@ func (b *S3Backend) CheckRepomdUnchanged(ctx context.Context) error {
 		return err
 	}
 	current := strings.Trim(aws.ToString(head.ETag), "\"")
-	if current != b.repomdETag {
-		return fmt.Errorf("conflict: repomd.xml changed since read (etag %s -> %s)", b.repomdETag, current)
+	if current != cached {
+		return fmt.Errorf("conflict: repomd.xml changed since read (etag %s -> %s)", cached, current)
 	}
 	return nil
 }
 
-func (b *S3Backend) putObject(ctx context.Context, key string, data []byte) error {
+// putObject uploads body to key with headers chosen for the repository path p.
+func (b *S3Backend) putObject(ctx context.Context, key, p string, body io.Reader) error {
 	_, err := b.uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket: aws.String(b.bucket),
-		Key:    aws.String(key),
-		Body:   bytes.NewReader(data),
+		Bucket:       aws.String(b.bucket),
+		Key:          aws.String(key),
+		Body:         body,
+		ContentType:  aws.String(contentTypeFor(p)),
+		CacheControl: aws.String(b.cacheControlFor(p)),
 	})
 	return err
 }
 
+// contentTypeFor returns the Content-Type for a repository path.
+func contentTypeFor(p string) string {
+	switch {
+	case strings.HasSuffix(p, ".rpm"):
+		return "application/x-rpm"
+	case strings.HasSuffix(p, ".xml"):
+		return "application/xml"
+	case strings.HasSuffix(p, ".gz"):
+		return "application/gzip"
+	case strings.HasSuffix(p, ".bz2"):
+		return "application/x-bzip2"
+	case strings.HasSuffix(p, ".asc"):
+		return "application/pgp-signature"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// cacheControlFor returns the Cache-Control for a repository path.
+func (b *S3Backend) cacheControlFor(p string) string {
+	if p == b.repodataDir+"/repomd.xml" || p == b.repodataDir+"/repomd.xml.asc" {
+		return b.repomdCacheControl
+	}
+	// Plain-named core metadata (primary.xml.gz) is rewritten in place and must not be cached as immutable.
+	if dir, name := path.Split(p); path.Clean(dir) == b.repodataDir && ChecksumPrefix(name) == "" {
+		return b.repomdCacheControl
+	}
+	return b.cacheControl
+}
+
 func (b *S3Backend) copyObject(ctx context.Context, srcKey, dstKey string) error {
 	_, err := b.client.CopyObject(ctx, &s3.CopyObjectInput{
 		Bucket:     aws.String(b.bucket),
-		CopySource: aws.String(path.Join("/", b.bucket, srcKey)),
+		CopySource: aws.String(copySource(b.bucket, srcKey)),
 		Key:        aws.String(dstKey),
 	})
 	return err
 }
 
-func (b *S3Backend) stageKey(path string) string {
-	base := strings.TrimPrefix(path, "repodata/")
-	return keyJoin(b.tempPrefix, base)
+// copySource returns the URL-encoded CopySource for key. RPM names routinely contain "+"
+// (libstdc++), which S3 would otherwise decode as a space.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, seg := range segments {
+		segments[i] = strings.ReplaceAll(url.QueryEscape(seg), "+", "%20")
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
+
+// stageKey returns a unique staging key for path. The random suffix keeps concurrent publishers
+// writing the same file from overwriting each other's staged objects.
+func (b *S3Backend) stageKey(path string) (string, error) {
+	var token [8]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return "", fmt.Errorf("generate staging key: %w", err)
+	}
+	base := strings.TrimPrefix(path, b.repodataDir+"/")
+	return keyJoin(b.tempPrefix, base+"."+hex.EncodeToString(token[:])), nil
 }
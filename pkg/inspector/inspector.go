@@ -2,8 +2,11 @@ package inspector
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io/fs"
+	"strconv"
+	"strings"
 
 	"github.com/cavaliergopher/rpm"
 
@@ -12,14 +15,26 @@ import (
 
 // InspectRPM parses RPM data and builds metadata.Package describing it.
 func InspectRPM(rpmPath string, rpmData []byte, info fs.FileInfo, checksumAlg, destRelPath string) (metadata.Package, error) {
+	pkg, _, err := InspectRPMChecksums(rpmPath, rpmData, info, checksumAlg, destRelPath)
+	return pkg, err
+}
+
+// InspectRPMChecksums is InspectRPM that also returns the RPM's checksums for extraAlgs (and
+// checksumAlg), keyed by lower-case algorithm, computed in the same pass as the pkgid.
+func InspectRPMChecksums(rpmPath string, rpmData []byte, info fs.FileInfo, checksumAlg, destRelPath string, extraAlgs ...string) (metadata.Package, map[string]string, error) {
 	pkg, err := rpm.Read(bytes.NewReader(rpmData))
 	if err != nil {
-		return metadata.Package{}, fmt.Errorf("parse rpm %s: %w", rpmPath, err)
+		return metadata.Package{}, nil, fmt.Errorf("parse rpm %s: %w", rpmPath, err)
 	}
 
-	pkgID, err := metadata.ComputeChecksum(rpmData, checksumAlg)
+	sums, err := metadata.ComputeChecksums(rpmData, append([]string{checksumAlg}, extraAlgs...)...)
+	if err != nil {
+		return metadata.Package{}, nil, fmt.Errorf("checksum rpm %s: %w", rpmPath, err)
+	}
+	pkgID := sums[strings.ToLower(checksumAlg)]
+	signed, sigKeyID, err := ReadSignature(rpmData)
 	if err != nil {
-		return metadata.Package{}, fmt.Errorf("checksum rpm %s: %w", rpmPath, err)
+		return metadata.Package{}, nil, fmt.Errorf("parse rpm %s: %w", rpmPath, err)
 	}
 
 	start, end := pkg.HeaderRange()
@@ -33,7 +48,7 @@ func InspectRPM(rpmPath string, rpmData []byte, info fs.FileInfo, checksumAlg, d
 
 	out := metadata.Package{
 		Name:          pkg.Name(),
-		Arch:          pkg.Architecture(),
+		Arch:          NormalizeArch(pkg.Architecture()),
 		Epoch:         pkg.Epoch(),
 		Version:       pkg.Version(),
 		Release:       pkg.Release(),
@@ -56,15 +71,95 @@ func InspectRPM(rpmPath string, rpmData []byte, info fs.FileInfo, checksumAlg, d
 		ChecksumType:  checksumAlg,
 		HeaderStart:   start,
 		HeaderEnd:     end,
+		Signed:        signed,
+		SigKeyID:      sigKeyID,
 		Provides:      depsFromRPM(pkg.Provides()),
 		Requires:      depsFromRPM(pkg.Requires()),
 		Conflicts:     depsFromRPM(pkg.Conflicts()),
 		Obsoletes:     depsFromRPM(pkg.Obsoletes()),
+		Recommends:    weakDepsFromRPM(pkg, tagRecommendName, tagRecommendVersion, tagRecommendFlags),
+		Suggests:      weakDepsFromRPM(pkg, tagSuggestName, tagSuggestVersion, tagSuggestFlags),
+		Supplements:   weakDepsFromRPM(pkg, tagSupplementName, tagSupplementVersion, tagSupplementFlags),
+		Enhances:      weakDepsFromRPM(pkg, tagEnhanceName, tagEnhanceVersion, tagEnhanceFlags),
 	}
 
 	out.Files = filesFromRPM(pkg.Files())
+	out.FileDigestType = fileDigestType(pkg)
 	out.Changelogs = changelogsFromRPM(pkg)
-	return out, nil
+	return out, sums, nil
+}
+
+// knownArches are the architectures rpm builds packages for, plus the source arches.
+var knownArches = map[string]bool{
+	"noarch": true, "src": true, "nosrc": true,
+	"i386": true, "i486": true, "i586": true, "i686": true, "athlon": true, "geode": true, "pentium3": true, "pentium4": true,
+	"x86_64": true, "x86_64_v2": true, "x86_64_v3": true, "x86_64_v4": true, "ia32e": true, "ia64": true,
+	"aarch64": true, "armv5tel": true, "armv6l": true, "armv6hl": true, "armv7l": true, "armv7hl": true, "armv7hnl": true,
+	"ppc": true, "ppc64": true, "ppc64le": true, "ppc64p7": true, "s390": true, "s390x": true,
+	"riscv64": true, "loongarch64": true, "mips": true, "mipsel": true, "mips64": true, "mips64el": true,
+	"sparc": true, "sparcv9": true, "sparc64": true, "alpha": true,
+}
+
+// archAliases maps arch names used by other packaging tools to the rpm spelling.
+var archAliases = map[string]string{
+	"amd64":   "x86_64",
+	"x86-64":  "x86_64",
+	"arm64":   "aarch64",
+	"ppc64el": "ppc64le",
+}
+
+// NormalizeArch lowercases arch, trims surrounding space, and maps known aliases (amd64, arm64,
+// ...) to the name rpm uses, so clients match the package against their own arch.
+func NormalizeArch(arch string) string {
+	arch = strings.ToLower(strings.TrimSpace(arch))
+	if alias, ok := archAliases[arch]; ok {
+		return alias
+	}
+	return arch
+}
+
+// CheckArch returns an error if arch is empty or is neither an architecture rpm knows nor one of
+// allowed. Callers treat it as a warning: custom arches are valid in private repositories.
+func CheckArch(arch string, allowed []string) error {
+	if arch == "" {
+		return fmt.Errorf("empty arch")
+	}
+	if knownArches[arch] {
+		return nil
+	}
+	for _, a := range allowed {
+		if NormalizeArch(a) == arch {
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized arch %q", arch)
+}
+
+// headerMagic starts every RPM header structure (magic plus version 1).
+var headerMagic = []byte{0x8E, 0xAD, 0xE8, 0x01}
+
+// ValidateHeader checks that data holds exactly one RPM header structure, as addressed by a
+// package's header range: the header magic, an index and data store whose declared sizes add up
+// to len(data), and index entries that point into the store.
+func ValidateHeader(data []byte) error {
+	if len(data) < 16 {
+		return fmt.Errorf("header too short: %d bytes", len(data))
+	}
+	if !bytes.Equal(data[:4], headerMagic) {
+		return fmt.Errorf("bad header magic %x", data[:4])
+	}
+	entries := uint64(binary.BigEndian.Uint32(data[8:]))
+	storeSize := uint64(binary.BigEndian.Uint32(data[12:]))
+	if want := 16 + 16*entries + storeSize; want != uint64(len(data)) {
+		return fmt.Errorf("header declares %d bytes (%d index entries, %d bytes of data) but the range holds %d", want, entries, storeSize, len(data))
+	}
+	for i := uint64(0); i < entries; i++ {
+		entry := data[16+16*i:]
+		if offset := uint64(binary.BigEndian.Uint32(entry[8:])); offset >= storeSize {
+			return fmt.Errorf("index entry %d (tag %d) points past the data store", i, binary.BigEndian.Uint32(entry))
+		}
+	}
+	return nil
 }
 
 func depsFromRPM(deps []rpm.Dependency) []metadata.Relation {
@@ -83,6 +178,56 @@ func depsFromRPM(deps []rpm.Dependency) []metadata.Relation {
 	return out
 }
 
+// Weak dependency header tags (name, version, flags). They are read directly rather than through
+// rpm.Package.Supplements, which takes its flags from the suggests tag.
+const (
+	tagRecommendName     = 5046
+	tagRecommendVersion  = 5047
+	tagRecommendFlags    = 5048
+	tagSuggestName       = 5049
+	tagSuggestVersion    = 5050
+	tagSuggestFlags      = 5051
+	tagSupplementName    = 5052
+	tagSupplementVersion = 5053
+	tagSupplementFlags   = 5054
+	tagEnhanceName       = 5055
+	tagEnhanceVersion    = 5056
+	tagEnhanceFlags      = 5057
+)
+
+// weakDepsFromRPM reads one weak dependency type. Entries without a matching version or flags value
+// are kept unversioned instead of failing the whole package.
+func weakDepsFromRPM(pkg *rpm.Package, nameTag, versionTag, flagsTag int) []metadata.Relation {
+	names := pkg.Header.GetTag(nameTag).StringSlice()
+	versions := pkg.Header.GetTag(versionTag).StringSlice()
+	flags := pkg.Header.GetTag(flagsTag).Int64Slice()
+	var out []metadata.Relation
+	for i, name := range names {
+		rel := metadata.Relation{Name: name}
+		if i < len(flags) && i < len(versions) {
+			rel.Flags, _ = depFlagsToString(int(flags[i]))
+			if rel.Flags != "" {
+				rel.Epoch, rel.Ver, rel.Rel = splitEVR(versions[i])
+			}
+		}
+		out = append(out, rel)
+	}
+	return out
+}
+
+// splitEVR splits an [epoch:]version[-release] dependency version.
+func splitEVR(evr string) (int, string, string) {
+	epoch := 0
+	if e, rest, ok := strings.Cut(evr, ":"); ok {
+		if n, err := strconv.Atoi(e); err == nil {
+			epoch = n
+		}
+		evr = rest
+	}
+	ver, rel, _ := strings.Cut(evr, "-")
+	return epoch, ver, rel
+}
+
 func filesFromRPM(files []rpm.FileInfo) []metadata.File {
 	var out []metadata.File
 	for _, f := range files {
@@ -93,13 +238,41 @@ func filesFromRPM(files []rpm.FileInfo) []metadata.File {
 			ftype = "dir"
 		}
 		out = append(out, metadata.File{
-			Path: f.Name(),
-			Type: ftype,
+			Path:   f.Name(),
+			Type:   ftype,
+			Mode:   f.Mode(),
+			Size:   f.Size(),
+			Digest: f.Digest(),
 		})
 	}
 	return out
 }
 
+// fileDigestType names the algorithm of the header's file digests (FILEDIGESTALGO, tag 5011),
+// which holds an OpenPGP hash algorithm id. Packages without the tag use MD5.
+func fileDigestType(pkg *rpm.Package) string {
+	algo := int64(1)
+	if v := pkg.Header.GetTag(5011).Int64Slice(); len(v) > 0 {
+		algo = v[0]
+	}
+	switch algo {
+	case 1:
+		return "md5"
+	case 2:
+		return "sha1"
+	case 8:
+		return "sha256"
+	case 9:
+		return "sha384"
+	case 10:
+		return "sha512"
+	case 11:
+		return "sha224"
+	default:
+		return fmt.Sprintf("unknown-%d", algo)
+	}
+}
+
 func changelogsFromRPM(pkg *rpm.Package) []metadata.Changelog {
 	// Changelog fields use tag IDs 1080/1081/1082.
 	times := pkg.Header.GetTag(1080).Int64Slice()
@@ -121,15 +294,15 @@ func depFlagsToString(flags int) (string, bool) {
 	pre := flags&rpm.DepFlagPrereq != 0
 	switch {
 	case flags&rpm.DepFlagLesserOrEqual == rpm.DepFlagLesserOrEqual:
-		return "LE", pre
+		return metadata.DepFlagLE, pre
 	case flags&rpm.DepFlagGreaterOrEqual == rpm.DepFlagGreaterOrEqual:
-		return "GE", pre
+		return metadata.DepFlagGE, pre
 	case flags&rpm.DepFlagLesser == rpm.DepFlagLesser:
-		return "LT", pre
+		return metadata.DepFlagLT, pre
 	case flags&rpm.DepFlagGreater == rpm.DepFlagGreater:
-		return "GT", pre
+		return metadata.DepFlagGT, pre
 	case flags&rpm.DepFlagEqual == rpm.DepFlagEqual:
-		return "EQ", pre
+		return metadata.DepFlagEQ, pre
 	default:
 		return "", pre
 	}
@@ -1,7 +1,12 @@
 package inspector
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -58,6 +63,50 @@ func TestMinLen(t *testing.T) {
 	}
 }
 
+func TestNormalizeArch(t *testing.T) {
+	tests := []struct {
+		arch string
+		want string
+	}{
+		{"x86_64", "x86_64"},
+		{"X86_64", "x86_64"},
+		{" noarch ", "noarch"},
+		{"AMD64", "x86_64"},
+		{"arm64", "aarch64"},
+		{"ppc64el", "ppc64le"},
+		{"", ""},
+		{"Custom", "custom"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeArch(tt.arch); got != tt.want {
+			t.Errorf("NormalizeArch(%q) = %q, want %q", tt.arch, got, tt.want)
+		}
+	}
+}
+
+func TestCheckArch(t *testing.T) {
+	tests := []struct {
+		arch    string
+		allowed []string
+		wantErr bool
+	}{
+		{"x86_64", nil, false},
+		{"src", nil, false},
+		{"aarch64", nil, false},
+		{"", nil, true},
+		{"", []string{""}, true},
+		{"custom", nil, true},
+		{"custom", []string{"other", "Custom"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := CheckArch(tt.arch, tt.allowed); (err != nil) != tt.wantErr {
+			t.Errorf("CheckArch(%q, %v) = %v, want error %v", tt.arch, tt.allowed, err, tt.wantErr)
+		}
+	}
+}
+
 func TestFilesFromRPMEmpty(t *testing.T) {
 	files := filesFromRPM(nil)
 	if files != nil {
@@ -125,3 +174,187 @@ func (m mockFileInfo) Sys() interface{}   { return nil }
 
 // Ensure Package type is correctly used (compile-time check)
 var _ metadata.Package
+
+func TestWeakDepsFromRPM(t *testing.T) {
+	pkg := &rpm.Package{Header: rpm.Header{Tags: map[int]*rpm.Tag{
+		tagSupplementName:    {ID: tagSupplementName, Value: []string{"foo-lang", "bar"}},
+		tagSupplementVersion: {ID: tagSupplementVersion, Value: []string{"1:2.0-3", ""}},
+		tagSupplementFlags:   {ID: tagSupplementFlags, Value: []int64{int64(rpm.DepFlagGreaterOrEqual), 0}},
+		tagRecommendName:     {ID: tagRecommendName, Value: []string{"orphan"}},
+	}}}
+
+	got := weakDepsFromRPM(pkg, tagSupplementName, tagSupplementVersion, tagSupplementFlags)
+	want := []metadata.Relation{
+		{Name: "foo-lang", Flags: "GE", Epoch: 1, Ver: "2.0", Rel: "3"},
+		{Name: "bar"},
+	}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("supplements = %+v, want %+v", got, want)
+	}
+	// Names without version/flags tags must not panic and stay unversioned.
+	if got := weakDepsFromRPM(pkg, tagRecommendName, tagRecommendVersion, tagRecommendFlags); len(got) != 1 || got[0].Flags != "" {
+		t.Fatalf("recommends = %+v", got)
+	}
+	if got := weakDepsFromRPM(pkg, tagSuggestName, tagSuggestVersion, tagSuggestFlags); got != nil {
+		t.Fatalf("suggests = %+v, want none", got)
+	}
+}
+
+// buildTestRPM assembles a minimal RPM: a v3 lead, an empty signature header, and a main header
+// holding the given string tags. It is enough for rpm.Read and InspectRPM.
+func buildTestRPM(tags map[int]string) []byte {
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xED, 0xAB, 0xEE, 0xDB, 3, 0})
+	buf.Write(lead)
+	writeHeader := func(ids []int, values map[int]string) {
+		var index, store bytes.Buffer
+		for _, id := range ids {
+			entry := make([]byte, 16)
+			binary.BigEndian.PutUint32(entry[0:], uint32(id))
+			binary.BigEndian.PutUint32(entry[4:], uint32(rpm.TagTypeString))
+			binary.BigEndian.PutUint32(entry[8:], uint32(store.Len()))
+			binary.BigEndian.PutUint32(entry[12:], 1)
+			index.Write(entry)
+			store.WriteString(values[id])
+			store.WriteByte(0)
+		}
+		intro := make([]byte, 16)
+		copy(intro, []byte{0x8E, 0xAD, 0xE8, 0x01})
+		binary.BigEndian.PutUint32(intro[8:], uint32(len(ids)))
+		binary.BigEndian.PutUint32(intro[12:], uint32(store.Len()))
+		buf.Write(intro)
+		buf.Write(index.Bytes())
+		buf.Write(store.Bytes())
+	}
+	writeHeader(nil, nil) // signature; empty, so no padding is needed
+	ids := make([]int, 0, len(tags))
+	for id := range tags {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	writeHeader(ids, tags)
+	return buf.Bytes()
+}
+
+func TestInspectedRPMEmitsHeaderRange(t *testing.T) {
+	data := buildTestRPM(map[int]string{1000: "foo", 1001: "1.0", 1002: "1", 1022: "noarch"})
+	pkg, err := InspectRPM("foo-1.0-1.noarch.rpm", data, mockFileInfo{size: int64(len(data))}, "sha256", "foo-1.0-1.noarch.rpm")
+	if err != nil {
+		t.Fatalf("InspectRPM: %v", err)
+	}
+	if pkg.NEVRA() != "foo-1.0-1.noarch" || pkg.HeaderStart != 96+16 || pkg.HeaderEnd <= pkg.HeaderStart {
+		t.Fatalf("unexpected package %s with header range %d-%d", pkg.NEVRA(), pkg.HeaderStart, pkg.HeaderEnd)
+	}
+	primary, _, _, err := metadata.RenderCoreXML([]metadata.Package{pkg})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	want := fmt.Sprintf(`<rpm:header-range start="%d" end="%d">`, pkg.HeaderStart, pkg.HeaderEnd)
+	if !strings.Contains(string(primary), want) {
+		t.Fatalf("primary.xml missing %s:\n%s", want, primary)
+	}
+
+	// A header starting at offset 0 is still written; packages without a header are not.
+	pkg.HeaderStart = 0
+	synthetic := metadata.Package{Name: "bar", Arch: "noarch", Version: "1", Release: "1", ChecksumType: "sha256", PkgID: "x", Location: "bar.rpm"}
+	primary, _, _, err = metadata.RenderCoreXML([]metadata.Package{pkg, synthetic})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if n := strings.Count(string(primary), "<rpm:header-range"); n != 1 || !strings.Contains(string(primary), `start="0"`) {
+		t.Fatalf("expected one header-range with start=\"0\", got %d:\n%s", n, primary)
+	}
+}
+
+func TestValidateHeader(t *testing.T) {
+	data := buildTestRPM(map[int]string{1000: "foo", 1001: "1.0", 1002: "1", 1022: "noarch"})
+	pkg, err := InspectRPM("foo-1.0-1.noarch.rpm", data, mockFileInfo{size: int64(len(data))}, "sha256", "foo-1.0-1.noarch.rpm")
+	if err != nil {
+		t.Fatalf("InspectRPM: %v", err)
+	}
+	if err := ValidateHeader(data[pkg.HeaderStart:pkg.HeaderEnd]); err != nil {
+		t.Fatalf("ValidateHeader on the recorded range: %v", err)
+	}
+	for name, bad := range map[string][]byte{
+		"short":         data[pkg.HeaderStart : pkg.HeaderStart+8],
+		"shifted":       data[pkg.HeaderStart+1 : pkg.HeaderEnd],
+		"truncated":     data[pkg.HeaderStart : pkg.HeaderEnd-1],
+		"signature hdr": data[96:pkg.HeaderEnd],
+	} {
+		if err := ValidateHeader(bad); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// withSignature returns data, an RPM from buildTestRPM, with its empty signature header replaced
+// by one holding sig under tag.
+func withSignature(t *testing.T, data []byte, tag int, sig []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	buf.Write(data[:96])
+	intro := make([]byte, 16)
+	copy(intro, []byte{0x8E, 0xAD, 0xE8, 0x01})
+	binary.BigEndian.PutUint32(intro[8:], 1)
+	binary.BigEndian.PutUint32(intro[12:], uint32(len(sig)))
+	buf.Write(intro)
+	entry := make([]byte, 16)
+	binary.BigEndian.PutUint32(entry[0:], uint32(tag))
+	binary.BigEndian.PutUint32(entry[4:], uint32(rpm.TagTypeBinary))
+	binary.BigEndian.PutUint32(entry[12:], uint32(len(sig)))
+	buf.Write(entry)
+	buf.Write(sig)
+	for buf.Len()%8 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(data[96+16:])
+	return buf.Bytes()
+}
+
+func TestReadSignature(t *testing.T) {
+	data := buildTestRPM(map[int]string{1000: "foo", 1001: "1.0", 1002: "1", 1022: "noarch"})
+	keyID := []byte{0x19, 0x9e, 0x2f, 0x91, 0xfd, 0x43, 0x1d, 0x51}
+
+	// Version 4: issuer subpacket in the unhashed area, in an old-format packet.
+	v4 := []byte{4, 0, 1, 8, 0, 6, 5, 2, 0, 0, 0, 1, 0, 10, 9, 16}
+	v4 = append(v4, keyID...)
+	v4 = append(v4, 0xab, 0xcd)
+	v4 = append([]byte{0x88, byte(len(v4))}, v4...)
+	// Version 3: the key ID is a fixed field, here in a new-format packet.
+	v3 := append([]byte{3, 5, 0, 0, 0, 0, 1}, keyID...)
+	v3 = append(v3, 1, 8, 0xab, 0xcd)
+	v3 = append([]byte{0xC2, byte(len(v3))}, v3...)
+
+	for name, tc := range map[string]struct {
+		data   []byte
+		signed bool
+		keyID  string
+	}{
+		"unsigned":      {data, false, ""},
+		"rsa header v4": {withSignature(t, data, 268, v4), true, "199e2f91fd431d51"},
+		"gpg v3":        {withSignature(t, data, 1005, v3), true, "199e2f91fd431d51"},
+		"unparseable":   {withSignature(t, data, 1002, []byte{0x01, 0x02}), true, ""},
+	} {
+		signed, id, err := ReadSignature(tc.data)
+		if err != nil || signed != tc.signed || id != tc.keyID {
+			t.Errorf("%s: got signed=%v key=%q err=%v, want signed=%v key=%q", name, signed, id, err, tc.signed, tc.keyID)
+		}
+	}
+
+	signedRPM := withSignature(t, data, 268, v4)
+	pkg, err := InspectRPM("foo-1.0-1.noarch.rpm", signedRPM, mockFileInfo{size: int64(len(signedRPM))}, "sha256", "foo-1.0-1.noarch.rpm")
+	if err != nil {
+		t.Fatalf("InspectRPM: %v", err)
+	}
+	if !pkg.Signed || pkg.SigKeyID != "199e2f91fd431d51" {
+		t.Fatalf("inspected package signed=%v key=%q", pkg.Signed, pkg.SigKeyID)
+	}
+	// The bytes before the main header are enough.
+	if signed, id, err := ReadSignature(signedRPM[:pkg.HeaderStart]); err != nil || !signed || id == "" {
+		t.Fatalf("ReadSignature on the signature range: %v %q %v", signed, id, err)
+	}
+	if _, _, err := ReadSignature(signedRPM[:100]); err == nil {
+		t.Fatalf("expected an error for a truncated signature header")
+	}
+}
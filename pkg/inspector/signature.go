@@ -0,0 +1,200 @@
+package inspector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// leadSize is the length of the RPM lead that precedes the signature header.
+const leadSize = 96
+
+// Signature header tags holding an OpenPGP signature: over the header alone (RSA, DSA) or over
+// header and payload (PGP, GPG). Any of them makes a package signed.
+var signatureTags = []uint32{268, 267, 1002, 1005}
+
+// ReadSignature reports whether the RPM in data carries an OpenPGP signature in its signature
+// header and, when the signature names one, the signing key ID in lower-case hex (as rpm -qi
+// shows it). Only the lead and signature header are read, so data may end anywhere after them,
+// e.g. at a package's HeaderStart.
+func ReadSignature(data []byte) (bool, string, error) {
+	if len(data) < leadSize+16 {
+		return false, "", fmt.Errorf("signature header truncated: %d bytes", len(data))
+	}
+	hdr := data[leadSize:]
+	if !bytes.Equal(hdr[:4], headerMagic) {
+		return false, "", fmt.Errorf("bad signature header magic %x", hdr[:4])
+	}
+	entries := uint64(binary.BigEndian.Uint32(hdr[8:]))
+	storeSize := uint64(binary.BigEndian.Uint32(hdr[12:]))
+	if uint64(len(hdr)) < 16+16*entries+storeSize {
+		return false, "", fmt.Errorf("signature header truncated: declares %d index entries and %d bytes of data", entries, storeSize)
+	}
+	store := hdr[16+16*entries : 16+16*entries+storeSize]
+	signed := false
+	for _, want := range signatureTags {
+		for i := uint64(0); i < entries; i++ {
+			entry := hdr[16+16*i:]
+			if binary.BigEndian.Uint32(entry) != want {
+				continue
+			}
+			signed = true
+			offset := uint64(binary.BigEndian.Uint32(entry[8:]))
+			count := uint64(binary.BigEndian.Uint32(entry[12:]))
+			if offset+count > storeSize {
+				return false, "", fmt.Errorf("signature tag %d points past the data store", want)
+			}
+			if keyID := pgpKeyID(store[offset : offset+count]); keyID != "" {
+				return true, keyID, nil
+			}
+		}
+	}
+	return signed, "", nil
+}
+
+// pgpKeyID returns the issuer key ID of the OpenPGP signature packet in sig, or "" if it has none
+// or cannot be parsed. Version 3 signatures carry it in a fixed field; later versions in an issuer
+// or issuer fingerprint subpacket.
+func pgpKeyID(sig []byte) string {
+	body, ok := pgpPacketBody(sig)
+	if !ok || len(body) == 0 {
+		return ""
+	}
+	switch body[0] {
+	case 3:
+		if len(body) < 15 {
+			return ""
+		}
+		return hex.EncodeToString(body[7:15])
+	case 4, 5, 6:
+		// version, type, public-key and hash algorithms, then hashed and unhashed subpackets,
+		// each prefixed with a 2-octet length (4 octets from version 5 on).
+		lenSize := 2
+		if body[0] > 4 {
+			lenSize = 4
+		}
+		rest := body[4:]
+		var fromFingerprint string
+		for i := 0; i < 2; i++ {
+			if len(rest) < lenSize {
+				return fromFingerprint
+			}
+			n := uint64(binary.BigEndian.Uint16(rest))
+			if lenSize == 4 {
+				n = uint64(binary.BigEndian.Uint32(rest))
+			}
+			rest = rest[lenSize:]
+			if uint64(len(rest)) < n {
+				return fromFingerprint
+			}
+			issuer, fingerprint := pgpIssuer(rest[:n])
+			if issuer != "" {
+				return issuer
+			}
+			if fromFingerprint == "" {
+				fromFingerprint = fingerprint
+			}
+			rest = rest[n:]
+		}
+		return fromFingerprint
+	default:
+		return ""
+	}
+}
+
+// pgpIssuer scans OpenPGP signature subpackets for the issuer key ID (type 16) and the key ID
+// derived from the issuer fingerprint (type 33).
+func pgpIssuer(subpackets []byte) (string, string) {
+	var fromFingerprint string
+	for len(subpackets) > 0 {
+		var n uint64
+		switch first := subpackets[0]; {
+		case first < 192:
+			n, subpackets = uint64(first), subpackets[1:]
+		case first < 255:
+			if len(subpackets) < 2 {
+				return "", fromFingerprint
+			}
+			n, subpackets = (uint64(first)-192)<<8+uint64(subpackets[1])+192, subpackets[2:]
+		default:
+			if len(subpackets) < 5 {
+				return "", fromFingerprint
+			}
+			n, subpackets = uint64(binary.BigEndian.Uint32(subpackets[1:])), subpackets[5:]
+		}
+		if n == 0 || uint64(len(subpackets)) < n {
+			return "", fromFingerprint
+		}
+		sub := subpackets[:n]
+		subpackets = subpackets[n:]
+		switch sub[0] & 0x7f {
+		case 16:
+			if len(sub) == 9 {
+				return hex.EncodeToString(sub[1:]), fromFingerprint
+			}
+		case 33:
+			// Key version, then the fingerprint: a v4 key ID is its last 8 octets, later ones its first.
+			if len(sub) < 2+8 {
+				continue
+			}
+			fp := sub[2:]
+			if sub[1] == 4 {
+				fromFingerprint = hex.EncodeToString(fp[len(fp)-8:])
+			} else {
+				fromFingerprint = hex.EncodeToString(fp[:8])
+			}
+		}
+	}
+	return "", fromFingerprint
+}
+
+// pgpPacketBody returns the body of the first OpenPGP packet in data, in old or new packet format.
+func pgpPacketBody(data []byte) ([]byte, bool) {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return nil, false
+	}
+	var n uint64
+	rest := data[1:]
+	if data[0]&0x40 == 0 {
+		// Old format: the low two bits select a 1, 2 or 4 octet length, or an indeterminate one.
+		switch data[0] & 3 {
+		case 0:
+			n, rest = uint64(rest[0]), rest[1:]
+		case 1:
+			if len(rest) < 2 {
+				return nil, false
+			}
+			n, rest = uint64(binary.BigEndian.Uint16(rest)), rest[2:]
+		case 2:
+			if len(rest) < 4 {
+				return nil, false
+			}
+			n, rest = uint64(binary.BigEndian.Uint32(rest)), rest[4:]
+		default:
+			n = uint64(len(rest))
+		}
+	} else {
+		switch first := rest[0]; {
+		case first < 192:
+			n, rest = uint64(first), rest[1:]
+		case first < 224:
+			if len(rest) < 2 {
+				return nil, false
+			}
+			n, rest = (uint64(first)-192)<<8+uint64(rest[1])+192, rest[2:]
+		case first == 255:
+			if len(rest) < 5 {
+				return nil, false
+			}
+			n, rest = uint64(binary.BigEndian.Uint32(rest[1:])), rest[5:]
+		default:
+			// Partial body lengths do not occur in signature packets.
+			return nil, false
+		}
+	}
+	if uint64(len(rest)) < n {
+		return nil, false
+	}
+	return rest[:n], true
+}
@@ -8,15 +8,124 @@ import (
 	"encoding/hex"
 	"encoding/xml"
 	"fmt"
+	"hash"
+	"io"
+	"path"
+	"regexp"
 	"strings"
 	"time"
+
+	"github.com/dsnet/compress/bzip2"
+)
+
+const (
+	CompressionGzip  = "gzip"
+	CompressionBzip2 = "bzip2"
 )
 
 const (
-	CommonNamespace    = "http://linux.duke.edu/metadata/common"
-	FilelistsNamespace = "http://linux.duke.edu/metadata/filelists"
-	OtherNamespace     = "http://linux.duke.edu/metadata/other"
-	RpmNamespace       = "http://linux.duke.edu/metadata/rpm"
+	// FilenameStyleHashed prefixes core filenames with their checksum (<hash>-primary.xml.gz).
+	FilenameStyleHashed = "hashed"
+	// FilenameStylePlain uses fixed core filenames (primary.xml.gz) that are overwritten in place.
+	FilenameStylePlain = "plain"
+)
+
+// CoreLayout controls where and how core metadata files are written.
+// Zero values select the defaults: gzip compression and hashed filenames.
+type CoreLayout struct {
+	Dir           string
+	Compression   string
+	FilenameStyle string
+	// NoFilelists omits the filelists metadata file and its repomd entry.
+	NoFilelists bool
+	// NoOther omits the other (changelog) metadata file and its repomd entry.
+	NoOther bool
+	// ChangelogLimit keeps only the newest this many changelog entries per package in other.xml.
+	// Zero keeps them all.
+	ChangelogLimit int
+	// FilelistsExt adds filelists-ext, filelists with per-file digests, as repomd type
+	// "filelists_ext". It is not written when NoFilelists is set.
+	FilelistsExt bool
+	// PrimaryFilePattern selects the files listed in primary.xml as well as filelists.xml, as a
+	// regular expression matched against each path. Empty uses IsPrimaryFile.
+	PrimaryFilePattern string
+
+	primaryFile func(string) bool
+}
+
+// emits reports whether the layout produces a core file of the given type. primary is always emitted.
+func (l CoreLayout) emits(typ string) bool {
+	switch typ {
+	case "filelists":
+		return !l.NoFilelists
+	case "filelists_ext":
+		return l.FilelistsExt && !l.NoFilelists
+	case "other":
+		return !l.NoOther
+	default:
+		return true
+	}
+}
+
+func (l CoreLayout) normalize() (CoreLayout, error) {
+	l.Compression = strings.ToLower(l.Compression)
+	if !SupportedCompression(l.Compression) {
+		return l, fmt.Errorf("unsupported compression %q", l.Compression)
+	}
+	l.FilenameStyle = strings.ToLower(l.FilenameStyle)
+	if !SupportedFilenameStyle(l.FilenameStyle) {
+		return l, fmt.Errorf("unsupported metadata filename style %q", l.FilenameStyle)
+	}
+	if l.ChangelogLimit < 0 {
+		return l, fmt.Errorf("changelog limit must not be negative")
+	}
+	l.primaryFile = IsPrimaryFile
+	if l.PrimaryFilePattern != "" {
+		re, err := compilePrimaryFilePattern(l.PrimaryFilePattern)
+		if err != nil {
+			return l, err
+		}
+		l.primaryFile = re.MatchString
+	}
+	return l, nil
+}
+
+// DefaultPrimaryFilePattern is the regular expression equivalent of IsPrimaryFile, as used by createrepo.
+const DefaultPrimaryFilePattern = `^(.*bin/.*|/etc/.*|/usr/lib/sendmail)$`
+
+// ValidatePrimaryFilePattern checks that pattern compiles as a primary file pattern. Empty is allowed.
+func ValidatePrimaryFilePattern(pattern string) error {
+	if pattern == "" {
+		return nil
+	}
+	_, err := compilePrimaryFilePattern(pattern)
+	return err
+}
+
+func compilePrimaryFilePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid primary file pattern: %w", err)
+	}
+	return re, nil
+}
+
+// path returns the location for a core metadata file of the given type and compressed checksum.
+// Types with an underscore (filelists_ext) are named with a dash (filelists-ext.xml.gz).
+func (l CoreLayout) path(sum, typ string) string {
+	name := fmt.Sprintf("%s.xml%s", strings.ReplaceAll(typ, "_", "-"), compressionSuffix(l.Compression))
+	if l.FilenameStyle != FilenameStylePlain {
+		name = sum + "-" + name
+	}
+	return path.Join(l.Dir, name)
+}
+
+const (
+	CommonNamespace       = "http://linux.duke.edu/metadata/common"
+	FilelistsNamespace    = "http://linux.duke.edu/metadata/filelists"
+	FilelistsExtNamespace = "http://linux.duke.edu/metadata/filelists-ext"
+	OtherNamespace        = "http://linux.duke.edu/metadata/other"
+	RpmNamespace          = "http://linux.duke.edu/metadata/rpm"
 )
 
 type CoreFile struct {
@@ -44,33 +153,48 @@ type filelistsRoot struct {
 	Packages int      `xml:"packages,attr"`
 }
 
+type filelistsExtRoot struct {
+	XMLName  xml.Name `xml:"filelists-ext"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Packages int      `xml:"packages,attr"`
+}
+
 type otherRoot struct {
 	XMLName  xml.Name `xml:"otherdata"`
 	Xmlns    string   `xml:"xmlns,attr"`
 	Packages int      `xml:"packages,attr"`
 }
 
-// BuildEmptyCoreFiles creates empty primary/filelists/other XML payloads, compresses
+// BuildEmptyCoreFiles creates empty primary/filelists/other XML payloads (minus any the layout omits), compresses
 // them, computes checksums, and prepares a repomd definition using the provided checksum algorithm.
-func BuildEmptyCoreFiles(checksumAlg string, now time.Time) ([]CoreFile, RepoMD, error) {
+// Files are named and compressed according to layout.
+func BuildEmptyCoreFiles(checksumAlg string, layout CoreLayout, now time.Time) ([]CoreFile, RepoMD, error) {
 	checksumAlg = strings.ToLower(checksumAlg)
 	if !SupportedChecksum(checksumAlg) {
 		return nil, RepoMD{}, fmt.Errorf("unsupported checksum algorithm %q", checksumAlg)
 	}
+	layout, err := layout.normalize()
+	if err != nil {
+		return nil, RepoMD{}, err
+	}
 
 	payloads := map[string]interface{}{
-		"primary":   primaryRoot{Xmlns: CommonNamespace, XmlnsRpm: RpmNamespace, Packages: 0},
-		"filelists": filelistsRoot{Xmlns: FilelistsNamespace, Packages: 0},
-		"other":     otherRoot{Xmlns: OtherNamespace, Packages: 0},
+		"primary":       primaryRoot{Xmlns: CommonNamespace, XmlnsRpm: RpmNamespace, Packages: 0},
+		"filelists":     filelistsRoot{Xmlns: FilelistsNamespace, Packages: 0},
+		"other":         otherRoot{Xmlns: OtherNamespace, Packages: 0},
+		"filelists_ext": filelistsExtRoot{Xmlns: FilelistsExtNamespace, Packages: 0},
 	}
 
 	var coreFiles []CoreFile
-	for _, t := range []string{"primary", "filelists", "other"} {
+	for _, t := range []string{"primary", "filelists", "other", "filelists_ext"} {
+		if !layout.emits(t) {
+			continue
+		}
 		xmlBytes, err := marshalWithHeader(payloads[t])
 		if err != nil {
 			return nil, RepoMD{}, err
 		}
-		compressed, err := gzipBytes(xmlBytes)
+		compressed, err := compressBytes(xmlBytes, layout.Compression)
 		if err != nil {
 			return nil, RepoMD{}, err
 		}
@@ -82,7 +206,7 @@ func BuildEmptyCoreFiles(checksumAlg string, now time.Time) ([]CoreFile, RepoMD,
 		if err != nil {
 			return nil, RepoMD{}, err
 		}
-		path := fmt.Sprintf("repodata/%s-%s.xml.gz", sum, t)
+		path := layout.path(sum, t)
 		coreFiles = append(coreFiles, CoreFile{
 			Type:         t,
 			Path:         path,
@@ -114,6 +238,40 @@ func BuildEmptyCoreFiles(checksumAlg string, now time.Time) ([]CoreFile, RepoMD,
 	return coreFiles, repomd, nil
 }
 
+// SupportedCompression reports whether core metadata can be written with the given compression.
+func SupportedCompression(compression string) bool {
+	switch strings.ToLower(compression) {
+	case "", CompressionGzip, CompressionBzip2:
+		return true
+	default:
+		return false
+	}
+}
+
+// SupportedFilenameStyle reports whether style is a known core metadata filename style.
+func SupportedFilenameStyle(style string) bool {
+	switch strings.ToLower(style) {
+	case "", FilenameStyleHashed, FilenameStylePlain:
+		return true
+	default:
+		return false
+	}
+}
+
+func compressionSuffix(compression string) string {
+	if compression == CompressionBzip2 {
+		return ".bz2"
+	}
+	return ".gz"
+}
+
+func compressBytes(content []byte, compression string) ([]byte, error) {
+	if compression == CompressionBzip2 {
+		return bzip2Bytes(content)
+	}
+	return gzipBytes(content)
+}
+
 func marshalWithHeader(v interface{}) ([]byte, error) {
 	body, err := xml.MarshalIndent(v, "", "  ")
 	if err != nil {
@@ -134,17 +292,77 @@ func gzipBytes(content []byte) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+func bzip2Bytes(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	bz, err := bzip2.NewWriter(&buf, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := bz.Write(content); err != nil {
+		return nil, err
+	}
+	if err := bz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func ComputeChecksum(data []byte, alg string) (string, error) {
-	switch strings.ToLower(alg) {
-	case "sha256":
-		sum := sha256.Sum256(data)
-		return hex.EncodeToString(sum[:]), nil
-	case "sha512":
-		sum := sha512.Sum512(data)
-		return hex.EncodeToString(sum[:]), nil
-	default:
-		return "", fmt.Errorf("unsupported checksum algorithm %q", alg)
+	sums, err := ComputeChecksums(data, alg)
+	if err != nil {
+		return "", err
+	}
+	return sums[strings.ToLower(alg)], nil
+}
+
+// ComputeChecksumReader is ComputeChecksum over everything read from rd, hashed as it streams.
+// It also returns the number of bytes read.
+func ComputeChecksumReader(rd io.Reader, alg string) (string, int64, error) {
+	sums, n, err := ComputeChecksumsReader(rd, alg)
+	if err != nil {
+		return "", n, err
+	}
+	return sums[strings.ToLower(alg)], n, nil
+}
+
+// ComputeChecksums returns the hex digest of data for each of algs, keyed by lower-case algorithm
+// name, hashing data once.
+func ComputeChecksums(data []byte, algs ...string) (map[string]string, error) {
+	sums, _, err := ComputeChecksumsReader(bytes.NewReader(data), algs...)
+	return sums, err
+}
+
+// ComputeChecksumsReader is ComputeChecksums over everything read from rd: the stream is read once
+// and fed to every hash. It also returns the number of bytes read.
+func ComputeChecksumsReader(rd io.Reader, algs ...string) (map[string]string, int64, error) {
+	hashes := make(map[string]hash.Hash, len(algs))
+	writers := make([]io.Writer, 0, len(algs))
+	for _, alg := range algs {
+		alg = strings.ToLower(alg)
+		if _, ok := hashes[alg]; ok {
+			continue
+		}
+		var h hash.Hash
+		switch alg {
+		case "sha256":
+			h = sha256.New()
+		case "sha512":
+			h = sha512.New()
+		default:
+			return nil, 0, fmt.Errorf("unsupported checksum algorithm %q", alg)
+		}
+		hashes[alg] = h
+		writers = append(writers, h)
+	}
+	n, err := io.Copy(io.MultiWriter(writers...), rd)
+	if err != nil {
+		return nil, n, err
+	}
+	sums := make(map[string]string, len(hashes))
+	for alg, h := range hashes {
+		sums[alg] = hex.EncodeToString(h.Sum(nil))
 	}
+	return sums, n, nil
 }
 
 // SupportedChecksum reports whether the algorithm is one of the allowed types.
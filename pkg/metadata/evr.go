@@ -0,0 +1,223 @@
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompareEVR orders two packages by epoch, then version, then release using rpm's rules.
+// It returns -1, 0 or 1. Name and arch are not compared.
+func CompareEVR(a, b Package) int {
+	switch {
+	case a.Epoch < b.Epoch:
+		return -1
+	case a.Epoch > b.Epoch:
+		return 1
+	}
+	if c := CompareVersions(a.Version, b.Version); c != 0 {
+		return c
+	}
+	return CompareVersions(a.Release, b.Release)
+}
+
+// CompareVersions compares two version or release strings the way rpmvercmp does: alternating
+// numeric and alphabetic segments, numeric beating alphabetic, "~" sorting before anything
+// (pre-releases) and "^" sorting after the base version but before any further segment.
+func CompareVersions(a, b string) int {
+	if a == b {
+		return 0
+	}
+	for len(a) > 0 || len(b) > 0 {
+		a = strings.TrimLeftFunc(a, isVersionSeparator)
+		b = strings.TrimLeftFunc(b, isVersionSeparator)
+
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			switch {
+			case a == "":
+				return -1
+			case b == "":
+				return 1
+			case !strings.HasPrefix(a, "^"):
+				return 1
+			case !strings.HasPrefix(b, "^"):
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if a == "" || b == "" {
+			break
+		}
+
+		numeric := isDigit(rune(a[0]))
+		segment := isAlpha
+		if numeric {
+			segment = isDigit
+		}
+		segA, restA := splitSegment(a, segment)
+		segB, restB := splitSegment(b, segment)
+		a, b = restA, restB
+		if segB == "" {
+			// Segments of different types: numeric is newer than alphabetic.
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			segA = strings.TrimLeft(segA, "0")
+			segB = strings.TrimLeft(segB, "0")
+			if len(segA) != len(segB) {
+				if len(segA) > len(segB) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(segA, segB); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	default:
+		return 1
+	}
+}
+
+// depOperators maps comparison flags to the operators rpm uses in dependency strings.
+var depOperators = map[string]string{
+	DepFlagEQ: "=",
+	DepFlagLT: "<",
+	DepFlagLE: "<=",
+	DepFlagGT: ">",
+	DepFlagGE: ">=",
+}
+
+// String formats r the way rpm prints dependencies: "name" or "name >= [epoch:]version[-release]".
+func (r Relation) String() string {
+	op, ok := depOperators[r.Flags]
+	if !ok {
+		return r.Name
+	}
+	evr := r.Ver
+	if r.Epoch > 0 {
+		evr = fmt.Sprintf("%d:%s", r.Epoch, evr)
+	}
+	if r.Rel != "" {
+		evr += "-" + r.Rel
+	}
+	return r.Name + " " + op + " " + evr
+}
+
+// ParseRelation parses a dependency string such as "libfoo.so.1()(64bit)" or
+// "bar >= 1:2.0-3". The version part is [epoch:]version[-release].
+func ParseRelation(s string) (Relation, error) {
+	fields := strings.Fields(s)
+	switch len(fields) {
+	case 1:
+		return Relation{Name: fields[0]}, nil
+	case 3:
+	default:
+		return Relation{}, fmt.Errorf("invalid dependency %q: want name or name <op> version", s)
+	}
+	rel := Relation{Name: fields[0]}
+	for flags, op := range depOperators {
+		if fields[1] == op {
+			rel.Flags = flags
+		}
+	}
+	if fields[1] == "==" {
+		rel.Flags = DepFlagEQ
+	}
+	if rel.Flags == "" {
+		return Relation{}, fmt.Errorf("invalid dependency %q: unknown operator %q", s, fields[1])
+	}
+	evr := fields[2]
+	if i := strings.IndexByte(evr, ':'); i >= 0 {
+		epoch, err := strconv.Atoi(evr[:i])
+		if err != nil || epoch < 0 {
+			return Relation{}, fmt.Errorf("invalid dependency %q: bad epoch %q", s, evr[:i])
+		}
+		rel.Epoch, evr = epoch, evr[i+1:]
+	}
+	if i := strings.LastIndexByte(evr, '-'); i >= 0 {
+		evr, rel.Rel = evr[:i], evr[i+1:]
+	}
+	if evr == "" {
+		return Relation{}, fmt.Errorf("invalid dependency %q: empty version", s)
+	}
+	rel.Ver = evr
+	return rel, nil
+}
+
+// Overlaps reports whether r and other share a name and their version ranges intersect, which
+// is how rpm decides that a provide satisfies a requirement. An unversioned side matches any
+// version, and the release is only compared when both sides have one.
+func (r Relation) Overlaps(other Relation) bool {
+	if r.Name != other.Name {
+		return false
+	}
+	aFlags, aOK := NormalizeDepFlag(r.Flags)
+	bFlags, bOK := NormalizeDepFlag(other.Flags)
+	if !aOK || !bOK {
+		return false
+	}
+	if aFlags == "" || bFlags == "" {
+		return true
+	}
+	sense := 0
+	switch {
+	case r.Epoch < other.Epoch:
+		sense = -1
+	case r.Epoch > other.Epoch:
+		sense = 1
+	}
+	if sense == 0 {
+		sense = CompareVersions(r.Ver, other.Ver)
+	}
+	if sense == 0 && r.Rel != "" && other.Rel != "" {
+		sense = CompareVersions(r.Rel, other.Rel)
+	}
+	less := func(f string) bool { return f == DepFlagLT || f == DepFlagLE }
+	greater := func(f string) bool { return f == DepFlagGT || f == DepFlagGE }
+	equal := func(f string) bool { return f == DepFlagEQ || f == DepFlagLE || f == DepFlagGE }
+	switch {
+	case sense < 0:
+		return greater(aFlags) || less(bFlags)
+	case sense > 0:
+		return less(aFlags) || greater(bFlags)
+	default:
+		return (equal(aFlags) && equal(bFlags)) || (less(aFlags) && less(bFlags)) || (greater(aFlags) && greater(bFlags))
+	}
+}
+
+func splitSegment(s string, in func(rune) bool) (string, string) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !in(r) })
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(r rune) bool { return r >= '0' && r <= '9' }
+
+func isAlpha(r rune) bool { return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') }
+
+func isVersionSeparator(r rune) bool {
+	return !isDigit(r) && !isAlpha(r) && r != '~' && r != '^'
+}
@@ -2,34 +2,79 @@ package metadata
 
 import (
 	"bytes"
+	"compress/bzip2"
 	"compress/gzip"
 	"context"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/e2llm/rpmrepo-update/pkg/backend"
 )
 
-// LoadRepoMD reads and unmarshals repodata/repomd.xml from backend.
+// ErrUnsupportedChecksum reports core metadata whose recorded checksum type (e.g. sha1) cannot be
+// computed, so the file cannot be verified.
+var ErrUnsupportedChecksum = errors.New("unsupported checksum type")
+
+// ErrTruncatedRepoMD reports a repomd.xml that ends before its closing tag, typically because an
+// upload was interrupted.
+var ErrTruncatedRepoMD = errors.New("repomd.xml is truncated")
+
+// LoadRepoMD reads and unmarshals repomd.xml from the backend's repodata directory.
 func LoadRepoMD(ctx context.Context, b backend.Backend) (RepoMD, error) {
-	data, err := b.ReadFile(ctx, "repodata/repomd.xml")
+	data, err := b.ReadFile(ctx, RepomdPath(b.RepodataDir()))
 	if err != nil {
 		return RepoMD{}, err
 	}
 	return ParseRepoMD(data)
 }
 
-// ParseRepoMD unmarshals repomd XML from raw bytes.
+// ParseRepoMD unmarshals repomd XML from raw bytes. Elements and attributes are matched by local
+// name, so files declaring another default namespace or using a prefix (<repo:repomd>) parse the
+// same; the namespace is normalized to RepoNamespace so a rewrite produces standard repomd.
 func ParseRepoMD(data []byte) (RepoMD, error) {
 	var md RepoMD
 	if err := xml.Unmarshal(data, &md); err != nil {
-		return RepoMD{}, err
+		if !bytes.HasSuffix(bytes.TrimSpace(data), []byte("</repomd>")) {
+			return RepoMD{}, fmt.Errorf("%w: %d bytes with no closing </repomd> (%v); run repair to rebuild it", ErrTruncatedRepoMD, len(data), err)
+		}
+		return RepoMD{}, fmt.Errorf("parse repomd.xml (%d bytes): %w; run repair to rebuild it", len(data), err)
+	}
+	if md.XMLName.Space != RepoNamespace || md.Xmlns != RepoNamespace {
+		// A foreign or missing namespace would otherwise be copied into rewritten repomd.xml,
+		// or marshalled as xmlns="" for a prefixed root element.
+		md.XMLName = xml.Name{}
+		md.Xmlns = RepoNamespace
+	}
+	seen := make(map[string]bool, len(md.Data))
+	for _, d := range md.Data {
+		// Readers would pick one entry of a repeated type arbitrarily, and rewrites would repeat it.
+		if seen[d.Type] {
+			return RepoMD{}, fmt.Errorf("repomd.xml lists data type %q more than once; run repair to rebuild it", d.Type)
+		}
+		seen[d.Type] = true
+		if d.Location.Href == "" {
+			continue
+		}
+		if err := ValidateHref(d.Location.Href); err != nil {
+			return RepoMD{}, fmt.Errorf("%s: %w", d.Type, err)
+		}
 	}
 	return md, nil
 }
 
+// FindData returns the RepoData entry of the given type, or nil if md has none.
+func FindData(md RepoMD, typ string) *RepoData {
+	for i := range md.Data {
+		if md.Data[i].Type == typ {
+			return &md.Data[i]
+		}
+	}
+	return nil
+}
+
 // GetCoreData returns the RepoData entries for primary, filelists, and other.
 func GetCoreData(md RepoMD) (primary, filelists, other *RepoData) {
 	for i := range md.Data {
@@ -48,6 +93,28 @@ func GetCoreData(md RepoMD) (primary, filelists, other *RepoData) {
 
 // ReadAndVerifyCore downloads and decompresses a core metadata file and verifies checksums.
 func ReadAndVerifyCore(ctx context.Context, b backend.Backend, d RepoData) (CoreFile, error) {
+	if d.Location.Href == "" {
+		return CoreFile{}, errors.New("missing location href")
+	}
+	if d.Checksum.Type == "" || d.OpenChecksum == nil || d.OpenChecksum.Type == "" {
+		return CoreFile{}, errors.New("missing checksum metadata")
+	}
+	core, err := ReadCore(ctx, b, d)
+	if err != nil {
+		return CoreFile{}, err
+	}
+	if core.Checksum != d.Checksum.Value {
+		return CoreFile{}, fmt.Errorf("checksum mismatch for %s: expected %s got %s", d.Type, d.Checksum.Value, core.Checksum)
+	}
+	if core.OpenChecksum != d.OpenChecksum.Value {
+		return CoreFile{}, fmt.Errorf("open-checksum mismatch for %s: expected %s got %s", d.Type, d.OpenChecksum.Value, core.OpenChecksum)
+	}
+	return core, nil
+}
+
+// ReadCore downloads and decompresses a core metadata file and computes its checksums with the
+// algorithms recorded in d (sha256 when unset), without comparing them to the recorded values.
+func ReadCore(ctx context.Context, b backend.Backend, d RepoData) (CoreFile, error) {
 	if d.Location.Href == "" {
 		return CoreFile{}, errors.New("missing location href")
 	}
@@ -55,33 +122,32 @@ func ReadAndVerifyCore(ctx context.Context, b backend.Backend, d RepoData) (Core
 	if err != nil {
 		return CoreFile{}, fmt.Errorf("read %s: %w", d.Location.Href, err)
 	}
-	uncompressed, err := gunzip(compressed)
+	uncompressed, err := decompress(d.Location.Href, compressed)
 	if err != nil {
 		return CoreFile{}, fmt.Errorf("decompress %s: %w", d.Location.Href, err)
 	}
 
-	if d.Checksum.Type == "" || d.OpenChecksum == nil || d.OpenChecksum.Type == "" {
-		return CoreFile{}, errors.New("missing checksum metadata")
+	alg := d.Checksum.Type
+	if alg == "" {
+		alg = "sha256"
 	}
-	if !SupportedChecksum(d.Checksum.Type) {
-		return CoreFile{}, fmt.Errorf("unsupported checksum type %q", d.Checksum.Type)
+	openAlg := alg
+	if d.OpenChecksum != nil && d.OpenChecksum.Type != "" {
+		openAlg = d.OpenChecksum.Type
 	}
-
-	sum, err := ComputeChecksum(compressed, d.Checksum.Type)
+	for _, a := range []string{alg, openAlg} {
+		if !SupportedChecksum(a) {
+			return CoreFile{}, fmt.Errorf("%w %q", ErrUnsupportedChecksum, a)
+		}
+	}
+	sum, err := ComputeChecksum(compressed, alg)
 	if err != nil {
 		return CoreFile{}, err
 	}
-	if sum != d.Checksum.Value {
-		return CoreFile{}, fmt.Errorf("checksum mismatch for %s: expected %s got %s", d.Type, d.Checksum.Value, sum)
-	}
-
-	openSum, err := ComputeChecksum(uncompressed, d.OpenChecksum.Type)
+	openSum, err := ComputeChecksum(uncompressed, openAlg)
 	if err != nil {
 		return CoreFile{}, err
 	}
-	if openSum != d.OpenChecksum.Value {
-		return CoreFile{}, fmt.Errorf("open-checksum mismatch for %s: expected %s got %s", d.Type, d.OpenChecksum.Value, openSum)
-	}
 
 	return CoreFile{
 		Type:         d.Type,
@@ -96,12 +162,27 @@ func ReadAndVerifyCore(ctx context.Context, b backend.Backend, d RepoData) (Core
 	}, nil
 }
 
+// decompress picks the decoder from the file suffix: .bz2 is bzip2, anything else is gzip.
+func decompress(href string, data []byte) ([]byte, error) {
+	if strings.HasSuffix(href, ".bz2") {
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, bzip2.NewReader(bytes.NewReader(data))); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return gunzip(data)
+}
+
+// gunzip reads every member of data. Some tools write metadata as concatenated gzip members;
+// stopping after the first would truncate it and show up as an open-checksum mismatch.
 func gunzip(data []byte) ([]byte, error) {
 	r, err := gzip.NewReader(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 	defer r.Close()
+	r.Multistream(true)
 	var buf bytes.Buffer
 	if _, err := io.Copy(&buf, r); err != nil {
 		return nil, err
@@ -1,8 +1,16 @@
 package metadata
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 )
 
 func TestRenderParseRoundTrip(t *testing.T) {
@@ -41,7 +49,7 @@ func TestRenderParseRoundTrip(t *testing.T) {
 
 func TestBuildEmptyCoreFiles(t *testing.T) {
 	now := time.Unix(0, 0)
-	files, repomd, err := BuildEmptyCoreFiles("sha256", now)
+	files, repomd, err := BuildEmptyCoreFiles("sha256", CoreLayout{Dir: "repodata"}, now)
 	if err != nil {
 		t.Fatalf("BuildEmptyCoreFiles: %v", err)
 	}
@@ -55,7 +63,7 @@ func TestBuildEmptyCoreFiles(t *testing.T) {
 
 func TestBuildEmptyCoreFilesSHA512(t *testing.T) {
 	now := time.Unix(0, 0)
-	files, _, err := BuildEmptyCoreFiles("sha512", now)
+	files, _, err := BuildEmptyCoreFiles("sha512", CoreLayout{Dir: "repodata"}, now)
 	if err != nil {
 		t.Fatalf("BuildEmptyCoreFiles with sha512: %v", err)
 	}
@@ -72,7 +80,7 @@ func TestBuildEmptyCoreFilesSHA512(t *testing.T) {
 
 func TestBuildEmptyCoreFilesInvalidChecksum(t *testing.T) {
 	now := time.Unix(0, 0)
-	_, _, err := BuildEmptyCoreFiles("md5", now)
+	_, _, err := BuildEmptyCoreFiles("md5", CoreLayout{Dir: "repodata"}, now)
 	if err == nil {
 		t.Fatal("expected error for unsupported checksum algorithm")
 	}
@@ -98,6 +106,42 @@ func TestComputeChecksum(t *testing.T) {
 	}
 }
 
+func TestComputeChecksumReader(t *testing.T) {
+	data := []byte("hello world")
+	for _, alg := range []string{"sha256", "sha512"} {
+		want, _ := ComputeChecksum(data, alg)
+		got, n, err := ComputeChecksumReader(bytes.NewReader(data), alg)
+		if err != nil || got != want || n != int64(len(data)) {
+			t.Errorf("ComputeChecksumReader %s = %s, %d, %v; want %s, %d", alg, got, n, err, want, len(data))
+		}
+	}
+	if _, _, err := ComputeChecksumReader(bytes.NewReader(data), "md5"); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
+
+func TestComputeChecksums(t *testing.T) {
+	data := []byte("hello world")
+	want := map[string]string{
+		"sha256": "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		"sha512": "309ecc489c12d6eb4cc40f50c902f2b4d0ed77ee511a7c7a9bcd3ca86d4cd86f989dd35bc5ff499670da34255b45b0cfd830e81f605dcf7dc5542e93ae9cd76f",
+	}
+	sums, err := ComputeChecksums(data, "sha256", "SHA512", "sha256")
+	if err != nil {
+		t.Fatalf("ComputeChecksums: %v", err)
+	}
+	if len(sums) != 2 || sums["sha256"] != want["sha256"] || sums["sha512"] != want["sha512"] {
+		t.Fatalf("ComputeChecksums = %v, want %v", sums, want)
+	}
+	sums, n, err := ComputeChecksumsReader(bytes.NewReader(data), "sha512", "sha256")
+	if err != nil || n != int64(len(data)) || sums["sha256"] != want["sha256"] || sums["sha512"] != want["sha512"] {
+		t.Fatalf("ComputeChecksumsReader = %v, %d, %v", sums, n, err)
+	}
+	if _, err := ComputeChecksums(data, "sha256", "md5"); err == nil {
+		t.Fatal("expected error for unsupported algorithm")
+	}
+}
+
 func TestComputeChecksumUnsupported(t *testing.T) {
 	_, err := ComputeChecksum([]byte("data"), "md5")
 	if err == nil {
@@ -173,9 +217,6 @@ func TestRenderParseRoundTripMultiplePackages(t *testing.T) {
 }
 
 func TestPackageWithDependencies(t *testing.T) {
-	// Note: Go's encoding/xml doesn't properly handle rpm: namespace prefix during unmarshal.
-	// Dependencies are populated from actual RPM files via inspector, not XML round-trip.
-	// This test verifies that packages with dependencies can be rendered without error.
 	pkgs := []Package{
 		{
 			Name:         "foo",
@@ -191,8 +232,14 @@ func TestPackageWithDependencies(t *testing.T) {
 			Provides: []Relation{
 				{Name: "foo", Flags: "EQ", Ver: "1.0", Rel: "1"},
 			},
+			Recommends:  []Relation{{Name: "foo-docs"}},
+			Suggests:    []Relation{{Name: "foo-extras", Flags: "GE", Epoch: 2, Ver: "1.0"}},
+			Supplements: []Relation{{Name: "(foo and langpacks-de)"}},
+			Enhances:    []Relation{{Name: "bar"}},
+			License:     "MIT",
 		},
 	}
+	pkgs[0].Requires = append(pkgs[0].Requires, Relation{Name: "/bin/sh", Pre: true})
 	primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
 	if err != nil {
 		t.Fatalf("render: %v", err)
@@ -204,9 +251,30 @@ func TestPackageWithDependencies(t *testing.T) {
 	if len(outPkgs) != 1 {
 		t.Fatalf("expected 1 package, got %d", len(outPkgs))
 	}
-	// Verify basic package info is preserved
-	if outPkgs[0].Name != "foo" {
-		t.Errorf("expected name 'foo', got %q", outPkgs[0].Name)
+	got := outPkgs[0]
+	if got.Name != "foo" || got.License != "MIT" {
+		t.Errorf("expected name 'foo' and license MIT, got %q %q", got.Name, got.License)
+	}
+	if len(got.Requires) != 3 || got.Requires[1].Flags != "GE" || got.Requires[1].Ver != "1.0" || !got.Requires[2].Pre {
+		t.Errorf("requires not preserved: %+v", got.Requires)
+	}
+	if len(got.Provides) != 1 || got.Provides[0].Rel != "1" {
+		t.Errorf("provides not preserved: %+v", got.Provides)
+	}
+	if len(got.Recommends) != 1 || got.Recommends[0].Name != "foo-docs" {
+		t.Errorf("recommends not preserved: %+v", got.Recommends)
+	}
+	if len(got.Suggests) != 1 || got.Suggests[0].Epoch != 2 || got.Suggests[0].Flags != "GE" {
+		t.Errorf("suggests not preserved: %+v", got.Suggests)
+	}
+	if len(got.Supplements) != 1 || got.Supplements[0].Name != "(foo and langpacks-de)" {
+		t.Errorf("supplements not preserved: %+v", got.Supplements)
+	}
+	if len(got.Enhances) != 1 || got.Enhances[0].Name != "bar" {
+		t.Errorf("enhances not preserved: %+v", got.Enhances)
+	}
+	if !bytes.Contains(primaryXML, []byte(`<rpm:recommends>`)) || !bytes.Contains(primaryXML, []byte(`pre="1"`)) {
+		t.Errorf("primary.xml missing rpm:recommends or pre attribute:\n%s", primaryXML)
 	}
 }
 
@@ -239,3 +307,694 @@ func TestPackageWithChangelogs(t *testing.T) {
 		t.Errorf("expected 1 changelog, got %d", len(outPkgs[0].Changelogs))
 	}
 }
+
+func TestChangelogLimit(t *testing.T) {
+	pkgs := []Package{{
+		Name: "foo", Arch: "x86_64", Version: "1.0", Release: "3", ChecksumType: "sha256", PkgID: "abc",
+		Changelogs: []Changelog{
+			{Author: "a", Date: 300, Text: "third"},
+			{Author: "a", Date: 200, Text: "second"},
+			{Author: "a", Date: 100, Text: "first"},
+		},
+	}}
+	now := time.Unix(0, 0)
+	core, err := BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata", ChangelogLimit: 2}, now)
+	if err != nil {
+		t.Fatalf("build core: %v", err)
+	}
+	outPkgs, err := ParsePackagesFromXML(core[0].Uncompressed, core[1].Uncompressed, core[2].Uncompressed)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := outPkgs[0].Changelogs
+	if len(got) != 2 || got[0].Text != "third" || got[1].Text != "second" {
+		t.Fatalf("changelogs = %+v, want the two newest", got)
+	}
+
+	core, err = BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata"}, now)
+	if err != nil {
+		t.Fatalf("build core: %v", err)
+	}
+	outPkgs, err = ParsePackagesFromXML(core[0].Uncompressed, core[1].Uncompressed, core[2].Uncompressed)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(outPkgs[0].Changelogs) != 3 {
+		t.Fatalf("expected all 3 changelogs without a limit, got %d", len(outPkgs[0].Changelogs))
+	}
+
+	if _, err := BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata", ChangelogLimit: -1}, now); err == nil {
+		t.Fatalf("expected a negative changelog limit to be rejected")
+	}
+}
+
+func TestRenderCleansInvalidText(t *testing.T) {
+	pkgs := []Package{
+		{
+			Name:         "foo",
+			Arch:         "noarch",
+			Version:      "1.0",
+			Release:      "1",
+			ChecksumType: "sha256",
+			PkgID:        "abcdef",
+			Summary:      "caf\xe9\x01",
+			Description:  "line one\n\x1b[1mbold\x1b[0m\tend",
+			Changelogs: []Changelog{
+				{Author: "J\xf6rg <jorg@example.com>", Date: 1234567890, Text: "- fix\x00 \x07r\xe9sum\xe9 é"},
+			},
+		},
+	}
+	primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if _, err := BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata"}, time.Unix(1234567890, 0)); err != nil {
+		t.Fatalf("build core files: %v", err)
+	}
+	for name, data := range map[string][]byte{"primary": primaryXML, "other": otherXML} {
+		if bytes.ContainsRune(data, utf8.RuneError) {
+			t.Errorf("%s contains U+FFFD replacement characters:\n%s", name, data)
+		}
+	}
+	outPkgs, err := ParsePackagesFromXML(primaryXML, filelistsXML, otherXML)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	got := outPkgs[0]
+	if got.Summary != "café" {
+		t.Errorf("summary = %q, want %q", got.Summary, "café")
+	}
+	if want := "line one\n[1mbold[0m\tend"; got.Description != want {
+		t.Errorf("description = %q, want %q", got.Description, want)
+	}
+	if len(got.Changelogs) != 1 {
+		t.Fatalf("expected 1 changelog, got %d", len(got.Changelogs))
+	}
+	if want := "Jörg <jorg@example.com>"; got.Changelogs[0].Author != want {
+		t.Errorf("changelog author = %q, want %q", got.Changelogs[0].Author, want)
+	}
+	if want := "- fix résumé é"; got.Changelogs[0].Text != want {
+		t.Errorf("changelog text = %q, want %q", got.Changelogs[0].Text, want)
+	}
+}
+
+// BenchmarkParsePackagesFromXML measures the parse cost that dominates add/remove on large repos.
+func BenchmarkParsePackagesFromXML(b *testing.B) {
+	pkgs := make([]Package, 0, 5000)
+	for i := 0; i < cap(pkgs); i++ {
+		pkgs = append(pkgs, Package{
+			Name:         fmt.Sprintf("pkg%d", i),
+			Arch:         "x86_64",
+			Version:      "1.0",
+			Release:      "1",
+			ChecksumType: "sha256",
+			PkgID:        fmt.Sprintf("%064d", i),
+			Location:     fmt.Sprintf("pkg%d-1.0-1.x86_64.rpm", i),
+			Requires:     []Relation{{Name: "libc.so.6"}},
+			Files:        []File{{Path: fmt.Sprintf("/usr/bin/pkg%d", i)}, {Path: fmt.Sprintf("/usr/share/pkg%d", i), Type: "dir"}},
+			Changelogs:   []Changelog{{Author: "dev <dev@example.com>", Date: 1234567890, Text: "- rebuild"}},
+		})
+	}
+	primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
+	if err != nil {
+		b.Fatalf("render: %v", err)
+	}
+	b.SetBytes(int64(len(primaryXML) + len(filelistsXML) + len(otherXML)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParsePackagesFromXML(primaryXML, filelistsXML, otherXML); err != nil {
+			b.Fatalf("parse: %v", err)
+		}
+	}
+}
+
+func TestFileTypesRoundTrip(t *testing.T) {
+	pkgs := []Package{
+		{
+			Name:         "foo",
+			Arch:         "x86_64",
+			Version:      "1.0",
+			Release:      "1",
+			ChecksumType: "sha256",
+			PkgID:        "abcdef",
+			Files: []File{
+				{Path: "/etc/foo", Type: "dir"},
+				{Path: "/etc/foo/foo.conf"},
+				{Path: "/usr/bin/foo"},
+				{Path: "/usr/share/foo", Type: "dir"},
+				{Path: "/var/log/foo.log", Type: "ghost"},
+			},
+		},
+	}
+	primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	outPkgs, err := ParsePackagesFromXML(primaryXML, filelistsXML, otherXML)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(outPkgs) != 1 {
+		t.Fatalf("expected 1 package, got %d", len(outPkgs))
+	}
+	got := outPkgs[0].Files
+	if len(got) != len(pkgs[0].Files) {
+		t.Fatalf("expected %d files, got %d: %+v", len(pkgs[0].Files), len(got), got)
+	}
+	for i, f := range pkgs[0].Files {
+		if got[i] != f {
+			t.Errorf("file %d: got %+v, want %+v", i, got[i], f)
+		}
+	}
+
+	primary, err := parsePrimary(primaryXML)
+	if err != nil {
+		t.Fatalf("parse primary: %v", err)
+	}
+	primaryFiles := primary.Packages[0].Format.Files
+	want := []fileEntry{
+		{Path: "/etc/foo", Type: "dir"},
+		{Path: "/etc/foo/foo.conf"},
+		{Path: "/usr/bin/foo"},
+	}
+	if len(primaryFiles) != len(want) {
+		t.Fatalf("expected %d primary files, got %d: %+v", len(want), len(primaryFiles), primaryFiles)
+	}
+	for i, f := range want {
+		if primaryFiles[i] != f {
+			t.Errorf("primary file %d: got %+v, want %+v", i, primaryFiles[i], f)
+		}
+	}
+}
+
+func TestIsPrimaryFile(t *testing.T) {
+	tests := map[string]bool{
+		"/etc/foo.conf":        true,
+		"/usr/bin/foo":         true,
+		"/usr/sbin/foo":        true,
+		"/bin/sh":              true,
+		"/usr/lib/sendmail":    true,
+		"/usr/share/doc/foo":   false,
+		"/usr/lib64/libfoo.so": false,
+		"/etc":                 false,
+	}
+	defaultPattern := regexp.MustCompile(DefaultPrimaryFilePattern)
+	for path, want := range tests {
+		if got := IsPrimaryFile(path); got != want {
+			t.Errorf("IsPrimaryFile(%q) = %v, want %v", path, got, want)
+		}
+		if got := defaultPattern.MatchString(path); got != want {
+			t.Errorf("DefaultPrimaryFilePattern matches %q = %v, want %v", path, got, want)
+		}
+	}
+}
+
+func TestPrimaryFilePattern(t *testing.T) {
+	pkgs := []Package{{
+		Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abc",
+		Files: []File{{Path: "/usr/bin/foo"}, {Path: "/opt/foo/libexec/helper"}, {Path: "/usr/share/doc/foo"}},
+	}}
+	layout := CoreLayout{Dir: "repodata", PrimaryFilePattern: `^(.*bin/.*|/etc/.*|/usr/lib/sendmail|/opt/.*/libexec/.*)$`}
+	core, err := BuildCoreFilesFromPackages(pkgs, "sha256", layout, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("build core: %v", err)
+	}
+	primary, err := parsePrimary(core[0].Uncompressed)
+	if err != nil {
+		t.Fatalf("parse primary: %v", err)
+	}
+	files := primary.Packages[0].Format.Files
+	if len(files) != 2 || files[0].Path != "/usr/bin/foo" || files[1].Path != "/opt/foo/libexec/helper" {
+		t.Fatalf("primary files = %+v", files)
+	}
+
+	layout.PrimaryFilePattern = "(unclosed"
+	if _, err := BuildCoreFilesFromPackages(pkgs, "sha256", layout, time.Unix(0, 0)); err == nil {
+		t.Fatalf("expected an invalid pattern to be rejected")
+	}
+	if err := ValidatePrimaryFilePattern("(unclosed"); err == nil {
+		t.Fatalf("ValidatePrimaryFilePattern accepted an invalid pattern")
+	}
+}
+
+func TestValidateHref(t *testing.T) {
+	tests := map[string]bool{
+		"repodata/abc-primary.xml.gz": true,
+		"Packages/f/foo.rpm":          true,
+		"foo/../foo.rpm":              true,
+		"":                            false,
+		"/etc/passwd":                 false,
+		"../../etc/passwd":            false,
+		"repodata/../../etc/passwd":   false,
+		"..":                          false,
+		"..\\..\\etc\\passwd":         false,
+	}
+	for href, ok := range tests {
+		if err := ValidateHref(href); (err == nil) != ok {
+			t.Errorf("ValidateHref(%q) error = %v, want ok=%v", href, err, ok)
+		}
+	}
+}
+
+func TestParseRepoMDNamespaces(t *testing.T) {
+	docs := map[string]string{
+		// Prefixed elements with an xlink href, as some non-createrepo tools write.
+		"prefixed": `<?xml version="1.0" encoding="UTF-8"?>
+<repo:repomd xmlns:repo="http://linux.duke.edu/metadata/repo" xmlns:xlink="http://www.w3.org/1999/xlink">
+  <repo:revision>1700000000</repo:revision>
+  <repo:data type="primary">
+    <repo:checksum type="sha256">abc</repo:checksum>
+    <repo:location xlink:href="repodata/primary.xml.gz"/>
+    <repo:size>42</repo:size>
+  </repo:data>
+</repo:repomd>`,
+		"other-namespace": `<?xml version="1.0"?>
+<repomd xmlns="http://example.com/metadata/repo">
+  <revision>1700000000</revision>
+  <data type="primary"><checksum type="sha256">abc</checksum><location href="repodata/primary.xml.gz"/><size>42</size></data>
+</repomd>`,
+		"no-namespace": `<repomd><revision>1700000000</revision><data type="primary"><checksum type="sha256">abc</checksum><location href="repodata/primary.xml.gz"/><size>42</size></data></repomd>`,
+	}
+	for name, doc := range docs {
+		md, err := ParseRepoMD([]byte(doc))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		primary, _, _ := GetCoreData(md)
+		if md.Revision != "1700000000" || primary == nil || primary.Checksum.Value != "abc" || primary.Location.Href != "repodata/primary.xml.gz" || primary.Size != 42 {
+			t.Fatalf("%s: parsed %+v", name, md)
+		}
+		out, err := MarshalRepoMD(md)
+		if err != nil {
+			t.Fatalf("%s: marshal: %v", name, err)
+		}
+		if !bytes.Contains(out, []byte(`<repomd xmlns="`+RepoNamespace+`">`)) {
+			t.Fatalf("%s: rewritten without the standard namespace:\n%s", name, out)
+		}
+	}
+}
+
+func TestParseRepoMDTruncated(t *testing.T) {
+	repomd := []byte(`<?xml version="1.0"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1</revision>
+  <data type="primary"><location href="repodata/primary.xml.gz"/></data>
+</repomd>
+`)
+	if _, err := ParseRepoMD(repomd); err != nil {
+		t.Fatalf("ParseRepoMD: %v", err)
+	}
+	for _, data := range [][]byte{repomd[:60], nil} {
+		_, err := ParseRepoMD(data)
+		if !errors.Is(err, ErrTruncatedRepoMD) || !strings.Contains(err.Error(), fmt.Sprintf("%d bytes", len(data))) {
+			t.Errorf("ParseRepoMD(%d bytes) = %v, want truncation error with size", len(data), err)
+		}
+	}
+	// Malformed but complete files are parse errors, not truncation.
+	malformed := bytes.Replace(repomd, []byte("</revision>"), []byte("</rev>"), 1)
+	if _, err := ParseRepoMD(malformed); err == nil || errors.Is(err, ErrTruncatedRepoMD) {
+		t.Errorf("malformed repomd: got %v", err)
+	}
+}
+
+// duplicatePrimaryRepoMD lists primary twice, pointing at different files.
+const duplicatePrimaryRepoMD = `<?xml version="1.0"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <revision>1700000000</revision>
+  <data type="primary"><checksum type="sha256">abc</checksum><location href="repodata/abc-primary.xml.gz"/></data>
+  <data type="filelists"><checksum type="sha256">def</checksum><location href="repodata/def-filelists.xml.gz"/></data>
+  <data type="primary"><checksum type="sha256">123</checksum><location href="repodata/123-primary.xml.gz"/></data>
+</repomd>`
+
+func TestParseRejectsDuplicateDataTypes(t *testing.T) {
+	_, err := ParseRepoMD([]byte(duplicatePrimaryRepoMD))
+	if err == nil || !strings.Contains(err.Error(), `"primary"`) {
+		t.Fatalf("expected an error naming the duplicated primary, got %v", err)
+	}
+}
+
+func TestParseRejectsTraversalLocations(t *testing.T) {
+	repomd := []byte(`<?xml version="1.0"?>
+<repomd xmlns="http://linux.duke.edu/metadata/repo">
+  <data type="primary"><location href="../../etc/passwd"/></data>
+</repomd>`)
+	if _, err := ParseRepoMD(repomd); err == nil {
+		t.Fatalf("expected repomd with traversal href to be rejected")
+	}
+
+	pkgs := []Package{{Name: "evil", Arch: "noarch", Version: "1", Release: "1", PkgID: "x", Location: "../../etc/passwd"}}
+	primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if _, err := ParsePackagesFromXML(primaryXML, filelistsXML, otherXML); err == nil {
+		t.Fatalf("expected package with traversal location to be rejected")
+	}
+}
+
+func TestBuildCoreFilesBzip2(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pkgs := []Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abcdef"},
+	}
+	files, err := BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata", Compression: CompressionBzip2}, now)
+	if err != nil {
+		t.Fatalf("BuildCoreFilesFromPackages: %v", err)
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Path, "-"+f.Type+".xml.bz2") {
+			t.Fatalf("unexpected path %s", f.Path)
+		}
+		if !strings.HasPrefix(string(f.Compressed), "BZh") {
+			t.Fatalf("%s is not bzip2 data", f.Path)
+		}
+		plain, err := decompress(f.Path, f.Compressed)
+		if err != nil {
+			t.Fatalf("decompress %s: %v", f.Path, err)
+		}
+		if !bytes.Equal(plain, f.Uncompressed) {
+			t.Fatalf("%s did not round-trip", f.Path)
+		}
+		sum, _ := ComputeChecksum(f.Compressed, "sha256")
+		openSum, _ := ComputeChecksum(plain, "sha256")
+		if sum != f.Checksum || openSum != f.OpenChecksum {
+			t.Fatalf("checksum mismatch for %s", f.Path)
+		}
+	}
+
+	if _, err := BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata", Compression: "xz"}, now); err == nil {
+		t.Fatalf("expected unsupported compression error")
+	}
+}
+
+func TestFilelistsExt(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	pkgs := []Package{
+		{
+			Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abcdef",
+			FileDigestType: "sha256",
+			Files: []File{
+				{Path: "/etc/foo", Type: "dir"},
+				{Path: "/usr/bin/foo", Digest: strings.Repeat("a", 64)},
+			},
+		},
+	}
+	files, err := BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata"}, now)
+	if err != nil {
+		t.Fatalf("BuildCoreFilesFromPackages: %v", err)
+	}
+	for _, f := range files {
+		if f.Type == "filelists_ext" {
+			t.Fatalf("filelists_ext written without CoreLayout.FilelistsExt")
+		}
+	}
+
+	files, err = BuildCoreFilesFromPackages(pkgs, "sha256", CoreLayout{Dir: "repodata", FilelistsExt: true}, now)
+	if err != nil {
+		t.Fatalf("BuildCoreFilesFromPackages: %v", err)
+	}
+	var ext *CoreFile
+	for i := range files {
+		if files[i].Type == "filelists_ext" {
+			ext = &files[i]
+		}
+	}
+	if ext == nil {
+		t.Fatalf("no filelists_ext in %d core files", len(files))
+	}
+	if !strings.HasSuffix(ext.Path, "-filelists-ext.xml.gz") {
+		t.Fatalf("unexpected path %s", ext.Path)
+	}
+	if !strings.Contains(string(ext.Uncompressed), `xmlns="`+FilelistsExtNamespace+`"`) {
+		t.Fatalf("missing filelists-ext namespace:\n%s", ext.Uncompressed)
+	}
+
+	parsed := []Package{{
+		Name: "foo", PkgID: "abcdef",
+		Files: []File{{Path: "/etc/foo", Type: "dir"}, {Path: "/usr/bin/foo"}},
+	}}
+	if err := ApplyFilelistsExt(parsed, ext.Uncompressed); err != nil {
+		t.Fatalf("ApplyFilelistsExt: %v", err)
+	}
+	if parsed[0].FileDigestType != "sha256" {
+		t.Fatalf("FileDigestType = %q", parsed[0].FileDigestType)
+	}
+	for i, f := range pkgs[0].Files {
+		if parsed[0].Files[i] != f {
+			t.Errorf("file %d: got %+v, want %+v", i, parsed[0].Files[i], f)
+		}
+	}
+
+	if err := ApplyFilelistsExt(parsed, []byte("<filelists-ext")); err == nil {
+		t.Fatalf("expected error for truncated filelists-ext")
+	}
+}
+
+func TestBuildRepo(t *testing.T) {
+	pkgs := []Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abcdef", Location: "Packages/foo-1.0-1.x86_64.rpm"},
+		{Name: "bar", Arch: "noarch", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "012345", Location: "Packages/bar-2.0-1.noarch.rpm"},
+	}
+	now := time.Unix(1700000000, 0)
+	files, err := BuildRepo(pkgs, BuildRepoOptions{Now: now})
+	if err != nil {
+		t.Fatalf("BuildRepo: %v", err)
+	}
+	md, err := ParseRepoMD(files["repodata/repomd.xml"])
+	if err != nil {
+		t.Fatalf("parse repomd.xml: %v", err)
+	}
+	if md.Revision != "1700000000" || len(md.Data) != 3 || len(files) != 4 {
+		t.Fatalf("unexpected repomd (revision %s, %d entries) for %d files", md.Revision, len(md.Data), len(files))
+	}
+	plain := make(map[string][]byte)
+	for _, d := range md.Data {
+		data, ok := files[d.Location.Href]
+		if !ok || !strings.HasPrefix(d.Location.Href, "repodata/") {
+			t.Fatalf("repomd lists %s, which BuildRepo did not return", d.Location.Href)
+		}
+		if sum, _ := ComputeChecksum(data, "sha256"); sum != d.Checksum.Value || int64(len(data)) != d.Size {
+			t.Fatalf("%s does not match its repomd entry", d.Location.Href)
+		}
+		if plain[d.Type], err = decompress(d.Location.Href, data); err != nil {
+			t.Fatalf("decompress %s: %v", d.Location.Href, err)
+		}
+	}
+	got, err := ParsePackagesFromXML(plain["primary"], plain["filelists"], plain["other"])
+	if err != nil {
+		t.Fatalf("parse packages: %v", err)
+	}
+	if len(got) != 2 || got[0].Location != "Packages/bar-2.0-1.noarch.rpm" || got[1].Location != "Packages/foo-1.0-1.x86_64.rpm" {
+		t.Fatalf("unexpected packages: %+v", got)
+	}
+
+	files, err = BuildRepo(pkgs, BuildRepoOptions{
+		ChecksumAlg: "sha512",
+		Layout:      CoreLayout{Dir: "meta", FilenameStyle: FilenameStylePlain, NoOther: true},
+		Revision:    "42",
+	})
+	if err != nil {
+		t.Fatalf("BuildRepo with options: %v", err)
+	}
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	if want := "meta/filelists.xml.gz,meta/primary.xml.gz,meta/repomd.xml"; strings.Join(paths, ",") != want {
+		t.Fatalf("paths = %v, want %s", paths, want)
+	}
+	if md, err := ParseRepoMD(files["meta/repomd.xml"]); err != nil || md.Revision != "42" || md.Data[0].Checksum.Type != "sha512" {
+		t.Fatalf("unexpected repomd %+v, %v", md, err)
+	}
+
+	if _, err := BuildRepo(pkgs, BuildRepoOptions{ChecksumAlg: "md5"}); err == nil {
+		t.Fatal("expected an unsupported checksum error")
+	}
+}
+
+func TestNormalizeNEVRA(t *testing.T) {
+	cases := map[string]string{
+		"foo-1.0-1.x86_64":        "foo-0:1.0-1.x86_64",
+		"foo-0:1.0-1.x86_64":      "foo-0:1.0-1.x86_64",
+		"foo-2:1.0-1.el9.x86_64":  "foo-2:1.0-1.el9.x86_64",
+		"my-pkg-1.0-1.el9.noarch": "my-pkg-0:1.0-1.el9.noarch",
+		"not-a-nevra":             "not-a-nevra",
+		"foo-x:1.0-1.x86_64":      "foo-x:1.0-1.x86_64",
+	}
+	for in, want := range cases {
+		if got := NormalizeNEVRA(in); got != want {
+			t.Errorf("NormalizeNEVRA(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+		{"1.10", "1.9", 1},
+		{"1.05", "1.5", 0},
+		{"1.0", "1.0.1", -1},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.a", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"1.0^git1", "1.0~rc1", 1},
+		{"1_0", "1.0", 0},
+		{"el9", "el10", -1},
+		{"2.el9", "10.el9", -1},
+	}
+	for _, tc := range tests {
+		if got := CompareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tc.a, tc.b, got, tc.want)
+		}
+		if got := CompareVersions(tc.b, tc.a); got != -tc.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tc.b, tc.a, got, -tc.want)
+		}
+	}
+}
+
+func TestCompareEVR(t *testing.T) {
+	base := Package{Version: "1.0", Release: "1"}
+	newerRelease := Package{Version: "1.0", Release: "2"}
+	epoch := Package{Epoch: 1, Version: "0.1", Release: "1"}
+	if CompareEVR(base, newerRelease) != -1 || CompareEVR(newerRelease, base) != 1 {
+		t.Errorf("release ordering wrong")
+	}
+	if CompareEVR(epoch, newerRelease) != 1 {
+		t.Errorf("epoch must dominate version")
+	}
+	if CompareEVR(base, base) != 0 {
+		t.Errorf("equal EVR must compare 0")
+	}
+}
+
+func TestDepFlagsRoundTrip(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{DepFlagEQ, "EQ"},
+		{DepFlagLT, "LT"},
+		{DepFlagLE, "LE"},
+		{DepFlagGT, "GT"},
+		{DepFlagGE, "GE"},
+		{"ge", "GE"},
+		{" Lt ", "LT"},
+		{"BOGUS", "BOGUS"},
+	}
+	for _, tt := range tests {
+		pkgs := []Package{{
+			Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abc",
+			Requires: []Relation{{Name: "bar", Flags: tt.in, Ver: "2.0", Rel: "1"}},
+		}}
+		primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
+		if err != nil {
+			t.Fatalf("render %q: %v", tt.in, err)
+		}
+		out, err := ParsePackagesFromXML(primaryXML, filelistsXML, otherXML)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tt.in, err)
+		}
+		if len(out) != 1 || len(out[0].Requires) != 1 {
+			t.Fatalf("flags %q: requires lost: %+v", tt.in, out)
+		}
+		if got := out[0].Requires[0].Flags; got != tt.want {
+			t.Errorf("flags %q: round trip got %q, want %q", tt.in, got, tt.want)
+		}
+		invalid := out[0].InvalidDepFlags()
+		if wantInvalid := tt.in == "BOGUS"; (len(invalid) > 0) != wantInvalid {
+			t.Errorf("flags %q: InvalidDepFlags = %v", tt.in, invalid)
+		}
+	}
+}
+
+func TestDecompressConcatenatedGzip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, part := range []string{"<metadata>", "</metadata>"} {
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(part)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+	plain, err := decompress("repodata/primary.xml.gz", buf.Bytes())
+	if err != nil {
+		t.Fatalf("decompress: %v", err)
+	}
+	if string(plain) != "<metadata></metadata>" {
+		t.Fatalf("expected both members, got %q", plain)
+	}
+}
+
+func TestParseRelation(t *testing.T) {
+	for in, want := range map[string]Relation{
+		"libfoo.so.1()(64bit)": {Name: "libfoo.so.1()(64bit)"},
+		"bar >= 1.0":           {Name: "bar", Flags: DepFlagGE, Ver: "1.0"},
+		"bar == 2:1.0-3.el9":   {Name: "bar", Flags: DepFlagEQ, Epoch: 2, Ver: "1.0", Rel: "3.el9"},
+		"  bar   <  1.0 ":      {Name: "bar", Flags: DepFlagLT, Ver: "1.0"},
+	} {
+		got, err := ParseRelation(in)
+		if err != nil || got != want {
+			t.Errorf("ParseRelation(%q) = %+v, %v; want %+v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "bar >=", "bar ~ 1.0", "bar = x:1.0", "bar = 1:"} {
+		if _, err := ParseRelation(in); err == nil {
+			t.Errorf("ParseRelation(%q): expected error", in)
+		}
+	}
+	if got := (Relation{Name: "bar", Flags: DepFlagLE, Epoch: 1, Ver: "2.0", Rel: "1"}).String(); got != "bar <= 1:2.0-1" {
+		t.Errorf("String = %q", got)
+	}
+}
+
+func TestRelationOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"bar", "bar >= 1.0", true},
+		{"bar = 1.0-1", "bar", true},
+		{"bar", "baz", false},
+		{"bar = 1.2-1", "bar >= 1.0", true},
+		{"bar = 0.9-1", "bar >= 1.0", false},
+		{"bar = 1.0-1", "bar >= 1.0", true},
+		{"bar = 1.0-1", "bar > 1.0", false},
+		{"bar = 1.0-1", "bar = 1.0", true},
+		{"bar = 1.0-1", "bar = 1.0-2", false},
+		{"bar = 1:0.5-1", "bar >= 1.0", true},
+		{"bar < 2.0", "bar > 1.0", true},
+		{"bar < 1.0", "bar > 2.0", false},
+		{"bar <= 1.0", "bar >= 1.0", true},
+		{"bar >= 2.0", "bar >= 1.0", true},
+	}
+	for _, tc := range tests {
+		a, err := ParseRelation(tc.a)
+		if err != nil {
+			t.Fatalf("ParseRelation(%q): %v", tc.a, err)
+		}
+		b, err := ParseRelation(tc.b)
+		if err != nil {
+			t.Fatalf("ParseRelation(%q): %v", tc.b, err)
+		}
+		if got := a.Overlaps(b); got != tc.want {
+			t.Errorf("%q overlaps %q = %v, want %v", tc.a, tc.b, got, tc.want)
+		}
+		if got := b.Overlaps(a); got != tc.want {
+			t.Errorf("%q overlaps %q = %v, want %v", tc.b, tc.a, got, tc.want)
+		}
+	}
+	if (Relation{Name: "bar", Flags: "BOGUS", Ver: "1"}).Overlaps(Relation{Name: "bar"}) {
+		t.Errorf("unknown flags must not match")
+	}
+}
@@ -3,10 +3,14 @@ package metadata
 import (
 	"encoding/xml"
 	"fmt"
+	"io/fs"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/e2llm/rpmrepo-update/pkg/backend"
 )
 
 // Package represents a single package's metadata across primary/filelists/other.
@@ -39,8 +43,20 @@ type Package struct {
 	Requires      []Relation
 	Conflicts     []Relation
 	Obsoletes     []Relation
+	Recommends    []Relation // weak dependencies follow (RPM 4.12+)
+	Suggests      []Relation
+	Supplements   []Relation
+	Enhances      []Relation
 	Files         []File
 	Changelogs    []Changelog
+	// FileDigestType is the algorithm of the per-file digests in Files (e.g. "sha256"), as
+	// published in filelists-ext.
+	FileDigestType string
+	// Signed and SigKeyID (lower-case hex, empty if the signature names no key) describe the
+	// OpenPGP signature in the RPM's signature header. They are not part of repodata: they are set
+	// when the RPM itself is inspected, and are unset for packages parsed from metadata.
+	Signed   bool
+	SigKeyID string
 }
 
 func (p Package) NEVRA() string {
@@ -52,6 +68,96 @@ func (p Package) NEVRA() string {
 	return fmt.Sprintf("%s-%s%s-%s.%s", p.Name, epochPart, p.Version, p.Release, p.Arch)
 }
 
+// EVR returns the package's [epoch:]version-release, omitting a zero epoch like NEVRA.
+func (p Package) EVR() string {
+	if p.Epoch > 0 {
+		return fmt.Sprintf("%d:%s-%s", p.Epoch, p.Version, p.Release)
+	}
+	return p.Version + "-" + p.Release
+}
+
+// ParseNEVRA splits a name-[epoch:]version-release.arch identifier into a Package carrying only
+// those fields. It reports false if s does not have that shape.
+func ParseNEVRA(s string) (Package, bool) {
+	dot := strings.LastIndexByte(s, '.')
+	if dot <= 0 {
+		return Package{}, false
+	}
+	rest, arch := s[:dot], s[dot+1:]
+	dash := strings.LastIndexByte(rest, '-')
+	if dash <= 0 {
+		return Package{}, false
+	}
+	rest, release := rest[:dash], rest[dash+1:]
+	dash = strings.LastIndexByte(rest, '-')
+	if dash <= 0 {
+		return Package{}, false
+	}
+	name, version := rest[:dash], rest[dash+1:]
+	epoch := 0
+	if e, v, ok := strings.Cut(version, ":"); ok {
+		n, err := strconv.Atoi(e)
+		if err != nil || n < 0 {
+			return Package{}, false
+		}
+		epoch, version = n, v
+	}
+	if version == "" || release == "" || arch == "" {
+		return Package{}, false
+	}
+	return Package{Name: name, Arch: arch, Epoch: epoch, Version: version, Release: release}, true
+}
+
+// NormalizeNEVRA canonicalizes a name-[epoch:]version-release.arch identifier so that an omitted
+// epoch and an explicit "0:" compare equal. Identifiers that do not parse are returned unchanged.
+func NormalizeNEVRA(s string) string {
+	p, ok := ParseNEVRA(s)
+	if !ok {
+		return s
+	}
+	return fmt.Sprintf("%s-%d:%s-%s.%s", p.Name, p.Epoch, p.Version, p.Release, p.Arch)
+}
+
+// IsSource reports whether p is a source package (arch "src" or "nosrc").
+func (p Package) IsSource() bool {
+	return p.Arch == "src" || p.Arch == "nosrc"
+}
+
+// IsSourceRPMPath reports whether path names a source RPM by its .src.rpm or .nosrc.rpm suffix.
+func IsSourceRPMPath(path string) bool {
+	return strings.HasSuffix(path, ".src.rpm") || strings.HasSuffix(path, ".nosrc.rpm")
+}
+
+// Filename returns the conventional name-version-release.arch.rpm filename.
+// The epoch is omitted, matching what rpmbuild produces.
+func (p Package) Filename() string {
+	return fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, p.Version, p.Release, p.Arch)
+}
+
+// Validate checks that p carries the fields needed to publish it without inspecting the RPM:
+// name, version, release, arch, a pkgid with a supported checksum type, and a safe location.
+func (p Package) Validate() error {
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"Name", p.Name}, {"Version", p.Version}, {"Release", p.Release},
+		{"Arch", p.Arch}, {"PkgID", p.PkgID}, {"Location", p.Location},
+	} {
+		if f.value == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("package missing required fields: %s", strings.Join(missing, ", "))
+	}
+	if !SupportedChecksum(p.ChecksumType) {
+		return fmt.Errorf("package %s: unsupported checksum type %q", p.NEVRA(), p.ChecksumType)
+	}
+	if err := ValidateHref(p.Location); err != nil {
+		return fmt.Errorf("package %s: %w", p.NEVRA(), err)
+	}
+	return nil
+}
+
 type Relation struct {
 	Name  string
 	Flags string
@@ -61,9 +167,56 @@ type Relation struct {
 	Pre   bool
 }
 
+// Dependency comparison flags as written in rpm:entry flags attributes. An empty Flags means the
+// relation is unversioned.
+const (
+	DepFlagEQ = "EQ"
+	DepFlagLT = "LT"
+	DepFlagLE = "LE"
+	DepFlagGT = "GT"
+	DepFlagGE = "GE"
+)
+
+// NormalizeDepFlag canonicalizes a flags value, accepting the known symbols in any case and with
+// surrounding space. ok is false for values createrepo never writes; those are returned unchanged.
+func NormalizeDepFlag(flags string) (string, bool) {
+	switch norm := strings.ToUpper(strings.TrimSpace(flags)); norm {
+	case "", DepFlagEQ, DepFlagLT, DepFlagLE, DepFlagGT, DepFlagGE:
+		return norm, true
+	default:
+		return flags, false
+	}
+}
+
+// InvalidDepFlags describes every relation of p whose Flags is not a known symbol, as
+// "<kind> <name>: flags <value>". Such relations are kept as-is when metadata is rewritten.
+func (p Package) InvalidDepFlags() []string {
+	var out []string
+	for _, group := range []struct {
+		kind string
+		rels []Relation
+	}{
+		{"provides", p.Provides}, {"requires", p.Requires}, {"conflicts", p.Conflicts}, {"obsoletes", p.Obsoletes},
+		{"recommends", p.Recommends}, {"suggests", p.Suggests}, {"supplements", p.Supplements}, {"enhances", p.Enhances},
+	} {
+		for _, r := range group.rels {
+			if _, ok := NormalizeDepFlag(r.Flags); !ok {
+				out = append(out, fmt.Sprintf("%s %s: flags %q", group.kind, r.Name, r.Flags))
+			}
+		}
+	}
+	return out
+}
+
 type File struct {
 	Path string
 	Type string // dir, ghost, or empty
+	// Mode and Size come from the RPM header and are not published in any metadata file.
+	Mode fs.FileMode
+	Size int64
+	// Digest is the file's digest from the RPM header (empty for directories and ghosts),
+	// published only in filelists-ext.
+	Digest string
 }
 
 type Changelog struct {
@@ -81,6 +234,11 @@ func ParsePackagesFromXML(primaryXML, filelistsXML, otherXML []byte) ([]Package,
 	pkgs := make([]Package, 0, len(primary.Packages))
 	index := make(map[string]*Package, len(primary.Packages))
 	for _, p := range primary.Packages {
+		if p.Location.Href != "" {
+			if err := ValidateHref(p.Location.Href); err != nil {
+				return nil, fmt.Errorf("package %s: %w", p.Name, err)
+			}
+		}
 		pkg := packageFromPrimary(p)
 		pkgs = append(pkgs, pkg)
 		index[pkg.PkgID] = &pkgs[len(pkgs)-1]
@@ -99,13 +257,20 @@ func ParsePackagesFromXML(primaryXML, filelistsXML, otherXML []byte) ([]Package,
 	return pkgs, nil
 }
 
-// RenderCoreXML renders primary/filelists/other XML payloads (uncompressed).
+// RenderCoreXML renders primary/filelists/other XML payloads (uncompressed), listing the files
+// selected by IsPrimaryFile in primary.
 func RenderCoreXML(pkgs []Package) (primaryXML, filelistsXML, otherXML []byte, err error) {
+	return renderCoreXML(pkgs, IsPrimaryFile, 0)
+}
+
+// renderCoreXML is RenderCoreXML with primaryFile selecting the files listed in primary.xml and
+// changelogLimit, if positive, capping the changelog entries per package in other.xml.
+func renderCoreXML(pkgs []Package, primaryFile func(string) bool, changelogLimit int) (primaryXML, filelistsXML, otherXML []byte, err error) {
 	sorted := append([]Package(nil), pkgs...)
 	sort.Slice(sorted, func(i, j int) bool {
 		return sorted[i].NEVRA() < sorted[j].NEVRA()
 	})
-	primaryXML, err = marshalPrimary(sorted)
+	primaryXML, err = marshalPrimary(sorted, primaryFile)
 	if err != nil {
 		return
 	}
@@ -113,17 +278,21 @@ func RenderCoreXML(pkgs []Package) (primaryXML, filelistsXML, otherXML []byte, e
 	if err != nil {
 		return
 	}
-	otherXML, err = marshalOther(sorted)
+	otherXML, err = marshalOther(sorted, changelogLimit)
 	return
 }
 
-// BuildCoreFilesFromPackages generates compressed core metadata files and checksum info.
-func BuildCoreFilesFromPackages(pkgs []Package, checksumAlg string, now time.Time) ([]CoreFile, error) {
+// BuildCoreFilesFromPackages generates compressed core metadata files and checksum info, named according to layout.
+func BuildCoreFilesFromPackages(pkgs []Package, checksumAlg string, layout CoreLayout, now time.Time) ([]CoreFile, error) {
 	checksumAlg = strings.ToLower(checksumAlg)
 	if !SupportedChecksum(checksumAlg) {
 		return nil, fmt.Errorf("unsupported checksum algorithm %q", checksumAlg)
 	}
-	primaryXML, filelistsXML, otherXML, err := RenderCoreXML(pkgs)
+	layout, err := layout.normalize()
+	if err != nil {
+		return nil, err
+	}
+	primaryXML, filelistsXML, otherXML, err := renderCoreXML(pkgs, layout.primaryFile, layout.ChangelogLimit)
 	if err != nil {
 		return nil, err
 	}
@@ -136,10 +305,24 @@ func BuildCoreFilesFromPackages(pkgs []Package, checksumAlg string, now time.Tim
 		{"filelists", filelistsXML},
 		{"other", otherXML},
 	}
+	if layout.emits("filelists_ext") {
+		sorted := append([]Package(nil), pkgs...)
+		sort.Slice(sorted, func(i, j int) bool {
+			return sorted[i].NEVRA() < sorted[j].NEVRA()
+		})
+		ext, err := marshalFilelistsExt(sorted)
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, payload{"filelists_ext", ext})
+	}
 
 	var coreFiles []CoreFile
 	for _, p := range payloads {
-		compressed, err := gzipBytes(p.data)
+		if !layout.emits(p.name) {
+			continue
+		}
+		compressed, err := compressBytes(p.data, layout.Compression)
 		if err != nil {
 			return nil, err
 		}
@@ -151,10 +334,9 @@ func BuildCoreFilesFromPackages(pkgs []Package, checksumAlg string, now time.Tim
 		if err != nil {
 			return nil, err
 		}
-		path := fmt.Sprintf("repodata/%s-%s.xml.gz", sum, p.name)
 		coreFiles = append(coreFiles, CoreFile{
 			Type:         p.name,
-			Path:         path,
+			Path:         layout.path(sum, p.name),
 			Compressed:   compressed,
 			Uncompressed: p.data,
 			Checksum:     sum,
@@ -179,7 +361,7 @@ func UpdateRepoMDWithCore(old RepoMD, core []CoreFile, checksumAlg string, now t
 	}
 	for _, d := range old.Data {
 		switch d.Type {
-		case "primary", "filelists", "other", "prestodelta":
+		case "primary", "filelists", "filelists_ext", "other", "prestodelta":
 			continue
 		default:
 			newMD.Data = append(newMD.Data, d)
@@ -204,6 +386,55 @@ func UpdateRepoMDWithCore(old RepoMD, core []CoreFile, checksumAlg string, now t
 	return newMD
 }
 
+// BuildRepoOptions configures BuildRepo. Zero values select the defaults.
+type BuildRepoOptions struct {
+	// ChecksumAlg is the checksum used for core files and repomd.xml entries (default "sha256").
+	ChecksumAlg string
+	// Layout names and compresses the core files. An empty Layout.Dir places them in "repodata".
+	Layout CoreLayout
+	// Now is the timestamp recorded for the core files and, unless Revision is set, the
+	// repomd.xml revision (default: the current time).
+	Now time.Time
+	// Revision, if non-empty, is the repomd.xml revision.
+	Revision string
+}
+
+// BuildRepo generates complete repository metadata for pkgs without touching any storage. It
+// returns repomd.xml and every core file it lists, keyed by repo-relative path (e.g.
+// "repodata/repomd.xml"). Package locations are used as given.
+func BuildRepo(pkgs []Package, opts BuildRepoOptions) (map[string][]byte, error) {
+	alg := opts.ChecksumAlg
+	if alg == "" {
+		alg = "sha256"
+	}
+	layout := opts.Layout
+	if layout.Dir == "" {
+		layout.Dir = backend.DefaultRepodataDir
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	core, err := BuildCoreFilesFromPackages(pkgs, alg, layout, now)
+	if err != nil {
+		return nil, err
+	}
+	md := UpdateRepoMDWithCore(RepoMD{}, core, strings.ToLower(alg), now)
+	if opts.Revision != "" {
+		md.Revision = opts.Revision
+	}
+	repomd, err := MarshalRepoMD(md)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte, len(core)+1)
+	for _, cf := range core {
+		files[cf.Path] = cf.Compressed
+	}
+	files[RepomdPath(layout.Dir)] = repomd
+	return files, nil
+}
+
 // Helpers and XML mapping structures.
 
 type primaryXML struct {
@@ -264,6 +495,40 @@ type primaryFormat struct {
 	Requires    []depEntry   `xml:"rpm:requires>rpm:entry,omitempty"`
 	Conflicts   []depEntry   `xml:"rpm:conflicts>rpm:entry,omitempty"`
 	Obsoletes   []depEntry   `xml:"rpm:obsoletes>rpm:entry,omitempty"`
+	Suggests    []depEntry   `xml:"rpm:suggests>rpm:entry,omitempty"`
+	Enhances    []depEntry   `xml:"rpm:enhances>rpm:entry,omitempty"`
+	Recommends  []depEntry   `xml:"rpm:recommends>rpm:entry,omitempty"`
+	Supplements []depEntry   `xml:"rpm:supplements>rpm:entry,omitempty"`
+	Files       []fileEntry  `xml:"file,omitempty"`
+}
+
+// primaryFormatIn mirrors primaryFormat for decoding. encoding/xml resolves the rpm: prefix to the
+// namespace URL on input, so the literal "rpm:" tags used for output never match; local names do.
+type primaryFormatIn struct {
+	License     string       `xml:"license"`
+	Vendor      string       `xml:"vendor"`
+	Group       string       `xml:"group"`
+	BuildHost   string       `xml:"buildhost"`
+	SourceRPM   string       `xml:"sourcerpm"`
+	HeaderRange *headerRange `xml:"header-range"`
+	Provides    []depEntry   `xml:"provides>entry"`
+	Requires    []depEntry   `xml:"requires>entry"`
+	Conflicts   []depEntry   `xml:"conflicts>entry"`
+	Obsoletes   []depEntry   `xml:"obsoletes>entry"`
+	Suggests    []depEntry   `xml:"suggests>entry"`
+	Enhances    []depEntry   `xml:"enhances>entry"`
+	Recommends  []depEntry   `xml:"recommends>entry"`
+	Supplements []depEntry   `xml:"supplements>entry"`
+	Files       []fileEntry  `xml:"file"`
+}
+
+func (f *primaryFormat) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var in primaryFormatIn
+	if err := d.DecodeElement(&in, &start); err != nil {
+		return err
+	}
+	*f = primaryFormat(in)
+	return nil
 }
 
 type headerRange struct {
@@ -297,9 +562,31 @@ type filelistsPackage struct {
 
 type fileEntry struct {
 	Type string `xml:"type,attr,omitempty"`
+	Hash string `xml:"hash,attr,omitempty"`
 	Path string `xml:",chardata"`
 }
 
+// filelistsExtXML is filelists.xml with per-file digests, as written by createrepo_c --filelists-ext.
+type filelistsExtXML struct {
+	XMLName  xml.Name              `xml:"filelists-ext"`
+	Xmlns    string                `xml:"xmlns,attr"`
+	Count    int                   `xml:"packages,attr"`
+	Packages []filelistsExtPackage `xml:"package"`
+}
+
+type filelistsExtPackage struct {
+	PkgID    string       `xml:"pkgid,attr"`
+	Name     string       `xml:"name,attr"`
+	Arch     string       `xml:"arch,attr"`
+	Version  rpmVersion   `xml:"version"`
+	Checksum fileChecksum `xml:"checksum"`
+	Files    []fileEntry  `xml:"file"`
+}
+
+type fileChecksum struct {
+	Type string `xml:"type,attr"`
+}
+
 type otherXML struct {
 	XMLName  xml.Name       `xml:"otherdata"`
 	Xmlns    string         `xml:"xmlns,attr"`
@@ -399,11 +686,15 @@ func packageFromPrimary(p primaryPackage) Package {
 		Requires:      relationsFromEntries(p.Format.Requires),
 		Conflicts:     relationsFromEntries(p.Format.Conflicts),
 		Obsoletes:     relationsFromEntries(p.Format.Obsoletes),
+		Recommends:    relationsFromEntries(p.Format.Recommends),
+		Suggests:      relationsFromEntries(p.Format.Suggests),
+		Supplements:   relationsFromEntries(p.Format.Supplements),
+		Enhances:      relationsFromEntries(p.Format.Enhances),
 	}
 	return rel
 }
 
-func marshalPrimary(pkgs []Package) ([]byte, error) {
+func marshalPrimary(pkgs []Package, primaryFile func(string) bool) ([]byte, error) {
 	var out primaryXML
 	out.Xmlns = CommonNamespace
 	out.XmlnsRpm = RpmNamespace
@@ -423,9 +714,9 @@ func marshalPrimary(pkgs []Package) ([]byte, error) {
 				PkgID: "YES",
 				Value: p.PkgID,
 			},
-			Summary:     p.Summary,
-			Description: p.Description,
-			Packager:    p.Packager,
+			Summary:     cleanText(p.Summary),
+			Description: cleanText(p.Description),
+			Packager:    cleanText(p.Packager),
 			URL:         p.URL,
 			Time: primaryTime{
 				File:  p.TimeFile,
@@ -438,20 +729,31 @@ func marshalPrimary(pkgs []Package) ([]byte, error) {
 			},
 			Location: Location{Href: p.Location},
 			Format: primaryFormat{
-				License:   p.License,
-				Vendor:    p.Vendor,
-				Group:     p.Group,
+				License:   cleanText(p.License),
+				Vendor:    cleanText(p.Vendor),
+				Group:     cleanText(p.Group),
 				BuildHost: p.BuildHost,
 				SourceRPM: p.SourceRPM,
 			},
 		}
-		if p.HeaderStart > 0 || p.HeaderEnd > 0 {
+		// Inspected packages always have a header end, so a start of 0 is still written explicitly;
+		// only synthetic packages without a known header omit the element.
+		if p.HeaderEnd > 0 {
 			pkg.Format.HeaderRange = &headerRange{Start: p.HeaderStart, End: p.HeaderEnd}
 		}
 		pkg.Format.Provides = entriesFromRelations(p.Provides)
 		pkg.Format.Requires = entriesFromRelations(p.Requires)
 		pkg.Format.Conflicts = entriesFromRelations(p.Conflicts)
 		pkg.Format.Obsoletes = entriesFromRelations(p.Obsoletes)
+		pkg.Format.Suggests = entriesFromRelations(p.Suggests)
+		pkg.Format.Enhances = entriesFromRelations(p.Enhances)
+		pkg.Format.Recommends = entriesFromRelations(p.Recommends)
+		pkg.Format.Supplements = entriesFromRelations(p.Supplements)
+		for _, f := range p.Files {
+			if primaryFile(f.Path) {
+				pkg.Format.Files = append(pkg.Format.Files, fileEntry{Type: f.Type, Path: f.Path})
+			}
+		}
 		out.Packages = append(out.Packages, pkg)
 	}
 	return marshalWithHeader(out)
@@ -480,7 +782,63 @@ func marshalFilelists(pkgs []Package) ([]byte, error) {
 	return marshalWithHeader(out)
 }
 
-func marshalOther(pkgs []Package) ([]byte, error) {
+func marshalFilelistsExt(pkgs []Package) ([]byte, error) {
+	var out filelistsExtXML
+	out.Xmlns = FilelistsExtNamespace
+	out.Count = len(pkgs)
+	for _, p := range pkgs {
+		pkg := filelistsExtPackage{
+			PkgID: p.PkgID,
+			Name:  p.Name,
+			Arch:  p.Arch,
+			Version: rpmVersion{
+				Epoch: strconv.Itoa(p.Epoch),
+				Ver:   p.Version,
+				Rel:   p.Release,
+			},
+			Checksum: fileChecksum{Type: p.FileDigestType},
+		}
+		for _, f := range p.Files {
+			pkg.Files = append(pkg.Files, fileEntry{Type: f.Type, Hash: f.Digest, Path: f.Path})
+		}
+		out.Packages = append(out.Packages, pkg)
+	}
+	return marshalWithHeader(out)
+}
+
+// ApplyFilelistsExt copies the file digests and digest algorithm from filelists-ext XML onto the
+// matching packages and files of pkgs. Packages or files it does not list are left unchanged.
+func ApplyFilelistsExt(pkgs []Package, data []byte) error {
+	var fl filelistsExtXML
+	if err := xml.Unmarshal(data, &fl); err != nil {
+		return fmt.Errorf("parse filelists-ext: %w", err)
+	}
+	index := make(map[string]*Package, len(pkgs))
+	for i := range pkgs {
+		index[pkgs[i].PkgID] = &pkgs[i]
+	}
+	for _, p := range fl.Packages {
+		pkg := index[p.PkgID]
+		if pkg == nil {
+			continue
+		}
+		pkg.FileDigestType = p.Checksum.Type
+		hashes := make(map[string]string, len(p.Files))
+		for _, f := range p.Files {
+			if f.Hash != "" {
+				hashes[f.Path] = f.Hash
+			}
+		}
+		for i := range pkg.Files {
+			if h, ok := hashes[pkg.Files[i].Path]; ok {
+				pkg.Files[i].Digest = h
+			}
+		}
+	}
+	return nil
+}
+
+func marshalOther(pkgs []Package, changelogLimit int) ([]byte, error) {
 	var out otherXML
 	out.Xmlns = OtherNamespace
 	out.Count = len(pkgs)
@@ -495,14 +853,82 @@ func marshalOther(pkgs []Package) ([]byte, error) {
 				Rel:   p.Release,
 			},
 		}
-		for _, c := range p.Changelogs {
-			pkg.Changelogs = append(pkg.Changelogs, changelogEntry(c))
+		for _, c := range newestChangelogs(p.Changelogs, changelogLimit) {
+			pkg.Changelogs = append(pkg.Changelogs, changelogEntry{
+				Author: cleanText(c.Author),
+				Date:   c.Date,
+				Text:   cleanText(c.Text),
+			})
 		}
 		out.Packages = append(out.Packages, pkg)
 	}
 	return marshalWithHeader(out)
 }
 
+// newestChangelogs returns the limit newest entries of changelogs, newest first, or changelogs
+// unchanged when limit is not positive or not exceeded. Entries with the same date keep their order.
+func newestChangelogs(changelogs []Changelog, limit int) []Changelog {
+	if limit <= 0 || len(changelogs) <= limit {
+		return changelogs
+	}
+	newest := append([]Changelog(nil), changelogs...)
+	sort.SliceStable(newest, func(i, j int) bool { return newest[i].Date > newest[j].Date })
+	return newest[:limit]
+}
+
+// cleanText makes free-form header text safe for XML. RPM headers are not guaranteed to be UTF-8:
+// bytes that do not form valid UTF-8 are taken as Latin-1, which is what old changelogs almost
+// always are, and characters XML 1.0 cannot represent (most C0 controls, unpaired surrogates,
+// U+FFFE/U+FFFF) are dropped rather than left for encoding/xml to turn into U+FFFD.
+func cleanText(s string) string {
+	clean := true
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if (r == utf8.RuneError && size == 1) || !isXMLChar(r) {
+			clean = false
+			break
+		}
+		i += size
+	}
+	if clean {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = rune(s[i])
+		}
+		i += size
+		if isXMLChar(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// isXMLChar reports whether r matches the XML 1.0 Char production.
+func isXMLChar(r rune) bool {
+	switch {
+	case r == '\t' || r == '\n' || r == '\r':
+		return true
+	case r >= 0x20 && r <= 0xD7FF:
+		return true
+	case r >= 0xE000 && r <= 0xFFFD:
+		return true
+	case r >= 0x10000 && r <= utf8.MaxRune:
+		return true
+	}
+	return false
+}
+
+// IsPrimaryFile reports whether a file path belongs in primary.xml in addition to filelists.xml.
+// It mirrors createrepo: anything under /etc/, any bin/ directory, and /usr/lib/sendmail.
+func IsPrimaryFile(path string) bool {
+	return strings.HasPrefix(path, "/etc/") || strings.Contains(path, "bin/") || path == "/usr/lib/sendmail"
+}
+
 func parseEpoch(s string) int {
 	if s == "" {
 		return 0
@@ -517,9 +943,10 @@ func parseEpoch(s string) int {
 func relationsFromEntries(entries []depEntry) []Relation {
 	var rels []Relation
 	for _, e := range entries {
+		flags, _ := NormalizeDepFlag(e.Flags)
 		r := Relation{
 			Name:  e.Name,
-			Flags: e.Flags,
+			Flags: flags,
 			Epoch: parseEpoch(e.Epoch),
 			Ver:   e.Ver,
 			Rel:   e.Rel,
@@ -2,10 +2,18 @@ package metadata
 
 import (
 	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
 )
 
 const RepoNamespace = "http://linux.duke.edu/metadata/repo"
 
+// RepomdPath returns the repomd.xml path inside repodataDir.
+func RepomdPath(repodataDir string) string {
+	return path.Join(repodataDir, "repomd.xml")
+}
+
 type RepoMD struct {
 	XMLName  xml.Name   `xml:"repomd"`
 	Xmlns    string     `xml:"xmlns,attr"`
@@ -32,6 +40,21 @@ type Location struct {
 	Href string `xml:"href,attr"`
 }
 
+// ValidateHref rejects location hrefs that are absolute or climb out of the repository
+// root with ".." components, so crafted metadata cannot reach files outside the repo.
+func ValidateHref(href string) error {
+	if href == "" {
+		return fmt.Errorf("empty location href")
+	}
+	if strings.HasPrefix(href, "/") || strings.Contains(href, "\\") {
+		return fmt.Errorf("invalid location href %q: must be a relative slash-separated path", href)
+	}
+	if clean := path.Clean(href); clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid location href %q: escapes repository root", href)
+	}
+	return nil
+}
+
 func MarshalRepoMD(md RepoMD) ([]byte, error) {
 	if md.Xmlns == "" {
 		md.Xmlns = RepoNamespace
@@ -2,85 +2,539 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/e2llm/rpmrepo-update/pkg/inspector"
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
 // AddRPMs adds RPMs to the repository, updating core metadata. Only filesystem/S3 backends are supported in v1.
-func (r *Repo) AddRPMs(ctx context.Context, rpmPaths []string, replaceExisting bool, dryRun bool, signRPMs bool, gpgKey string) error {
+func (r *Repo) AddRPMs(ctx context.Context, rpmPaths []string, replaceExisting bool, dryRun bool, signRPMs bool, gpgKey string) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
 	if r.backend == nil {
-		return fmt.Errorf("backend is required")
+		return result, fmt.Errorf("backend is required")
 	}
 	if len(rpmPaths) == 0 {
-		return fmt.Errorf("no RPM paths provided")
+		return result, fmt.Errorf("no RPM paths provided")
 	}
 
-	md, pkgs, checksumAlg, err := r.loadPackages(ctx)
+	target, err := r.loadAddTarget(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
-
-	index := make(map[string]int, len(pkgs))
-	for i := range pkgs {
-		index[pkgs[i].NEVRA()] = i
-	}
-
-	// detect duplicates in existing metadata
-	if len(index) != len(pkgs) {
-		return fmt.Errorf("metadata contains duplicate NEVRA entries")
+	rpmAlg, err := r.rpmChecksumFor(target.checksumAlg)
+	if err != nil {
+		return result, err
 	}
 
 	now := time.Now().UTC()
+	var noarch []inspectedRPM
+	// A noarch mirror with another pkgid algorithm gets its checksum from the same pass over the RPM.
+	var mirrorAlgs []string
+	if r.NoarchMirror != nil {
+		if alg, err := r.NoarchMirror.pkgidChecksum(ctx); err == nil && alg != rpmAlg {
+			mirrorAlgs = []string{alg}
+		}
+	}
 
+	stdinSeen := false
 	for _, path := range rpmPaths {
-		info, err := os.Stat(path)
-		if err != nil {
-			return fmt.Errorf("stat %s: %w", path, err)
+		var (
+			rpm     inspectedRPM
+			destRel string
+		)
+		if path == StdinPath {
+			if stdinSeen {
+				return result, fmt.Errorf("standard input can only be added once")
+			}
+			stdinSeen = true
+			rpm, destRel, err = r.inspectStdinRPM(rpmAlg, now, mirrorAlgs...)
+		} else {
+			rpm, destRel, err = r.inspectFileRPM(path, rpmAlg, mirrorAlgs...)
 		}
-		data, err := os.ReadFile(path)
 		if err != nil {
-			return fmt.Errorf("read %s: %w", path, err)
+			return result, err
 		}
-		destRel := filepath.Base(path)
-		if r.DestPrefix != "" {
-			destRel = filepath.ToSlash(filepath.Join(r.DestPrefix, destRel))
+		data, pkgMeta := rpm.data, rpm.pkg
+		if r.DedupeStore != "" {
+			destRel = r.dedupePath(pkgMeta)
+			pkgMeta.Location = r.locationFor(destRel)
 		}
-		pkgMeta, err := inspector.InspectRPM(path, data, info, checksumAlg, destRel)
-		if err != nil {
-			return err
+		if err := checkDestPath(destRel); err != nil {
+			return result, fmt.Errorf("%s: %w", path, err)
+		}
+		if r.Resume && alreadyIndexed(target.pkgs, target.index, pkgMeta) {
+			r.logger.Printf("info: skipping %s: already indexed at %s", pkgMeta.NEVRA(), pkgMeta.Location)
+			result.Packages = append(result.Packages, PackageChange{NEVRA: pkgMeta.NEVRA(), Location: pkgMeta.Location, Status: ChangeSkipped, Source: path})
+			if r.NoarchMirror != nil && pkgMeta.Arch == "noarch" && !signRPMs {
+				// The earlier run may have been interrupted before it reached the mirror.
+				noarch = append(noarch, rpm)
+			}
+			continue
+		}
+		if r.OnlyIfNewer && !target.newest.admit(pkgMeta, r.logger) {
+			result.Packages = append(result.Packages, PackageChange{NEVRA: pkgMeta.NEVRA(), Location: pkgMeta.Location, Status: ChangeSkipped, Source: path})
+			continue
 		}
 		if signRPMs && !dryRun {
 			signed, err := r.signRPM(ctx, data, gpgKey)
 			if err != nil {
-				return fmt.Errorf("sign rpm %s: %w", path, err)
+				return result, fmt.Errorf("sign rpm %s: %w", path, err)
 			}
 			data = signed
 		}
 
-		key := pkgMeta.NEVRA()
-		if idx, ok := index[key]; ok {
-			if !replaceExisting {
-				return fmt.Errorf("package %s already exists (use --replace-existing)", key)
+		var status string
+		target.pkgs, status, err = mergePackage(target.pkgs, target.index, pkgMeta, replaceExisting)
+		if err != nil {
+			return result, err
+		}
+
+		if !dryRun {
+			n, err := r.storeRPM(ctx, destRel, data)
+			if err != nil {
+				return result, err
 			}
-			pkgs[idx] = pkgMeta
-		} else {
-			pkgs = append(pkgs, pkgMeta)
-			index[key] = len(pkgs) - 1
+			result.BytesUploaded += n
+		}
+		result.Packages = append(result.Packages, PackageChange{NEVRA: pkgMeta.NEVRA(), Location: pkgMeta.Location, Status: status, Source: path})
+		if r.NoarchMirror != nil && pkgMeta.Arch == "noarch" {
+			sums := rpm.sums
+			if signRPMs {
+				// Signing rewrote the RPM after it was hashed.
+				sums = nil
+			}
+			noarch = append(noarch, inspectedRPM{source: path, name: filepath.Base(destRel), data: data, pkg: pkgMeta, sums: sums})
 		}
+	}
+
+	if !dryRun && result.hasChanges() {
+		revision, err := r.writeMetadata(ctx, target.md, target.pkgs, target.checksumAlg, now)
+		if err != nil {
+			return result, err
+		}
+		result.Revision = revision
+	}
+	if len(noarch) > 0 {
+		mirrored, err := r.NoarchMirror.addInspected(ctx, noarch, replaceExisting, dryRun)
+		result.NoarchMirror = &mirrored
+		if err != nil {
+			return result, fmt.Errorf("noarch mirror: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// addTarget is the current metadata an add merges into, with its packages indexed by NEVRA.
+type addTarget struct {
+	md          metadata.RepoMD
+	pkgs        []metadata.Package
+	checksumAlg string
+	index       map[string]int
+	newest      newestPackages
+}
+
+// loadAddTarget loads r's metadata for an add, starting from empty metadata when the repository
+// is missing and InitIfMissing is set. Metadata listing a NEVRA twice is rejected.
+func (r *Repo) loadAddTarget(ctx context.Context) (addTarget, error) {
+	md, pkgs, checksumAlg, err := r.loadPackagesForWrite(ctx)
+	if errors.Is(err, ErrNotInitialized) && r.InitIfMissing {
+		// Start from empty metadata; writeMetadata produces the full core set and repomd.xml.
+		r.logger.Printf("info: %s not found; initializing repository", r.repomdPath())
+		md, pkgs = metadata.RepoMD{}, nil
+		checksumAlg, err = r.writeChecksum(md)
+	}
+	if err != nil {
+		return addTarget{}, err
+	}
+	index := make(map[string]int, len(pkgs))
+	for i := range pkgs {
+		index[pkgs[i].NEVRA()] = i
+	}
+	if len(index) != len(pkgs) {
+		return addTarget{}, fmt.Errorf("metadata contains duplicate NEVRA entries")
+	}
+	return addTarget{md: md, pkgs: pkgs, checksumAlg: checksumAlg, index: index, newest: newestByNameArch(pkgs)}, nil
+}
+
+// pkgidChecksum returns the algorithm AddRPMs would use for pkgids in r.
+func (r *Repo) pkgidChecksum(ctx context.Context) (string, error) {
+	_, _, checksumAlg, err := r.loadPackagesForWrite(ctx)
+	if errors.Is(err, ErrNotInitialized) && r.InitIfMissing {
+		checksumAlg, err = r.writeChecksum(metadata.RepoMD{})
+	}
+	if err != nil {
+		return "", err
+	}
+	return r.rpmChecksumFor(checksumAlg)
+}
+
+// StdinPath, given as an RPM path to AddRPMs, reads a single RPM from standard input.
+const StdinPath = "-"
+
+// inspectFileRPM reads and inspects the local RPM at path, returning it with its storage path.
+// The RPM's checksums for extraAlgs are computed along with its pkgid.
+func (r *Repo) inspectFileRPM(path, rpmAlg string, extraAlgs ...string) (inspectedRPM, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return inspectedRPM{}, "", fmt.Errorf("stat %s: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return inspectedRPM{}, "", fmt.Errorf("read %s: %w", path, err)
+	}
+	destRel, err := r.fileDestPath(path)
+	if err != nil {
+		return inspectedRPM{}, "", err
+	}
+	pkg, sums, err := r.inspectRPMChecksums(path, data, info, rpmAlg, r.locationFor(destRel), extraAlgs...)
+	if err != nil {
+		return inspectedRPM{}, "", err
+	}
+	return inspectedRPM{source: path, name: filepath.Base(destRel), data: data, pkg: pkg, sums: sums}, destRel, nil
+}
+
+// inspectStdinRPM reads one RPM from standard input. With no filename to go by, it is stored as
+// StdinName, or under the conventional filename derived from its header.
+func (r *Repo) inspectStdinRPM(rpmAlg string, now time.Time, extraAlgs ...string) (inspectedRPM, string, error) {
+	stdin := r.stdin
+	if stdin == nil {
+		stdin = os.Stdin
+	}
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return inspectedRPM{}, "", fmt.Errorf("read standard input: %w", err)
+	}
+	if len(data) == 0 {
+		return inspectedRPM{}, "", fmt.Errorf("read standard input: no RPM data")
+	}
+	name := r.StdinName
+	info := rpmFileInfo{name: name, size: int64(len(data)), modTime: now}
+	pkg, sums, err := inspector.InspectRPMChecksums("<stdin>", data, info, rpmAlg, "", extraAlgs...)
+	if err != nil {
+		return inspectedRPM{}, "", err
+	}
+	r.warnArch(StdinPath, pkg)
+	if name == "" {
+		name = pkg.Filename()
+	} else if err := r.verifyFilename(name, pkg); err != nil {
+		return inspectedRPM{}, "", err
+	}
+	destRel := r.destPathFor(name)
+	pkg.Location = r.locationFor(destRel)
+	return inspectedRPM{source: StdinPath, name: filepath.Base(destRel), data: data, pkg: pkg, sums: sums}, destRel, nil
+}
+
+// ValidateStdinName checks that name is usable as the filename of an RPM read from standard input.
+func ValidateStdinName(name string) error {
+	if name == "" {
+		return nil
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." || !strings.HasSuffix(name, ".rpm") {
+		return fmt.Errorf("invalid destination name %q: must be a plain filename ending in .rpm", name)
+	}
+	return nil
+}
+
+// FilterRPMPaths returns the paths whose base name matches one of include (all of them when
+// include is empty) and none of exclude, so an exclude pattern wins over an include pattern.
+// Patterns use filepath.Match syntax. StdinPath is always kept.
+func FilterRPMPaths(paths, include, exclude []string) ([]string, error) {
+	for _, pat := range append(append([]string(nil), include...), exclude...) {
+		if _, err := filepath.Match(pat, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pat, err)
+		}
+	}
+	matchAny := func(pats []string, name string) bool {
+		for _, pat := range pats {
+			if ok, _ := filepath.Match(pat, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	var out []string
+	for _, p := range paths {
+		name := filepath.Base(p)
+		if p == StdinPath || ((len(include) == 0 || matchAny(include, name)) && !matchAny(exclude, name)) {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// inspectedRPM is an RPM payload with the metadata already built from it. name is the filename it
+// is stored under.
+type inspectedRPM struct {
+	source string
+	name   string
+	data   []byte
+	pkg    metadata.Package
+	// sums holds checksums of data computed during inspection, by algorithm; it may be nil.
+	sums map[string]string
+}
 
+// addInspected writes already inspected RPMs and merges them into r's metadata. Only the location
+// and, if r uses a different pkgid algorithm, the pkgid are recomputed; the payload is not re-parsed.
+func (r *Repo) addInspected(ctx context.Context, rpms []inspectedRPM, replaceExisting bool, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
+	target, err := r.loadAddTarget(ctx)
+	if err != nil {
+		return result, err
+	}
+	rpmAlg, err := r.rpmChecksumFor(target.checksumAlg)
+	if err != nil {
+		return result, err
+	}
+
+	for _, rpm := range rpms {
+		pkg := rpm.pkg
+		if pkg.ChecksumType != rpmAlg {
+			if sum, ok := rpm.sums[rpmAlg]; ok {
+				pkg.PkgID = sum
+			} else if pkg.PkgID, err = metadata.ComputeChecksum(rpm.data, rpmAlg); err != nil {
+				return result, err
+			}
+			pkg.ChecksumType = rpmAlg
+		}
+		destRel := r.destPathFor(rpm.name)
+		if r.DedupeStore != "" {
+			destRel = r.dedupePath(pkg)
+		}
+		if err := checkDestPath(destRel); err != nil {
+			return result, fmt.Errorf("%s: %w", rpm.source, err)
+		}
+		pkg.Location = r.locationFor(destRel)
+		if r.Resume && alreadyIndexed(target.pkgs, target.index, pkg) {
+			r.logger.Printf("info: skipping %s: already indexed at %s", pkg.NEVRA(), pkg.Location)
+			result.Packages = append(result.Packages, PackageChange{NEVRA: pkg.NEVRA(), Location: pkg.Location, Status: ChangeSkipped, Source: rpm.source})
+			continue
+		}
+		if r.OnlyIfNewer && !target.newest.admit(pkg, r.logger) {
+			result.Packages = append(result.Packages, PackageChange{NEVRA: pkg.NEVRA(), Location: pkg.Location, Status: ChangeSkipped, Source: rpm.source})
+			continue
+		}
+		var status string
+		target.pkgs, status, err = mergePackage(target.pkgs, target.index, pkg, replaceExisting)
+		if err != nil {
+			return result, err
+		}
 		if !dryRun {
-			if err := r.backend.WriteFile(ctx, destRel, data); err != nil {
-				return fmt.Errorf("write rpm %s: %w", destRel, err)
+			n, err := r.storeRPM(ctx, destRel, rpm.data)
+			if err != nil {
+				return result, err
 			}
+			result.BytesUploaded += n
 		}
+		result.Packages = append(result.Packages, PackageChange{NEVRA: pkg.NEVRA(), Location: pkg.Location, Status: status, Source: rpm.source})
 	}
 
-	if dryRun {
+	if dryRun || !result.hasChanges() {
+		return result, nil
+	}
+	revision, err := r.writeMetadata(ctx, target.md, target.pkgs, target.checksumAlg, time.Now().UTC())
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	return result, nil
+}
+
+// destPathFor returns the storage path, relative to the repo root, for an RPM added from path.
+func (r *Repo) destPathFor(path string) string {
+	destRel := filepath.Base(path)
+	if r.DestPrefix != "" {
+		destRel = filepath.ToSlash(filepath.Join(r.DestPrefix, destRel))
+	}
+	return destRel
+}
+
+// fileDestPath returns the storage path for the local RPM at path: its base name or, with
+// PreserveDirBase, its path relative to that directory, under DestPrefix either way.
+func (r *Repo) fileDestPath(path string) (string, error) {
+	if r.PreserveDirBase == "" {
+		return r.destPathFor(path), nil
+	}
+	base, err := filepath.Abs(r.PreserveDirBase)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(base, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under base directory %s", path, r.PreserveDirBase)
+	}
+	return filepath.ToSlash(filepath.Join(r.DestPrefix, rel)), nil
+}
+
+// AddPackages merges pre-computed package metadata without reading or writing any RPM files.
+// The caller's checksums and locations are trusted; the RPMs must already be stored at their
+// locations. sources labels each package in the result and must match pkgs in length.
+func (r *Repo) AddPackages(ctx context.Context, pkgsIn []metadata.Package, sources []string, replaceExisting bool, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
+	if len(pkgsIn) == 0 {
+		return result, fmt.Errorf("no packages provided")
+	}
+	if len(sources) != len(pkgsIn) {
+		return result, fmt.Errorf("got %d sources for %d packages", len(sources), len(pkgsIn))
+	}
+	for i, p := range pkgsIn {
+		if err := p.Validate(); err != nil {
+			return result, fmt.Errorf("%s: %w", sources[i], err)
+		}
+		if err := r.verifyFilename(p.Location, p); err != nil {
+			return result, err
+		}
+	}
+
+	target, err := r.loadAddTarget(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	for i, p := range pkgsIn {
+		if r.OnlyIfNewer && !target.newest.admit(p, r.logger) {
+			result.Packages = append(result.Packages, PackageChange{NEVRA: p.NEVRA(), Location: p.Location, Status: ChangeSkipped, Source: sources[i]})
+			continue
+		}
+		var status string
+		target.pkgs, status, err = mergePackage(target.pkgs, target.index, p, replaceExisting)
+		if err != nil {
+			return result, err
+		}
+		result.Packages = append(result.Packages, PackageChange{NEVRA: p.NEVRA(), Location: p.Location, Status: status, Source: sources[i]})
+	}
+
+	if dryRun || !result.hasChanges() {
+		return result, nil
+	}
+	revision, err := r.writeMetadata(ctx, target.md, target.pkgs, target.checksumAlg, time.Now().UTC())
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	return result, nil
+}
+
+// mergePackage adds pkg to pkgs, or replaces the entry with the same NEVRA when replaceExisting
+// is set. index maps NEVRA to position in pkgs and is kept up to date.
+func mergePackage(pkgs []metadata.Package, index map[string]int, pkg metadata.Package, replaceExisting bool) ([]metadata.Package, string, error) {
+	key := pkg.NEVRA()
+	if idx, ok := index[key]; ok {
+		if !replaceExisting {
+			return pkgs, "", fmt.Errorf("package %s already exists (use --replace-existing)", key)
+		}
+		pkgs[idx] = pkg
+		return pkgs, ChangeReplaced, nil
+	}
+	pkgs = append(pkgs, pkg)
+	index[key] = len(pkgs) - 1
+	return pkgs, ChangeAdded, nil
+}
+
+// newestPackages maps name.arch to the package with the highest EVR.
+type newestPackages map[string]metadata.Package
+
+func newestByNameArch(pkgs []metadata.Package) newestPackages {
+	newest := make(newestPackages, len(pkgs))
+	for _, p := range pkgs {
+		key := p.Name + "." + p.Arch
+		if cur, ok := newest[key]; !ok || metadata.CompareEVR(p, cur) > 0 {
+			newest[key] = p
+		}
+	}
+	return newest
+}
+
+// admit reports whether pkg is strictly newer than every known package with its name and arch,
+// recording it as the newest if so. Rejections are logged.
+func (n newestPackages) admit(pkg metadata.Package, logger *log.Logger) bool {
+	key := pkg.Name + "." + pkg.Arch
+	if cur, ok := n[key]; ok && metadata.CompareEVR(pkg, cur) <= 0 {
+		logger.Printf("info: skipping %s: not newer than %s", pkg.NEVRA(), cur.NEVRA())
+		return false
+	}
+	n[key] = pkg
+	return true
+}
+
+// inspectRPM builds package metadata for an RPM payload and applies filename verification.
+func (r *Repo) inspectRPM(path string, data []byte, info fs.FileInfo, checksumAlg, destRel string) (metadata.Package, error) {
+	pkg, _, err := r.inspectRPMChecksums(path, data, info, checksumAlg, destRel)
+	return pkg, err
+}
+
+// inspectRPMChecksums is inspectRPM that also returns the checksums inspector.InspectRPMChecksums
+// computes for extraAlgs.
+func (r *Repo) inspectRPMChecksums(path string, data []byte, info fs.FileInfo, checksumAlg, destRel string, extraAlgs ...string) (metadata.Package, map[string]string, error) {
+	pkg, sums, err := inspector.InspectRPMChecksums(path, data, info, checksumAlg, destRel, extraAlgs...)
+	if err != nil {
+		return metadata.Package{}, nil, err
+	}
+	r.warnArch(path, pkg)
+	if err := r.verifyFilename(path, pkg); err != nil {
+		return metadata.Package{}, nil, err
+	}
+	return pkg, sums, nil
+}
+
+// warnArch logs a warning when pkg's arch is empty or neither known to rpm nor in AllowedArches.
+func (r *Repo) warnArch(path string, pkg metadata.Package) {
+	if err := inspector.CheckArch(pkg.Arch, r.AllowedArches); err != nil {
+		r.logger.Printf("warn: %s: package %s has %v", path, pkg.NEVRA(), err)
+	}
+}
+
+// verifyFilename applies the VerifyFilename policy to a single RPM path.
+func (r *Repo) verifyFilename(path string, pkg metadata.Package) error {
+	switch r.VerifyFilename {
+	case "", "off":
 		return nil
+	case "warn", "error":
+	default:
+		return fmt.Errorf("invalid verify-filename policy %q", r.VerifyFilename)
+	}
+	base := filepath.Base(path)
+	if filenameMatchesPackage(base, pkg) {
+		return nil
+	}
+	if r.VerifyFilename == "warn" {
+		r.logger.Printf("warn: filename %s does not match package %s (expected %s)", base, pkg.NEVRA(), pkg.Filename())
+		return nil
+	}
+	return fmt.Errorf("filename %s does not match package %s (expected %s)", base, pkg.NEVRA(), pkg.Filename())
+}
+
+// filenameMatchesPackage reports whether base follows the name-version-release.arch.rpm
+// convention for pkg. An explicit epoch (name-E:version-release.arch.rpm) is accepted,
+// as is the "src" arch for source packages, which carry no SOURCERPM header.
+func filenameMatchesPackage(base string, pkg metadata.Package) bool {
+	stem, ok := strings.CutSuffix(base, ".rpm")
+	if !ok {
+		return false
+	}
+	arches := []string{pkg.Arch}
+	if pkg.SourceRPM == "" {
+		arches = append(arches, "src", "nosrc")
+	}
+	for _, arch := range arches {
+		if stem == fmt.Sprintf("%s-%s-%s.%s", pkg.Name, pkg.Version, pkg.Release, arch) {
+			return true
+		}
+		if stem == fmt.Sprintf("%s-%d:%s-%s.%s", pkg.Name, pkg.Epoch, pkg.Version, pkg.Release, arch) {
+			return true
+		}
 	}
-	return r.writeMetadata(ctx, md, pkgs, checksumAlg, now)
+	return false
 }
@@ -0,0 +1,478 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestFilenameMatchesPackage(t *testing.T) {
+	bin := metadata.Package{Name: "foo", Version: "1.0", Release: "2", Arch: "x86_64", SourceRPM: "foo-1.0-2.src.rpm"}
+	withEpoch := bin
+	withEpoch.Epoch = 3
+	src := metadata.Package{Name: "foo", Version: "1.0", Release: "2", Arch: "x86_64"}
+
+	tests := []struct {
+		base string
+		pkg  metadata.Package
+		want bool
+	}{
+		{"foo-1.0-2.x86_64.rpm", bin, true},
+		{"foo-1.0-1.x86_64.rpm", bin, false},
+		{"foo-1.0-2.noarch.rpm", bin, false},
+		{"foo-1.0-2.x86_64", bin, false},
+		{"foo-0:1.0-2.x86_64.rpm", bin, true},
+		{"foo-1.0-2.x86_64.rpm", withEpoch, true},
+		{"foo-3:1.0-2.x86_64.rpm", withEpoch, true},
+		{"foo-2:1.0-2.x86_64.rpm", withEpoch, false},
+		{"foo-1.0-2.src.rpm", src, true},
+		{"foo-1.0-2.src.rpm", bin, false},
+	}
+	for _, tt := range tests {
+		if got := filenameMatchesPackage(tt.base, tt.pkg); got != tt.want {
+			t.Errorf("filenameMatchesPackage(%q, %s) = %v, want %v", tt.base, tt.pkg.NEVRA(), got, tt.want)
+		}
+	}
+}
+
+func TestVerifyFilenamePolicy(t *testing.T) {
+	pkg := metadata.Package{Name: "foo", Version: "1.0", Release: "2", Arch: "x86_64", SourceRPM: "foo-1.0-2.src.rpm"}
+	r := &Repo{logger: newTestLogger(t)}
+
+	r.VerifyFilename = "error"
+	if err := r.verifyFilename("/tmp/foo-1.0-1.x86_64.rpm", pkg); err == nil {
+		t.Fatalf("expected mismatch error")
+	}
+	if err := r.verifyFilename("/tmp/foo-1.0-2.x86_64.rpm", pkg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.VerifyFilename = "warn"
+	if err := r.verifyFilename("/tmp/foo-1.0-1.x86_64.rpm", pkg); err != nil {
+		t.Fatalf("warn policy should not error: %v", err)
+	}
+	r.VerifyFilename = "bogus"
+	if err := r.verifyFilename("/tmp/foo-1.0-2.x86_64.rpm", pkg); err == nil {
+		t.Fatalf("expected invalid policy error")
+	}
+}
+
+func TestAddInspectedReusesInspection(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	mirror := New(mb)
+	mirror.logger = newTestLogger(t)
+	mirror.DestPrefix = "Packages"
+	mirror.RPMChecksum = "sha512"
+
+	data := []byte("noarch payload")
+	pkg := metadata.Package{Name: "docs", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "stale", Location: "docs-1.0-1.noarch.rpm"}
+	result, err := mirror.addInspected(ctx, []inspectedRPM{{source: "/build/docs-1.0-1.noarch.rpm", name: "docs-1.0-1.noarch.rpm", data: data, pkg: pkg}}, false, false)
+	if err != nil {
+		t.Fatalf("addInspected: %v", err)
+	}
+	if len(result.Packages) != 1 || result.Packages[0].Status != ChangeAdded || result.Revision == "" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if string(mb.files["Packages/docs-1.0-1.noarch.rpm"]) != string(data) {
+		t.Fatalf("payload not written under mirror dest prefix")
+	}
+	pkgs, err := mirror.ListPackages(ctx)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	want, _ := metadata.ComputeChecksum(data, "sha512")
+	if len(pkgs) != 1 || pkgs[0].Location != "Packages/docs-1.0-1.noarch.rpm" || pkgs[0].ChecksumType != "sha512" || pkgs[0].PkgID != want {
+		t.Fatalf("unexpected mirrored package: %+v", pkgs)
+	}
+
+	if _, err := mirror.addInspected(ctx, []inspectedRPM{{source: "/build/docs-1.0-1.noarch.rpm", name: "docs-1.0-1.noarch.rpm", data: data, pkg: pkg}}, false, false); err == nil {
+		t.Fatalf("expected duplicate NEVRA to fail without replace")
+	}
+}
+
+func TestAddKeepsFilelistsExt(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.FilelistsExt = true
+
+	digest := strings.Repeat("a", 64)
+	pkg := metadata.Package{
+		Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "stale",
+		FileDigestType: "sha256",
+		Files:          []metadata.File{{Path: "/usr/bin/foo", Digest: digest}},
+	}
+	if _, err := r.addInspected(ctx, []inspectedRPM{{source: "foo-1.0-1.noarch.rpm", name: "foo-1.0-1.noarch.rpm", data: []byte("foo"), pkg: pkg}}, false, false); err != nil {
+		t.Fatalf("addInspected: %v", err)
+	}
+
+	// A later run without the option keeps filelists-ext and the digests already recorded.
+	r = New(mb)
+	r.logger = newTestLogger(t)
+	bar := metadata.Package{Name: "bar", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "stale"}
+	if _, err := r.addInspected(ctx, []inspectedRPM{{source: "bar-1.0-1.noarch.rpm", name: "bar-1.0-1.noarch.rpm", data: []byte("bar"), pkg: bar}}, false, false); err != nil {
+		t.Fatalf("addInspected: %v", err)
+	}
+	md, err := metadata.ParseRepoMD(mb.files["repodata/repomd.xml"])
+	if err != nil {
+		t.Fatalf("parse repomd: %v", err)
+	}
+	if metadata.FindData(md, "filelists_ext") == nil {
+		t.Fatalf("filelists_ext dropped from repomd.xml: %+v", md.Data)
+	}
+	pkgs, err := r.ListPackages(ctx)
+	if err != nil {
+		t.Fatalf("ListPackages: %v", err)
+	}
+	for _, p := range pkgs {
+		if p.Name != "foo" {
+			continue
+		}
+		if p.FileDigestType != "sha256" || len(p.Files) != 1 || p.Files[0].Digest != digest {
+			t.Fatalf("digests not kept: %+v", p)
+		}
+	}
+	if err := r.CheckDetailed(ctx, CheckOptions{}).Failure(); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+}
+
+func TestAddRPMsFromStdin(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.DestPrefix = "Packages"
+
+	r.stdin = bytes.NewReader(minimalRPM("foo", "1.0", "1", "noarch"))
+	result, err := r.AddRPMs(ctx, []string{StdinPath}, false, false, false, "")
+	if err != nil {
+		t.Fatalf("AddRPMs from stdin: %v", err)
+	}
+	if len(result.Packages) != 1 || result.Packages[0].Location != "Packages/foo-1.0-1.noarch.rpm" || result.Packages[0].Source != StdinPath {
+		t.Fatalf("unexpected result: %+v", result.Packages)
+	}
+	if _, ok := mb.files["Packages/foo-1.0-1.noarch.rpm"]; !ok {
+		t.Fatalf("rpm not stored under its derived name: %v", mb.files)
+	}
+
+	// An explicit name is used as given and checked against the header like a local filename.
+	r.StdinName = "bar-custom.rpm"
+	r.VerifyFilename = "error"
+	r.stdin = bytes.NewReader(minimalRPM("bar", "2.0", "1", "x86_64"))
+	if _, err := r.AddRPMs(ctx, []string{StdinPath}, false, false, false, ""); err == nil {
+		t.Fatal("expected the mismatching name to be rejected")
+	}
+	r.VerifyFilename = "off"
+	r.stdin = bytes.NewReader(minimalRPM("bar", "2.0", "1", "x86_64"))
+	if _, err := r.AddRPMs(ctx, []string{StdinPath}, false, false, false, ""); err != nil {
+		t.Fatalf("AddRPMs with StdinName: %v", err)
+	}
+	pkgs, err := r.ListPackages(ctx)
+	if err != nil {
+		t.Fatalf("ListPackages: %v", err)
+	}
+	var locations []string
+	for _, p := range pkgs {
+		locations = append(locations, p.Location)
+	}
+	sort.Strings(locations)
+	if want := []string{"Packages/bar-custom.rpm", "Packages/foo-1.0-1.noarch.rpm"}; strings.Join(locations, ",") != strings.Join(want, ",") {
+		t.Fatalf("locations = %v, want %v", locations, want)
+	}
+
+	if _, err := r.AddRPMs(ctx, []string{StdinPath, StdinPath}, false, true, false, ""); err == nil {
+		t.Fatal("expected standard input given twice to be rejected")
+	}
+}
+
+func TestValidateStdinName(t *testing.T) {
+	for name, ok := range map[string]bool{"": true, "foo.rpm": true, "dir/foo.rpm": false, "foo": false, "..": false} {
+		if err := ValidateStdinName(name); (err == nil) != ok {
+			t.Errorf("ValidateStdinName(%q) = %v, want ok=%v", name, err, ok)
+		}
+	}
+}
+
+func TestAddRPMsDedupeStore(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.DedupeStore = "packages"
+	data := minimalRPM("foo", "1.0", "1", "noarch")
+
+	r.stdin = bytes.NewReader(data)
+	result, err := r.AddRPMs(ctx, []string{StdinPath}, false, false, false, "")
+	if err != nil {
+		t.Fatalf("AddRPMs: %v", err)
+	}
+	pkgid, _ := metadata.ComputeChecksum(data, "sha256")
+	want := "packages/" + pkgid + "/foo-1.0-1.noarch.rpm"
+	if result.Packages[0].Location != want || result.BytesUploaded != int64(len(data)) {
+		t.Fatalf("location %s (want %s), uploaded %d", result.Packages[0].Location, want, result.BytesUploaded)
+	}
+
+	// The same payload again is merged without being uploaded.
+	r.stdin = bytes.NewReader(data)
+	result, err = r.AddRPMs(ctx, []string{StdinPath}, true, false, false, "")
+	if err != nil {
+		t.Fatalf("AddRPMs again: %v", err)
+	}
+	if result.BytesUploaded != 0 || result.Packages[0].Status != ChangeReplaced {
+		t.Fatalf("expected a replace without upload, got %+v", result)
+	}
+
+	// Store RPMs of other repos are not orphans of this one.
+	mb.files["packages/"+strings.Repeat("0", 64)+"/bar-1.0-1.noarch.rpm"] = []byte("rpm")
+	if res := r.CheckDetailed(ctx, CheckOptions{}); res.Failure() != nil {
+		t.Fatalf("check: %v", res.Failure())
+	}
+
+	// Removing the package keeps the shared copy.
+	if _, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.noarch"}, true, true, false); err != nil {
+		t.Fatalf("RemoveRPMs: %v", err)
+	}
+	if _, ok := mb.files[want]; !ok {
+		t.Fatalf("store copy %s was deleted", want)
+	}
+}
+
+func TestCheckDedupeAddressMismatch(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	loc := "packages/" + strings.Repeat("0", 64) + "/foo-1.0-1.x86_64.rpm"
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: strings.Repeat("a", 64), Location: loc},
+	})
+	mb.files[loc] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.DedupeStore = "packages"
+
+	res := r.CheckDetailed(ctx, CheckOptions{})
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "does not match its pkgid") {
+		t.Fatalf("expected pkgid mismatch, got %v", res.Err)
+	}
+}
+
+func TestAddRejectsDestPathOutsideRoot(t *testing.T) {
+	ctx := context.Background()
+	for _, prefix := range []string{"el9/../../outside", "/srv/abs"} {
+		mb := newMemBackend()
+		seedRepo(t, mb, nil)
+		r := New(mb)
+		r.logger = newTestLogger(t)
+		r.DestPrefix = prefix
+
+		pkg := metadata.Package{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "stale"}
+		_, err := r.addInspected(ctx, []inspectedRPM{{source: "foo-1.0-1.noarch.rpm", name: "foo-1.0-1.noarch.rpm", data: []byte("foo"), pkg: pkg}}, false, false)
+		if err == nil || !strings.Contains(err.Error(), "invalid destination path") {
+			t.Fatalf("prefix %q: error = %v, want invalid destination path", prefix, err)
+		}
+		for p := range mb.files {
+			if strings.HasSuffix(p, ".rpm") {
+				t.Fatalf("prefix %q: rpm stored at %s", prefix, p)
+			}
+		}
+		if _, err := r.layoutPath(LayoutFlat, pkg); err == nil {
+			t.Fatalf("prefix %q: layoutPath accepted an escaping path", prefix)
+		}
+		if err := ValidateDestPrefix(prefix); err == nil {
+			t.Fatalf("prefix %q: ValidateDestPrefix accepted it", prefix)
+		}
+	}
+	if err := ValidateDestPrefix("el9/x86_64/"); err != nil {
+		t.Fatalf("ValidateDestPrefix: %v", err)
+	}
+}
+
+func TestArchRepodata(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.ArchRepodata = true
+	r.DestPrefix = "x86_64"
+
+	var rpms []inspectedRPM
+	for _, p := range []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a"},
+		{Name: "docs", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b"},
+	} {
+		rpms = append(rpms, inspectedRPM{source: p.Filename(), name: p.Filename(), data: []byte(p.Name), pkg: p})
+	}
+	if _, err := r.addInspected(ctx, rpms, false, false); err != nil {
+		t.Fatalf("addInspected: %v", err)
+	}
+	r.DestPrefix = ""
+	src := metadata.Package{Name: "foo", Arch: "src", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "c"}
+	if _, err := r.addInspected(ctx, []inspectedRPM{{source: src.Filename(), name: src.Filename(), data: []byte("src"), pkg: src}}, false, false); err != nil {
+		t.Fatalf("addInspected: %v", err)
+	}
+
+	// subtree returns the uncompressed primary of an arch subtree and its repomd.
+	subtree := func(arch string) (string, metadata.RepoMD) {
+		t.Helper()
+		md, err := metadata.ParseRepoMD(mb.files[arch+"/repodata/repomd.xml"])
+		if err != nil {
+			t.Fatalf("%s repomd.xml: %v", arch, err)
+		}
+		primary, _, _ := metadata.GetCoreData(md)
+		d := *primary
+		d.Location.Href = arch + "/" + d.Location.Href
+		core, err := metadata.ReadAndVerifyCore(ctx, mb, d)
+		if err != nil {
+			t.Fatalf("%s primary: %v", arch, err)
+		}
+		return string(core.Uncompressed), md
+	}
+	x86, x86MD := subtree("x86_64")
+	if !strings.Contains(x86, `href="foo-1.0-1.x86_64.rpm"`) || !strings.Contains(x86, `href="docs-1.0-1.noarch.rpm"`) || strings.Contains(x86, "src.rpm") {
+		t.Fatalf("x86_64 primary lists the wrong packages:\n%s", x86)
+	}
+	if srcPrimary, _ := subtree("src"); !strings.Contains(srcPrimary, `href="../foo-1.0-1.src.rpm"`) || strings.Contains(srcPrimary, "noarch") {
+		t.Fatalf("src primary lists the wrong packages:\n%s", srcPrimary)
+	}
+	if _, ok := mb.files["noarch/repodata/repomd.xml"]; ok {
+		t.Fatalf("unexpected noarch subtree next to a binary arch")
+	}
+
+	// Without the option the subtrees are kept current, and an emptied arch is written empty.
+	r = New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64", "docs-1.0-1.noarch"}, true, false, false); err != nil {
+		t.Fatalf("RemoveRPMs: %v", err)
+	}
+	x86, _ = subtree("x86_64")
+	if !strings.Contains(x86, `packages="0"`) {
+		t.Fatalf("x86_64 subtree not emptied:\n%s", x86)
+	}
+	for _, d := range x86MD.Data {
+		if _, ok := mb.files["x86_64/"+d.Location.Href]; ok {
+			t.Fatalf("old x86_64 %s not deleted", d.Type)
+		}
+	}
+}
+
+func TestFilterRPMPaths(t *testing.T) {
+	paths := []string{"out/foo-1.0-1.x86_64.rpm", "out/foo-debuginfo-1.0-1.x86_64.rpm", "out/foo-tests-1.0-1.x86_64.rpm", "out/bar-2.0-1.noarch.rpm", StdinPath}
+	for _, tc := range []struct {
+		include, exclude []string
+		want             []string
+	}{
+		{nil, []string{"*-debuginfo-*", "*-tests-*"}, []string{"out/foo-1.0-1.x86_64.rpm", "out/bar-2.0-1.noarch.rpm", StdinPath}},
+		{[]string{"foo-*"}, nil, []string{"out/foo-1.0-1.x86_64.rpm", "out/foo-debuginfo-1.0-1.x86_64.rpm", "out/foo-tests-1.0-1.x86_64.rpm", StdinPath}},
+		{[]string{"foo-*", "*.noarch.rpm"}, []string{"*-debuginfo-*"}, []string{"out/foo-1.0-1.x86_64.rpm", "out/foo-tests-1.0-1.x86_64.rpm", "out/bar-2.0-1.noarch.rpm", StdinPath}},
+	} {
+		got, err := FilterRPMPaths(paths, tc.include, tc.exclude)
+		if err != nil {
+			t.Fatalf("filter: %v", err)
+		}
+		if strings.Join(got, " ") != strings.Join(tc.want, " ") {
+			t.Errorf("include %v exclude %v: got %v, want %v", tc.include, tc.exclude, got, tc.want)
+		}
+	}
+	if _, err := FilterRPMPaths(paths, []string{"["}, nil); err == nil {
+		t.Fatalf("expected an error for a malformed pattern")
+	}
+}
+
+func TestAddPreservesDirectoryLayout(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	build := t.TempDir()
+	rpmPath := filepath.Join(build, "x86_64", "bar-2.0-1.x86_64.rpm")
+	if err := os.MkdirAll(filepath.Dir(rpmPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(rpmPath, minimalRPM("bar", "2.0", "1", "x86_64"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.DestPrefix = "el9"
+	r.PreserveDirBase = build
+
+	result, err := r.AddRPMs(ctx, []string{rpmPath}, false, false, false, "")
+	if err != nil {
+		t.Fatalf("AddRPMs: %v", err)
+	}
+	if loc := result.Packages[0].Location; loc != "el9/x86_64/bar-2.0-1.x86_64.rpm" {
+		t.Fatalf("location = %q, want the build tree's layout under the prefix", loc)
+	}
+	if _, ok := mb.files["el9/x86_64/bar-2.0-1.x86_64.rpm"]; !ok {
+		t.Fatalf("RPM not stored at its preserved path")
+	}
+
+	r.PreserveDirBase = filepath.Join(build, "noarch")
+	if _, err := r.AddRPMs(ctx, []string{rpmPath}, true, true, false, ""); err == nil || !strings.Contains(err.Error(), "not under base directory") {
+		t.Fatalf("expected an RPM outside the base directory to be refused, got %v", err)
+	}
+}
+
+func TestAddRPMsResume(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"foo", "bar"} {
+		p := filepath.Join(dir, name+"-1.0-1.x86_64.rpm")
+		if err := os.WriteFile(p, minimalRPM(name, "1.0", "1", "x86_64"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, p)
+	}
+	// An interrupted run uploaded foo but never wrote metadata.
+	mb.files["foo-1.0-1.x86_64.rpm"] = minimalRPM("foo", "1.0", "1", "x86_64")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.Resume = true
+
+	result, err := r.AddRPMs(ctx, paths, false, false, false, "")
+	if err != nil {
+		t.Fatalf("AddRPMs: %v", err)
+	}
+	if want := int64(len(mb.files["bar-1.0-1.x86_64.rpm"])); result.BytesUploaded != want {
+		t.Fatalf("uploaded %d bytes, want only bar's %d", result.BytesUploaded, want)
+	}
+	if len(result.Packages) != 2 || result.Packages[0].Status != ChangeAdded {
+		t.Fatalf("expected both packages indexed, got %+v", result.Packages)
+	}
+
+	// A stale copy of different content is replaced.
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("partial")
+	if _, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64"}, true, false, false); err != nil {
+		t.Fatalf("RemoveRPMs: %v", err)
+	}
+	result, err = r.AddRPMs(ctx, paths, false, false, false, "")
+	if err != nil {
+		t.Fatalf("AddRPMs again: %v", err)
+	}
+	if result.Packages[0].Status != ChangeAdded || result.BytesUploaded == 0 {
+		t.Fatalf("expected foo to be uploaded again, got %+v", result)
+	}
+	if result.Packages[1].Status != ChangeSkipped {
+		t.Fatalf("expected the indexed bar to be skipped, got %+v", result.Packages[1])
+	}
+
+	// Without Resume an already indexed package is still a duplicate.
+	r.Resume = false
+	if _, err := r.AddRPMs(ctx, paths, false, true, false, ""); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected a duplicate error, got %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// archRepodataBase returns the directory of arch's metadata subtree, next to the directory the
+// main repodata lives in (e.g. "x86_64" for the default "repodata").
+func (r *Repo) archRepodataBase(arch string) string {
+	return path.Join(path.Dir(r.backend.RepodataDir()), arch)
+}
+
+// archSubtrees groups pkgs by the subtree they are published in. Binary arches get their own
+// packages plus every noarch package, since their clients install both; source packages form
+// their own subtrees. A repo holding only noarch packages gets a "noarch" subtree.
+func archSubtrees(pkgs []metadata.Package) map[string][]metadata.Package {
+	var noarch []metadata.Package
+	out := make(map[string][]metadata.Package)
+	for _, p := range pkgs {
+		if p.Arch == "noarch" {
+			noarch = append(noarch, p)
+			continue
+		}
+		out[p.Arch] = append(out[p.Arch], p)
+	}
+	binary := 0
+	for arch := range out {
+		if arch != "src" && arch != "nosrc" {
+			out[arch] = append(out[arch], noarch...)
+			binary++
+		}
+	}
+	if binary == 0 && len(noarch) > 0 {
+		out["noarch"] = noarch
+	}
+	return out
+}
+
+// writeArchRepodata writes, for every arch, a complete repository under <arch>/repodata/ listing
+// only that arch's packages, so clients pointed at <root>/<arch>/ fetch less metadata. Location
+// hrefs are made relative to the subtree; RPMs stored outside it are reached with "../". It runs
+// when ArchRepodata is set or a subtree already exists. Subtrees of arches that lost their last
+// package are rewritten empty, and core files the previous subtree listed are deleted.
+func (r *Repo) writeArchRepodata(ctx context.Context, md metadata.RepoMD, pkgs []metadata.Package, checksumAlg, revision string, now time.Time) error {
+	subtrees := archSubtrees(pkgs)
+	// Writes follow loadPackages, whose cached package set is then the one md describes.
+	if r.cache != nil && r.cache.key == packageCacheKey(md) {
+		for arch := range archSubtrees(r.cache.pkgs) {
+			if _, ok := subtrees[arch]; !ok {
+				subtrees[arch] = nil
+			}
+		}
+	}
+	arches := make([]string, 0, len(subtrees))
+	for arch := range subtrees {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+
+	if !r.ArchRepodata {
+		enabled := false
+		for _, arch := range arches {
+			exists, err := r.backend.Exists(ctx, metadata.RepomdPath(path.Join(r.archRepodataBase(arch), "repodata")))
+			if err != nil {
+				return err
+			}
+			if exists {
+				enabled = true
+				break
+			}
+		}
+		if !enabled {
+			return nil
+		}
+	}
+	for _, arch := range arches {
+		if err := r.writeArchSubtree(ctx, arch, subtrees[arch], checksumAlg, revision, now); err != nil {
+			return fmt.Errorf("write %s repodata: %w", arch, err)
+		}
+	}
+	return nil
+}
+
+func (r *Repo) writeArchSubtree(ctx context.Context, arch string, pkgs []metadata.Package, checksumAlg, revision string, now time.Time) error {
+	base := r.archRepodataBase(arch)
+	layout := r.coreLayout()
+	layout.Dir = path.Join(base, "repodata")
+	repomdPath := metadata.RepomdPath(layout.Dir)
+
+	var previous []string
+	if data, err := r.backend.ReadFile(ctx, repomdPath); err == nil {
+		if old, err := metadata.ParseRepoMD(data); err == nil {
+			for _, d := range old.Data {
+				previous = append(previous, d.Location.Href)
+			}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("read %s: %w", repomdPath, err)
+	}
+
+	sub := make([]metadata.Package, len(pkgs))
+	for i, p := range pkgs {
+		if rel, ok := strings.CutPrefix(p.Location, arch+"/"); ok {
+			p.Location = rel
+		} else {
+			p.Location = "../" + p.Location
+		}
+		sub[i] = p
+	}
+	coreFiles, err := metadata.BuildCoreFilesFromPackages(sub, checksumAlg, layout, now)
+	if err != nil {
+		return fmt.Errorf("build core metadata: %w", err)
+	}
+	// Core file hrefs in repomd.xml are relative to the subtree, like the package locations.
+	for i := range coreFiles {
+		coreFiles[i].Path = strings.TrimPrefix(coreFiles[i].Path, base+"/")
+	}
+	subMD := metadata.UpdateRepoMDWithCore(metadata.RepoMD{}, coreFiles, checksumAlg, now)
+	subMD.Revision = revision
+	repomd, err := metadata.MarshalRepoMD(subMD)
+	if err != nil {
+		return fmt.Errorf("marshal repomd.xml: %w", err)
+	}
+	for i := range coreFiles {
+		coreFiles[i].Path = path.Join(base, coreFiles[i].Path)
+		if err := r.backend.WriteFile(ctx, coreFiles[i].Path, coreFiles[i].Compressed); err != nil {
+			return fmt.Errorf("write %s: %w", coreFiles[i].Path, err)
+		}
+	}
+	if err := r.signCoreFiles(ctx, coreFiles); err != nil {
+		return err
+	}
+	if err := r.backend.WriteFile(ctx, repomdPath, repomd); err != nil {
+		return fmt.Errorf("write %s: %w", repomdPath, err)
+	}
+
+	current := make(map[string]struct{}, len(subMD.Data))
+	for _, d := range subMD.Data {
+		current[d.Location.Href] = struct{}{}
+	}
+	for _, href := range previous {
+		if _, ok := current[href]; ok {
+			continue
+		}
+		old := path.Join(base, href)
+		if err := r.backend.DeleteFile(ctx, old); err != nil {
+			r.logger.Printf("warn: delete %s: %v", old, err)
+		}
+		if r.SignMetadataFiles {
+			if err := r.backend.DeleteFile(ctx, old+".asc"); err != nil {
+				r.logger.Printf("warn: delete %s: %v", old+".asc", err)
+			}
+		}
+	}
+	return nil
+}
@@ -4,56 +4,435 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/e2llm/rpmrepo-update/pkg/backend"
+	"github.com/e2llm/rpmrepo-update/pkg/inspector"
 	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
-// CheckResult captures warnings and an optional terminal error.
+// Warning codes are stable identifiers for routing warnings without matching message text.
+const (
+	WarnUnknownMetadataType = "unknown_metadata_type"
+	WarnMissingFilelists    = "missing_filelists"
+	WarnMixedChecksumTypes  = "mixed_checksum_types"
+	WarnInvalidDepFlags     = "invalid_dependency_flags"
+	WarnUnverifiableCore    = "unverifiable_core_checksum"
+	WarnUnknownArch         = "unknown_arch"
+	WarnDuplicateLocation   = "duplicate_location"
+)
+
+// Warning is a non-fatal finding with a stable machine-readable code.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// String renders the warning for text output.
+func (w Warning) String() string {
+	return w.Message
+}
+
+func unknownTypeWarning(typ string) Warning {
+	return Warning{
+		Code:    WarnUnknownMetadataType,
+		Message: fmt.Sprintf("preserving unknown metadata type '%s' from repomd.xml; checksum not verified", typ),
+		Detail:  typ,
+	}
+}
+
+// mixedChecksumWarning reports core metadata entries whose checksum types disagree. Writes
+// normalize to primary's algorithm, but clients reading the repo as-is may be confused by the mix.
+func mixedChecksumWarning(core []*metadata.RepoData) (Warning, bool) {
+	var types []string
+	algs := make(map[string]struct{})
+	for _, d := range core {
+		if d == nil {
+			continue
+		}
+		types = append(types, d.Type+"="+d.Checksum.Type)
+		algs[d.Checksum.Type] = struct{}{}
+	}
+	if len(algs) < 2 {
+		return Warning{}, false
+	}
+	detail := strings.Join(types, ", ")
+	return Warning{
+		Code:    WarnMixedChecksumTypes,
+		Message: fmt.Sprintf("core metadata uses mixed checksum types (%s)", detail),
+		Detail:  detail,
+	}, true
+}
+
+// depFlagWarnings reports packages carrying dependency flags that are not EQ/LT/LE/GT/GE. The
+// values are preserved on rewrite, so clients keep seeing them until the package is re-added.
+func depFlagWarnings(pkgs []metadata.Package) []Warning {
+	var warnings []Warning
+	for _, p := range pkgs {
+		invalid := p.InvalidDepFlags()
+		if len(invalid) == 0 {
+			continue
+		}
+		warnings = append(warnings, Warning{
+			Code:    WarnInvalidDepFlags,
+			Message: fmt.Sprintf("package %s has unknown dependency flags (%s); kept unchanged", p.NEVRA(), strings.Join(invalid, "; ")),
+			Detail:  p.NEVRA(),
+		})
+	}
+	return warnings
+}
+
+// archWarnings reports packages whose arch is empty or neither known to rpm nor in allowed.
+// Clients skip packages whose arch they do not recognize.
+func archWarnings(pkgs []metadata.Package, allowed []string) []Warning {
+	var warnings []Warning
+	for _, p := range pkgs {
+		if err := inspector.CheckArch(p.Arch, allowed); err != nil {
+			warnings = append(warnings, Warning{
+				Code:    WarnUnknownArch,
+				Message: fmt.Sprintf("package %s has %v", p.NEVRA(), err),
+				Detail:  p.NEVRA(),
+			})
+		}
+	}
+	return warnings
+}
+
+// locationConflict is a location href shared by more than one package.
+type locationConflict struct {
+	Location string
+	NEVRAs   []string
+}
+
+func (c locationConflict) String() string {
+	return fmt.Sprintf("%s is the location of %s", c.Location, strings.Join(c.NEVRAs, ", "))
+}
+
+// duplicateLocations returns the locations shared by several packages, sorted by location. Such
+// packages would overwrite each other's RPM and be indistinguishable to check and remove.
+func duplicateLocations(pkgs []metadata.Package) []locationConflict {
+	byLocation := make(map[string][]string, len(pkgs))
+	for _, p := range pkgs {
+		byLocation[p.Location] = append(byLocation[p.Location], p.NEVRA())
+	}
+	var conflicts []locationConflict
+	for loc, nevras := range byLocation {
+		if len(nevras) > 1 {
+			conflicts = append(conflicts, locationConflict{Location: loc, NEVRAs: nevras})
+		}
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Location < conflicts[j].Location })
+	return conflicts
+}
+
+// duplicateLocationWarnings reports packages sharing a location href.
+func duplicateLocationWarnings(pkgs []metadata.Package) []Warning {
+	var warnings []Warning
+	for _, c := range duplicateLocations(pkgs) {
+		warnings = append(warnings, Warning{
+			Code:    WarnDuplicateLocation,
+			Message: "duplicate package location: " + c.String(),
+			Detail:  c.Location,
+		})
+	}
+	return warnings
+}
+
+// CheckResult captures warnings, RPM consistency findings, and an optional terminal error.
 type CheckResult struct {
-	Warnings []string `json:"warnings"`
-	Err      error    `json:"-"`
+	Warnings []Warning `json:"warnings"`
+	// Orphaned lists RPMs present in the repo but not referenced by metadata.
+	Orphaned []string `json:"orphaned"`
+	// Missing lists RPM locations referenced by metadata but absent from the repo.
+	Missing []string `json:"missing"`
+	// Fixed lists repomd.xml values corrected by CheckOptions.Fix.
+	Fixed []RepomdFix `json:"fixed,omitempty"`
+	// Unresolved lists hard requirements no package provides; only set with CheckOptions.Deps.
+	Unresolved []UnresolvedDep `json:"unresolved,omitempty"`
+	// Indexed lists the orphaned RPMs added to metadata by CheckOptions.IndexOrphans; they are no
+	// longer reported in Orphaned. The stats below describe the metadata before indexing.
+	Indexed []PackageChange `json:"indexed,omitempty"`
+	// Unsigned lists the NEVRAs of packages whose RPM has no OpenPGP signature; only set with
+	// CheckOptions.RequireSigned.
+	Unsigned []string `json:"unsigned,omitempty"`
+	// Signature is the repomd.xml signature check; nil unless CheckOptions.RepomdSignature is set.
+	Signature *SignatureCheck `json:"signature,omitempty"`
+	// Revision is the repomd.xml revision.
+	Revision string `json:"revision"`
+	// PackageCount and TotalRPMSize (the sum of package sizes recorded in primary) are zero when
+	// the packages could not be parsed.
+	PackageCount int    `json:"package_count"`
+	TotalRPMSize uint64 `json:"total_rpm_size"`
+	// FileCount and ChangelogCount total the file and changelog entries of all packages. Without
+	// filelists metadata, FileCount only covers the files listed in primary.
+	FileCount      int `json:"file_count"`
+	ChangelogCount int `json:"changelog_count"`
+	// MetadataSize is the size of repomd.xml plus every file it lists; verified core files count
+	// their actual size, the rest the size recorded in repomd.xml.
+	MetadataSize int64 `json:"metadata_size"`
+	// CoreFiles has the sizes of each core metadata file listed in repomd.xml, measured the same way.
+	CoreFiles []CoreFileStats `json:"core_files"`
+	Err       error           `json:"-"`
+}
+
+// CoreFileStats describes the size of one core metadata file.
+type CoreFileStats struct {
+	Type     string `json:"type"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	OpenSize int64  `json:"open_size"`
+}
+
+// SourcesDir holds source RPMs kept next to the repository without being indexed; check does not
+// report the .src.rpm files in it as orphans.
+const SourcesDir = "sources"
+
+// Signature check statuses reported in SignatureCheck.Status.
+const (
+	SignatureValid   = "valid"
+	SignatureMissing = "missing"
+	SignatureInvalid = "invalid"
+)
+
+// SignatureCheck reports whether repomd.xml.asc exists and verifies.
+type SignatureCheck struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+	// Detail carries gpg's explanation for an invalid signature.
+	Detail string `json:"detail,omitempty"`
+}
+
+// OK reports whether the signature is present and valid.
+func (s *SignatureCheck) OK() bool {
+	return s == nil || s.Status == SignatureValid
+}
+
+// String renders the signature check for text output.
+func (s SignatureCheck) String() string {
+	switch s.Status {
+	case SignatureValid:
+		return fmt.Sprintf("signature valid: %s", s.Path)
+	case SignatureMissing:
+		return fmt.Sprintf("signature missing: %s", s.Path)
+	default:
+		return fmt.Sprintf("signature invalid: %s: %s", s.Path, s.Detail)
+	}
+}
+
+// RepomdFix records a stale repomd.xml value that was replaced with the value computed from the file.
+type RepomdFix struct {
+	Type  string `json:"type"`
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// String renders the fix for text output.
+func (f RepomdFix) String() string {
+	return fmt.Sprintf("%s %s: %s -> %s", f.Type, f.Field, f.Old, f.New)
+}
+
+// Failure returns Err combined with an error for any orphaned, missing or unsigned RPMs or
+// unresolved dependencies, or nil if the check passed.
+func (c CheckResult) Failure() error {
+	errs := []error{c.Err}
+	for _, p := range c.Missing {
+		errs = append(errs, fmt.Errorf("rpm missing: %s", p))
+	}
+	for _, p := range c.Orphaned {
+		errs = append(errs, fmt.Errorf("rpm present but not referenced: %s", p))
+	}
+	for _, u := range c.Unresolved {
+		errs = append(errs, fmt.Errorf("unresolved dependency: %s", u))
+	}
+	for _, p := range c.Unsigned {
+		errs = append(errs, fmt.Errorf("package not signed: %s", p))
+	}
+	if !c.Signature.OK() {
+		errs = append(errs, errors.New(c.Signature.String()))
+	}
+	return errors.Join(errs...)
+}
+
+// CheckOptions selects which checks to run. The zero value runs everything.
+type CheckOptions struct {
+	// MetadataOnly verifies repomd.xml and the core files only, without listing RPMs or checking
+	// that referenced ones exist. It suits frequent health probes of large repositories.
+	MetadataOnly bool
+	// OrphansOnly limits RPM consistency checks to RPMs present but not referenced.
+	OrphansOnly bool
+	// MissingOnly limits RPM consistency checks to referenced RPMs that are absent.
+	MissingOnly bool
+	// Fix rewrites repomd.xml with the checksums and sizes of the core files when those files
+	// decompress and parse but the recorded values are stale. Core files are never modified.
+	Fix bool
+	// IndexOrphans inspects orphaned RPMs and adds them to metadata where they are stored, instead
+	// of reporting them. It is skipped when the metadata itself has errors.
+	IndexOrphans bool
+	// ReplaceExisting lets IndexOrphans replace a package with the same NEVRA already in metadata.
+	ReplaceExisting bool
+	// NoSource leaves source packages out of every RPM-level check: arch src/nosrc packages in
+	// metadata and .src.rpm files in storage are neither checked nor reported.
+	NoSource bool
+	// VerifyRPMs streams every referenced RPM and compares its checksum with the recorded pkgid.
+	VerifyRPMs bool
+	// VerifyHeaderRange reads the header range recorded for each package from its RPM and checks
+	// that it holds a valid RPM header, as clients doing header-only fetches rely on.
+	VerifyHeaderRange bool
+	// RequireSigned reads the signature header of each referenced RPM and reports packages
+	// without an OpenPGP signature in CheckResult.Unsigned. Signatures are not verified.
+	RequireSigned bool
+	// RepomdSignature requires repomd.xml.asc to exist and verify.
+	RepomdSignature bool
+	// GPGKeyring is a public key file (armored or binary) to verify against. Empty uses the
+	// default gpg keyring (or Repo.GPGHomedir).
+	GPGKeyring string
+	// Deps checks that every hard requirement of a binary package is provided by some package in
+	// the repository or by AssumeProvided, and reports the rest in CheckResult.Unresolved.
+	Deps bool
+	// AssumeProvided lists dependencies available outside the repository, e.g. from a base repo.
+	AssumeProvided []metadata.Relation
+	// Skip lists metadata types whose files are neither read nor verified, for faster checks of
+	// large repositories (see ParseCheckSkip). Packages then lack what those files carry: file
+	// lists without filelists, changelogs without other.
+	Skip map[string]struct{}
+}
+
+// skippableTypes are the metadata types CheckOptions.Skip accepts. primary is always verified,
+// since every other check reads the packages from it.
+var skippableTypes = []string{"filelists", "filelists_ext", "other"}
+
+// ParseCheckSkip parses a comma-separated list of metadata types for CheckOptions.Skip.
+func ParseCheckSkip(list string) (map[string]struct{}, error) {
+	skip := make(map[string]struct{})
+	for _, t := range strings.Split(list, ",") {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		if !slices.Contains(skippableTypes, t) {
+			return nil, fmt.Errorf("cannot skip metadata type %q (skippable: %s)", t, strings.Join(skippableTypes, ", "))
+		}
+		skip[t] = struct{}{}
+	}
+	return skip, nil
 }
 
 // CheckDetailed performs checks and returns warnings/errors without writing output.
-func (r *Repo) CheckDetailed(ctx context.Context) CheckResult {
-	warnings, err := r.checkCollect(ctx)
-	return CheckResult{Warnings: warnings, Err: err}
+func (r *Repo) CheckDetailed(ctx context.Context, opts CheckOptions) CheckResult {
+	return r.checkCollect(ctx, opts)
 }
 
 // Check validates that core metadata files exist, decompress, and match checksums recorded in repomd.xml.
 func (r *Repo) Check(ctx context.Context) error {
-	warnings, err := r.checkCollect(ctx)
-	for _, w := range warnings {
+	result := r.checkCollect(ctx, CheckOptions{})
+	for _, w := range result.Warnings {
 		r.logger.Printf("warn: %s", w)
 	}
-	return err
+	return result.Failure()
 }
 
-func (r *Repo) checkCollect(ctx context.Context) ([]string, error) {
+func (r *Repo) checkCollect(ctx context.Context, opts CheckOptions) CheckResult {
+	var result CheckResult
 	if r.backend == nil {
-		return nil, fmt.Errorf("backend is required")
+		result.Err = fmt.Errorf("backend is required")
+		return result
+	}
+	if opts.OrphansOnly && opts.MissingOnly {
+		result.Err = fmt.Errorf("orphans-only and missing-only are mutually exclusive")
+		return result
+	}
+	if opts.IndexOrphans && opts.MissingOnly {
+		result.Err = fmt.Errorf("index-orphans and missing-only are mutually exclusive")
+		return result
+	}
+	if opts.VerifyHeaderRange && opts.OrphansOnly {
+		result.Err = fmt.Errorf("verify-header-range and orphans-only are mutually exclusive")
+		return result
+	}
+	if opts.VerifyRPMs && opts.OrphansOnly {
+		result.Err = fmt.Errorf("verify-rpms and orphans-only are mutually exclusive")
+		return result
+	}
+	if opts.RequireSigned && opts.OrphansOnly {
+		result.Err = fmt.Errorf("require-signed and orphans-only are mutually exclusive")
+		return result
+	}
+	if opts.MetadataOnly && (opts.OrphansOnly || opts.MissingOnly || opts.IndexOrphans || opts.VerifyRPMs || opts.VerifyHeaderRange || opts.RequireSigned) {
+		result.Err = fmt.Errorf("metadata-only cannot be combined with RPM checks")
+		return result
+	}
+	if _, ok := opts.Skip["filelists"]; ok && opts.Deps {
+		result.Err = fmt.Errorf("deps needs the file lists; filelists cannot be skipped")
+		return result
+	}
+	if opts.Fix {
+		fixed, err := r.fixRepomd(ctx)
+		if err != nil {
+			result.Err = fmt.Errorf("fix repomd.xml: %w", err)
+			return result
+		}
+		result.Fixed = fixed
 	}
 	md, err := metadata.LoadRepoMD(ctx, r.backend)
+	if errors.Is(err, metadata.ErrTruncatedRepoMD) {
+		result.Err = fmt.Errorf("load repomd.xml: %w%s", err, r.repomdBackupHint(ctx))
+		return result
+	}
 	if err != nil {
-		return nil, fmt.Errorf("load repomd.xml: %w", err)
+		result.Err = fmt.Errorf("load repomd.xml: %w", err)
+		return result
+	}
+	result.Revision = md.Revision
+	if repomd, err := r.backend.ReadFile(ctx, r.repomdPath()); err == nil {
+		result.MetadataSize = int64(len(repomd))
 	}
 	primary, filelists, other := metadata.GetCoreData(md)
 	var errs []error
 	if primary == nil {
 		errs = append(errs, errors.New("missing primary metadata in repomd.xml"))
 	}
-	if filelists == nil {
-		errs = append(errs, errors.New("missing filelists metadata in repomd.xml"))
+	_, skipFilelists := opts.Skip["filelists"]
+	if filelists == nil && primary != nil && !skipFilelists {
+		result.Warnings = append(result.Warnings, Warning{
+			Code:    WarnMissingFilelists,
+			Message: "repomd.xml has no filelists metadata; file lists are not checked",
+		})
 	}
-	if other == nil {
-		errs = append(errs, errors.New("missing other metadata in repomd.xml"))
+	if w, ok := mixedChecksumWarning([]*metadata.RepoData{primary, filelists, other}); ok {
+		result.Warnings = append(result.Warnings, w)
 	}
-	for _, d := range []*metadata.RepoData{primary, filelists, other} {
+	// Only primary is mandatory; filelists and other are verified and parsed when present.
+	verified := make(map[string][]byte, 3)
+	coreSizes := make(map[string]int64, 3)
+	for _, d := range []*metadata.RepoData{primary, filelists, other, metadata.FindData(md, "filelists_ext")} {
 		if d == nil {
 			continue
 		}
+		if _, ok := opts.Skip[d.Type]; ok {
+			continue
+		}
+		stats := CoreFileStats{Type: d.Type, Path: d.Location.Href, Size: d.Size, OpenSize: d.OpenSize}
 		core, err := metadata.ReadAndVerifyCore(ctx, r.backend, *d)
+		if err == nil {
+			stats.Size, stats.OpenSize = core.Size, core.OpenSize
+		}
+		result.CoreFiles = append(result.CoreFiles, stats)
+		if errors.Is(err, metadata.ErrUnsupportedChecksum) {
+			// A legacy algorithm on one file should not hide problems elsewhere; its contents are skipped.
+			result.Warnings = append(result.Warnings, Warning{
+				Code:    WarnUnverifiableCore,
+				Message: fmt.Sprintf("core %s cannot be verified: %v; its contents are not checked", d.Type, err),
+				Detail:  d.Type,
+			})
+			continue
+		}
 		if err != nil {
 			errs = append(errs, fmt.Errorf("core %s: %w", d.Type, err))
 			continue
@@ -64,68 +443,319 @@ func (r *Repo) checkCollect(ctx context.Context) ([]string, error) {
 		if d.OpenSize != 0 && d.OpenSize != core.OpenSize {
 			errs = append(errs, fmt.Errorf("core %s open-size mismatch: repomd=%d actual=%d", d.Type, d.OpenSize, core.OpenSize))
 		}
+		verified[d.Type] = core.Uncompressed
+		coreSizes[d.Type] = core.Size
+	}
+	for _, d := range md.Data {
+		if size, ok := coreSizes[d.Type]; ok {
+			result.MetadataSize += size
+		} else {
+			result.MetadataSize += d.Size
+		}
 	}
 
 	// Parse packages for deeper checks.
 	var pkgs []metadata.Package
-	if len(errs) == 0 && primary != nil && filelists != nil && other != nil {
-		primaryCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *primary)
+	parsed := false
+	if len(errs) == 0 && verified["primary"] != nil {
+		pkgs, err = metadata.ParsePackagesFromXML(verified["primary"], verified["filelists"], verified["other"])
 		if err != nil {
-			errs = append(errs, fmt.Errorf("primary parse: %w", err))
+			errs = append(errs, fmt.Errorf("parse packages: %w", err))
 		} else {
-			filelistsCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *filelists)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("filelists parse: %w", err))
-			} else {
-				otherCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *other)
-				if err != nil {
-					errs = append(errs, fmt.Errorf("other parse: %w", err))
-				} else {
-					pkgs, err = metadata.ParsePackagesFromXML(primaryCore.Uncompressed, filelistsCore.Uncompressed, otherCore.Uncompressed)
-					if err != nil {
-						errs = append(errs, fmt.Errorf("parse packages: %w", err))
-					}
-				}
+			parsed = true
+			result.Warnings = append(result.Warnings, depFlagWarnings(pkgs)...)
+			result.Warnings = append(result.Warnings, archWarnings(pkgs, r.AllowedArches)...)
+			result.Warnings = append(result.Warnings, duplicateLocationWarnings(pkgs)...)
+			if opts.Deps {
+				result.Unresolved = unresolvedDeps(pkgs, opts.AssumeProvided)
+			}
+			result.PackageCount = len(pkgs)
+			for _, p := range pkgs {
+				result.TotalRPMSize += p.SizePackage
+				result.FileCount += len(p.Files)
+				result.ChangelogCount += len(p.Changelogs)
 			}
 		}
 	}
 
-	if len(pkgs) > 0 {
-		rpmList, err := r.backend.ListRPMs(ctx)
+	if parsed && !opts.MetadataOnly {
+		rpmList, err := r.listRPMs(ctx)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("list rpms: %w", err))
 		} else {
 			expected := make(map[string]struct{}, len(pkgs))
 			for _, p := range pkgs {
-				expected[p.Location] = struct{}{}
 				if p.Location == "" {
 					errs = append(errs, fmt.Errorf("package %s missing location", p.NEVRA()))
 					continue
 				}
-				exists, err := r.backend.Exists(ctx, p.Location)
+				storagePath := r.storagePathFor(p.Location)
+				expected[storagePath] = struct{}{}
+				if opts.NoSource && p.IsSource() {
+					continue
+				}
+				if p.IsSource() && !metadata.IsSourceRPMPath(p.Location) {
+					errs = append(errs, fmt.Errorf("source package %s: location %s is not a .src.rpm", p.NEVRA(), p.Location))
+				}
+				if err := r.checkDedupeAddress(storagePath, p); err != nil {
+					errs = append(errs, err)
+				}
+				if opts.OrphansOnly {
+					continue
+				}
+				exists, err := r.backend.Exists(ctx, storagePath)
 				if err != nil {
-					errs = append(errs, fmt.Errorf("exists %s: %w", p.Location, err))
+					errs = append(errs, fmt.Errorf("exists %s: %w", storagePath, err))
 					continue
 				}
 				if !exists {
-					errs = append(errs, fmt.Errorf("rpm missing for %s (%s)", p.NEVRA(), p.Location))
+					result.Missing = append(result.Missing, storagePath)
+					continue
+				}
+				if opts.VerifyHeaderRange {
+					if err := r.verifyHeaderRange(ctx, storagePath, p); err != nil {
+						errs = append(errs, err)
+					}
+				}
+				if opts.VerifyRPMs {
+					if err := r.verifyRPMChecksum(ctx, storagePath, p); err != nil {
+						errs = append(errs, err)
+					}
+				}
+				if opts.RequireSigned {
+					if err := r.readSignature(ctx, &p); err != nil {
+						errs = append(errs, err)
+					} else if !p.Signed {
+						result.Unsigned = append(result.Unsigned, p.NEVRA())
+					}
 				}
 			}
-			for _, rpmPath := range rpmList {
-				base := filepath.ToSlash(rpmPath)
-				if _, ok := expected[base]; !ok {
-					errs = append(errs, fmt.Errorf("rpm present but not referenced: %s", base))
+			if !opts.MissingOnly {
+				for _, rpmPath := range rpmList {
+					base := filepath.ToSlash(rpmPath)
+					// Store RPMs may belong to other repos sharing the root.
+					if r.inDedupeStore(base) {
+						continue
+					}
+					// Source RPMs kept in sources/ are deliberately not indexed.
+					if metadata.IsSourceRPMPath(base) && (opts.NoSource || strings.HasPrefix(base, SourcesDir+"/")) {
+						continue
+					}
+					if _, ok := expected[base]; !ok {
+						result.Orphaned = append(result.Orphaned, base)
+					}
 				}
 			}
 		}
 	}
 
-	var warnings []string
 	for _, d := range md.Data {
-		if d.Type != "primary" && d.Type != "filelists" && d.Type != "other" && d.Type != "modules" {
-			warnings = append(warnings, fmt.Sprintf("preserving unknown metadata type '%s' from repomd.xml; checksum not verified", d.Type))
+		switch d.Type {
+		case "primary", "filelists", "filelists_ext", "other", "modules", "group", "group_gz", "updateinfo":
+		default:
+			result.Warnings = append(result.Warnings, unknownTypeWarning(d.Type))
 		}
 	}
 
-	return warnings, errors.Join(errs...)
+	if opts.IndexOrphans && len(result.Orphaned) > 0 && len(errs) == 0 {
+		sort.Strings(result.Orphaned)
+		indexed, err := r.IndexOrphans(ctx, result.Orphaned, opts.ReplaceExisting, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("index orphans: %w", err))
+		} else {
+			result.Indexed = indexed.Packages
+			result.Orphaned = nil
+		}
+	}
+
+	if opts.RepomdSignature {
+		sig, err := r.checkRepomdSignature(ctx, opts.GPGKeyring)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		result.Signature = sig
+	}
+
+	sort.Strings(result.Orphaned)
+	sort.Strings(result.Missing)
+	sort.Strings(result.Unsigned)
+	result.Err = errors.Join(errs...)
+	return result
+}
+
+// verifyRPMChecksum hashes pkg's RPM as it streams from the backend, so memory use does not grow
+// with package size, and compares the result with the pkgid and package size in metadata.
+func (r *Repo) verifyRPMChecksum(ctx context.Context, storagePath string, pkg metadata.Package) error {
+	rc, err := r.backend.Open(ctx, storagePath)
+	if err != nil {
+		return fmt.Errorf("package %s: open %s: %w", pkg.NEVRA(), storagePath, err)
+	}
+	defer rc.Close()
+	sum, n, err := metadata.ComputeChecksumReader(rc, pkg.ChecksumType)
+	if err != nil {
+		return fmt.Errorf("package %s: checksum %s: %w", pkg.NEVRA(), storagePath, err)
+	}
+	if sum != pkg.PkgID {
+		return fmt.Errorf("package %s: %s %s of %s does not match pkgid %s", pkg.NEVRA(), pkg.ChecksumType, sum, storagePath, pkg.PkgID)
+	}
+	if pkg.SizePackage != 0 && uint64(n) != pkg.SizePackage {
+		return fmt.Errorf("package %s: %s is %d bytes, metadata says %d", pkg.NEVRA(), storagePath, n, pkg.SizePackage)
+	}
+	return nil
+}
+
+// verifyHeaderRange fetches only the recorded header range of pkg's RPM and validates it.
+// Packages without a header range are skipped.
+func (r *Repo) verifyHeaderRange(ctx context.Context, storagePath string, pkg metadata.Package) error {
+	if pkg.HeaderEnd == 0 {
+		return nil
+	}
+	if pkg.HeaderEnd <= pkg.HeaderStart {
+		return fmt.Errorf("package %s: invalid header range %d-%d", pkg.NEVRA(), pkg.HeaderStart, pkg.HeaderEnd)
+	}
+	data, err := r.backend.ReadRange(ctx, storagePath, int64(pkg.HeaderStart), int64(pkg.HeaderEnd-pkg.HeaderStart))
+	if err != nil {
+		return fmt.Errorf("package %s: read header range: %w", pkg.NEVRA(), err)
+	}
+	if err := inspector.ValidateHeader(data); err != nil {
+		return fmt.Errorf("package %s: header range %d-%d of %s: %w", pkg.NEVRA(), pkg.HeaderStart, pkg.HeaderEnd, storagePath, err)
+	}
+	return nil
+}
+
+// repomdBackupHint points at an intact repomd.xml backup for recovery messages, or returns "" if
+// there is none.
+func (r *Repo) repomdBackupHint(ctx context.Context) string {
+	backupPath := repomdBackupPath(r.repomdPath())
+	data, err := r.backend.ReadFile(ctx, backupPath)
+	if err != nil {
+		return ""
+	}
+	backup, err := metadata.ParseRepoMD(data)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("; %s (revision %s) is intact and can be copied back instead", backupPath, backup.Revision)
+}
+
+// checkRepomdSignature verifies repomd.xml.asc against repomd.xml. A missing or bad signature
+// is a finding, not an error; errors are reserved for failing to read or run the check.
+func (r *Repo) checkRepomdSignature(ctx context.Context, keyring string) (*SignatureCheck, error) {
+	repomdPath := r.repomdPath()
+	check := &SignatureCheck{Path: repomdPath + ".asc"}
+	sig, err := r.backend.ReadFile(ctx, check.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		check.Status = SignatureMissing
+		return check, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", check.Path, err)
+	}
+	data, err := r.backend.ReadFile(ctx, repomdPath)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", repomdPath, err)
+	}
+	if err := r.verifyDetached(ctx, data, sig, keyring); err != nil {
+		var bad *badSignatureError
+		if !errors.As(err, &bad) {
+			return nil, fmt.Errorf("verify %s: %w", check.Path, err)
+		}
+		check.Status = SignatureInvalid
+		check.Detail = bad.detail
+		return check, nil
+	}
+	check.Status = SignatureValid
+	return check, nil
+}
+
+// fixRepomd reconciles the core entries in repomd.xml with the files they reference.
+// It refuses to write unless every core file decompresses and parses, and a checksum-prefixed
+// filename still matches its content.
+func (r *Repo) fixRepomd(ctx context.Context) ([]RepomdFix, error) {
+	md, err := metadata.LoadRepoMD(ctx, r.backend)
+	if err != nil {
+		return nil, fmt.Errorf("load repomd.xml: %w", err)
+	}
+	primary, filelists, other := metadata.GetCoreData(md)
+	if primary == nil {
+		return nil, errors.New("repo missing primary metadata")
+	}
+	var entries []*metadata.RepoData
+	for _, d := range []*metadata.RepoData{primary, filelists, other} {
+		if d != nil {
+			entries = append(entries, d)
+		}
+	}
+	cores := make([]metadata.CoreFile, len(entries))
+	uncompressed := make(map[string][]byte, len(entries))
+	for i, d := range entries {
+		core, err := metadata.ReadCore(ctx, r.backend, *d)
+		if err != nil {
+			return nil, fmt.Errorf("core %s: %w", d.Type, err)
+		}
+		if prefix := backend.ChecksumPrefix(d.Location.Href); prefix != "" && prefix != core.Checksum {
+			return nil, fmt.Errorf("core %s: %s content does not match its filename checksum", d.Type, d.Location.Href)
+		}
+		cores[i] = core
+		uncompressed[d.Type] = core.Uncompressed
+	}
+	if _, err := metadata.ParsePackagesFromXML(uncompressed["primary"], uncompressed["filelists"], uncompressed["other"]); err != nil {
+		return nil, fmt.Errorf("core files do not parse: %w", err)
+	}
+
+	var fixes []RepomdFix
+	note := func(typ, field, recorded, actual string) {
+		if recorded != actual {
+			fixes = append(fixes, RepomdFix{Type: typ, Field: field, Old: recorded, New: actual})
+		}
+	}
+	for i, d := range entries {
+		core := cores[i]
+		alg := d.Checksum.Type
+		if alg == "" {
+			alg = "sha256"
+		}
+		note(d.Type, "checksum", d.Checksum.Value, core.Checksum)
+		d.Checksum = metadata.Checksum{Type: alg, Value: core.Checksum}
+		openAlg := alg
+		oldOpen := ""
+		if d.OpenChecksum != nil {
+			oldOpen = d.OpenChecksum.Value
+			if d.OpenChecksum.Type != "" {
+				openAlg = d.OpenChecksum.Type
+			}
+		}
+		note(d.Type, "open-checksum", oldOpen, core.OpenChecksum)
+		d.OpenChecksum = &metadata.Checksum{Type: openAlg, Value: core.OpenChecksum}
+		note(d.Type, "size", fmt.Sprint(d.Size), fmt.Sprint(core.Size))
+		d.Size = core.Size
+		note(d.Type, "open-size", fmt.Sprint(d.OpenSize), fmt.Sprint(core.OpenSize))
+		d.OpenSize = core.OpenSize
+	}
+	if len(fixes) == 0 {
+		return nil, nil
+	}
+
+	if validator, ok := r.backend.(RepomdValidator); ok {
+		if err := validator.CheckRepomdUnchanged(ctx); err != nil {
+			return nil, err
+		}
+	}
+	revision, err := r.nextRevision(md.Revision, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	md.Revision = revision
+	repomdBytes, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		return nil, fmt.Errorf("marshal repomd.xml: %w", err)
+	}
+	repomdPath := r.repomdPath()
+	if err := r.backend.WriteFile(ctx, repomdPath, repomdBytes); err != nil {
+		return nil, fmt.Errorf("write %s: %w", repomdPath, err)
+	}
+	if signed, err := r.backend.Exists(ctx, repomdPath+".asc"); err == nil && signed {
+		r.logger.Printf("warn: %s.asc no longer matches the rewritten repomd.xml; re-sign it", repomdPath)
+	}
+	return fixes, nil
 }
@@ -0,0 +1,808 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func newCheckFixture(t *testing.T) *Repo {
+	t.Helper()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "bar", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.x86_64.rpm"},
+	})
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	mb.files["stray-1.0-1.x86_64.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	return r
+}
+
+func TestCheckReportsOrphanedAndMissing(t *testing.T) {
+	r := newCheckFixture(t)
+	result := r.CheckDetailed(context.Background(), CheckOptions{})
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+	if len(result.Missing) != 1 || result.Missing[0] != "bar-1.0-1.x86_64.rpm" {
+		t.Fatalf("unexpected missing: %v", result.Missing)
+	}
+	if len(result.Orphaned) != 1 || result.Orphaned[0] != "stray-1.0-1.x86_64.rpm" {
+		t.Fatalf("unexpected orphaned: %v", result.Orphaned)
+	}
+	if result.Failure() == nil {
+		t.Fatalf("expected Failure to report findings")
+	}
+}
+
+// TestCheckDefaultFailsOnMissingOrOrphaned pins the exit status of a plain check: before missing
+// and orphaned RPMs were reported separately they failed the check as errors, and they still must.
+func TestCheckDefaultFailsOnMissingOrOrphaned(t *testing.T) {
+	ctx := context.Background()
+	pkgs := []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	}
+	for name, tc := range map[string]struct {
+		files   []string
+		wantErr bool
+	}{
+		"consistent": {files: []string{"foo-1.0-1.x86_64.rpm"}},
+		"missing":    {files: nil, wantErr: true},
+		"orphaned":   {files: []string{"foo-1.0-1.x86_64.rpm", "stray-1.0-1.x86_64.rpm"}, wantErr: true},
+	} {
+		mb := newMemBackend()
+		seedRepo(t, mb, pkgs)
+		for _, f := range tc.files {
+			mb.files[f] = []byte("rpm")
+		}
+		r := New(mb)
+		r.logger = newTestLogger(t)
+		if err := r.Check(ctx); (err != nil) != tc.wantErr {
+			t.Errorf("%s: Check() = %v, want error %v", name, err, tc.wantErr)
+		}
+		result := r.CheckDetailed(ctx, CheckOptions{})
+		if result.Err != nil {
+			t.Errorf("%s: findings must not be reported as Err: %v", name, result.Err)
+		}
+		if (result.Failure() != nil) != tc.wantErr {
+			t.Errorf("%s: Failure() = %v, want error %v", name, result.Failure(), tc.wantErr)
+		}
+	}
+}
+
+func TestCheckManagedPathsIgnoresSiblingRepo(t *testing.T) {
+	// Two repos share one root: "el9" is described by this metadata, "el9-debug" belongs to another.
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "el9/foo-1.0-1.x86_64.rpm"},
+	})
+	mb.files["el9/foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	mb.files["el9-debug/foo-debuginfo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if result := r.CheckDetailed(context.Background(), CheckOptions{}); len(result.Orphaned) != 1 {
+		t.Fatalf("without managed paths: orphaned=%v, want the sibling repo's rpm", result.Orphaned)
+	}
+	managed, err := ParseManagedPaths(" el9/ ,")
+	if err != nil {
+		t.Fatalf("ParseManagedPaths: %v", err)
+	}
+	r.ManagedPaths = managed
+	result := r.CheckDetailed(context.Background(), CheckOptions{})
+	if result.Err != nil || len(result.Orphaned) != 0 || len(result.Missing) != 0 {
+		t.Fatalf("with managed paths: err=%v orphaned=%v missing=%v", result.Err, result.Orphaned, result.Missing)
+	}
+	if _, err := ParseManagedPaths("el9,../other"); err == nil {
+		t.Fatalf("expected a managed path outside the root to be rejected")
+	}
+}
+
+func TestCheckFocusedModes(t *testing.T) {
+	r := newCheckFixture(t)
+	ctx := context.Background()
+
+	orphans := r.CheckDetailed(ctx, CheckOptions{OrphansOnly: true})
+	if len(orphans.Missing) != 0 || len(orphans.Orphaned) != 1 {
+		t.Fatalf("orphans-only: missing=%v orphaned=%v", orphans.Missing, orphans.Orphaned)
+	}
+	missing := r.CheckDetailed(ctx, CheckOptions{MissingOnly: true})
+	if len(missing.Missing) != 1 || len(missing.Orphaned) != 0 {
+		t.Fatalf("missing-only: missing=%v orphaned=%v", missing.Missing, missing.Orphaned)
+	}
+	if both := r.CheckDetailed(ctx, CheckOptions{OrphansOnly: true, MissingOnly: true}); both.Err == nil {
+		t.Fatalf("expected error for mutually exclusive options")
+	}
+}
+
+// staleRepomd rewrites repomd.xml through mutate, leaving the core files untouched.
+func staleRepomd(t *testing.T, mb *memBackend, mutate func(d *metadata.RepoData)) {
+	t.Helper()
+	md, err := metadata.LoadRepoMD(context.Background(), mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+	primary, _, _ := metadata.GetCoreData(md)
+	mutate(primary)
+	data, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		t.Fatalf("MarshalRepoMD: %v", err)
+	}
+	mb.files["repodata/repomd.xml"] = data
+}
+
+func TestCheckFixReconcilesStaleRepomd(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	staleRepomd(t, mb, func(d *metadata.RepoData) {
+		d.Size++
+		d.OpenChecksum.Value = "stale"
+	})
+	before := make(map[string][]byte)
+	for p, data := range mb.files {
+		before[p] = data
+	}
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if result := r.CheckDetailed(ctx, CheckOptions{}); result.Err == nil {
+		t.Fatalf("expected stale repomd to fail check")
+	}
+	result := r.CheckDetailed(ctx, CheckOptions{Fix: true})
+	if result.Err != nil {
+		t.Fatalf("check --fix: %v", result.Err)
+	}
+	if len(result.Fixed) != 2 {
+		t.Fatalf("expected 2 fixes, got %v", result.Fixed)
+	}
+	for p, data := range before {
+		if p != "repodata/repomd.xml" && string(mb.files[p]) != string(data) {
+			t.Fatalf("core file %s was modified", p)
+		}
+	}
+	if result := r.CheckDetailed(ctx, CheckOptions{Fix: true}); result.Err != nil || len(result.Fixed) != 0 {
+		t.Fatalf("second fix: fixed=%v err=%v", result.Fixed, result.Err)
+	}
+}
+
+func TestCheckFixRefusesCorruptCore(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+	primary, _, _ := metadata.GetCoreData(md)
+	mb.files[primary.Location.Href] = []byte("not gzip")
+	repomdBefore := string(mb.files["repodata/repomd.xml"])
+
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if result := r.CheckDetailed(ctx, CheckOptions{Fix: true}); result.Err == nil {
+		t.Fatalf("expected fix to refuse corrupt core file")
+	}
+	if string(mb.files["repodata/repomd.xml"]) != repomdBefore {
+		t.Fatalf("repomd.xml must not be rewritten")
+	}
+}
+
+func TestCheckAndRemoveStripLocationPrefix(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "cdn/el9/foo-1.0-1.x86_64.rpm"},
+	})
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.LocationPrefix = "cdn/el9/"
+
+	if got := r.locationFor("bar.rpm"); got != "cdn/el9/bar.rpm" {
+		t.Fatalf("locationFor = %q", got)
+	}
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if err := result.Failure(); err != nil {
+		t.Fatalf("check with prefix: %v", err)
+	}
+	if _, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64.rpm"}, false, true, false); err != nil {
+		t.Fatalf("RemoveRPMs: %v", err)
+	}
+	if _, ok := mb.files["foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("expected stored rpm to be deleted")
+	}
+	if err := ValidateLocationPrefix("../outside"); err == nil {
+		t.Fatalf("expected escaping prefix to be rejected")
+	}
+}
+
+func TestCheckWarnsMixedChecksumTypes(t *testing.T) {
+	ctx := context.Background()
+	r := newCheckFixture(t)
+	mb := r.backend.(*memBackend)
+
+	// Re-record filelists with sha512 checksums, as a different tool might have.
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("load repomd: %v", err)
+	}
+	for i, d := range md.Data {
+		if d.Type != "filelists" {
+			continue
+		}
+		core, err := metadata.ReadCore(ctx, mb, d)
+		if err != nil {
+			t.Fatalf("read filelists: %v", err)
+		}
+		sum, _ := metadata.ComputeChecksum(core.Compressed, "sha512")
+		openSum, _ := metadata.ComputeChecksum(core.Uncompressed, "sha512")
+		md.Data[i].Checksum = metadata.Checksum{Type: "sha512", Value: sum}
+		md.Data[i].OpenChecksum = &metadata.Checksum{Type: "sha512", Value: openSum}
+	}
+	data, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	mb.files["repodata/repomd.xml"] = data
+
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+	var found bool
+	for _, w := range result.Warnings {
+		if w.Code == WarnMixedChecksumTypes {
+			found = true
+			if w.Detail != "primary=sha256, filelists=sha512, other=sha256" {
+				t.Errorf("unexpected detail %q", w.Detail)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("expected mixed checksum warning, got %v", result.Warnings)
+	}
+
+	if _, ok := mixedChecksumWarning([]*metadata.RepoData{{Type: "primary", Checksum: metadata.Checksum{Type: "sha256"}}, nil, nil}); ok {
+		t.Fatalf("single type must not warn")
+	}
+}
+
+func TestCheckWarnsInvalidDepFlags(t *testing.T) {
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm",
+			Requires: []metadata.Relation{{Name: "bar", Flags: "GE", Ver: "1.0"}, {Name: "baz", Flags: "ATLEAST", Ver: "2"}}},
+		{Name: "ok", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "ok-1.0-1.x86_64.rpm",
+			Requires: []metadata.Relation{{Name: "bar", Flags: "EQ", Ver: "1.0"}}},
+	})
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	mb.files["ok-1.0-1.x86_64.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	result := r.CheckDetailed(context.Background(), CheckOptions{})
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+	var found []Warning
+	for _, w := range result.Warnings {
+		if w.Code == WarnInvalidDepFlags {
+			found = append(found, w)
+		}
+	}
+	if len(found) != 1 || found[0].Detail != "foo-1.0-1.x86_64" || !strings.Contains(found[0].Message, `requires baz: flags "ATLEAST"`) {
+		t.Fatalf("expected one invalid flags warning for foo, got %v", result.Warnings)
+	}
+}
+
+func TestCheckWarnsUnknownArch(t *testing.T) {
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "bar", Arch: "", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.rpm"},
+		{Name: "baz", Arch: "e2k", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "c", Location: "baz-1.0-1.e2k.rpm"},
+	})
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	mb.files["bar-1.0-1.rpm"] = []byte("rpm")
+	mb.files["baz-1.0-1.e2k.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	unknownArch := func() []string {
+		result := r.CheckDetailed(context.Background(), CheckOptions{})
+		if result.Err != nil {
+			t.Fatalf("unexpected error: %v", result.Err)
+		}
+		var found []string
+		for _, w := range result.Warnings {
+			if w.Code == WarnUnknownArch {
+				found = append(found, w.Detail)
+			}
+		}
+		sort.Strings(found)
+		return found
+	}
+	if got := unknownArch(); strings.Join(got, ",") != "bar-1.0-1.,baz-1.0-1.e2k" {
+		t.Fatalf("unknown arch warnings = %v", got)
+	}
+	r.AllowedArches = ParseArches("E2K")
+	if got := unknownArch(); strings.Join(got, ",") != "bar-1.0-1." {
+		t.Fatalf("unknown arch warnings with allow-list = %v", got)
+	}
+}
+
+func TestDuplicateLocations(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "pool/foo.rpm"},
+		{Name: "foo", Arch: "x86_64", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "pool/foo.rpm"},
+		{Name: "bar", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "c", Location: "bar-1.0-1.x86_64.rpm"},
+	})
+	mb.files["pool/foo.rpm"] = []byte("rpm")
+	mb.files["bar-1.0-1.x86_64.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if result.Err != nil {
+		t.Fatalf("unexpected error: %v", result.Err)
+	}
+	var found []Warning
+	for _, w := range result.Warnings {
+		if w.Code == WarnDuplicateLocation {
+			found = append(found, w)
+		}
+	}
+	if len(found) != 1 || found[0].Detail != "pool/foo.rpm" || !strings.Contains(found[0].Message, "foo-1.0-1.x86_64, foo-2.0-1.x86_64") {
+		t.Fatalf("duplicate location warnings = %+v", found)
+	}
+
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	_, err = r.writeMetadata(ctx, md, pkgs, alg, time.Now())
+	if err == nil || !strings.Contains(err.Error(), "pool/foo.rpm is the location of foo-1.0-1.x86_64, foo-2.0-1.x86_64") {
+		t.Fatalf("writeMetadata error = %v", err)
+	}
+}
+
+func TestCheckWarnsUnsupportedCoreChecksum(t *testing.T) {
+	ctx := context.Background()
+	r := newCheckFixture(t)
+	mb := r.backend.(*memBackend)
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("load repomd: %v", err)
+	}
+	for i := range md.Data {
+		if md.Data[i].Type == "filelists" {
+			md.Data[i].Checksum.Type = "sha1"
+		}
+	}
+	repomdBytes, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	mb.files["repodata/repomd.xml"] = repomdBytes
+
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if result.Err != nil {
+		t.Fatalf("unsupported checksum should not be fatal: %v", result.Err)
+	}
+	var warned bool
+	for _, w := range result.Warnings {
+		if w.Code == WarnUnverifiableCore && w.Detail == "filelists" && strings.Contains(w.Message, `"sha1"`) {
+			warned = true
+		}
+	}
+	if !warned {
+		t.Fatalf("expected unverifiable filelists warning, got %v", result.Warnings)
+	}
+	// The remaining checks still run.
+	if len(result.Missing) != 1 || len(result.Orphaned) != 1 {
+		t.Fatalf("expected missing and orphaned findings, got %v %v", result.Missing, result.Orphaned)
+	}
+
+	if _, err := r.ListPackages(ctx); !errors.Is(err, metadata.ErrUnsupportedChecksum) {
+		t.Fatalf("expected load path to keep the hard error, got %v", err)
+	}
+}
+
+func TestCheckReportsStats(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm", SizePackage: 1000,
+			Files:      []metadata.File{{Path: "/usr/bin/foo"}, {Path: "/usr/share/doc/foo"}},
+			Changelogs: []metadata.Changelog{{Author: "dev", Date: 1, Text: "- one"}, {Author: "dev", Date: 2, Text: "- two"}}},
+		{Name: "bar", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.x86_64.rpm", SizePackage: 234,
+			Files: []metadata.File{{Path: "/etc/bar.conf"}}},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	var wantMeta int64
+	for _, data := range mb.files {
+		wantMeta += int64(len(data))
+	}
+	md, _ := metadata.LoadRepoMD(ctx, mb)
+	if result.Revision != md.Revision || result.PackageCount != 2 || result.TotalRPMSize != 1234 || result.MetadataSize != wantMeta {
+		t.Fatalf("unexpected stats: revision=%q count=%d rpm=%d meta=%d (want meta %d)",
+			result.Revision, result.PackageCount, result.TotalRPMSize, result.MetadataSize, wantMeta)
+	}
+	if result.FileCount != 3 || result.ChangelogCount != 2 {
+		t.Fatalf("file_count=%d changelog_count=%d, want 3 and 2", result.FileCount, result.ChangelogCount)
+	}
+	if len(result.CoreFiles) != 3 {
+		t.Fatalf("expected 3 core file stats, got %+v", result.CoreFiles)
+	}
+	for _, cf := range result.CoreFiles {
+		if cf.Size != int64(len(mb.files[cf.Path])) || cf.OpenSize <= 0 {
+			t.Errorf("unexpected %s stats: %+v (stored size %d)", cf.Type, cf, len(mb.files[cf.Path]))
+		}
+	}
+}
+
+func TestCheckTruncatedRepomdPointsAtBackup(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.RemoveAll(ctx, false, false); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	backup, err := metadata.ParseRepoMD(mb.files["repodata/repomd.xml.bak"])
+	if err != nil {
+		t.Fatalf("expected a repomd.xml backup: %v", err)
+	}
+	// The backup's core files survive cleanup, so it can be restored as is.
+	for _, d := range backup.Data {
+		if _, ok := mb.files[d.Location.Href]; !ok {
+			t.Fatalf("backup references deleted %s", d.Location.Href)
+		}
+	}
+
+	repomd := mb.files["repodata/repomd.xml"]
+	mb.files["repodata/repomd.xml"] = repomd[:len(repomd)/2]
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if !errors.Is(result.Err, metadata.ErrTruncatedRepoMD) {
+		t.Fatalf("expected truncated repomd error, got %v", result.Err)
+	}
+	if !strings.Contains(result.Err.Error(), "repomd.xml.bak") || !strings.Contains(result.Err.Error(), "bytes") {
+		t.Fatalf("error lacks size or backup hint: %v", result.Err)
+	}
+
+	mb.files["repodata/repomd.xml"] = mb.files["repodata/repomd.xml.bak"]
+	if result := r.CheckDetailed(ctx, CheckOptions{}); result.Err != nil {
+		t.Fatalf("restored backup does not check clean: %v", result.Err)
+	}
+}
+
+func TestCheckIndexOrphans(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.noarch.rpm"},
+	})
+	mb.files["foo-1.0-1.noarch.rpm"] = []byte("rpm")
+	mb.files["Packages/bar-2.0-1.x86_64.rpm"] = minimalRPM("bar", "2.0", "1", "x86_64")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	result := r.CheckDetailed(ctx, CheckOptions{IndexOrphans: true})
+	if err := result.Failure(); err != nil {
+		t.Fatalf("check --index-orphans: %v", err)
+	}
+	if len(result.Indexed) != 1 || result.Indexed[0].Location != "Packages/bar-2.0-1.x86_64.rpm" || result.Indexed[0].Status != ChangeAdded {
+		t.Fatalf("unexpected indexed packages: %+v", result.Indexed)
+	}
+	if result := r.CheckDetailed(ctx, CheckOptions{}); result.Failure() != nil || len(result.Orphaned) != 0 {
+		t.Fatalf("orphan still reported after indexing: %v", result.Failure())
+	}
+
+	// A duplicate NEVRA follows the duplicate policy.
+	mb.files["copy/foo-1.0-1.noarch.rpm"] = minimalRPM("foo", "1.0", "1", "noarch")
+	result = r.CheckDetailed(ctx, CheckOptions{IndexOrphans: true})
+	if result.Err == nil || len(result.Orphaned) != 1 {
+		t.Fatalf("expected duplicate error with the orphan still reported, got %v %v", result.Err, result.Orphaned)
+	}
+	result = r.CheckDetailed(ctx, CheckOptions{IndexOrphans: true, ReplaceExisting: true})
+	if result.Err != nil || len(result.Indexed) != 1 || result.Indexed[0].Status != ChangeReplaced {
+		t.Fatalf("expected replace, got %v %+v", result.Err, result.Indexed)
+	}
+}
+
+func TestCheckVerifyHeaderRange(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	data := minimalRPM("foo", "1.0", "1", "noarch")
+	// The lead and the empty signature header take 112 bytes; the main header runs to EOF.
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.noarch.rpm", HeaderStart: 112, HeaderEnd: len(data)},
+		{Name: "bar", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.noarch.rpm"},
+	})
+	mb.files["foo-1.0-1.noarch.rpm"] = data
+	mb.files["bar-1.0-1.noarch.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if err := r.CheckDetailed(ctx, CheckOptions{VerifyHeaderRange: true}).Failure(); err != nil {
+		t.Fatalf("check --verify-header-range: %v", err)
+	}
+
+	mb.files["foo-1.0-1.noarch.rpm"] = append([]byte("garbage"), data...)
+	err := r.CheckDetailed(ctx, CheckOptions{VerifyHeaderRange: true}).Failure()
+	if err == nil || !strings.Contains(err.Error(), "header range 112-") {
+		t.Fatalf("expected header range error, got %v", err)
+	}
+	if err := r.CheckDetailed(ctx, CheckOptions{}).Failure(); err != nil {
+		t.Fatalf("plain check should not read headers: %v", err)
+	}
+	if r.CheckDetailed(ctx, CheckOptions{VerifyHeaderRange: true, OrphansOnly: true}).Err == nil {
+		t.Fatal("expected verify-header-range with orphans-only to be rejected")
+	}
+}
+
+func TestCheckVerifyRPMs(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	data := minimalRPM("foo", "1.0", "1", "noarch")
+	sum, err := metadata.ComputeChecksum(data, "sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: sum, Location: "foo-1.0-1.noarch.rpm", SizePackage: uint64(len(data))},
+	})
+	mb.files["foo-1.0-1.noarch.rpm"] = data
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if err := r.CheckDetailed(ctx, CheckOptions{VerifyRPMs: true}).Failure(); err != nil {
+		t.Fatalf("check --verify-rpms: %v", err)
+	}
+
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	mb.files["foo-1.0-1.noarch.rpm"] = corrupt
+	err = r.CheckDetailed(ctx, CheckOptions{VerifyRPMs: true}).Failure()
+	if err == nil || !strings.Contains(err.Error(), "does not match pkgid") {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	if err := r.CheckDetailed(ctx, CheckOptions{}).Failure(); err != nil {
+		t.Fatalf("plain check should not read RPMs: %v", err)
+	}
+}
+
+func TestCheckMetadataOnly(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.noarch.rpm"},
+	})
+	mb.files["orphan-1.0-1.noarch.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if r.CheckDetailed(ctx, CheckOptions{}).Failure() == nil {
+		t.Fatal("full check should report the missing and orphaned RPMs")
+	}
+	result := r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true})
+	if err := result.Failure(); err != nil {
+		t.Fatalf("check --metadata-only: %v", err)
+	}
+	if result.PackageCount != 1 {
+		t.Fatalf("package count = %d, want 1", result.PackageCount)
+	}
+	if r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true, VerifyRPMs: true}).Err == nil {
+		t.Fatal("expected metadata-only with verify-rpms to be rejected")
+	}
+
+	// Core metadata is still verified.
+	for p := range mb.files {
+		if strings.Contains(p, "primary.xml") {
+			mb.files[p] = []byte("corrupt")
+		}
+	}
+	if r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true}).Failure() == nil {
+		t.Fatal("expected corrupt primary to fail check --metadata-only")
+	}
+}
+
+func TestCheckSourceRPMs(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "foo", Arch: "src", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "SRPMS/foo-1.0-1.src.rpm"},
+	})
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	mb.files["sources/bar-2.0-1.src.rpm"] = []byte("srpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	// The indexed SRPM is checked like any package; the one in sources/ is not an orphan.
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if len(result.Missing) != 1 || result.Missing[0] != "SRPMS/foo-1.0-1.src.rpm" || len(result.Orphaned) != 0 {
+		t.Fatalf("missing %v, orphaned %v", result.Missing, result.Orphaned)
+	}
+
+	mb.files["SRPMS/baz-1.0-1.src.rpm"] = []byte("srpm")
+	result = r.CheckDetailed(ctx, CheckOptions{NoSource: true})
+	if err := result.Failure(); err != nil {
+		t.Fatalf("check --no-source: %v", err)
+	}
+	if result := r.CheckDetailed(ctx, CheckOptions{}); len(result.Orphaned) != 1 {
+		t.Fatalf("expected the SRPM outside sources/ to be orphaned, got %v", result.Orphaned)
+	}
+}
+
+func TestCheckSourcePackageLocation(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "src", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	err := r.CheckDetailed(ctx, CheckOptions{}).Failure()
+	if err == nil || !strings.Contains(err.Error(), "is not a .src.rpm") {
+		t.Fatalf("expected source location error, got %v", err)
+	}
+	if err := r.CheckDetailed(ctx, CheckOptions{NoSource: true}).Failure(); err != nil {
+		t.Fatalf("check --no-source: %v", err)
+	}
+}
+
+func TestCheckDeps(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{
+			Name: "libfoo", Arch: "x86_64", Version: "1.2", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "libfoo-1.2-1.x86_64.rpm",
+			Provides: []metadata.Relation{{Name: "libfoo.so.1()(64bit)"}},
+			Files:    []metadata.File{{Path: "/usr/bin/foo"}},
+		},
+		{
+			Name: "app", Arch: "x86_64", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "app-2.0-1.x86_64.rpm",
+			Requires: []metadata.Relation{
+				{Name: "libfoo.so.1()(64bit)"},
+				{Name: "libfoo", Flags: metadata.DepFlagGE, Ver: "1.0"},
+				{Name: "/usr/bin/foo"},
+				{Name: "rpmlib(CompressedFileNames)", Flags: metadata.DepFlagLE, Ver: "3.0.4", Rel: "1"},
+				{Name: "libfoo", Flags: metadata.DepFlagGE, Ver: "2.0"},
+				{Name: "glibc", Pre: true},
+				{Name: "glibc"},
+			},
+		},
+		{
+			Name: "app", Arch: "src", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "c", Location: "app-2.0-1.src.rpm",
+			Requires: []metadata.Relation{{Name: "gcc"}},
+		},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if result := r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true}); result.Unresolved != nil {
+		t.Fatalf("dependencies checked without Deps: %v", result.Unresolved)
+	}
+	result := r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true, Deps: true})
+	var got []string
+	for _, u := range result.Unresolved {
+		got = append(got, u.String())
+	}
+	want := "app-2.0-1.x86_64 requires libfoo >= 2.0,app-2.0-1.x86_64 requires glibc"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("unresolved = %v, want %s", got, want)
+	}
+	if result.Failure() == nil {
+		t.Fatalf("expected unresolved dependencies to fail the check")
+	}
+
+	assumed, err := ParseAssumeProvided([]byte("# base repo\nglibc = 2.34-100.el9\n\nlibfoo = 2.1-1\n"))
+	if err != nil {
+		t.Fatalf("ParseAssumeProvided: %v", err)
+	}
+	result = r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true, Deps: true, AssumeProvided: assumed})
+	if err := result.Failure(); err != nil {
+		t.Fatalf("with assumed base: %v", err)
+	}
+	if _, err := ParseAssumeProvided([]byte("ok\nbad >=\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("ParseAssumeProvided error = %v, want line 2", err)
+	}
+}
+
+func TestCheckSkipsMetadataTypes(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm", Files: []metadata.File{{Path: "/usr/bin/foo"}}},
+	})
+	for p := range mb.files {
+		if strings.HasSuffix(p, "-filelists.xml.gz") {
+			mb.files[p] = []byte("corrupt")
+		}
+	}
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if result := r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true}); result.Err == nil {
+		t.Fatalf("expected the corrupt filelists to fail the check")
+	}
+	skip, err := ParseCheckSkip("filelists, other")
+	if err != nil {
+		t.Fatalf("ParseCheckSkip: %v", err)
+	}
+	result := r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true, Skip: skip})
+	if result.Err != nil {
+		t.Fatalf("check with filelists skipped: %v", result.Err)
+	}
+	if result.PackageCount != 1 || len(result.CoreFiles) != 1 || result.CoreFiles[0].Type != "primary" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if result := r.CheckDetailed(ctx, CheckOptions{MetadataOnly: true, Skip: skip, Deps: true}); result.Err == nil {
+		t.Fatalf("expected Deps with filelists skipped to be refused")
+	}
+	if _, err := ParseCheckSkip("primary"); err == nil {
+		t.Fatalf("expected primary to be refused")
+	}
+}
+
+func TestCheckRequireSigned(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	unsigned := minimalRPM("foo", "1.0", "1", "noarch")
+	// A lead and a signature header holding only a (here unparseable) PGP signature tag.
+	signed := make([]byte, 96)
+	signed = append(signed, 0x8E, 0xAD, 0xE8, 0x01, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 2)
+	signed = append(signed, 0, 0, 0x03, 0xEA, 0, 0, 0, 7, 0, 0, 0, 0, 0, 0, 0, 2, 0x01, 0x02)
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.noarch.rpm", HeaderStart: 112, HeaderEnd: len(unsigned)},
+		{Name: "bar", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.noarch.rpm"},
+	})
+	mb.files["foo-1.0-1.noarch.rpm"] = unsigned
+	mb.files["bar-1.0-1.noarch.rpm"] = signed
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if err := r.CheckDetailed(ctx, CheckOptions{}).Failure(); err != nil {
+		t.Fatalf("plain check should not read signatures: %v", err)
+	}
+	result := r.CheckDetailed(ctx, CheckOptions{RequireSigned: true})
+	if len(result.Unsigned) != 1 || result.Unsigned[0] != "foo-1.0-1.noarch" {
+		t.Fatalf("expected foo to be reported unsigned, got %v", result.Unsigned)
+	}
+	if err := result.Failure(); err == nil || !strings.Contains(err.Error(), "package not signed: foo-1.0-1.noarch") {
+		t.Fatalf("expected an unsigned package failure, got %v", err)
+	}
+
+	pkgs, err := r.ListPackages(ctx)
+	if err != nil {
+		t.Fatalf("ListPackages: %v", err)
+	}
+	if err := r.ReadSignatures(ctx, pkgs); err != nil {
+		t.Fatalf("ReadSignatures: %v", err)
+	}
+	if !pkgs[0].Signed || pkgs[1].Signed {
+		t.Fatalf("expected only bar signed, got %+v %+v", pkgs[0].Signed, pkgs[1].Signed)
+	}
+	if r.CheckDetailed(ctx, CheckOptions{RequireSigned: true, MetadataOnly: true}).Err == nil {
+		t.Fatal("expected require-signed with metadata-only to be rejected")
+	}
+}
@@ -0,0 +1,83 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// ValidateDedupeStore rejects content-addressed store directories outside the repository root or
+// inside repodata.
+func ValidateDedupeStore(dir, repodataDir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := metadata.ValidateHref(dir); err != nil {
+		return fmt.Errorf("invalid dedupe store: %w", err)
+	}
+	clean := cleanLocationPrefix(dir)
+	if clean == "" || clean == "." {
+		return fmt.Errorf("invalid dedupe store %q: must be a directory below the repo root", dir)
+	}
+	if clean == repodataDir || strings.HasPrefix(clean, repodataDir+"/") {
+		return fmt.Errorf("invalid dedupe store %q: inside %s", dir, repodataDir)
+	}
+	return nil
+}
+
+// dedupePath returns the content-addressed storage path of pkg: <store>/<pkgid>/<filename>.
+func (r *Repo) dedupePath(pkg metadata.Package) string {
+	return path.Join(cleanLocationPrefix(r.DedupeStore), pkg.PkgID, pkg.Filename())
+}
+
+// inDedupeStore reports whether rel lies under DedupeStore.
+func (r *Repo) inDedupeStore(rel string) bool {
+	dir := cleanLocationPrefix(r.DedupeStore)
+	return dir != "" && strings.HasPrefix(rel, dir+"/")
+}
+
+// storeRPM writes an RPM payload to destRel and returns the number of bytes uploaded. A
+// content-addressed path that already exists holds the same payload, so it is not written again;
+// with Resume, neither is any other path that already holds the same payload.
+func (r *Repo) storeRPM(ctx context.Context, destRel string, data []byte) (int64, error) {
+	if r.inDedupeStore(destRel) {
+		exists, err := r.backend.Exists(ctx, destRel)
+		if err != nil {
+			return 0, fmt.Errorf("exists %s: %w", destRel, err)
+		}
+		if exists {
+			r.logger.Printf("info: %s already stored; not uploading", destRel)
+			return 0, nil
+		}
+	} else if r.Resume {
+		same, err := r.storedCopyMatches(ctx, destRel, data)
+		if err != nil {
+			return 0, err
+		}
+		if same {
+			r.logger.Printf("info: %s already uploaded; not uploading again", destRel)
+			return 0, nil
+		}
+	}
+	if err := r.backend.WriteFile(ctx, destRel, data); err != nil {
+		return 0, fmt.Errorf("write rpm %s: %w", destRel, err)
+	}
+	return int64(len(data)), nil
+}
+
+// checkDedupeAddress reports an error if storagePath lies in the dedupe store under a pkgid other
+// than pkg's, i.e. the metadata and the content address disagree.
+func (r *Repo) checkDedupeAddress(storagePath string, pkg metadata.Package) error {
+	if !r.inDedupeStore(storagePath) {
+		return nil
+	}
+	rel := strings.TrimPrefix(storagePath, cleanLocationPrefix(r.DedupeStore)+"/")
+	addr, _, ok := strings.Cut(rel, "/")
+	if !ok || addr != pkg.PkgID {
+		return fmt.Errorf("package %s stored at %s does not match its pkgid %s", pkg.NEVRA(), storagePath, pkg.PkgID)
+	}
+	return nil
+}
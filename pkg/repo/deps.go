@@ -0,0 +1,97 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// UnresolvedDep is a hard requirement of a package that nothing in the repository, nor the
+// assumed-provided base, satisfies.
+type UnresolvedDep struct {
+	NEVRA    string `json:"nevra"`
+	Requires string `json:"requires"`
+}
+
+func (u UnresolvedDep) String() string {
+	return u.NEVRA + " requires " + u.Requires
+}
+
+// ParseAssumeProvided parses dependencies provided outside the repository, one per line in the
+// form accepted by metadata.ParseRelation; blank lines and lines starting with # are skipped. The
+// output of "rpm -q --provides" and absolute file paths can be used as they are.
+func ParseAssumeProvided(data []byte) ([]metadata.Relation, error) {
+	var rels []metadata.Relation
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rel, err := metadata.ParseRelation(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		rels = append(rels, rel)
+	}
+	return rels, sc.Err()
+}
+
+// unresolvedDeps returns the Requires of binary packages that no package provides (explicitly, by
+// its own name and EVR, or by shipping the required file) and that are not in assumed. Source
+// packages are skipped since their requirements are build dependencies. rpmlib() requirements
+// are satisfied by rpm itself, and rich dependencies ("(a or b)") are not evaluated.
+func unresolvedDeps(pkgs []metadata.Package, assumed []metadata.Relation) []UnresolvedDep {
+	provides := make(map[string][]metadata.Relation)
+	addProvide := func(rel metadata.Relation) {
+		provides[rel.Name] = append(provides[rel.Name], rel)
+	}
+	files := make(map[string]struct{})
+	for _, rel := range assumed {
+		addProvide(rel)
+	}
+	for _, p := range pkgs {
+		addProvide(metadata.Relation{Name: p.Name, Flags: metadata.DepFlagEQ, Epoch: p.Epoch, Ver: p.Version, Rel: p.Release})
+		for _, rel := range p.Provides {
+			addProvide(rel)
+		}
+		if p.IsSource() {
+			continue
+		}
+		for _, f := range p.Files {
+			files[f.Path] = struct{}{}
+		}
+	}
+
+	var out []UnresolvedDep
+	for _, p := range pkgs {
+		if p.IsSource() {
+			continue
+		}
+		// Pre and post scriptlet requirements repeat the same relation.
+		reported := make(map[string]struct{})
+		for _, req := range p.Requires {
+			if strings.HasPrefix(req.Name, "rpmlib(") || strings.HasPrefix(req.Name, "(") {
+				continue
+			}
+			if _, ok := files[req.Name]; ok {
+				continue
+			}
+			satisfied := false
+			for _, prov := range provides[req.Name] {
+				if prov.Overlaps(req) {
+					satisfied = true
+					break
+				}
+			}
+			if _, ok := reported[req.String()]; !satisfied && !ok {
+				reported[req.String()] = struct{}{}
+				out = append(out, UnresolvedDep{NEVRA: p.NEVRA(), Requires: req.String()})
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package repo
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// DiffEntry is one name and arch whose newest version differs between two package sets.
+type DiffEntry struct {
+	Name string `json:"name"`
+	Arch string `json:"arch"`
+	// From and To are the newest EVR on the old and new side; From is empty for added packages and
+	// To for removed ones.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// Downgrade marks a change to an older EVR.
+	Downgrade bool `json:"downgrade,omitempty"`
+}
+
+// PackageSetDiff lists what changed between two package sets, sorted by name and arch.
+type PackageSetDiff struct {
+	Added   []DiffEntry `json:"added"`
+	Removed []DiffEntry `json:"removed"`
+	Changed []DiffEntry `json:"changed"`
+}
+
+// Empty reports whether both sides offer the same newest versions.
+func (d PackageSetDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// DiffPackages compares two package sets by name and arch. Each side is summarized by its newest
+// version, which is what clients install, so pruning older versions alone is not a change.
+func DiffPackages(from, to []metadata.Package) PackageSetDiff {
+	before, after := newestByNameArch(from), newestByNameArch(to)
+	var diff PackageSetDiff
+	for key, n := range after {
+		o, ok := before[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, DiffEntry{Name: n.Name, Arch: n.Arch, To: n.EVR()})
+		case metadata.CompareEVR(o, n) != 0:
+			diff.Changed = append(diff.Changed, DiffEntry{Name: n.Name, Arch: n.Arch, From: o.EVR(), To: n.EVR(), Downgrade: metadata.CompareEVR(n, o) < 0})
+		}
+	}
+	for key, o := range before {
+		if _, ok := after[key]; !ok {
+			diff.Removed = append(diff.Removed, DiffEntry{Name: o.Name, Arch: o.Arch, From: o.EVR()})
+		}
+	}
+	for _, entries := range [][]DiffEntry{diff.Added, diff.Removed, diff.Changed} {
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].Name != entries[j].Name {
+				return entries[i].Name < entries[j].Name
+			}
+			return entries[i].Arch < entries[j].Arch
+		})
+	}
+	return diff
+}
+
+// ManifestPackages returns the packages pinned by m, carrying only name, arch and EVR.
+func ManifestPackages(m Manifest) ([]metadata.Package, error) {
+	pkgs := make([]metadata.Package, 0, len(m.Packages))
+	for _, e := range m.Packages {
+		p, ok := metadata.ParseNEVRA(e.NEVRA)
+		if !ok {
+			return nil, fmt.Errorf("manifest entry %q is not a NEVRA", e.NEVRA)
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs, nil
+}
@@ -0,0 +1,58 @@
+package repo
+
+import (
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestDiffPackages(t *testing.T) {
+	pkg := func(name, arch string, epoch int, ver string) metadata.Package {
+		return metadata.Package{Name: name, Arch: arch, Epoch: epoch, Version: ver, Release: "1"}
+	}
+	old := []metadata.Package{
+		pkg("foo", "x86_64", 0, "1.0"),
+		pkg("foo", "x86_64", 0, "1.1"),
+		pkg("foo", "aarch64", 0, "1.1"),
+		pkg("bar", "noarch", 0, "2.0"),
+		pkg("gone", "noarch", 0, "1"),
+		pkg("same", "noarch", 0, "3"),
+		pkg("same", "noarch", 0, "2"),
+	}
+	cur := []metadata.Package{
+		pkg("foo", "x86_64", 0, "1.2"),
+		pkg("foo", "aarch64", 0, "1.1"),
+		pkg("bar", "noarch", 0, "1.9"),
+		pkg("baz", "noarch", 1, "0.1"),
+		pkg("same", "noarch", 0, "3"), // older version pruned: not a change
+	}
+	diff := DiffPackages(old, cur)
+
+	if len(diff.Added) != 1 || diff.Added[0] != (DiffEntry{Name: "baz", Arch: "noarch", To: "1:0.1-1"}) {
+		t.Errorf("added = %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != (DiffEntry{Name: "gone", Arch: "noarch", From: "1-1"}) {
+		t.Errorf("removed = %+v", diff.Removed)
+	}
+	want := []DiffEntry{
+		{Name: "bar", Arch: "noarch", From: "2.0-1", To: "1.9-1", Downgrade: true},
+		{Name: "foo", Arch: "x86_64", From: "1.1-1", To: "1.2-1"},
+	}
+	if len(diff.Changed) != len(want) || diff.Changed[0] != want[0] || diff.Changed[1] != want[1] {
+		t.Errorf("changed = %+v, want %+v", diff.Changed, want)
+	}
+	if !DiffPackages(old, old).Empty() {
+		t.Errorf("a package set differs from itself")
+	}
+}
+
+func TestManifestPackages(t *testing.T) {
+	m := Manifest{Packages: []ManifestEntry{{NEVRA: "foo-1:2.0-3.el9.x86_64"}}}
+	pkgs, err := ManifestPackages(m)
+	if err != nil || len(pkgs) != 1 || pkgs[0].Name != "foo" || pkgs[0].EVR() != "1:2.0-3.el9" || pkgs[0].Arch != "x86_64" {
+		t.Fatalf("ManifestPackages = %+v, %v", pkgs, err)
+	}
+	if _, err := ManifestPackages(Manifest{Packages: []ManifestEntry{{NEVRA: "garbage"}}}); err == nil {
+		t.Fatalf("expected an unparsable NEVRA to be rejected")
+	}
+}
@@ -0,0 +1,68 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+// Fingerprint identifies a repository's content with a single hash, for comparing mirrors.
+type Fingerprint struct {
+	Fingerprint string                `json:"fingerprint"`
+	Packages    []FingerprintPackage  `json:"packages"`
+	Metadata    []FingerprintMetadata `json:"metadata"`
+}
+
+// FingerprintPackage is a package component of a Fingerprint.
+type FingerprintPackage struct {
+	NEVRA string `json:"nevra"`
+	PkgID string `json:"pkgid"`
+}
+
+// FingerprintMetadata is a non-core metadata component (comps, updateinfo, ...) of a Fingerprint.
+type FingerprintMetadata struct {
+	Type     string `json:"type"`
+	Checksum string `json:"checksum"`
+}
+
+// Fingerprint returns the sha256, in hex, of the packages' NEVRAs and pkgids sorted by NEVRA,
+// followed by the checksums of the non-core metadata sorted by type. Core metadata files are left
+// out: they are derived from the packages, and their bytes change with compression, filename
+// style and revision. Package locations are left out too, so mirrors laid out differently but
+// serving the same RPMs and metadata compare equal.
+func (r *Repo) Fingerprint(ctx context.Context) (Fingerprint, error) {
+	md, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	fp := Fingerprint{Packages: make([]FingerprintPackage, 0, len(pkgs)), Metadata: []FingerprintMetadata{}}
+	for _, p := range pkgs {
+		fp.Packages = append(fp.Packages, FingerprintPackage{NEVRA: p.NEVRA(), PkgID: p.PkgID})
+	}
+	sort.Slice(fp.Packages, func(i, j int) bool {
+		if fp.Packages[i].NEVRA != fp.Packages[j].NEVRA {
+			return fp.Packages[i].NEVRA < fp.Packages[j].NEVRA
+		}
+		return fp.Packages[i].PkgID < fp.Packages[j].PkgID
+	})
+	for _, d := range md.Data {
+		switch d.Type {
+		case "primary", "filelists", "filelists_ext", "other":
+			continue
+		}
+		fp.Metadata = append(fp.Metadata, FingerprintMetadata{Type: d.Type, Checksum: d.Checksum.Type + ":" + d.Checksum.Value})
+	}
+	sort.Slice(fp.Metadata, func(i, j int) bool { return fp.Metadata[i].Type < fp.Metadata[j].Type })
+
+	h := sha256.New()
+	for _, p := range fp.Packages {
+		fmt.Fprintf(h, "package %s %s\n", p.NEVRA, p.PkgID)
+	}
+	for _, m := range fp.Metadata {
+		fmt.Fprintf(h, "metadata %s %s\n", m.Type, m.Checksum)
+	}
+	fp.Fingerprint = hex.EncodeToString(h.Sum(nil))
+	return fp, nil
+}
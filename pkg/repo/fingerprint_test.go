@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestFingerprint(t *testing.T) {
+	ctx := context.Background()
+	foo := metadata.Package{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"}
+	bar := metadata.Package{Name: "bar", Arch: "noarch", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-2.0-1.noarch.rpm"}
+
+	fingerprint := func(pkgs []metadata.Package, configure func(*Repo)) Fingerprint {
+		t.Helper()
+		mb := newMemBackend()
+		seedRepo(t, mb, pkgs)
+		r := New(mb)
+		r.logger = newTestLogger(t)
+		if configure != nil {
+			configure(r)
+			if _, err := r.Rebuild(ctx, "", false, "", false); err != nil {
+				t.Fatalf("Rebuild: %v", err)
+			}
+		}
+		fp, err := r.Fingerprint(ctx)
+		if err != nil {
+			t.Fatalf("Fingerprint: %v", err)
+		}
+		return fp
+	}
+
+	a := fingerprint([]metadata.Package{foo, bar}, nil)
+	if len(a.Fingerprint) != 64 || len(a.Packages) != 2 || a.Packages[0].NEVRA != "bar-2.0-1.noarch" {
+		t.Fatalf("unexpected fingerprint %+v", a)
+	}
+	// Package order and core file encoding do not matter.
+	b := fingerprint([]metadata.Package{bar, foo}, func(r *Repo) { r.Compression = metadata.CompressionBzip2 })
+	if a.Fingerprint != b.Fingerprint {
+		t.Fatalf("fingerprints differ for the same content: %s, %s", a.Fingerprint, b.Fingerprint)
+	}
+	rebuilt := foo
+	rebuilt.PkgID = "c"
+	if c := fingerprint([]metadata.Package{rebuilt, bar}, nil); c.Fingerprint == a.Fingerprint {
+		t.Fatalf("fingerprint unchanged after a package's content changed")
+	}
+}
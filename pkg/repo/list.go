@@ -0,0 +1,55 @@
+package repo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// ListPackages returns every package in the repository sorted by NEVRA.
+func (r *Repo) ListPackages(ctx context.Context) ([]metadata.Package, error) {
+	_, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].NEVRA() < pkgs[j].NEVRA() })
+	return pkgs, nil
+}
+
+// PackageFilter selects packages by build time (TimeBuild) and by the time the RPM file was
+// added (TimeFile, its mtime when published). After bounds are inclusive and Before bounds are
+// exclusive; a zero bound is open. The zero PackageFilter matches everything.
+type PackageFilter struct {
+	BuiltAfter  time.Time
+	BuiltBefore time.Time
+	AddedAfter  time.Time
+	AddedBefore time.Time
+}
+
+// Match reports whether p falls inside every bound of f.
+func (f PackageFilter) Match(p metadata.Package) bool {
+	return inWindow(p.TimeBuild, f.BuiltAfter, f.BuiltBefore) && inWindow(p.TimeFile, f.AddedAfter, f.AddedBefore)
+}
+
+// Filter returns the packages matching f, preserving order. It never returns nil.
+func (f PackageFilter) Filter(pkgs []metadata.Package) []metadata.Package {
+	out := make([]metadata.Package, 0, len(pkgs))
+	for _, p := range pkgs {
+		if f.Match(p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func inWindow(ts int64, after, before time.Time) bool {
+	if !after.IsZero() && ts < after.Unix() {
+		return false
+	}
+	if !before.IsZero() && ts >= before.Unix() {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+package repo
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestPackageFilter(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	pkgs := []metadata.Package{
+		{Name: "old", TimeBuild: day(1).Unix(), TimeFile: day(10).Unix()},
+		{Name: "edge", TimeBuild: day(5).Unix(), TimeFile: day(5).Unix()},
+		{Name: "new", TimeBuild: day(9).Unix(), TimeFile: day(9).Unix()},
+	}
+	names := func(ps []metadata.Package) []string {
+		var out []string
+		for _, p := range ps {
+			out = append(out, p.Name)
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		filter PackageFilter
+		want   []string
+	}{
+		{"zero matches all", PackageFilter{}, []string{"old", "edge", "new"}},
+		{"after is inclusive", PackageFilter{BuiltAfter: day(5)}, []string{"edge", "new"}},
+		{"before is exclusive", PackageFilter{BuiltBefore: day(5)}, []string{"old"}},
+		{"window", PackageFilter{BuiltAfter: day(2), BuiltBefore: day(9)}, []string{"edge"}},
+		{"added", PackageFilter{AddedAfter: day(9)}, []string{"old", "new"}},
+		{"built and added", PackageFilter{BuiltBefore: day(6), AddedAfter: day(9)}, []string{"old"}},
+	}
+	for _, tt := range tests {
+		got := tt.filter.Filter(pkgs)
+		if strings.Join(names(got), ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, names(got), tt.want)
+		}
+	}
+	if got := (PackageFilter{BuiltAfter: day(20)}).Filter(pkgs); got == nil || len(got) != 0 {
+		t.Errorf("expected empty non-nil result, got %#v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package repo
+
+import (
+	"context"
+	"sort"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// Manifest is a lockfile of a repository's package set: every package's NEVRA, location and pkgid.
+type Manifest struct {
+	// Revision is the repomd.xml revision the manifest was taken from; informational only.
+	Revision string          `json:"revision"`
+	Packages []ManifestEntry `json:"packages"`
+}
+
+// ManifestEntry pins one package.
+type ManifestEntry struct {
+	NEVRA        string `json:"nevra"`
+	Location     string `json:"location"`
+	ChecksumType string `json:"checksum_type"`
+	PkgID        string `json:"pkgid"`
+}
+
+// ManifestDiff lists packages that differ between a manifest and the repository. Changed holds the
+// repository's current entry for packages whose location or pkgid no longer match.
+type ManifestDiff struct {
+	Added   []ManifestEntry `json:"added"`
+	Removed []ManifestEntry `json:"removed"`
+	Changed []ManifestEntry `json:"changed"`
+}
+
+// Empty reports whether the repository matched the manifest.
+func (d ManifestDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// BuildManifest returns the current package set as a manifest sorted by NEVRA.
+func (r *Repo) BuildManifest(ctx context.Context) (Manifest, error) {
+	md, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := Manifest{Revision: md.Revision, Packages: make([]ManifestEntry, 0, len(pkgs))}
+	for _, p := range pkgs {
+		m.Packages = append(m.Packages, manifestEntry(p))
+	}
+	sort.Slice(m.Packages, func(i, j int) bool { return m.Packages[i].NEVRA < m.Packages[j].NEVRA })
+	return m, nil
+}
+
+// VerifyManifest compares the repository's package set against m. Packages are matched by NEVRA,
+// with an omitted epoch equal to "0:"; the revision is ignored, so metadata rewrites that keep
+// the same packages still verify.
+func (r *Repo) VerifyManifest(ctx context.Context, m Manifest) (ManifestDiff, error) {
+	current, err := r.BuildManifest(ctx)
+	if err != nil {
+		return ManifestDiff{}, err
+	}
+	want := make(map[string]ManifestEntry, len(m.Packages))
+	for _, e := range m.Packages {
+		want[metadata.NormalizeNEVRA(e.NEVRA)] = e
+	}
+	var diff ManifestDiff
+	seen := make(map[string]struct{}, len(current.Packages))
+	for _, e := range current.Packages {
+		key := metadata.NormalizeNEVRA(e.NEVRA)
+		seen[key] = struct{}{}
+		w, ok := want[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, e)
+		case w.Location != e.Location || w.PkgID != e.PkgID || w.ChecksumType != e.ChecksumType:
+			diff.Changed = append(diff.Changed, e)
+		}
+	}
+	for _, e := range m.Packages {
+		if _, ok := seen[metadata.NormalizeNEVRA(e.NEVRA)]; !ok {
+			diff.Removed = append(diff.Removed, e)
+		}
+	}
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].NEVRA < diff.Removed[j].NEVRA })
+	return diff, nil
+}
+
+func manifestEntry(p metadata.Package) ManifestEntry {
+	return ManifestEntry{NEVRA: p.NEVRA(), Location: p.Location, ChecksumType: p.ChecksumType, PkgID: p.PkgID}
+}
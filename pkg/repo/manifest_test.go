@@ -0,0 +1,50 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "bar", Epoch: 2, Arch: "noarch", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-2.0-1.noarch.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	m, err := r.BuildManifest(ctx)
+	if err != nil {
+		t.Fatalf("BuildManifest: %v", err)
+	}
+	if len(m.Packages) != 2 || m.Packages[0].NEVRA != "bar-2:2.0-1.noarch" {
+		t.Fatalf("unexpected manifest: %+v", m)
+	}
+	diff, err := r.VerifyManifest(ctx, m)
+	if err != nil || !diff.Empty() {
+		t.Fatalf("expected clean verify, got %+v, %v", diff, err)
+	}
+
+	// Drift: foo's pkgid changes, bar disappears, baz appears.
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a2", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "baz", Arch: "noarch", Version: "1", Release: "1", ChecksumType: "sha256", PkgID: "c", Location: "baz-1-1.noarch.rpm"},
+	})
+	diff, err = r.VerifyManifest(ctx, m)
+	if err != nil {
+		t.Fatalf("VerifyManifest: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].NEVRA != "baz-1-1.noarch" {
+		t.Errorf("added = %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].NEVRA != "bar-2:2.0-1.noarch" {
+		t.Errorf("removed = %+v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].PkgID != "a2" {
+		t.Errorf("changed = %+v", diff.Changed)
+	}
+}
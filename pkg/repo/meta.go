@@ -2,7 +2,11 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -12,88 +16,302 @@ import (
 // loadPackages loads repomd and core metadata, returning parsed packages and the checksum algorithm.
 func (r *Repo) loadPackages(ctx context.Context) (metadata.RepoMD, []metadata.Package, string, error) {
 	md, err := metadata.LoadRepoMD(ctx, r.backend)
+	if errors.Is(err, fs.ErrNotExist) {
+		return metadata.RepoMD{}, nil, "", fmt.Errorf("%w: %s not found", ErrNotInitialized, r.repomdPath())
+	}
 	if err != nil {
 		return metadata.RepoMD{}, nil, "", fmt.Errorf("load repomd.xml: %w", err)
 	}
 	primaryData, filelistsData, otherData := metadata.GetCoreData(md)
-	if primaryData == nil || filelistsData == nil || otherData == nil {
-		return metadata.RepoMD{}, nil, "", fmt.Errorf("repo missing core metadata (primary/filelists/other)")
+	if primaryData == nil {
+		return metadata.RepoMD{}, nil, "", fmt.Errorf("repo missing core metadata (primary)")
 	}
-	if isSqlite(primaryData.Location.Href) || isSqlite(filelistsData.Location.Href) || isSqlite(otherData.Location.Href) {
-		return metadata.RepoMD{}, nil, "", fmt.Errorf("unsupported: sqlite-only metadata in v1")
+	for _, d := range []*metadata.RepoData{primaryData, filelistsData, otherData} {
+		if d != nil && isSqlite(d.Location.Href) {
+			return metadata.RepoMD{}, nil, "", fmt.Errorf("unsupported: sqlite-only metadata in v1")
+		}
+	}
+
+	checksumAlg := primaryData.Checksum.Type
+	if checksumAlg == "" {
+		checksumAlg = "sha256"
+	}
+	key := packageCacheKey(md)
+	if r.cache != nil && r.cache.key == key {
+		return md, append([]metadata.Package(nil), r.cache.pkgs...), checksumAlg, nil
 	}
 
 	primaryCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *primaryData)
 	if err != nil {
 		return metadata.RepoMD{}, nil, "", fmt.Errorf("read primary: %w", err)
 	}
-	filelistsCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *filelistsData)
-	if err != nil {
-		return metadata.RepoMD{}, nil, "", fmt.Errorf("read filelists: %w", err)
+	// filelists and other are optional; packages parsed without them carry no file lists or changelogs.
+	var filelistsXML []byte
+	if filelistsData != nil {
+		filelistsCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *filelistsData)
+		if err != nil {
+			return metadata.RepoMD{}, nil, "", fmt.Errorf("read filelists: %w", err)
+		}
+		filelistsXML = filelistsCore.Uncompressed
 	}
-	otherCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *otherData)
-	if err != nil {
-		return metadata.RepoMD{}, nil, "", fmt.Errorf("read other: %w", err)
+	var otherXML []byte
+	if otherData != nil {
+		otherCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *otherData)
+		if err != nil {
+			return metadata.RepoMD{}, nil, "", fmt.Errorf("read other: %w", err)
+		}
+		otherXML = otherCore.Uncompressed
 	}
 
-	pkgs, err := metadata.ParsePackagesFromXML(primaryCore.Uncompressed, filelistsCore.Uncompressed, otherCore.Uncompressed)
+	pkgs, err := metadata.ParsePackagesFromXML(primaryCore.Uncompressed, filelistsXML, otherXML)
 	if err != nil {
 		return metadata.RepoMD{}, nil, "", fmt.Errorf("parse metadata: %w", err)
 	}
+	// File digests live only in filelists-ext; without them a rewrite would publish it empty.
+	if extData := metadata.FindData(md, "filelists_ext"); extData != nil && filelistsData != nil {
+		extCore, err := metadata.ReadAndVerifyCore(ctx, r.backend, *extData)
+		if err != nil {
+			return metadata.RepoMD{}, nil, "", fmt.Errorf("read filelists-ext: %w", err)
+		}
+		if err := metadata.ApplyFilelistsExt(pkgs, extCore.Uncompressed); err != nil {
+			return metadata.RepoMD{}, nil, "", err
+		}
+	}
+	for _, w := range depFlagWarnings(pkgs) {
+		r.logger.Printf("warn: %s", w)
+	}
+	r.cache = &packageCache{key: key, pkgs: append([]metadata.Package(nil), pkgs...)}
+	return md, pkgs, checksumAlg, nil
+}
 
-	checksumAlg := primaryData.Checksum.Type
-	if checksumAlg == "" {
-		checksumAlg = "sha256"
+// ChecksumAuto, as Repo.Checksum, keeps the checksum algorithm the existing metadata uses.
+const ChecksumAuto = "auto"
+
+// loadPackagesForWrite is loadPackages for add and remove: the returned algorithm is the one the
+// rewritten metadata uses, chosen by writeChecksum.
+func (r *Repo) loadPackagesForWrite(ctx context.Context) (metadata.RepoMD, []metadata.Package, string, error) {
+	md, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		return metadata.RepoMD{}, nil, "", err
+	}
+	checksumAlg, err := r.writeChecksum(md)
+	if err != nil {
+		return metadata.RepoMD{}, nil, "", err
 	}
 	return md, pkgs, checksumAlg, nil
 }
 
+// writeChecksum returns the checksum algorithm to write metadata with: Checksum if it names one,
+// migrating the repo to it, or else the one md's core files already use. Detection fails rather
+// than guess when the core files record no algorithm, disagree, or use one that cannot be
+// written, so that a sha512 repo is never quietly rewritten as sha256. Empty metadata, as for a
+// repo being initialized, gets sha256.
+func (r *Repo) writeChecksum(md metadata.RepoMD) (string, error) {
+	if alg := strings.ToLower(r.Checksum); alg != "" && alg != ChecksumAuto {
+		if !metadata.SupportedChecksum(alg) {
+			return "", fmt.Errorf("unsupported checksum algorithm %q", r.Checksum)
+		}
+		return alg, nil
+	}
+	primary, filelists, other := metadata.GetCoreData(md)
+	if primary == nil {
+		return "sha256", nil
+	}
+	detected := ""
+	for _, d := range []*metadata.RepoData{primary, filelists, other} {
+		if d == nil {
+			continue
+		}
+		alg := strings.ToLower(d.Checksum.Type)
+		switch {
+		case alg == "":
+			return "", fmt.Errorf("cannot detect checksum algorithm: %s has no checksum type (set --checksum)", d.Type)
+		case detected != "" && alg != detected:
+			return "", fmt.Errorf("cannot detect checksum algorithm: core metadata uses both %s and %s (set --checksum)", detected, alg)
+		}
+		detected = alg
+	}
+	if !metadata.SupportedChecksum(detected) {
+		return "", fmt.Errorf("existing metadata uses unsupported checksum algorithm %q (set --checksum to migrate)", detected)
+	}
+	return detected, nil
+}
+
+// packageCache holds the package set parsed from core metadata so repeated operations on one Repo
+// skip re-reading and re-parsing primary/filelists/other. Parsing dominates for large repos (tens of
+// thousands of packages), while repomd.xml is tiny, so repomd.xml is still read on every operation and
+// the cache is only used when its core checksums match; a concurrent writer therefore causes a miss,
+// never stale data. The tradeoff is memory: the full package set stays resident for the Repo's lifetime.
+type packageCache struct {
+	key  string
+	pkgs []metadata.Package
+}
+
+// packageCacheKey identifies the core metadata referenced by md. Absent types are part of the key.
+func packageCacheKey(md metadata.RepoMD) string {
+	primary, filelists, other := metadata.GetCoreData(md)
+	var parts []string
+	for _, d := range []*metadata.RepoData{primary, filelists, other, metadata.FindData(md, "filelists_ext")} {
+		if d == nil {
+			parts = append(parts, "-")
+			continue
+		}
+		parts = append(parts, d.Type+"="+d.Checksum.Type+":"+d.Checksum.Value)
+	}
+	return strings.Join(parts, "|")
+}
+
+// packagesFromCoreFiles parses the package set back from freshly built core files. The cache is
+// filled from this rather than from the packages passed in, because rendering cleans text and
+// trims changelogs, and a cache hit must return what a reload from the backend would.
+func packagesFromCoreFiles(coreFiles []metadata.CoreFile) ([]metadata.Package, error) {
+	payloads := make(map[string][]byte, len(coreFiles))
+	for _, cf := range coreFiles {
+		payloads[cf.Type] = cf.Uncompressed
+	}
+	pkgs, err := metadata.ParsePackagesFromXML(payloads["primary"], payloads["filelists"], payloads["other"])
+	if err != nil {
+		return nil, err
+	}
+	if ext, ok := payloads["filelists_ext"]; ok && payloads["filelists"] != nil {
+		if err := metadata.ApplyFilelistsExt(pkgs, ext); err != nil {
+			return nil, err
+		}
+	}
+	return pkgs, nil
+}
+
+// layoutFor returns the core layout for rewriting md. Optional types the existing repo does not
+// have stay omitted: their contents were never loaded, so regenerating them would publish empty data.
+func (r *Repo) layoutFor(md metadata.RepoMD) metadata.CoreLayout {
+	layout := r.coreLayout()
+	primary, filelists, other := metadata.GetCoreData(md)
+	if primary != nil {
+		layout.NoFilelists = layout.NoFilelists || filelists == nil
+		layout.NoOther = layout.NoOther || other == nil
+		layout.FilelistsExt = layout.FilelistsExt || metadata.FindData(md, "filelists_ext") != nil
+	}
+	return layout
+}
+
 // writeMetadata regenerates core metadata and repomd.xml, writing via backend.
-func (r *Repo) writeMetadata(ctx context.Context, md metadata.RepoMD, pkgs []metadata.Package, checksumAlg string, now time.Time) error {
+// It returns the revision of the new repomd.xml.
+func (r *Repo) writeMetadata(ctx context.Context, md metadata.RepoMD, pkgs []metadata.Package, checksumAlg string, now time.Time) (string, error) {
 	if validator, ok := r.backend.(RepomdValidator); ok {
 		if err := validator.CheckRepomdUnchanged(ctx); err != nil {
-			return err
+			return "", err
 		}
 	}
 	checksumAlg = normalizeChecksum(checksumAlg)
+	if conflicts := duplicateLocations(pkgs); len(conflicts) > 0 {
+		msgs := make([]string, len(conflicts))
+		for i, c := range conflicts {
+			msgs[i] = c.String()
+		}
+		return "", fmt.Errorf("duplicate package locations: %s", strings.Join(msgs, "; "))
+	}
 
-	coreFiles, err := metadata.BuildCoreFilesFromPackages(pkgs, checksumAlg, now)
+	coreFiles, err := metadata.BuildCoreFilesFromPackages(pkgs, checksumAlg, r.layoutFor(md), now)
 	if err != nil {
-		return fmt.Errorf("build core metadata: %w", err)
+		return "", fmt.Errorf("build core metadata: %w", err)
 	}
 	newRepoMD, warnings := assembleRepoMD(md, coreFiles, checksumAlg, now, r.AllowUnknown)
-	repomdBytes, err := metadata.MarshalRepoMD(newRepoMD)
-	if err != nil {
-		return fmt.Errorf("marshal repomd.xml: %w", err)
-	}
 	for _, w := range warnings {
 		r.logger.Printf("warn: %s", w)
 	}
+	// Rewriting identical metadata would only bump the revision and invalidate client caches.
+	if !r.ForceRewrite && (r.RepodataRevision == "" || r.RepodataRevision == md.Revision) && sameRepoData(md, newRepoMD) {
+		r.logger.Printf("info: no changes; metadata not rewritten")
+		if r.ArchRepodata {
+			if err := r.writeArchRepodata(ctx, md, pkgs, checksumAlg, md.Revision, now); err != nil {
+				return "", err
+			}
+		}
+		return md.Revision, nil
+	}
+	if newRepoMD.Revision, err = r.nextRevision(md.Revision, now); err != nil {
+		return "", err
+	}
+	repomdBytes, err := metadata.MarshalRepoMD(newRepoMD)
+	if err != nil {
+		return "", fmt.Errorf("marshal repomd.xml: %w", err)
+	}
 
 	for _, cf := range coreFiles {
 		if err := r.backend.WriteFile(ctx, cf.Path, cf.Compressed); err != nil {
-			return fmt.Errorf("write %s: %w", cf.Path, err)
+			return "", fmt.Errorf("write %s: %w", cf.Path, err)
+		}
+	}
+	if err := r.signCoreFiles(ctx, coreFiles); err != nil {
+		return "", err
+	}
+	repomdPath := r.repomdPath()
+	// Keep the repomd.xml being replaced so a bad or interrupted write can be rolled back. Only one
+	// that loaded is kept, so a truncated file never overwrites a good backup. Plain filenames are
+	// overwritten in place, which would leave a backup pointing at files that no longer match it.
+	if !r.NoBackup && (md.Revision != "" || len(md.Data) > 0) && !strings.EqualFold(r.MetadataFilenameStyle, metadata.FilenameStylePlain) {
+		if err := r.backend.Copy(ctx, repomdPath, repomdBackupPath(repomdPath)); err != nil {
+			r.logger.Printf("warn: back up %s: %v", repomdPath, err)
 		}
 	}
-	if err := r.backend.WriteFile(ctx, "repodata/repomd.xml", repomdBytes); err != nil {
-		return fmt.Errorf("write repodata/repomd.xml: %w", err)
+	if err := r.backend.WriteFile(ctx, repomdPath, repomdBytes); err != nil {
+		return "", fmt.Errorf("write %s: %w", repomdPath, err)
+	}
+	if err := r.writeArchRepodata(ctx, md, pkgs, checksumAlg, newRepoMD.Revision, now); err != nil {
+		return "", err
+	}
+
+	r.cache = nil
+	if written, err := packagesFromCoreFiles(coreFiles); err == nil {
+		r.cache = &packageCache{key: packageCacheKey(newRepoMD), pkgs: written}
 	}
 
 	// Clean up old metadata files no longer referenced
 	if err := r.cleanupOldMetadata(ctx, newRepoMD); err != nil {
 		r.logger.Printf("warn: cleanup old metadata: %v", err)
 	}
-	return nil
+	return newRepoMD.Revision, nil
+}
+
+// sameRepoData reports whether next lists exactly the files old does, by type, href and checksum.
+func sameRepoData(old, next metadata.RepoMD) bool {
+	if len(old.Data) != len(next.Data) {
+		return false
+	}
+	for _, d := range next.Data {
+		o := metadata.FindData(old, d.Type)
+		if o == nil || o.Location.Href != d.Location.Href || o.Checksum != d.Checksum {
+			return false
+		}
+	}
+	return true
 }
 
-// cleanupOldMetadata removes metadata files not referenced in current repomd.xml
+// repomdBackupPath returns where the previous repomd.xml is kept.
+func repomdBackupPath(repomdPath string) string {
+	return repomdPath + ".bak"
+}
+
+// cleanupOldMetadata removes metadata files not referenced in current repomd.xml or its backup,
+// so the backup stays restorable until the next write.
 func (r *Repo) cleanupOldMetadata(ctx context.Context, md metadata.RepoMD) error {
 	// Build set of referenced files
+	repomdPath := r.repomdPath()
 	referenced := make(map[string]struct{})
-	referenced["repodata/repomd.xml"] = struct{}{}
-	referenced["repodata/repomd.xml.asc"] = struct{}{}
-	for _, d := range md.Data {
+	referenced[repomdPath] = struct{}{}
+	referenced[repomdPath+".asc"] = struct{}{}
+	referenced[repomdBackupPath(repomdPath)] = struct{}{}
+	keep := md.Data
+	if data, err := r.backend.ReadFile(ctx, repomdBackupPath(repomdPath)); err == nil {
+		if backup, err := metadata.ParseRepoMD(data); err == nil {
+			keep = append(append([]metadata.RepoData(nil), keep...), backup.Data...)
+		}
+	}
+	for _, d := range keep {
 		referenced[d.Location.Href] = struct{}{}
+		if r.SignMetadataFiles {
+			referenced[d.Location.Href+".asc"] = struct{}{}
+		}
 	}
 
 	// List current repodata files
@@ -102,13 +320,20 @@ func (r *Repo) cleanupOldMetadata(ctx context.Context, md metadata.RepoMD) error
 		return fmt.Errorf("list repodata: %w", err)
 	}
 
+	for _, f := range r.retainedOldMetadata(ctx, files, referenced) {
+		referenced[f] = struct{}{}
+		if r.SignMetadataFiles {
+			referenced[f+".asc"] = struct{}{}
+		}
+	}
+
 	// Delete unreferenced files
 	for _, f := range files {
 		if _, ok := referenced[f]; ok {
 			continue
 		}
 		// Skip .tmp directory
-		if strings.HasPrefix(f, "repodata/.tmp") {
+		if strings.HasPrefix(f, path.Join(r.backend.RepodataDir(), ".tmp")) {
 			continue
 		}
 		if err := r.backend.DeleteFile(ctx, f); err != nil {
@@ -118,12 +343,59 @@ func (r *Repo) cleanupOldMetadata(ctx context.Context, md metadata.RepoMD) error
 	return nil
 }
 
+// retainedOldMetadata returns the unreferenced core files of the KeepOldMetadata newest superseded
+// generations. Each generation writes one file per core type, so the newest files of each type are
+// kept. Backends that cannot report modification times keep none.
+func (r *Repo) retainedOldMetadata(ctx context.Context, files []string, referenced map[string]struct{}) []string {
+	if r.KeepOldMetadata <= 0 {
+		return nil
+	}
+	mt, ok := r.backend.(ModTimer)
+	if !ok {
+		r.logger.Printf("warn: backend cannot report modification times; old metadata is not kept")
+		return nil
+	}
+	type oldFile struct {
+		path    string
+		modTime time.Time
+	}
+	byType := make(map[string][]oldFile)
+	for _, f := range files {
+		if _, ok := referenced[f]; ok {
+			continue
+		}
+		typ := coreFileType(f)
+		if typ == "" {
+			continue
+		}
+		modTime, err := mt.ModTime(ctx, f)
+		if err != nil {
+			r.logger.Printf("warn: stat %s: %v", f, err)
+			continue
+		}
+		byType[typ] = append(byType[typ], oldFile{path: f, modTime: modTime})
+	}
+	var keep []string
+	for _, old := range byType {
+		sort.Slice(old, func(i, j int) bool { return old[i].modTime.After(old[j].modTime) })
+		for i := 0; i < len(old) && i < r.KeepOldMetadata; i++ {
+			keep = append(keep, old[i].path)
+		}
+	}
+	return keep
+}
+
+// ModTimer is implemented by backends that can report when a file was last modified.
+type ModTimer interface {
+	ModTime(ctx context.Context, path string) (time.Time, error)
+}
+
 // RepomdValidator optionally protects writes with ETag checks.
 type RepomdValidator interface {
 	CheckRepomdUnchanged(ctx context.Context) error
 }
 
-func assembleRepoMD(old metadata.RepoMD, core []metadata.CoreFile, checksumAlg string, now time.Time, allowUnknown bool) (metadata.RepoMD, []string) {
+func assembleRepoMD(old metadata.RepoMD, core []metadata.CoreFile, checksumAlg string, now time.Time, allowUnknown bool) (metadata.RepoMD, []Warning) {
 	newMD := metadata.RepoMD{
 		Xmlns:    old.Xmlns,
 		Revision: fmt.Sprintf("%d", now.Unix()),
@@ -135,9 +407,9 @@ func assembleRepoMD(old metadata.RepoMD, core []metadata.CoreFile, checksumAlg s
 	unknownTypes := make(map[string]struct{})
 	for _, d := range old.Data {
 		switch d.Type {
-		case "primary", "filelists", "other", "prestodelta":
+		case "primary", "filelists", "filelists_ext", "other", "prestodelta":
 			continue
-		case "modules":
+		case "modules", "group", "group_gz", "updateinfo":
 			newMD.Data = append(newMD.Data, d)
 		default:
 			if allowUnknown {
@@ -166,9 +438,9 @@ func assembleRepoMD(old metadata.RepoMD, core []metadata.CoreFile, checksumAlg s
 		})
 	}
 
-	var warnings []string
+	var warnings []Warning
 	for t := range unknownTypes {
-		warnings = append(warnings, fmt.Sprintf("preserving unknown metadata type '%s' from repomd.xml; checksum not verified", t))
+		warnings = append(warnings, unknownTypeWarning(t))
 	}
 	return newMD, warnings
 }
@@ -2,6 +2,7 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -36,8 +37,8 @@ func TestAssembleRepoMDUnknownWarning(t *testing.T) {
 	if len(warnings) != 1 {
 		t.Fatalf("expected 1 warning, got %d: %v", len(warnings), warnings)
 	}
-	if warnings[0] == "" {
-		t.Fatalf("warning should not be empty")
+	if warnings[0].Code != WarnUnknownMetadataType || warnings[0].Detail != "productid" {
+		t.Fatalf("unexpected warning: %+v", warnings[0])
 	}
 }
 
@@ -69,3 +70,274 @@ func TestLoadPackagesRejectsSqlite(t *testing.T) {
 		t.Fatalf("expected sqlite error, got nil")
 	}
 }
+
+func TestLoadPackagesUsesCacheUntilMetadataChanges(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	r := New(mb)
+	if _, _, _, err := r.loadPackages(ctx); err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+
+	// Core files unchanged per repomd.xml: a cache hit must not touch them.
+	for k := range mb.files {
+		if k != "repodata/repomd.xml" {
+			delete(mb.files, k)
+		}
+	}
+	_, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("cached loadPackages: %v", err)
+	}
+	if len(pkgs) != 1 {
+		t.Fatalf("expected 1 cached package, got %d", len(pkgs))
+	}
+
+	// New core metadata from another writer invalidates the cache.
+	seedRepo(t, mb, []metadata.Package{})
+	_, pkgs, _, err = r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("reloaded loadPackages: %v", err)
+	}
+	if len(pkgs) != 0 {
+		t.Fatalf("expected reload after metadata change, got %d packages", len(pkgs))
+	}
+}
+
+func TestCacheAfterWriteMatchesReload(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.ChangelogLimit = 1
+	if err := r.InitRepo(ctx, "sha256", false, false, ""); err != nil {
+		t.Fatalf("InitRepo: %v", err)
+	}
+	md, _, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	pkgs := []metadata.Package{{
+		Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abc", Location: "foo-1.0-1.x86_64.rpm",
+		Summary: "caf\xe9",
+		Changelogs: []metadata.Changelog{
+			{Author: "a", Date: 200, Text: "new"},
+			{Author: "a", Date: 100, Text: "old"},
+		},
+	}}
+	if _, err := r.writeMetadata(ctx, md, pkgs, "sha256", time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+
+	_, cached, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("cached loadPackages: %v", err)
+	}
+	r.cache = nil
+	_, reloaded, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("reloaded loadPackages: %v", err)
+	}
+	if len(cached) != 1 || len(reloaded) != 1 {
+		t.Fatalf("expected 1 package, got %d cached and %d reloaded", len(cached), len(reloaded))
+	}
+	if got, want := cached[0], reloaded[0]; got.Summary != want.Summary || len(got.Changelogs) != len(want.Changelogs) {
+		t.Fatalf("cache after write differs from metadata on disk: %+v vs %+v", got, want)
+	}
+	if reloaded[0].Summary != "caf\u00e9" || len(reloaded[0].Changelogs) != 1 {
+		t.Fatalf("unexpected reloaded package %+v", reloaded[0])
+	}
+}
+
+func TestWriteMetadataPlainFilenames(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.MetadataFilenameStyle = metadata.FilenameStylePlain
+
+	if err := r.InitRepo(ctx, "sha256", false, false, ""); err != nil {
+		t.Fatalf("InitRepo: %v", err)
+	}
+	pkgs := []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "abc", Location: "foo-1.0-1.x86_64.rpm"},
+	}
+	for i := 0; i < 2; i++ {
+		md, _, _, err := r.loadPackages(ctx)
+		if err != nil {
+			t.Fatalf("loadPackages: %v", err)
+		}
+		if _, err := r.writeMetadata(ctx, md, pkgs[:i], "sha256", time.Unix(int64(1700000000+i), 0)); err != nil {
+			t.Fatalf("writeMetadata: %v", err)
+		}
+	}
+
+	want := map[string]bool{
+		"repodata/repomd.xml":       true,
+		"repodata/primary.xml.gz":   true,
+		"repodata/filelists.xml.gz": true,
+		"repodata/other.xml.gz":     true,
+	}
+	for p := range mb.files {
+		if !want[p] {
+			t.Fatalf("unexpected file %s", p)
+		}
+	}
+	if len(mb.files) != len(want) {
+		t.Fatalf("expected %d files, got %d", len(want), len(mb.files))
+	}
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+	for _, d := range md.Data {
+		if !want[d.Location.Href] {
+			t.Fatalf("repomd references %s", d.Location.Href)
+		}
+	}
+	r.cache = nil
+	if _, got, _, err := r.loadPackages(ctx); err != nil || len(got) != 1 {
+		t.Fatalf("reload after overwrite: %d packages, err %v", len(got), err)
+	}
+}
+
+type forbiddenBackend struct{ *memBackend }
+
+func (forbiddenBackend) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	return nil, errors.New("AccessDenied: 403")
+}
+
+func TestLoadPackagesNotInitialized(t *testing.T) {
+	ctx := context.Background()
+	r := New(newMemBackend())
+	_, _, _, err := r.loadPackages(ctx)
+	if !errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("expected ErrNotInitialized, got %v", err)
+	}
+
+	r = New(forbiddenBackend{newMemBackend()})
+	_, _, _, err = r.loadPackages(ctx)
+	if err == nil || errors.Is(err, ErrNotInitialized) {
+		t.Fatalf("permission error must not be reported as not initialized, got %v", err)
+	}
+}
+
+func TestRepoWithoutFilelists(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	pkgs := []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	}
+	now := time.Unix(0, 0)
+	core, err := metadata.BuildCoreFilesFromPackages(pkgs, "sha256", metadata.CoreLayout{Dir: "repodata", NoFilelists: true}, now)
+	if err != nil {
+		t.Fatalf("build core: %v", err)
+	}
+	md := metadata.UpdateRepoMDWithCore(metadata.RepoMD{}, core, "sha256", now)
+	repomdBytes, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	for _, cf := range core {
+		mb.files[cf.Path] = cf.Compressed
+	}
+	mb.files["repodata/repomd.xml"] = repomdBytes
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	result := r.CheckDetailed(ctx, CheckOptions{})
+	if err := result.Failure(); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0].Code != WarnMissingFilelists {
+		t.Fatalf("expected missing filelists warning, got %v", result.Warnings)
+	}
+
+	loaded, got, alg, err := r.loadPackages(ctx)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("loadPackages: %d packages, err %v", len(got), err)
+	}
+	if _, err := r.writeMetadata(ctx, loaded, got, alg, time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	rewritten, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+	primary, filelists, other := metadata.GetCoreData(rewritten)
+	if primary == nil || other == nil || filelists != nil {
+		t.Fatalf("expected primary and other without filelists, got %+v", rewritten.Data)
+	}
+}
+
+func TestWriteMetadataSkipsUnchangedMetadata(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	repomd := string(mb.files["repodata/repomd.xml"])
+
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	rev, err := r.writeMetadata(ctx, md, pkgs, alg, time.Now())
+	if err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	if rev != md.Revision || string(mb.files["repodata/repomd.xml"]) != repomd {
+		t.Fatalf("identical metadata was rewritten (revision %s, was %s)", rev, md.Revision)
+	}
+
+	r.ForceRewrite = true
+	if rev, err = r.writeMetadata(ctx, md, pkgs, alg, time.Now()); err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	if rev == md.Revision || string(mb.files["repodata/repomd.xml"]) == repomd {
+		t.Fatalf("ForceRewrite did not rewrite metadata")
+	}
+}
+
+func TestWriteChecksum(t *testing.T) {
+	core := func(types ...string) metadata.RepoMD {
+		var md metadata.RepoMD
+		for i, typ := range []string{"primary", "filelists", "other"} {
+			md.Data = append(md.Data, metadata.RepoData{Type: typ, Checksum: metadata.Checksum{Type: types[i]}})
+		}
+		return md
+	}
+	r := New(newMemBackend())
+	for _, tc := range []struct {
+		name, checksum string
+		md             metadata.RepoMD
+		want           string
+	}{
+		{"detected", "", core("sha512", "sha512", "sha512"), "sha512"},
+		{"auto", ChecksumAuto, core("SHA512", "sha512", "sha512"), "sha512"},
+		{"explicit migrates", "sha256", core("sha512", "sha512", "sha512"), "sha256"},
+		{"uninitialized", "", metadata.RepoMD{}, "sha256"},
+		{"missing type", "", core("sha512", "", "sha512"), ""},
+		{"mixed types", ChecksumAuto, core("sha512", "sha256", "sha512"), ""},
+		{"unsupported", "", core("sha1", "sha1", "sha1"), ""},
+		{"explicit overrides undetectable", "sha512", core("sha1", "", "sha256"), "sha512"},
+	} {
+		r.Checksum = tc.checksum
+		got, err := r.writeChecksum(tc.md)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", tc.name, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %q, %v; want %q", tc.name, got, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/e2llm/rpmrepo-update/pkg/inspector"
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// ReadSignatures sets Signed and SigKeyID on each of pkgs from the signature header of its RPM in
+// storage, which repodata does not record.
+func (r *Repo) ReadSignatures(ctx context.Context, pkgs []metadata.Package) error {
+	for i := range pkgs {
+		if err := r.readSignature(ctx, &pkgs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSignature sets pkg's signature fields from its stored RPM. The signature header ends where
+// the main header starts, so only the bytes before the recorded header range are fetched; a
+// package without one has its RPM read whole.
+func (r *Repo) readSignature(ctx context.Context, pkg *metadata.Package) error {
+	storagePath := r.storagePathFor(pkg.Location)
+	var data []byte
+	var err error
+	if pkg.HeaderStart > 0 {
+		data, err = r.backend.ReadRange(ctx, storagePath, 0, int64(pkg.HeaderStart))
+	} else {
+		data, err = r.backend.ReadFile(ctx, storagePath)
+	}
+	if err != nil {
+		return fmt.Errorf("package %s: read %s: %w", pkg.NEVRA(), storagePath, err)
+	}
+	pkg.Signed, pkg.SigKeyID, err = inspector.ReadSignature(data)
+	if err != nil {
+		return fmt.Errorf("package %s: %s: %w", pkg.NEVRA(), storagePath, err)
+	}
+	return nil
+}
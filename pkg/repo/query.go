@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"context"
+	"strings"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// DepMatch is a package found by a dependency query, with the entry that matched it.
+type DepMatch struct {
+	NEVRA    string `json:"nevra"`
+	Location string `json:"location"`
+	Match    string `json:"match"`
+}
+
+// WhatProvides returns the packages, sorted by NEVRA, with a provide satisfying query, a
+// dependency such as "libfoo.so.1()(64bit)" or "bar >= 1.0" (see metadata.ParseRelation).
+// A query for an absolute path also matches the files the package ships, as rpm does.
+func (r *Repo) WhatProvides(ctx context.Context, query string) ([]DepMatch, error) {
+	return r.queryRelations(ctx, query, func(p metadata.Package) []metadata.Relation { return p.Provides }, true)
+}
+
+// WhatRequires returns the packages, sorted by NEVRA, with a requirement that query satisfies.
+// A versioned query matches requirements whose version range overlaps it.
+func (r *Repo) WhatRequires(ctx context.Context, query string) ([]DepMatch, error) {
+	return r.queryRelations(ctx, query, func(p metadata.Package) []metadata.Relation { return p.Requires }, false)
+}
+
+func (r *Repo) queryRelations(ctx context.Context, query string, relations func(metadata.Package) []metadata.Relation, files bool) ([]DepMatch, error) {
+	q, err := metadata.ParseRelation(query)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := r.ListPackages(ctx)
+	if err != nil {
+		return nil, err
+	}
+	matches := []DepMatch{}
+	for _, p := range pkgs {
+		if m, ok := matchRelations(p, relations(p), q, files); ok {
+			matches = append(matches, DepMatch{NEVRA: p.NEVRA(), Location: p.Location, Match: m})
+		}
+	}
+	return matches, nil
+}
+
+// matchRelations returns the first of rels overlapping q or, with files set and an unversioned
+// path query, the matching file of p.
+func matchRelations(p metadata.Package, rels []metadata.Relation, q metadata.Relation, files bool) (string, bool) {
+	for _, rel := range rels {
+		if rel.Overlaps(q) {
+			return rel.String(), true
+		}
+	}
+	if files && q.Flags == "" && strings.HasPrefix(q.Name, "/") {
+		for _, f := range p.Files {
+			if f.Path == q.Name {
+				return f.Path, true
+			}
+		}
+	}
+	return "", false
+}
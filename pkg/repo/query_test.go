@@ -0,0 +1,67 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestWhatProvidesAndRequires(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{
+			Name: "libfoo", Arch: "x86_64", Version: "1.2", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "libfoo-1.2-1.x86_64.rpm",
+			Provides: []metadata.Relation{{Name: "libfoo.so.1()(64bit)"}, {Name: "libfoo", Flags: metadata.DepFlagEQ, Ver: "1.2", Rel: "1"}},
+			Files:    []metadata.File{{Path: "/usr/lib64/libfoo.so.1"}},
+		},
+		{
+			Name: "app", Arch: "x86_64", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "app-2.0-1.x86_64.rpm",
+			Requires: []metadata.Relation{{Name: "libfoo.so.1()(64bit)"}, {Name: "libfoo", Flags: metadata.DepFlagGE, Ver: "1.0"}},
+		},
+		{
+			Name: "legacy", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "c", Location: "legacy-1.0-1.x86_64.rpm",
+			Requires: []metadata.Relation{{Name: "libfoo", Flags: metadata.DepFlagLT, Ver: "1.0"}},
+		},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	nevras := func(matches []DepMatch, err error) []string {
+		t.Helper()
+		if err != nil {
+			t.Fatalf("query: %v", err)
+		}
+		out := []string{}
+		for _, m := range matches {
+			out = append(out, m.NEVRA+" "+m.Match)
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		query string
+		got   []string
+		want  []string
+	}{
+		{"libfoo.so.1()(64bit)", nevras(r.WhatProvides(ctx, "libfoo.so.1()(64bit)")), []string{"libfoo-1.2-1.x86_64 libfoo.so.1()(64bit)"}},
+		{"libfoo >= 1.0", nevras(r.WhatProvides(ctx, "libfoo >= 1.0")), []string{"libfoo-1.2-1.x86_64 libfoo = 1.2-1"}},
+		{"libfoo >= 2.0", nevras(r.WhatProvides(ctx, "libfoo >= 2.0")), []string{}},
+		{"/usr/lib64/libfoo.so.1", nevras(r.WhatProvides(ctx, "/usr/lib64/libfoo.so.1")), []string{"libfoo-1.2-1.x86_64 /usr/lib64/libfoo.so.1"}},
+		{"whatrequires libfoo", nevras(r.WhatRequires(ctx, "libfoo")), []string{"app-2.0-1.x86_64 libfoo >= 1.0", "legacy-1.0-1.x86_64 libfoo < 1.0"}},
+		{"whatrequires libfoo = 1.2", nevras(r.WhatRequires(ctx, "libfoo = 1.2")), []string{"app-2.0-1.x86_64 libfoo >= 1.0"}},
+	} {
+		if len(tc.got) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.query, tc.got, tc.want)
+			continue
+		}
+		for i := range tc.want {
+			if tc.got[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.query, tc.got, tc.want)
+			}
+		}
+	}
+	if _, err := r.WhatProvides(ctx, "libfoo >="); err == nil {
+		t.Fatalf("expected an error for an incomplete query")
+	}
+}
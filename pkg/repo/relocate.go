@@ -0,0 +1,126 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// Package layouts accepted by Relocate. Each places the RPM file, under its existing
+// basename, below DestPrefix (if set).
+const (
+	// LayoutFlat stores every RPM directly in the repository root: foo-1.0-1.x86_64.rpm.
+	LayoutFlat = "flat"
+	// LayoutLetter groups RPMs by the first letter of the package name: Packages/f/foo-1.0-1.x86_64.rpm.
+	LayoutLetter = "letter"
+	// LayoutPool groups RPMs by first letter and package name: pool/f/foo/foo-1.0-1.x86_64.rpm.
+	LayoutPool = "pool"
+)
+
+// SupportedLayout reports whether name is a known package layout.
+func SupportedLayout(name string) bool {
+	switch name {
+	case LayoutFlat, LayoutLetter, LayoutPool:
+		return true
+	default:
+		return false
+	}
+}
+
+// layoutPath returns the storage path for pkg under the given layout.
+func (r *Repo) layoutPath(layout string, pkg metadata.Package) (string, error) {
+	base := path.Base(r.storagePathFor(pkg.Location))
+	var rel string
+	switch layout {
+	case LayoutFlat:
+		rel = base
+	case LayoutLetter:
+		rel = path.Join("Packages", nameLetter(pkg.Name), base)
+	case LayoutPool:
+		rel = path.Join("pool", nameLetter(pkg.Name), pkg.Name, base)
+	default:
+		return "", fmt.Errorf("unknown layout %q", layout)
+	}
+	if r.DestPrefix != "" {
+		rel = path.Join(r.DestPrefix, rel)
+	}
+	if err := checkDestPath(rel); err != nil {
+		return "", err
+	}
+	return rel, nil
+}
+
+// nameLetter returns the lower-cased first character of a package name.
+func nameLetter(name string) string {
+	c, _ := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(c))
+}
+
+// Relocate moves every package to the path given by layout and updates its location in metadata.
+// RPMs are copied within the backend and never re-inspected, so package checksums are preserved.
+// New copies are written before metadata and the old files are deleted only afterwards, so
+// an interrupted run leaves metadata pointing at files that exist.
+func (r *Repo) Relocate(ctx context.Context, layout string, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
+	if !SupportedLayout(layout) {
+		return result, fmt.Errorf("unknown layout %q", layout)
+	}
+	md, pkgs, checksumAlg, err := r.loadPackages(ctx)
+	if err != nil {
+		return result, err
+	}
+
+	current := make(map[string]struct{}, len(pkgs))
+	for _, p := range pkgs {
+		current[r.storagePathFor(p.Location)] = struct{}{}
+	}
+	type move struct{ src, dst string }
+	var moves []move
+	claimed := make(map[string]string)
+	for i := range pkgs {
+		src := r.storagePathFor(pkgs[i].Location)
+		dst, err := r.layoutPath(layout, pkgs[i])
+		if err != nil {
+			return result, err
+		}
+		if other, ok := claimed[dst]; ok {
+			return result, fmt.Errorf("packages %s and %s both map to %s", other, pkgs[i].NEVRA(), dst)
+		}
+		claimed[dst] = pkgs[i].NEVRA()
+		if dst == src {
+			continue
+		}
+		if _, ok := current[dst]; ok {
+			return result, fmt.Errorf("cannot move %s to %s: path is used by another package", src, dst)
+		}
+		oldLocation := pkgs[i].Location
+		pkgs[i].Location = r.locationFor(dst)
+		moves = append(moves, move{src: src, dst: dst})
+		result.Packages = append(result.Packages, PackageChange{NEVRA: pkgs[i].NEVRA(), Location: pkgs[i].Location, Status: ChangeRelocated, Source: oldLocation})
+	}
+
+	if dryRun || len(moves) == 0 {
+		return result, nil
+	}
+	for _, m := range moves {
+		if err := r.backend.Copy(ctx, m.src, m.dst); err != nil {
+			return result, fmt.Errorf("copy %s to %s: %w", m.src, m.dst, err)
+		}
+	}
+	revision, err := r.writeMetadata(ctx, md, pkgs, checksumAlg, time.Now().UTC())
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	for _, m := range moves {
+		if err := r.backend.DeleteFile(ctx, m.src); err != nil {
+			r.logger.Printf("warn: delete %s: %v", m.src, err)
+		}
+	}
+	return result, nil
+}
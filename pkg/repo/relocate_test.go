@@ -0,0 +1,74 @@
+package repo
+
+import (
+	"context"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestRelocatePool(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "Foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "aaa", Location: "Foo-1.0-1.x86_64.rpm"},
+		{Name: "bar", Arch: "noarch", Version: "2.0", Release: "1", ChecksumType: "sha256", PkgID: "bbb", Location: "pool/b/bar/bar-2.0-1.noarch.rpm"},
+	})
+	mb.files["Foo-1.0-1.x86_64.rpm"] = []byte("foo")
+	mb.files["pool/b/bar/bar-2.0-1.noarch.rpm"] = []byte("bar")
+
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	result, err := r.Relocate(ctx, LayoutPool, true)
+	if err != nil {
+		t.Fatalf("Relocate dry run: %v", err)
+	}
+	want := PackageChange{NEVRA: "Foo-1.0-1.x86_64", Location: "pool/f/Foo/Foo-1.0-1.x86_64.rpm", Status: ChangeRelocated, Source: "Foo-1.0-1.x86_64.rpm"}
+	if len(result.Packages) != 1 || result.Packages[0] != want {
+		t.Fatalf("unexpected dry-run packages: %+v", result.Packages)
+	}
+	if _, ok := mb.files["pool/f/Foo/Foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("dry run copied file")
+	}
+
+	if _, err := r.Relocate(ctx, LayoutPool, false); err != nil {
+		t.Fatalf("Relocate: %v", err)
+	}
+	if _, ok := mb.files["Foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("expected old file deleted")
+	}
+	if string(mb.files["pool/f/Foo/Foo-1.0-1.x86_64.rpm"]) != "foo" {
+		t.Fatalf("expected file copied to pool")
+	}
+	_, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	for _, p := range pkgs {
+		if p.Name == "Foo" && (p.Location != want.Location || p.PkgID != "aaa") {
+			t.Fatalf("unexpected relocated package: %+v", p)
+		}
+	}
+
+	// A second run has nothing to move.
+	result, err = r.Relocate(ctx, LayoutPool, false)
+	if err != nil || len(result.Packages) != 0 {
+		t.Fatalf("expected no-op relocation, got %+v, %v", result, err)
+	}
+}
+
+func TestRelocateCollision(t *testing.T) {
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "a/foo.rpm"},
+		{Name: "foo", Arch: "x86_64", Version: "1.1", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "b/foo.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.Relocate(context.Background(), LayoutFlat, false); err == nil {
+		t.Fatalf("expected collision error")
+	}
+	if _, err := r.Relocate(context.Background(), "bogus", false); err == nil {
+		t.Fatalf("expected unknown layout error")
+	}
+}
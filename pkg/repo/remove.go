@@ -9,20 +9,22 @@ import (
 	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
-// RemoveRPMs removes packages identified by filename (default) or NEVRA. Optionally deletes RPM files.
-func (r *Repo) RemoveRPMs(ctx context.Context, identifiers []string, byNEVRA bool, deleteFiles bool, dryRun bool) error {
+// RemoveRPMs removes packages identified by filename (default) or NEVRA. Optionally deletes RPM files
+// (or moves them to TrashPrefix).
+func (r *Repo) RemoveRPMs(ctx context.Context, identifiers []string, byNEVRA bool, deleteFiles bool, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
 	if len(identifiers) == 0 {
-		return fmt.Errorf("no identifiers provided")
+		return result, fmt.Errorf("no identifiers provided")
 	}
-	md, pkgs, checksumAlg, err := r.loadPackages(ctx)
+	md, pkgs, checksumAlg, err := r.loadPackagesForWrite(ctx)
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	index := make(map[string]int, len(pkgs))
 	nameIndex := make(map[string]int, len(pkgs))
 	for i := range pkgs {
-		index[pkgs[i].NEVRA()] = i
+		index[metadata.NormalizeNEVRA(pkgs[i].NEVRA())] = i
 		nameIndex[filepath.Base(pkgs[i].Location)] = i
 	}
 
@@ -31,12 +33,15 @@ func (r *Repo) RemoveRPMs(ctx context.Context, identifiers []string, byNEVRA boo
 		var idx int
 		var ok bool
 		if byNEVRA {
-			idx, ok = index[id]
+			idx, ok = index[metadata.NormalizeNEVRA(id)]
 		} else {
 			idx, ok = nameIndex[id]
 		}
 		if !ok {
-			return fmt.Errorf("package %s not found", id)
+			return result, fmt.Errorf("package %s not found", id)
+		}
+		if _, dup := toDelete[idx]; !dup {
+			result.Packages = append(result.Packages, PackageChange{NEVRA: pkgs[idx].NEVRA(), Location: pkgs[idx].Location, Status: ChangeRemoved, Source: id})
 		}
 		toDelete[idx] = struct{}{}
 	}
@@ -45,7 +50,7 @@ func (r *Repo) RemoveRPMs(ctx context.Context, identifiers []string, byNEVRA boo
 	var deletePaths []string
 	for i, p := range pkgs {
 		if _, drop := toDelete[i]; drop {
-			deletePaths = append(deletePaths, p.Location)
+			deletePaths = append(deletePaths, r.storagePathFor(p.Location))
 			continue
 		}
 		kept = append(kept, p)
@@ -53,15 +58,98 @@ func (r *Repo) RemoveRPMs(ctx context.Context, identifiers []string, byNEVRA boo
 
 	if deleteFiles && !dryRun {
 		for _, path := range deletePaths {
-			if err := r.backend.DeleteFile(ctx, path); err != nil {
-				return fmt.Errorf("delete %s: %w", path, err)
+			if err := r.deleteRPM(ctx, path); err != nil {
+				return result, err
 			}
 		}
 	}
 
 	now := time.Now().UTC()
 	if dryRun {
-		return nil
+		return result, nil
+	}
+	revision, err := r.writeMetadata(ctx, md, kept, checksumAlg, now)
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	return result, nil
+}
+
+// RemoveAll drops every package, leaving the repository initialized with empty core metadata.
+// Extra metadata such as comps and updateinfo is preserved. With deleteFiles, the RPMs referenced
+// by the old metadata are deleted after the new metadata is written; other files are left alone.
+func (r *Repo) RemoveAll(ctx context.Context, deleteFiles bool, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
+	md, pkgs, checksumAlg, err := r.loadPackagesForWrite(ctx)
+	if err != nil {
+		return result, err
+	}
+	for _, p := range pkgs {
+		result.Packages = append(result.Packages, PackageChange{NEVRA: p.NEVRA(), Location: p.Location, Status: ChangeRemoved, Source: p.Location})
+	}
+	if dryRun {
+		return result, nil
+	}
+	revision, err := r.writeMetadata(ctx, md, nil, checksumAlg, time.Now().UTC())
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	if deleteFiles {
+		for _, p := range pkgs {
+			path := r.storagePathFor(p.Location)
+			if err := r.deleteRPM(ctx, path); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// RemoveMatching removes the packages selected by filter. Unless removeLatest is set, the newest
+// version of each name and arch is kept even when it matches and is reported as skipped, so
+// time-based pruning cannot leave a package uninstallable. With deleteFiles, the RPMs are deleted
+// after the new metadata is written.
+func (r *Repo) RemoveMatching(ctx context.Context, filter PackageFilter, removeLatest bool, deleteFiles bool, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
+	md, pkgs, checksumAlg, err := r.loadPackagesForWrite(ctx)
+	if err != nil {
+		return result, err
+	}
+	newest := newestByNameArch(pkgs)
+
+	var kept, removed []metadata.Package
+	for _, p := range pkgs {
+		if !filter.Match(p) {
+			kept = append(kept, p)
+			continue
+		}
+		if !removeLatest && newest[p.Name+"."+p.Arch].NEVRA() == p.NEVRA() {
+			r.logger.Printf("info: keeping %s: newest version of %s.%s", p.NEVRA(), p.Name, p.Arch)
+			result.Packages = append(result.Packages, PackageChange{NEVRA: p.NEVRA(), Location: p.Location, Status: ChangeSkipped, Source: p.Location})
+			kept = append(kept, p)
+			continue
+		}
+		result.Packages = append(result.Packages, PackageChange{NEVRA: p.NEVRA(), Location: p.Location, Status: ChangeRemoved, Source: p.Location})
+		removed = append(removed, p)
+	}
+
+	if dryRun || !result.hasChanges() {
+		return result, nil
+	}
+	revision, err := r.writeMetadata(ctx, md, kept, checksumAlg, time.Now().UTC())
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	if deleteFiles {
+		for _, p := range removed {
+			path := r.storagePathFor(p.Location)
+			if err := r.deleteRPM(ctx, path); err != nil {
+				return result, err
+			}
+		}
 	}
-	return r.writeMetadata(ctx, md, kept, checksumAlg, now)
+	return result, nil
 }
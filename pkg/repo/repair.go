@@ -0,0 +1,408 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/backend"
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// RepairRepo rebuilds core metadata from the RPM files present in the repository, discarding the
+// existing package list. Known extra metadata types (comps, updateinfo, modules) are preserved.
+// An empty checksumAlg keeps the algorithm of the existing metadata. It returns the number of packages indexed.
+func (r *Repo) RepairRepo(ctx context.Context, checksumAlg string, dryRun bool) (int, error) {
+	if r.backend == nil {
+		return 0, fmt.Errorf("backend is required")
+	}
+	md, err := metadata.LoadRepoMD(ctx, r.backend)
+	if err != nil {
+		// A broken or missing repomd.xml is exactly what repair is for; start from scratch.
+		r.logger.Printf("warn: load repomd.xml: %v; rebuilding without preserved metadata", err)
+		md = metadata.RepoMD{}
+	}
+	if checksumAlg == "" {
+		checksumAlg = "sha256"
+		if primary, _, _ := metadata.GetCoreData(md); primary != nil && primary.Checksum.Type != "" {
+			checksumAlg = primary.Checksum.Type
+		}
+	}
+	if !metadata.SupportedChecksum(checksumAlg) {
+		return 0, fmt.Errorf("unsupported checksum algorithm %q", checksumAlg)
+	}
+	rpmAlg, err := r.rpmChecksumFor(checksumAlg)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	pkgs, err := r.indexRPMs(ctx, rpmAlg, now)
+	if err != nil {
+		return 0, err
+	}
+
+	if dryRun {
+		return len(pkgs), nil
+	}
+	// RPM headers carry everything, so repair emits the full core set even if the old repo lacked
+	// filelists or other; only NoOther omits a type here.
+	if _, err := r.writeMetadata(ctx, withoutCoreData(md), pkgs, checksumAlg, now); err != nil {
+		return 0, err
+	}
+	return len(pkgs), nil
+}
+
+// Rebuild rewrites every core metadata file and repomd.xml from the package list already in the
+// metadata, even if nothing changed, so that the current renderer, layout options (compression,
+// filename style, filelists-ext, ...) and checksumAlg take effect. RPMs are not read: pkgids keep
+// their checksum type. An empty checksumAlg keeps the algorithm of the existing metadata. Optional
+// types the repo lacks stay omitted, and other metadata (comps, updateinfo, modules) is carried
+// over unchanged. It returns the number of packages written.
+func (r *Repo) Rebuild(ctx context.Context, checksumAlg string, signRepodata bool, gpgKey string, dryRun bool) (int, error) {
+	md, pkgs, currentAlg, err := r.loadPackages(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if checksumAlg == "" {
+		checksumAlg = currentAlg
+	}
+	checksumAlg = strings.ToLower(checksumAlg)
+	if !metadata.SupportedChecksum(checksumAlg) {
+		return 0, fmt.Errorf("unsupported checksum algorithm %q", checksumAlg)
+	}
+	if dryRun {
+		return len(pkgs), nil
+	}
+	if _, err := r.writeMetadata(ctx, md, pkgs, checksumAlg, time.Now().UTC()); err != nil {
+		return 0, err
+	}
+	if signRepodata {
+		repomdPath := r.repomdPath()
+		repomd, err := r.backend.ReadFile(ctx, repomdPath)
+		if err != nil {
+			return 0, fmt.Errorf("read %s: %w", repomdPath, err)
+		}
+		if err := r.signRepomd(ctx, repomd, gpgKey); err != nil {
+			return 0, fmt.Errorf("sign repomd.xml: %w", err)
+		}
+	}
+	return len(pkgs), nil
+}
+
+// InitFromExisting initializes a repository over RPMs that are already stored in it: every RPM
+// from ListRPMs is inspected and the populated metadata is written in one pass. Like InitRepo, it
+// refuses to replace an existing repomd.xml unless force is set; nothing of the old metadata is kept
+// except that the revision stays increasing. It returns the number of packages indexed.
+func (r *Repo) InitFromExisting(ctx context.Context, checksumAlg string, force bool, signRepodata bool, gpgKey string, dryRun bool) (int, error) {
+	if r.backend == nil {
+		return 0, fmt.Errorf("backend is required")
+	}
+	repomdPath := r.repomdPath()
+	exists, err := r.backend.Exists(ctx, repomdPath)
+	if err != nil {
+		return 0, err
+	}
+	if exists && !force {
+		return 0, fmt.Errorf("%s already exists (use --force to overwrite)", repomdPath)
+	}
+	checksumAlg = strings.ToLower(checksumAlg)
+	if !metadata.SupportedChecksum(checksumAlg) {
+		return 0, fmt.Errorf("unsupported checksum algorithm %q", checksumAlg)
+	}
+	rpmAlg, err := r.rpmChecksumFor(checksumAlg)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now().UTC()
+	pkgs, err := r.indexRPMs(ctx, rpmAlg, now)
+	if err != nil {
+		return 0, err
+	}
+	if dryRun {
+		return len(pkgs), nil
+	}
+	var base metadata.RepoMD
+	if exists {
+		// Best effort, as in PlanInit: only the old revision is carried over.
+		if old, err := metadata.LoadRepoMD(ctx, r.backend); err == nil {
+			base.Revision = old.Revision
+		}
+	}
+	if _, err := r.writeMetadata(ctx, base, pkgs, checksumAlg, now); err != nil {
+		return 0, err
+	}
+	if signRepodata {
+		repomd, err := r.backend.ReadFile(ctx, repomdPath)
+		if err != nil {
+			return 0, fmt.Errorf("read %s: %w", repomdPath, err)
+		}
+		if err := r.signRepomd(ctx, repomd, gpgKey); err != nil {
+			return 0, fmt.Errorf("sign repomd.xml: %w", err)
+		}
+	}
+	return len(pkgs), nil
+}
+
+// IndexOrphans inspects RPMs that are stored in the repository but not referenced by metadata
+// (see CheckResult.Orphaned) and merges them into the metadata at their current paths; nothing is
+// uploaded or moved. A NEVRA already in the metadata is an error unless replaceExisting is set.
+func (r *Repo) IndexOrphans(ctx context.Context, paths []string, replaceExisting bool, dryRun bool) (ChangeResult, error) {
+	result := ChangeResult{DryRun: dryRun}
+	if r.backend == nil {
+		return result, fmt.Errorf("backend is required")
+	}
+	if len(paths) == 0 {
+		return result, nil
+	}
+	md, pkgs, checksumAlg, err := r.loadPackages(ctx)
+	if err != nil {
+		return result, err
+	}
+	rpmAlg, err := r.rpmChecksumFor(checksumAlg)
+	if err != nil {
+		return result, err
+	}
+	index := make(map[string]int, len(pkgs))
+	for i := range pkgs {
+		index[pkgs[i].NEVRA()] = i
+	}
+	if len(index) != len(pkgs) {
+		return result, fmt.Errorf("metadata contains duplicate NEVRA entries")
+	}
+
+	now := time.Now().UTC()
+	for _, rel := range paths {
+		data, err := r.backend.ReadFile(ctx, rel)
+		if err != nil {
+			return result, fmt.Errorf("read %s: %w", rel, err)
+		}
+		info := rpmFileInfo{name: path.Base(rel), size: int64(len(data)), modTime: now}
+		pkg, err := r.inspectRPM(rel, data, info, rpmAlg, r.locationFor(rel))
+		if err != nil {
+			return result, err
+		}
+		var status string
+		pkgs, status, err = mergePackage(pkgs, index, pkg, replaceExisting)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", rel, err)
+		}
+		result.Packages = append(result.Packages, PackageChange{NEVRA: pkg.NEVRA(), Location: pkg.Location, Status: status, Source: rel})
+	}
+	if dryRun || !result.hasChanges() {
+		return result, nil
+	}
+	revision, err := r.writeMetadata(ctx, md, pkgs, checksumAlg, now)
+	if err != nil {
+		return result, err
+	}
+	result.Revision = revision
+	return result, nil
+}
+
+// indexRPMs inspects every RPM the backend lists, in path order. Later files with an already seen
+// NEVRA are skipped with a warning.
+func (r *Repo) indexRPMs(ctx context.Context, rpmAlg string, now time.Time) ([]metadata.Package, error) {
+	rpmPaths, err := r.listRPMs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list rpms: %w", err)
+	}
+	sort.Strings(rpmPaths)
+
+	pkgs := make([]metadata.Package, 0, len(rpmPaths))
+	seen := make(map[string]string, len(rpmPaths))
+	for _, rel := range rpmPaths {
+		data, err := r.backend.ReadFile(ctx, rel)
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", rel, err)
+		}
+		info := rpmFileInfo{name: path.Base(rel), size: int64(len(data)), modTime: now}
+		pkg, err := r.inspectRPM(rel, data, info, rpmAlg, r.locationFor(rel))
+		if err != nil {
+			return nil, err
+		}
+		key := pkg.NEVRA()
+		if prev, ok := seen[key]; ok {
+			r.logger.Printf("warn: skipping %s: duplicate of %s (%s)", rel, prev, key)
+			continue
+		}
+		seen[key] = rel
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// rpmFileInfo describes an RPM read through a backend, which exposes no stat information.
+type rpmFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i rpmFileInfo) Name() string       { return i.name }
+func (i rpmFileInfo) Size() int64        { return i.size }
+func (i rpmFileInfo) Mode() fs.FileMode  { return 0o644 }
+func (i rpmFileInfo) ModTime() time.Time { return i.modTime }
+func (i rpmFileInfo) IsDir() bool        { return false }
+func (i rpmFileInfo) Sys() interface{}   { return nil }
+
+// withoutCoreData returns md with its primary, filelists, and other entries removed.
+func withoutCoreData(md metadata.RepoMD) metadata.RepoMD {
+	out := md
+	out.Data = nil
+	for _, d := range md.Data {
+		switch d.Type {
+		case "primary", "filelists", "filelists_ext", "other":
+			continue
+		}
+		out.Data = append(out.Data, d)
+	}
+	return out
+}
+
+// RebuildRepomd writes a new repomd.xml for the core metadata files already in the repodata
+// directory, for when repomd.xml is lost or corrupt but the core files are intact. RPMs are not
+// read. Each file's type comes from its name (<checksum>-primary.xml.gz, filelists.xml.bz2, ...);
+// files also listed in repomd.xml.bak are taken as the previous generation when both exist.
+// A checksum-named file must match its name, and the chosen set must parse. checksumAlg applies
+// to plain-named files; empty means sha256. It returns the entries of the new repomd.xml.
+func (r *Repo) RebuildRepomd(ctx context.Context, checksumAlg string, dryRun bool) ([]metadata.RepoData, error) {
+	if r.backend == nil {
+		return nil, fmt.Errorf("backend is required")
+	}
+	if checksumAlg == "" {
+		checksumAlg = "sha256"
+	}
+	if !metadata.SupportedChecksum(checksumAlg) {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", checksumAlg)
+	}
+	repomdPath := r.repomdPath()
+	// Read the current repomd.xml, however broken, so the rewrite is conditional on it.
+	if _, err := r.backend.ReadFile(ctx, repomdPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read %s: %w", repomdPath, err)
+	}
+	var backup metadata.RepoMD
+	previous := make(map[string]bool)
+	if data, err := r.backend.ReadFile(ctx, repomdBackupPath(repomdPath)); err == nil {
+		if md, err := metadata.ParseRepoMD(data); err == nil {
+			backup = md
+			for _, d := range md.Data {
+				previous[d.Location.Href] = true
+			}
+		}
+	}
+
+	files, err := r.backend.ListRepodata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list repodata: %w", err)
+	}
+	candidates := make(map[string][]string)
+	for _, f := range files {
+		if typ := coreFileType(f); typ != "" {
+			candidates[typ] = append(candidates[typ], f)
+		} else if !isRepomdFile(f, repomdPath) {
+			r.logger.Printf("warn: %s is not core metadata and will not be listed in the rebuilt repomd.xml", f)
+		}
+	}
+
+	now := time.Now().UTC()
+	var data []metadata.RepoData
+	uncompressed := make(map[string][]byte)
+	for _, typ := range []string{"primary", "filelists", "other", "filelists_ext"} {
+		hrefs := candidates[typ]
+		if len(hrefs) > 1 {
+			var current []string
+			for _, href := range hrefs {
+				if !previous[href] {
+					current = append(current, href)
+				}
+			}
+			if len(current) > 0 {
+				hrefs = current
+			}
+		}
+		switch {
+		case len(hrefs) == 0 && typ == "primary":
+			return nil, fmt.Errorf("no primary metadata file in %s", r.backend.RepodataDir())
+		case len(hrefs) == 0:
+			continue
+		case len(hrefs) > 1:
+			return nil, fmt.Errorf("cannot tell which %s file is current: %s; remove the stale ones", typ, strings.Join(hrefs, ", "))
+		}
+		href := hrefs[0]
+		alg := checksumAlg
+		switch len(backend.ChecksumPrefix(href)) {
+		case 64:
+			alg = "sha256"
+		case 128:
+			alg = "sha512"
+		}
+		d := metadata.RepoData{Type: typ, Checksum: metadata.Checksum{Type: alg}, Location: metadata.Location{Href: href}}
+		core, err := metadata.ReadCore(ctx, r.backend, d)
+		if err != nil {
+			return nil, fmt.Errorf("core %s: %w", typ, err)
+		}
+		if prefix := backend.ChecksumPrefix(href); prefix != "" && prefix != core.Checksum {
+			return nil, fmt.Errorf("core %s: %s content does not match its filename checksum", typ, href)
+		}
+		d.Checksum.Value = core.Checksum
+		d.OpenChecksum = &metadata.Checksum{Type: alg, Value: core.OpenChecksum}
+		d.Timestamp = now.Unix()
+		d.Size = core.Size
+		d.OpenSize = core.OpenSize
+		data = append(data, d)
+		uncompressed[typ] = core.Uncompressed
+	}
+	if _, err := metadata.ParsePackagesFromXML(uncompressed["primary"], uncompressed["filelists"], uncompressed["other"]); err != nil {
+		return nil, fmt.Errorf("core files do not parse: %w", err)
+	}
+	if dryRun {
+		return data, nil
+	}
+
+	md := metadata.RepoMD{Xmlns: metadata.RepoNamespace, Data: data}
+	if md.Revision, err = r.nextRevision(backup.Revision, now); err != nil {
+		return nil, err
+	}
+	repomdBytes, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		return nil, fmt.Errorf("marshal repomd.xml: %w", err)
+	}
+	if err := r.backend.WriteFile(ctx, repomdPath, repomdBytes); err != nil {
+		return nil, fmt.Errorf("write %s: %w", repomdPath, err)
+	}
+	if signed, err := r.backend.Exists(ctx, repomdPath+".asc"); err == nil && signed {
+		r.logger.Printf("warn: %s.asc no longer matches the rewritten repomd.xml; re-sign it", repomdPath)
+	}
+	return data, nil
+}
+
+// coreFileType returns the core metadata type named by a repodata file such as
+// "<checksum>-primary.xml.gz", "other.xml.bz2" or "filelists-ext.xml.gz", or "" for any other file.
+func coreFileType(href string) string {
+	base := path.Base(href)
+	for _, typ := range []string{"primary", "filelists", "other", "filelists_ext"} {
+		name := strings.ReplaceAll(typ, "_", "-")
+		for _, ext := range []string{".xml.gz", ".xml.bz2"} {
+			if base == name+ext || strings.HasSuffix(base, "-"+name+ext) {
+				return typ
+			}
+		}
+	}
+	return ""
+}
+
+// isRepomdFile reports whether f is repomd.xml itself, its signature, its backup, or a staged upload.
+func isRepomdFile(f, repomdPath string) bool {
+	switch f {
+	case repomdPath, repomdPath + ".asc", repomdBackupPath(repomdPath):
+		return true
+	}
+	return strings.HasPrefix(f, path.Join(path.Dir(repomdPath), ".tmp")+"/")
+}
@@ -0,0 +1,225 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestRepairRepoRebuildsMissingRepomd(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	n, err := r.RepairRepo(ctx, "", false)
+	if err != nil {
+		t.Fatalf("RepairRepo: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("expected 0 packages, got %d", n)
+	}
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if len(pkgs) != 0 || alg != "sha256" {
+		t.Fatalf("unexpected rebuild: %d packages, checksum %s", len(pkgs), alg)
+	}
+	if len(md.Data) != 3 {
+		t.Fatalf("expected 3 core entries, got %d", len(md.Data))
+	}
+}
+
+func TestRepairRepoDropsStalePackagesKeepsExtras(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "gone", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "gone-1.0-1.x86_64.rpm"},
+	})
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+	md.Data = append(md.Data, metadata.RepoData{Type: "updateinfo", Location: metadata.Location{Href: "repodata/x-updateinfo.xml.gz"}})
+	repomdBytes, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	mb.files["repodata/repomd.xml"] = repomdBytes
+	mb.files["repodata/x-updateinfo.xml.gz"] = []byte("updateinfo")
+
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.RepairRepo(ctx, "", false); err != nil {
+		t.Fatalf("RepairRepo: %v", err)
+	}
+	md, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if len(pkgs) != 0 {
+		t.Fatalf("expected stale package dropped, got %d", len(pkgs))
+	}
+	found := false
+	for _, d := range md.Data {
+		if d.Type == "updateinfo" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected updateinfo preserved")
+	}
+	if _, ok := mb.files["repodata/x-updateinfo.xml.gz"]; !ok {
+		t.Fatalf("expected updateinfo file kept by cleanup")
+	}
+}
+
+func TestRebuildRewritesCoreFilesKeepingPackages(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "kept", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "kept-1.0-1.x86_64.rpm"},
+	})
+	before, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if n, err := r.Rebuild(ctx, "sha512", false, "", true); err != nil || n != 1 {
+		t.Fatalf("Rebuild dry run: n=%d err=%v", n, err)
+	}
+	if _, err := r.Rebuild(ctx, "bogus", false, "", false); err == nil {
+		t.Fatalf("expected unsupported checksum error")
+	}
+	n, err := r.Rebuild(ctx, "sha512", false, "", false)
+	if err != nil {
+		t.Fatalf("Rebuild: %v", err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 package, got %d", n)
+	}
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if alg != "sha512" {
+		t.Fatalf("unexpected checksum %s", alg)
+	}
+	for _, d := range md.Data {
+		if d.Checksum.Type != "sha512" {
+			t.Fatalf("%s still uses %s", d.Type, d.Checksum.Type)
+		}
+	}
+	if len(pkgs) != 1 || pkgs[0].PkgID != "a" || pkgs[0].ChecksumType != "sha256" {
+		t.Fatalf("package not preserved: %+v", pkgs)
+	}
+	for _, d := range before.Data {
+		if metadata.FindData(md, d.Type).Location.Href == d.Location.Href {
+			t.Fatalf("%s was not rewritten", d.Type)
+		}
+	}
+}
+
+func TestInitFromExisting(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if n, err := r.InitFromExisting(ctx, "sha512", false, false, "", true); err != nil || n != 0 {
+		t.Fatalf("dry run: %d, %v", n, err)
+	}
+	if _, ok := mb.files["repodata/repomd.xml"]; ok {
+		t.Fatalf("dry run wrote repomd.xml")
+	}
+	if _, err := r.InitFromExisting(ctx, "sha512", false, false, "", false); err != nil {
+		t.Fatalf("InitFromExisting: %v", err)
+	}
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("load repomd: %v", err)
+	}
+	if primary, _, _ := metadata.GetCoreData(md); primary == nil || primary.Checksum.Type != "sha512" {
+		t.Fatalf("expected sha512 primary, got %+v", md.Data)
+	}
+
+	if _, err := r.InitFromExisting(ctx, "sha256", false, false, "", false); err == nil {
+		t.Fatalf("expected existing repomd.xml to be refused without force")
+	}
+	md.Revision = "99999999999"
+	repomdBytes, _ := metadata.MarshalRepoMD(md)
+	mb.files["repodata/repomd.xml"] = repomdBytes
+	if _, err := r.InitFromExisting(ctx, "sha256", true, false, "", false); err != nil {
+		t.Fatalf("InitFromExisting --force: %v", err)
+	}
+	md, _ = metadata.LoadRepoMD(ctx, mb)
+	if md.Revision != "100000000000" {
+		t.Fatalf("expected revision to keep increasing, got %s", md.Revision)
+	}
+}
+
+func TestRebuildRepomd(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.noarch.rpm"},
+		{Name: "bar", Arch: "noarch", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.noarch.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	// A second generation leaves the first one's core files next to it, listed in repomd.xml.bak.
+	if _, err := r.RemoveRPMs(ctx, []string{"bar-1.0-1.noarch"}, true, false, false); err != nil {
+		t.Fatalf("RemoveRPMs: %v", err)
+	}
+	mb.files["repodata/repomd.xml"] = []byte("<repomd><data type=")
+	mb.files["repodata/updateinfo.xml.gz"] = []byte("extra")
+
+	data, err := r.RebuildRepomd(ctx, "", false)
+	if err != nil {
+		t.Fatalf("RebuildRepomd: %v", err)
+	}
+	if len(data) != 3 {
+		t.Fatalf("expected 3 core entries, got %+v", data)
+	}
+	r.cache = nil
+	_, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if len(pkgs) != 1 || pkgs[0].Name != "foo" {
+		t.Fatalf("rebuilt repomd.xml points at the wrong generation: %+v", pkgs)
+	}
+
+	// Without the backup the two generations cannot be told apart.
+	delete(mb.files, "repodata/repomd.xml.bak")
+	if _, err := r.RebuildRepomd(ctx, "", true); err == nil {
+		t.Fatal("expected an error for ambiguous core files")
+	}
+}
+
+func TestRebuildRepomdRejectsMismatchedChecksumName(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	var primary, other string
+	for p := range mb.files {
+		switch coreFileType(p) {
+		case "primary":
+			primary = p
+		case "other":
+			other = p
+		}
+	}
+	mb.files[other] = mb.files[primary]
+	delete(mb.files, "repodata/repomd.xml")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.RebuildRepomd(ctx, "", false); err == nil || !strings.Contains(err.Error(), "does not match its filename checksum") {
+		t.Fatalf("expected a checksum name mismatch, got %v", err)
+	}
+}
@@ -2,16 +2,66 @@ package repo
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/e2llm/rpmrepo-update/pkg/backend"
+	"github.com/e2llm/rpmrepo-update/pkg/inspector"
 	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
+// ErrNotInitialized is returned when repomd.xml does not exist in the repository.
+var ErrNotInitialized = errors.New("repo not initialized; run init first")
+
+// Change statuses reported in PackageChange.Status.
+const (
+	ChangeAdded     = "added"
+	ChangeReplaced  = "replaced"
+	ChangeRemoved   = "removed"
+	ChangeRelocated = "relocated"
+	ChangeSkipped   = "skipped"
+)
+
+// PackageChange describes one package affected by AddRPMs, RemoveRPMs or Relocate.
+type PackageChange struct {
+	NEVRA    string `json:"nevra"`
+	Location string `json:"location"`
+	Status   string `json:"status"`
+	// Source is the local RPM path for adds, the identifier given for removes, or the previous location for relocations.
+	Source string `json:"source"`
+}
+
+// ChangeResult summarizes an add, remove or relocate run.
+type ChangeResult struct {
+	Packages []PackageChange `json:"packages"`
+	// BytesUploaded counts RPM payload bytes written to the backend; metadata is not included.
+	BytesUploaded int64 `json:"bytes_uploaded"`
+	// Revision is the new repomd.xml revision; empty for dry runs.
+	Revision string `json:"revision,omitempty"`
+	DryRun   bool   `json:"dry_run"`
+	// NoarchMirror reports the changes made to Repo.NoarchMirror, if any noarch packages were added.
+	NoarchMirror *ChangeResult `json:"noarch_mirror,omitempty"`
+}
+
+// hasChanges reports whether any package in the result was actually changed rather than skipped.
+func (c ChangeResult) hasChanges() bool {
+	for _, p := range c.Packages {
+		if p.Status != ChangeSkipped {
+			return true
+		}
+	}
+	return false
+}
+
 type Repo struct {
 	backend backend.Backend
 	logger  *log.Logger
@@ -19,6 +69,97 @@ type Repo struct {
 	AllowUnknown bool
 	// DestPrefix sets a destination prefix under the repo root for RPM writes.
 	DestPrefix string
+	// PreserveDirBase, if set, stores each local RPM added at its path relative to this directory
+	// (under DestPrefix) instead of at its base name, keeping a build tree's layout.
+	PreserveDirBase string
+	// VerifyFilename controls how an RPM whose basename disagrees with its header NEVRA is handled on add:
+	// "" or "off" skips the check, "warn" logs a warning, "error" rejects the package.
+	VerifyFilename string
+	// AllowedArches are custom architectures accepted alongside those rpm knows; add and check
+	// warn about packages with any other arch, or none.
+	AllowedArches []string
+	// Compression selects how core metadata is compressed when written: "" or "gzip", or "bzip2".
+	Compression string
+	// MetadataFilenameStyle selects "hashed" (default) or "plain" core metadata filenames.
+	MetadataFilenameStyle string
+	// NoOther omits the other (changelog) core metadata file when writing metadata.
+	NoOther bool
+	// ChangelogLimit keeps only the newest this many changelog entries per package in other.xml
+	// when writing metadata. Zero keeps them all.
+	ChangelogLimit int
+	// FilelistsExt also writes filelists-ext (filelists with per-file digests). Repositories
+	// that already have it keep it regardless.
+	FilelistsExt bool
+	// ArchRepodata also publishes a per-arch repository under <arch>/repodata/ next to the combined
+	// metadata, listing only that arch's packages (plus noarch ones). Once a repo has such subtrees,
+	// later writes keep them up to date without the option.
+	ArchRepodata bool
+	// NoBackup skips copying the previous repomd.xml to repomd.xml.bak before it is replaced.
+	// Without a backup, Rollback has nothing to restore.
+	NoBackup bool
+	// KeepOldMetadata keeps the core files of this many superseded generations, newest by backend
+	// modification time, when old metadata is cleaned up, so clients still downloading an earlier
+	// generation are not broken. Files referenced by repomd.xml and its backup are always kept.
+	KeepOldMetadata int
+	// PrimaryFilePattern overrides which files are listed in primary.xml (see metadata.CoreLayout).
+	PrimaryFilePattern string
+	// OnlyIfNewer makes adds skip a package unless its EVR is strictly greater than every existing
+	// package with the same name and arch. Skipped packages are reported, not treated as errors.
+	OnlyIfNewer bool
+	// Resume makes a re-run of an interrupted add cheap: an RPM whose destination already holds the
+	// same payload is not uploaded again, and a package already indexed with the same pkgid and
+	// location is skipped instead of failing as a duplicate.
+	Resume bool
+	// InitIfMissing lets AddRPMs create the repository metadata when repomd.xml does not exist yet.
+	InitIfMissing bool
+	// LocationPrefix is prepended to package location hrefs written into metadata, for serving RPMs
+	// from a path that differs from the storage key (e.g. behind a CDN). Files are still stored at the
+	// unprefixed path; check and remove strip the prefix to find them.
+	LocationPrefix string
+	// RepodataRevision, if set, is written as the repomd.xml revision instead of the generated one.
+	// See ValidateRevision.
+	RepodataRevision string
+	// ForceRewrite writes metadata even when the regenerated files are identical to the current
+	// ones; by default such a write is skipped, keeping the revision.
+	ForceRewrite bool
+	// Checksum selects the checksum algorithm add and remove write core metadata and repomd.xml
+	// with. "" or ChecksumAuto keeps the existing one and fails if it cannot be detected; "sha256"
+	// or "sha512" migrates the repo to that algorithm.
+	Checksum string
+	// RPMChecksum selects the algorithm for package pkgids ("sha256" or "sha512"). Empty uses the
+	// metadata checksum algorithm. Each package records its own checksum type, so repos may mix them.
+	RPMChecksum string
+	// SignMetadataFiles writes a detached signature (<file>.asc) next to every core metadata file.
+	SignMetadataFiles bool
+	// GPGKey selects the key for signatures made while writing metadata (default: gpg's default key).
+	GPGKey string
+	// GPGHomedir sets GNUPGHOME for gpg and rpmsign subprocesses.
+	GPGHomedir string
+	// GPGPassphraseFile is passed to gpg with loopback pinentry so signing never prompts.
+	GPGPassphraseFile string
+	// NoarchMirror, if set, also receives every noarch RPM added with AddRPMs, for keeping per-arch
+	// repos in sync. The payload and inspection are reused; the mirror's own settings and policies apply.
+	NoarchMirror *Repo
+	// ManagedPaths, if set, limits the RPMs this repo considers its own to those under these
+	// directories (relative to the repo root). check reports orphans and repair/init --from-existing
+	// index RPMs only there, so several repos can share a bucket or directory tree.
+	ManagedPaths []string
+	// TrashPrefix, if set, makes removals with deleteFiles move RPMs under this directory (relative
+	// to the repo root, keeping their paths) instead of deleting them. See EmptyTrash.
+	TrashPrefix string
+	// DedupeStore, if set, stores added RPMs content-addressed as <DedupeStore>/<pkgid>/<filename>
+	// (relative to the repo root) instead of under DestPrefix. An RPM already stored there is not
+	// uploaded again, so several repos sharing the root (e.g. with different repodata directories)
+	// share one copy. check verifies addresses against pkgids and does not report store RPMs as
+	// orphans, and removals never delete them, since other repos may still reference them.
+	DedupeStore string
+	// StdinName is the filename for an RPM added from standard input (path "-" in AddRPMs). Empty
+	// derives name-version-release.arch.rpm from the package header.
+	StdinName string
+
+	// stdin replaces os.Stdin in tests.
+	stdin io.Reader
+	cache *packageCache
 }
 
 func New(backend backend.Backend) *Repo {
@@ -33,41 +174,254 @@ func (r *Repo) WithLogger(w io.Writer) {
 	r.logger = log.New(w, "", 0)
 }
 
-// InitRepo creates an empty repository layout with core metadata files.
-func (r *Repo) InitRepo(ctx context.Context, checksumAlg string, force bool, signRepodata bool, gpgKey string) error {
+// InitPlan describes the files InitRepo would write.
+type InitPlan struct {
+	ChecksumAlg string
+	CoreFiles   []metadata.CoreFile
+	RepomdPath  string
+	// Overwrite reports that an existing repomd.xml would be replaced.
+	Overwrite bool
+
+	repomd []byte
+}
+
+// PlanInit computes the empty core metadata and repomd.xml in memory without writing anything.
+// It fails if repomd.xml already exists and force is false.
+func (r *Repo) PlanInit(ctx context.Context, checksumAlg string, force bool) (InitPlan, error) {
 	if r.backend == nil {
-		return fmt.Errorf("backend is required")
+		return InitPlan{}, fmt.Errorf("backend is required")
 	}
-	exists, err := r.backend.Exists(ctx, "repodata/repomd.xml")
+	repomdPath := r.repomdPath()
+	exists, err := r.backend.Exists(ctx, repomdPath)
 	if err != nil {
-		return err
+		return InitPlan{}, err
 	}
 	if exists && !force {
-		return fmt.Errorf("repodata/repomd.xml already exists (use --force to overwrite)")
+		return InitPlan{}, fmt.Errorf("%s already exists (use --force to overwrite)", repomdPath)
 	}
 
 	now := time.Now().UTC()
-	coreFiles, repomd, err := metadata.BuildEmptyCoreFiles(checksumAlg, now)
+	coreFiles, repomd, err := metadata.BuildEmptyCoreFiles(checksumAlg, r.coreLayout(), now)
 	if err != nil {
-		return err
+		return InitPlan{}, err
+	}
+	var oldRevision string
+	if exists {
+		// Best effort: a corrupt repomd.xml being overwritten has no usable revision.
+		if old, err := metadata.LoadRepoMD(ctx, r.backend); err == nil {
+			oldRevision = old.Revision
+		}
+	}
+	if repomd.Revision, err = r.nextRevision(oldRevision, now); err != nil {
+		return InitPlan{}, err
 	}
 	repomdBytes, err := metadata.MarshalRepoMD(repomd)
 	if err != nil {
-		return err
+		return InitPlan{}, err
 	}
+	return InitPlan{
+		ChecksumAlg: strings.ToLower(checksumAlg),
+		CoreFiles:   coreFiles,
+		RepomdPath:  repomdPath,
+		Overwrite:   exists,
+		repomd:      repomdBytes,
+	}, nil
+}
 
-	for _, file := range coreFiles {
+// InitRepo creates an empty repository layout with core metadata files.
+func (r *Repo) InitRepo(ctx context.Context, checksumAlg string, force bool, signRepodata bool, gpgKey string) error {
+	plan, err := r.PlanInit(ctx, checksumAlg, force)
+	if err != nil {
+		return err
+	}
+	for _, file := range plan.CoreFiles {
 		if err := r.backend.WriteFile(ctx, file.Path, file.Compressed); err != nil {
 			return fmt.Errorf("write %s: %w", file.Path, err)
 		}
 	}
-	if err := r.backend.WriteFile(ctx, "repodata/repomd.xml", repomdBytes); err != nil {
-		return fmt.Errorf("write repodata/repomd.xml: %w", err)
+	if err := r.signCoreFiles(ctx, plan.CoreFiles); err != nil {
+		return err
+	}
+	if err := r.backend.WriteFile(ctx, plan.RepomdPath, plan.repomd); err != nil {
+		return fmt.Errorf("write %s: %w", plan.RepomdPath, err)
 	}
 	if signRepodata {
-		if err := r.signRepomd(ctx, repomdBytes, gpgKey); err != nil {
+		if err := r.signRepomd(ctx, plan.repomd, gpgKey); err != nil {
 			return fmt.Errorf("sign repomd.xml: %w", err)
 		}
 	}
 	return nil
 }
+
+// ValidateRevision checks that rev is usable as a repomd.xml revision override: any single token,
+// such as an integer, a timestamp like 2024-01-15T10:00:00Z, or a git SHA. Empty is allowed.
+func ValidateRevision(rev string) error {
+	if rev == "" {
+		return nil
+	}
+	if strings.IndexFunc(rev, func(c rune) bool { return unicode.IsSpace(c) || unicode.IsControl(c) }) >= 0 {
+		return fmt.Errorf("invalid repodata revision %q: must not contain spaces or control characters", rev)
+	}
+	return nil
+}
+
+// nextRevision returns the revision for a repomd.xml replacing one with revision old. Unless
+// RepodataRevision overrides it, this is the current unix time, bumped past old when the clock
+// has not advanced (or has gone backwards) so clients always see an increasing revision. An old
+// revision that is not an integer was set explicitly and is not compared.
+func (r *Repo) nextRevision(old string, now time.Time) (string, error) {
+	if r.RepodataRevision != "" {
+		if err := ValidateRevision(r.RepodataRevision); err != nil {
+			return "", err
+		}
+		return r.RepodataRevision, nil
+	}
+	next := now.Unix()
+	if prev, err := strconv.ParseInt(old, 10, 64); err == nil && prev >= next {
+		next = prev + 1
+	}
+	return strconv.FormatInt(next, 10), nil
+}
+
+// coreLayout returns the naming and compression settings for core metadata files.
+func (r *Repo) coreLayout() metadata.CoreLayout {
+	return metadata.CoreLayout{
+		Dir:                r.backend.RepodataDir(),
+		Compression:        r.Compression,
+		FilenameStyle:      r.MetadataFilenameStyle,
+		NoOther:            r.NoOther,
+		ChangelogLimit:     r.ChangelogLimit,
+		FilelistsExt:       r.FilelistsExt,
+		PrimaryFilePattern: r.PrimaryFilePattern,
+	}
+}
+
+// rpmChecksumFor returns the pkgid algorithm to use alongside the metadata checksum algorithm.
+func (r *Repo) rpmChecksumFor(metadataAlg string) (string, error) {
+	if r.RPMChecksum == "" {
+		return metadataAlg, nil
+	}
+	alg := strings.ToLower(r.RPMChecksum)
+	if !metadata.SupportedChecksum(alg) {
+		return "", fmt.Errorf("unsupported rpm checksum algorithm %q", r.RPMChecksum)
+	}
+	return alg, nil
+}
+
+// locationFor returns the metadata location href for an RPM stored at storagePath.
+func (r *Repo) locationFor(storagePath string) string {
+	prefix := cleanLocationPrefix(r.LocationPrefix)
+	if prefix == "" {
+		return storagePath
+	}
+	return prefix + "/" + storagePath
+}
+
+// storagePathFor maps a metadata location href back to the backend path. Locations written
+// without the current prefix are returned unchanged.
+func (r *Repo) storagePathFor(location string) string {
+	prefix := cleanLocationPrefix(r.LocationPrefix)
+	if prefix == "" {
+		return location
+	}
+	if rest, ok := strings.CutPrefix(location, prefix+"/"); ok {
+		return rest
+	}
+	return location
+}
+
+func cleanLocationPrefix(prefix string) string {
+	if prefix == "" {
+		return ""
+	}
+	return strings.Trim(path.Clean(prefix), "/")
+}
+
+// ValidateLocationPrefix rejects prefixes that would produce location hrefs outside the repository root.
+func ValidateLocationPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if err := metadata.ValidateHref(prefix); err != nil {
+		return fmt.Errorf("invalid location prefix: %w", err)
+	}
+	return nil
+}
+
+// ValidateDestPrefix rejects destination prefixes that are absolute or escape the repository root.
+func ValidateDestPrefix(prefix string) error {
+	if prefix == "" {
+		return nil
+	}
+	if err := metadata.ValidateHref(prefix); err != nil {
+		return fmt.Errorf("invalid destination prefix: %w", err)
+	}
+	return nil
+}
+
+// checkDestPath rejects a computed storage path that is absolute or escapes the repository root.
+// Its location href would break clients and the missing/orphan checks, and S3 would store it as is.
+func checkDestPath(destRel string) error {
+	if err := metadata.ValidateHref(destRel); err != nil {
+		return fmt.Errorf("invalid destination path: %w", err)
+	}
+	return nil
+}
+
+// repomdPath returns the repomd.xml path for the backend's repodata directory.
+func (r *Repo) repomdPath() string {
+	return metadata.RepomdPath(r.backend.RepodataDir())
+}
+
+// ParseManagedPaths splits a comma-separated list of managed directories, cleaning each entry.
+func ParseManagedPaths(list string) ([]string, error) {
+	var out []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if err := metadata.ValidateHref(p); err != nil {
+			return nil, fmt.Errorf("invalid managed path: %w", err)
+		}
+		out = append(out, strings.Trim(path.Clean(p), "/"))
+	}
+	return out, nil
+}
+
+// ParseArches splits a comma-separated list of architectures, normalizing each as the inspector does.
+func ParseArches(list string) []string {
+	var out []string
+	for _, a := range strings.Split(list, ",") {
+		if a = inspector.NormalizeArch(a); a != "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// listRPMs returns the RPMs the backend lists, restricted to ManagedPaths when set. Trashed RPMs
+// are never included.
+func (r *Repo) listRPMs(ctx context.Context) ([]string, error) {
+	rpms, err := r.backend.ListRPMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := rpms[:0]
+	for _, rel := range rpms {
+		if !r.inTrash(filepath.ToSlash(rel)) && (len(r.ManagedPaths) == 0 || r.isManaged(rel)) {
+			out = append(out, rel)
+		}
+	}
+	return out, nil
+}
+
+func (r *Repo) isManaged(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, dir := range r.ManagedPaths {
+		if dir == "." || dir == "" || strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/e2llm/rpmrepo-update/pkg/backend"
 	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
@@ -27,7 +28,7 @@ func TestRemoveRPMsMetadataOnly(t *testing.T) {
 		},
 	}
 	now := time.Unix(0, 0)
-	core, err := metadata.BuildCoreFilesFromPackages(pkgs, "sha256", now)
+	core, err := metadata.BuildCoreFilesFromPackages(pkgs, "sha256", metadata.CoreLayout{Dir: "repodata"}, now)
 	if err != nil {
 		t.Fatalf("build core: %v", err)
 	}
@@ -43,9 +44,17 @@ func TestRemoveRPMsMetadataOnly(t *testing.T) {
 	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpmdata")
 
 	r := New(mb)
-	if err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64.rpm"}, false, true, false); err != nil {
+	result, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64.rpm"}, false, true, false)
+	if err != nil {
 		t.Fatalf("RemoveRPMs: %v", err)
 	}
+	want := PackageChange{NEVRA: "foo-1.0-1.x86_64", Location: "foo-1.0-1.x86_64.rpm", Status: ChangeRemoved, Source: "foo-1.0-1.x86_64.rpm"}
+	if len(result.Packages) != 1 || result.Packages[0] != want {
+		t.Fatalf("unexpected result packages: %+v", result.Packages)
+	}
+	if result.Revision == "" || result.DryRun {
+		t.Fatalf("unexpected result: %+v", result)
+	}
 	_, pkgsOut, _, err := r.loadPackages(ctx)
 	if err != nil {
 		t.Fatalf("loadPackages: %v", err)
@@ -58,6 +67,108 @@ func TestRemoveRPMsMetadataOnly(t *testing.T) {
 	}
 }
 
+func TestRemoveRPMsToTrash(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "Packages/foo-1.0-1.x86_64.rpm"},
+	})
+	mb.files["Packages/foo-1.0-1.x86_64.rpm"] = []byte("rpmdata")
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.TrashPrefix = DefaultTrashPrefix
+
+	if _, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64.rpm"}, false, true, false); err != nil {
+		t.Fatalf("RemoveRPMs: %v", err)
+	}
+	if _, ok := mb.files["Packages/foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("expected rpm moved out of the repo")
+	}
+	if string(mb.files[".trash/Packages/foo-1.0-1.x86_64.rpm"]) != "rpmdata" {
+		t.Fatalf("expected rpm under .trash, files: %v", mb.files)
+	}
+	// Trashed RPMs are not orphans.
+	if result := r.CheckDetailed(ctx, CheckOptions{}); result.Err != nil || len(result.Orphaned) != 0 {
+		t.Fatalf("check: err=%v orphaned=%v", result.Err, result.Orphaned)
+	}
+
+	planned, err := r.EmptyTrash(ctx, true)
+	if err != nil || len(planned) != 1 || mb.files[".trash/Packages/foo-1.0-1.x86_64.rpm"] == nil {
+		t.Fatalf("dry-run EmptyTrash = %v, %v", planned, err)
+	}
+	if _, err := r.EmptyTrash(ctx, false); err != nil {
+		t.Fatalf("EmptyTrash: %v", err)
+	}
+	if _, ok := mb.files[".trash/Packages/foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("expected trash emptied")
+	}
+
+	if err := ValidateTrashPrefix("repodata/trash", "repodata"); err == nil {
+		t.Fatalf("expected a trash prefix inside repodata to be rejected")
+	}
+}
+
+func TestRollbackRestoresPreviousMetadata(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.Rollback(ctx, false, "", false); err == nil {
+		t.Fatalf("expected rollback without a backup to fail")
+	}
+	removed, err := r.RemoveAll(ctx, false, false)
+	if err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+
+	plan, err := r.Rollback(ctx, false, "", true)
+	if err != nil || plan.ReplacedRevision != removed.Revision || plan.Revision != "" {
+		t.Fatalf("dry run = %+v, %v", plan, err)
+	}
+	result, err := r.Rollback(ctx, false, "", false)
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if result.Revision <= removed.Revision {
+		t.Fatalf("revision %s does not follow the replaced %s", result.Revision, removed.Revision)
+	}
+	if _, pkgs, _, err := r.loadPackages(ctx); err != nil || len(pkgs) != 1 {
+		t.Fatalf("after rollback: %d packages, err %v", len(pkgs), err)
+	}
+	// The replaced metadata became the backup, so the rollback can be undone.
+	if _, err := r.Rollback(ctx, false, "", false); err != nil {
+		t.Fatalf("undo rollback: %v", err)
+	}
+	if _, pkgs, _, err := r.loadPackages(ctx); err != nil || len(pkgs) != 0 {
+		t.Fatalf("after undo: %d packages, err %v", len(pkgs), err)
+	}
+
+	// A backup whose core files are gone is refused.
+	backup, _ := metadata.ParseRepoMD(mb.files["repodata/repomd.xml.bak"])
+	primary, _, _ := metadata.GetCoreData(backup)
+	delete(mb.files, primary.Location.Href)
+	if _, err := r.Rollback(ctx, false, "", false); err == nil {
+		t.Fatalf("expected rollback to a backup with missing files to fail")
+	}
+}
+
+func TestWriteMetadataNoBackup(t *testing.T) {
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.NoBackup = true
+	if _, err := r.RemoveAll(context.Background(), false, false); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	if _, ok := mb.files["repodata/repomd.xml.bak"]; ok {
+		t.Fatalf("backup written despite NoBackup")
+	}
+}
+
 type conflictBackend struct {
 	memBackend
 }
@@ -72,7 +183,7 @@ func TestWriteMetadataConflict(t *testing.T) {
 	pkgs := []metadata.Package{}
 	now := time.Unix(0, 0)
 	md := metadata.RepoMD{}
-	err := (&Repo{backend: cb, logger: newTestLogger(t)}).writeMetadata(ctx, md, pkgs, "sha256", now)
+	_, err := (&Repo{backend: cb, logger: newTestLogger(t)}).writeMetadata(ctx, md, pkgs, "sha256", now)
 	if err == nil {
 		t.Fatalf("expected conflict error")
 	}
@@ -83,3 +194,252 @@ func newTestLogger(t *testing.T) *log.Logger {
 	t.Helper()
 	return log.New(io.Discard, "", 0)
 }
+
+func TestRemoveRPMsByNEVRAEpochForms(t *testing.T) {
+	ctx := context.Background()
+	cases := []struct {
+		id    string
+		epoch int
+	}{
+		{"foo-1.0-1.x86_64", 0},
+		{"foo-0:1.0-1.x86_64", 0},
+		{"foo-3:1.0-1.x86_64", 3},
+	}
+	for _, tc := range cases {
+		mb := newMemBackend()
+		seedRepo(t, mb, []metadata.Package{
+			{Name: "foo", Epoch: tc.epoch, Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		})
+		r := New(mb)
+		r.logger = newTestLogger(t)
+		if _, err := r.RemoveRPMs(ctx, []string{tc.id}, true, false, true); err != nil {
+			t.Errorf("remove %s (epoch %d): %v", tc.id, tc.epoch, err)
+		}
+	}
+
+	// An epoch-less identifier must not match a package with a real epoch.
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Epoch: 3, Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	if _, err := r.RemoveRPMs(ctx, []string{"foo-1.0-1.x86_64"}, true, false, true); err == nil {
+		t.Fatalf("expected epoch-less identifier not to match epoch 3 package")
+	}
+}
+
+func TestRemoveAllKeepsExtraMetadata(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "bar", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "b", Location: "bar-1.0-1.x86_64.rpm"},
+	})
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("load repomd: %v", err)
+	}
+	md.Data = append(md.Data, metadata.RepoData{Type: "group", Location: metadata.Location{Href: "repodata/comps.xml"}})
+	repomdBytes, err := metadata.MarshalRepoMD(md)
+	if err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	mb.files["repodata/repomd.xml"] = repomdBytes
+	mb.files["repodata/comps.xml"] = []byte("<comps/>")
+	mb.files["foo-1.0-1.x86_64.rpm"] = []byte("rpm")
+	mb.files["bar-1.0-1.x86_64.rpm"] = []byte("rpm")
+
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	result, err := r.RemoveAll(ctx, true, true)
+	if err != nil || len(result.Packages) != 2 {
+		t.Fatalf("dry run: %+v, %v", result, err)
+	}
+	if _, ok := mb.files["foo-1.0-1.x86_64.rpm"]; !ok {
+		t.Fatalf("dry run deleted files")
+	}
+
+	if _, err := r.RemoveAll(ctx, true, false); err != nil {
+		t.Fatalf("RemoveAll: %v", err)
+	}
+	md, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if len(pkgs) != 0 {
+		t.Fatalf("expected empty repo, got %d packages", len(pkgs))
+	}
+	var hasGroup bool
+	for _, d := range md.Data {
+		hasGroup = hasGroup || d.Type == "group"
+	}
+	if !hasGroup {
+		t.Fatalf("expected group metadata preserved")
+	}
+	if _, ok := mb.files["foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("expected rpm deleted")
+	}
+}
+
+func TestRemoveMatchingKeepsLatest(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	recent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", TimeBuild: old, ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+		{Name: "foo", Arch: "x86_64", Version: "2.0", Release: "1", TimeBuild: old, ChecksumType: "sha256", PkgID: "b", Location: "foo-2.0-1.x86_64.rpm"},
+		{Name: "bar", Arch: "noarch", Version: "1.0", Release: "1", TimeBuild: old, ChecksumType: "sha256", PkgID: "c", Location: "bar-1.0-1.noarch.rpm"},
+		{Name: "baz", Arch: "noarch", Version: "1.0", Release: "1", TimeBuild: recent, ChecksumType: "sha256", PkgID: "d", Location: "baz-1.0-1.noarch.rpm"},
+	})
+	for _, f := range []string{"foo-1.0-1.x86_64.rpm", "foo-2.0-1.x86_64.rpm", "bar-1.0-1.noarch.rpm", "baz-1.0-1.noarch.rpm"} {
+		mb.files[f] = []byte("rpm")
+	}
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	filter := PackageFilter{BuiltBefore: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	result, err := r.RemoveMatching(ctx, filter, false, true, false)
+	if err != nil {
+		t.Fatalf("RemoveMatching: %v", err)
+	}
+	statuses := make(map[string]string)
+	for _, p := range result.Packages {
+		statuses[p.NEVRA] = p.Status
+	}
+	if len(statuses) != 3 || statuses["foo-1.0-1.x86_64"] != ChangeRemoved ||
+		statuses["foo-2.0-1.x86_64"] != ChangeSkipped || statuses["bar-1.0-1.noarch"] != ChangeSkipped {
+		t.Fatalf("unexpected result: %+v", result.Packages)
+	}
+	if _, ok := mb.files["foo-1.0-1.x86_64.rpm"]; ok {
+		t.Fatalf("expected old foo deleted")
+	}
+	pkgs, err := r.ListPackages(ctx)
+	if err != nil || len(pkgs) != 3 {
+		t.Fatalf("expected 3 packages left, got %d, %v", len(pkgs), err)
+	}
+
+	result, err = r.RemoveMatching(ctx, filter, true, false, false)
+	if err != nil {
+		t.Fatalf("RemoveMatching with removeLatest: %v", err)
+	}
+	if pkgs, _ := r.ListPackages(ctx); len(pkgs) != 1 || pkgs[0].Name != "baz" || len(result.Packages) != 2 {
+		t.Fatalf("expected only baz left, got %+v", pkgs)
+	}
+}
+
+func TestCleanupKeepsCoreSignatures(t *testing.T) {
+	ctx := context.Background()
+	for _, signing := range []bool{true, false} {
+		mb := newMemBackend()
+		seedRepo(t, mb, nil)
+		md, err := metadata.LoadRepoMD(ctx, mb)
+		if err != nil {
+			t.Fatalf("load repomd: %v", err)
+		}
+		primary, _, _ := metadata.GetCoreData(md)
+		sig := primary.Location.Href + ".asc"
+		mb.files[sig] = []byte("sig")
+		mb.files["repodata/old-primary.xml.gz.asc"] = []byte("stale")
+
+		r := New(mb)
+		r.logger = newTestLogger(t)
+		r.SignMetadataFiles = signing
+		if err := r.cleanupOldMetadata(ctx, md); err != nil {
+			t.Fatalf("cleanup: %v", err)
+		}
+		if _, ok := mb.files[sig]; ok != signing {
+			t.Errorf("signing=%v: signature kept = %v", signing, ok)
+		}
+		if _, ok := mb.files["repodata/old-primary.xml.gz.asc"]; ok {
+			t.Errorf("signing=%v: stale signature kept", signing)
+		}
+	}
+}
+
+type modTimeBackend struct {
+	*memBackend
+	modTimes map[string]time.Time
+}
+
+func (m modTimeBackend) ModTime(ctx context.Context, path string) (time.Time, error) {
+	return m.modTimes[path], nil
+}
+
+func TestCleanupKeepsOldMetadataGenerations(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("load repomd: %v", err)
+	}
+	b := modTimeBackend{memBackend: mb, modTimes: make(map[string]time.Time)}
+	for i, gen := range []string{"aaa", "bbb", "ccc"} {
+		for _, name := range []string{"primary", "filelists"} {
+			p := "repodata/" + gen + "-" + name + ".xml.gz"
+			mb.files[p] = []byte(gen)
+			b.modTimes[p] = time.Unix(int64(1000+i), 0)
+		}
+	}
+	mb.files["repodata/stray.txt"] = []byte("stray")
+
+	r := New(b)
+	r.logger = newTestLogger(t)
+	r.KeepOldMetadata = 2
+	if err := r.cleanupOldMetadata(ctx, md); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	for _, d := range md.Data {
+		if _, ok := mb.files[d.Location.Href]; !ok {
+			t.Errorf("current %s deleted", d.Type)
+		}
+	}
+	for _, p := range []string{"repodata/bbb-primary.xml.gz", "repodata/ccc-primary.xml.gz", "repodata/bbb-filelists.xml.gz", "repodata/ccc-filelists.xml.gz"} {
+		if _, ok := mb.files[p]; !ok {
+			t.Errorf("%s deleted, want kept", p)
+		}
+	}
+	for _, p := range []string{"repodata/aaa-primary.xml.gz", "repodata/aaa-filelists.xml.gz", "repodata/stray.txt"} {
+		if _, ok := mb.files[p]; ok {
+			t.Errorf("%s kept, want deleted", p)
+		}
+	}
+
+	// Without modification times nothing extra is kept.
+	r = New(mb)
+	r.logger = newTestLogger(t)
+	r.KeepOldMetadata = 2
+	if err := r.cleanupOldMetadata(ctx, md); err != nil {
+		t.Fatalf("cleanup: %v", err)
+	}
+	if _, ok := mb.files["repodata/ccc-primary.xml.gz"]; ok {
+		t.Errorf("old primary kept without modification times")
+	}
+}
+
+func TestMemBackendFlowDetectsConcurrentUpdate(t *testing.T) {
+	ctx := context.Background()
+	mb := backend.NewMemBackend()
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	if err := r.InitRepo(ctx, "sha256", false, false, ""); err != nil {
+		t.Fatalf("InitRepo: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := r.RepairRepo(ctx, "", false); err != nil {
+			t.Fatalf("RepairRepo #%d: %v", i+1, err)
+		}
+	}
+
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	mb.TouchRepomd()
+	if _, err := r.writeMetadata(ctx, md, pkgs, alg, time.Now()); err == nil {
+		t.Fatal("expected writeMetadata to fail after repomd.xml changed underneath it")
+	}
+}
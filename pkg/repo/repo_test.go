@@ -0,0 +1,274 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestPlanInitWritesNothing(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+
+	plan, err := r.PlanInit(ctx, "SHA512", false)
+	if err != nil {
+		t.Fatalf("PlanInit: %v", err)
+	}
+	if len(mb.files) != 0 {
+		t.Fatalf("expected no writes, got %d files", len(mb.files))
+	}
+	if plan.ChecksumAlg != "sha512" || len(plan.CoreFiles) != 3 || plan.Overwrite {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	if err := r.InitRepo(ctx, "sha256", false, false, ""); err != nil {
+		t.Fatalf("InitRepo: %v", err)
+	}
+	if _, err := r.PlanInit(ctx, "sha256", false); err == nil {
+		t.Fatalf("expected existing repomd.xml to be refused without force")
+	}
+	plan, err = r.PlanInit(ctx, "sha256", true)
+	if err != nil {
+		t.Fatalf("PlanInit with force: %v", err)
+	}
+	if !plan.Overwrite {
+		t.Fatalf("expected plan to report overwrite")
+	}
+}
+
+func TestInitRepoBzip2(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+	r.Compression = "bzip2"
+
+	if err := r.InitRepo(ctx, "sha256", false, false, ""); err != nil {
+		t.Fatalf("InitRepo: %v", err)
+	}
+	var bz2 int
+	for p := range mb.files {
+		if strings.HasSuffix(p, ".xml.bz2") {
+			bz2++
+		}
+	}
+	if bz2 != 3 {
+		t.Fatalf("expected 3 bzip2 core files, got %d", bz2)
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+}
+
+func TestNoOtherRepo(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.NoOther = true
+
+	if err := r.InitRepo(ctx, "sha256", false, false, ""); err != nil {
+		t.Fatalf("InitRepo: %v", err)
+	}
+	md, err := metadata.LoadRepoMD(ctx, mb)
+	if err != nil {
+		t.Fatalf("LoadRepoMD: %v", err)
+	}
+	if _, _, other := metadata.GetCoreData(md); other != nil || len(md.Data) != 2 {
+		t.Fatalf("expected primary and filelists only, got %+v", md.Data)
+	}
+	if err := r.Check(ctx); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+
+	pkgs := []metadata.Package{{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"}}
+	if _, err := r.writeMetadata(ctx, md, pkgs, "sha256", time.Unix(1700000000, 0)); err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	r.cache = nil
+	_, got, _, err := r.loadPackages(ctx)
+	if err != nil || len(got) != 1 {
+		t.Fatalf("loadPackages: %d packages, err %v", len(got), err)
+	}
+}
+
+func TestRPMChecksumIndependentOfMetadata(t *testing.T) {
+	r := New(newMemBackend())
+	if alg, err := r.rpmChecksumFor("sha256"); err != nil || alg != "sha256" {
+		t.Fatalf("default rpm checksum = %q, %v", alg, err)
+	}
+	r.RPMChecksum = "SHA512"
+	if alg, err := r.rpmChecksumFor("sha256"); err != nil || alg != "sha512" {
+		t.Fatalf("rpm checksum = %q, %v", alg, err)
+	}
+	r.RPMChecksum = "md5"
+	if _, err := r.rpmChecksumFor("sha256"); err == nil {
+		t.Fatalf("expected unsupported rpm checksum error")
+	}
+
+	// A sha512 pkgid in sha256 metadata keeps its own type through a rewrite.
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha512", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	r = New(mb)
+	r.logger = newTestLogger(t)
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if _, err := r.writeMetadata(ctx, md, pkgs, alg, time.Unix(1, 0)); err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	_, pkgs, alg, err = r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if alg != "sha256" || pkgs[0].ChecksumType != "sha512" {
+		t.Fatalf("metadata %s, pkgid %s; want sha256 and sha512", alg, pkgs[0].ChecksumType)
+	}
+}
+
+func TestAddPackagesMetadataOnly(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	pkg := metadata.Package{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha512", PkgID: "abc", Location: "Packages/foo-1.0-1.x86_64.rpm"}
+	result, err := r.AddPackages(ctx, []metadata.Package{pkg}, []string{"foo.json"}, false, false)
+	if err != nil {
+		t.Fatalf("AddPackages: %v", err)
+	}
+	if len(result.Packages) != 1 || result.Packages[0].Status != ChangeAdded || result.BytesUploaded != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if _, ok := mb.files[pkg.Location]; ok {
+		t.Fatalf("metadata-only add must not write the RPM")
+	}
+	_, pkgs, _, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	if len(pkgs) != 1 || pkgs[0].PkgID != "abc" || pkgs[0].ChecksumType != "sha512" {
+		t.Fatalf("unexpected packages: %+v", pkgs)
+	}
+
+	if _, err := r.AddPackages(ctx, []metadata.Package{pkg}, []string{"foo.json"}, false, false); err == nil {
+		t.Fatalf("expected duplicate error without replace")
+	}
+	bad := pkg
+	bad.PkgID = ""
+	bad.Location = ""
+	_, err = r.AddPackages(ctx, []metadata.Package{bad}, []string{"bad.json"}, true, false)
+	if err == nil || !strings.Contains(err.Error(), "PkgID, Location") {
+		t.Fatalf("expected missing fields error, got %v", err)
+	}
+}
+
+func TestListPackagesSorted(t *testing.T) {
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "zed", Arch: "noarch", Version: "1", Release: "1", ChecksumType: "sha256", PkgID: "z", Location: "zed-1-1.noarch.rpm"},
+		{Name: "abc", Arch: "noarch", Version: "1", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "abc-1-1.noarch.rpm"},
+	})
+	pkgs, err := New(mb).ListPackages(context.Background())
+	if err != nil {
+		t.Fatalf("ListPackages: %v", err)
+	}
+	if len(pkgs) != 2 || pkgs[0].Name != "abc" || pkgs[1].Name != "zed" {
+		t.Fatalf("unexpected order: %+v", pkgs)
+	}
+}
+
+func TestAddPackagesOnlyIfNewer(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.10", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.10-1.x86_64.rpm"},
+	})
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	r.OnlyIfNewer = true
+
+	pkg := func(version, arch string) metadata.Package {
+		return metadata.Package{Name: "foo", Arch: arch, Version: version, Release: "1", ChecksumType: "sha256", PkgID: version + arch, Location: "foo-" + version + "-1." + arch + ".rpm"}
+	}
+	in := []metadata.Package{pkg("1.9", "x86_64"), pkg("1.10", "x86_64"), pkg("1.11", "x86_64"), pkg("1.0", "aarch64"), pkg("1.10.1~rc1", "x86_64")}
+	result, err := r.AddPackages(ctx, in, []string{"a", "b", "c", "d", "e"}, false, false)
+	if err != nil {
+		t.Fatalf("AddPackages: %v", err)
+	}
+	want := []string{ChangeSkipped, ChangeSkipped, ChangeAdded, ChangeAdded, ChangeSkipped}
+	for i, p := range result.Packages {
+		if p.Status != want[i] {
+			t.Errorf("%s: status %s, want %s", p.NEVRA, p.Status, want[i])
+		}
+	}
+
+	// Nothing newer: metadata is left untouched.
+	before := string(mb.files["repodata/repomd.xml"])
+	if _, err := r.AddPackages(ctx, in[:1], []string{"a"}, false, false); err != nil {
+		t.Fatalf("AddPackages: %v", err)
+	}
+	if string(mb.files["repodata/repomd.xml"]) != before {
+		t.Fatalf("expected no metadata rewrite when every package is skipped")
+	}
+}
+
+func TestRevisionAlwaysIncreases(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, nil)
+	r := New(mb)
+	r.logger = newTestLogger(t)
+	// The package set never changes, so identical writes must be forced.
+	r.ForceRewrite = true
+
+	now := time.Unix(1000, 0)
+	md, pkgs, alg, err := r.loadPackages(ctx)
+	if err != nil {
+		t.Fatalf("loadPackages: %v", err)
+	}
+	first, err := r.writeMetadata(ctx, md, pkgs, alg, now)
+	if err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	md, pkgs, alg, _ = r.loadPackages(ctx)
+	// Same second, then a clock that went backwards.
+	second, err := r.writeMetadata(ctx, md, pkgs, alg, now)
+	if err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	md, pkgs, alg, _ = r.loadPackages(ctx)
+	third, err := r.writeMetadata(ctx, md, pkgs, alg, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("writeMetadata: %v", err)
+	}
+	if first != "1000" || second != "1001" || third != "1002" {
+		t.Fatalf("revisions = %s, %s, %s; want 1000, 1001, 1002", first, second, third)
+	}
+
+	r.RepodataRevision = "42"
+	if rev, err := r.nextRevision("1002", now); err != nil || rev != "42" {
+		t.Fatalf("override = %q, %v", rev, err)
+	}
+	r.RepodataRevision = "2024-01-15T10:00:00Z"
+	if rev, err := r.nextRevision("1002", now); err != nil || rev != "2024-01-15T10:00:00Z" {
+		t.Fatalf("string override = %q, %v", rev, err)
+	}
+	r.RepodataRevision = "v 1"
+	if _, err := r.nextRevision("", now); err == nil {
+		t.Fatalf("expected an override with a space to be rejected")
+	}
+	// Back to generated revisions after a string one.
+	r.RepodataRevision = ""
+	if rev, err := r.nextRevision("2024-01-15T10:00:00Z", now); err != nil || rev != "1000" {
+		t.Fatalf("revision after a string one = %q, %v", rev, err)
+	}
+}
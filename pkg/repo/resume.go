@@ -0,0 +1,48 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// alreadyIndexed reports whether pkgs already lists pkg with the same pkgid and location, as after
+// an earlier run of the same add that got as far as writing metadata.
+func alreadyIndexed(pkgs []metadata.Package, index map[string]int, pkg metadata.Package) bool {
+	idx, ok := index[pkg.NEVRA()]
+	if !ok {
+		return false
+	}
+	cur := pkgs[idx]
+	return cur.PkgID == pkg.PkgID && cur.ChecksumType == pkg.ChecksumType && cur.Location == pkg.Location
+}
+
+// storedCopyMatches reports whether destRel already holds exactly data, as left by an earlier
+// interrupted add. The stored file is hashed as it streams from the backend.
+func (r *Repo) storedCopyMatches(ctx context.Context, destRel string, data []byte) (bool, error) {
+	exists, err := r.backend.Exists(ctx, destRel)
+	if err != nil {
+		return false, fmt.Errorf("exists %s: %w", destRel, err)
+	}
+	if !exists {
+		return false, nil
+	}
+	rc, err := r.backend.Open(ctx, destRel)
+	if err != nil {
+		return false, fmt.Errorf("open %s: %w", destRel, err)
+	}
+	defer rc.Close()
+	stored, n, err := metadata.ComputeChecksumReader(rc, "sha256")
+	if err != nil {
+		return false, fmt.Errorf("checksum %s: %w", destRel, err)
+	}
+	if n != int64(len(data)) {
+		return false, nil
+	}
+	local, err := metadata.ComputeChecksum(data, "sha256")
+	if err != nil {
+		return false, err
+	}
+	return stored == local, nil
+}
@@ -0,0 +1,121 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// RollbackResult describes a Rollback.
+type RollbackResult struct {
+	// RestoredRevision is the revision recorded in the backup that was restored.
+	RestoredRevision string `json:"restored_revision"`
+	// ReplacedRevision is the revision of the repomd.xml that was replaced, if it could be read.
+	ReplacedRevision string `json:"replaced_revision,omitempty"`
+	// Revision is the revision written with the restored metadata; empty for dry runs.
+	Revision string `json:"revision,omitempty"`
+	DryRun   bool   `json:"dry_run"`
+}
+
+// Rollback re-points the repository at the metadata in repomd.xml.bak. Every file the backup lists
+// must still exist and core files must verify, otherwise nothing is written. The restored
+// repomd.xml gets a new revision so clients still see it increase, and unless NoBackup is set the
+// replaced repomd.xml becomes the new backup, so a rollback can itself be undone.
+func (r *Repo) Rollback(ctx context.Context, signRepodata bool, gpgKey string, dryRun bool) (RollbackResult, error) {
+	result := RollbackResult{DryRun: dryRun}
+	if r.backend == nil {
+		return result, fmt.Errorf("backend is required")
+	}
+	repomdPath := r.repomdPath()
+	backupPath := repomdBackupPath(repomdPath)
+	data, err := r.backend.ReadFile(ctx, backupPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return result, fmt.Errorf("no %s to roll back to", backupPath)
+	}
+	if err != nil {
+		return result, fmt.Errorf("read %s: %w", backupPath, err)
+	}
+	backup, err := metadata.ParseRepoMD(data)
+	if err != nil {
+		return result, fmt.Errorf("%s: %w", backupPath, err)
+	}
+	result.RestoredRevision = backup.Revision
+	if err := r.verifyRepoMDFiles(ctx, backup); err != nil {
+		return result, fmt.Errorf("cannot roll back to %s: %w; run repair instead", backupPath, err)
+	}
+
+	current, currentErr := r.backend.ReadFile(ctx, repomdPath)
+	var currentMD metadata.RepoMD
+	if currentErr == nil {
+		if currentMD, currentErr = metadata.ParseRepoMD(current); currentErr == nil {
+			result.ReplacedRevision = currentMD.Revision
+		}
+	}
+	if dryRun {
+		return result, nil
+	}
+	if validator, ok := r.backend.(RepomdValidator); ok {
+		if err := validator.CheckRepomdUnchanged(ctx); err != nil {
+			return result, err
+		}
+	}
+
+	if backup.Revision, err = r.nextRevision(currentMD.Revision, time.Now().UTC()); err != nil {
+		return result, err
+	}
+	repomdBytes, err := metadata.MarshalRepoMD(backup)
+	if err != nil {
+		return result, fmt.Errorf("marshal repomd.xml: %w", err)
+	}
+	// Only a replaced repomd.xml that parsed becomes the backup; a broken one is simply dropped.
+	if !r.NoBackup && currentErr == nil {
+		if err := r.backend.WriteFile(ctx, backupPath, current); err != nil {
+			return result, fmt.Errorf("write %s: %w", backupPath, err)
+		}
+	}
+	if err := r.backend.WriteFile(ctx, repomdPath, repomdBytes); err != nil {
+		return result, fmt.Errorf("write %s: %w", repomdPath, err)
+	}
+	r.cache = nil
+	result.Revision = backup.Revision
+	if signRepodata {
+		if err := r.signRepomd(ctx, repomdBytes, gpgKey); err != nil {
+			return result, fmt.Errorf("sign repomd.xml: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// verifyRepoMDFiles checks that every file md lists exists, and that core files match their checksums.
+func (r *Repo) verifyRepoMDFiles(ctx context.Context, md metadata.RepoMD) error {
+	primary, filelists, other := metadata.GetCoreData(md)
+	if primary == nil {
+		return errors.New("no primary metadata")
+	}
+	for _, d := range []*metadata.RepoData{primary, filelists, other} {
+		if d == nil {
+			continue
+		}
+		if _, err := metadata.ReadAndVerifyCore(ctx, r.backend, *d); err != nil {
+			return fmt.Errorf("core %s: %w", d.Type, err)
+		}
+	}
+	for _, d := range md.Data {
+		switch d.Type {
+		case "primary", "filelists", "other":
+			continue
+		}
+		exists, err := r.backend.Exists(ctx, d.Location.Href)
+		if err != nil {
+			return fmt.Errorf("exists %s: %w", d.Location.Href, err)
+		}
+		if !exists {
+			return fmt.Errorf("%s %s is missing", d.Type, d.Location.Href)
+		}
+	}
+	return nil
+}
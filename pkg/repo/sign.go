@@ -5,26 +5,168 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
-// signRepomd writes a detached ASCII-armored signature for repomd.xml as repodata/repomd.xml.asc.
+// signRepomd writes a detached ASCII-armored signature for repomd.xml as repomd.xml.asc next to it.
 func (r *Repo) signRepomd(ctx context.Context, repomd []byte, gpgKey string) error {
+	return r.signFile(ctx, r.repomdPath(), repomd, gpgKey)
+}
+
+// signFile writes a detached ASCII-armored signature for data as path+".asc".
+func (r *Repo) signFile(ctx context.Context, path string, data []byte, gpgKey string) error {
+	sig, err := r.detachSign(ctx, data, gpgKey)
+	if err != nil {
+		return err
+	}
+	return r.backend.WriteFile(ctx, path+".asc", sig)
+}
+
+// detachSign returns a detached ASCII-armored gpg signature for data.
+func (r *Repo) detachSign(ctx context.Context, data []byte, gpgKey string) ([]byte, error) {
 	cmd := exec.CommandContext(ctx, "gpg", "--detach-sign", "--armor", "--batch", "--yes")
+	cmd.Args = append(cmd.Args, r.gpgPassphraseArgs()...)
 	if gpgKey != "" {
 		cmd.Args = append(cmd.Args, "--local-user", gpgKey)
 	}
 	cmd.Args = append(cmd.Args, "-o", "-")
-	cmd.Stdin = bytes.NewReader(repomd)
+	cmd.Env = r.gpgEnv()
+	cmd.Stdin = bytes.NewReader(data)
 	out, err := cmd.Output()
 	if err != nil {
 		// capture stderr if available
 		var ee *exec.ExitError
 		if errors.As(err, &ee) {
-			return fmt.Errorf("gpg sign failed: %s", strings.TrimSpace(string(ee.Stderr)))
+			return nil, fmt.Errorf("gpg sign failed: %s", strings.TrimSpace(string(ee.Stderr)))
+		}
+		return nil, fmt.Errorf("gpg sign failed: %w", err)
+	}
+	return out, nil
+}
+
+// signCoreFiles writes a detached signature next to each core metadata file when
+// SignMetadataFiles is set. repomd.xml remains the trust anchor, so nothing is added to it.
+func (r *Repo) signCoreFiles(ctx context.Context, files []metadata.CoreFile) error {
+	if !r.SignMetadataFiles {
+		return nil
+	}
+	for _, cf := range files {
+		if err := r.signFile(ctx, cf.Path, cf.Compressed, r.GPGKey); err != nil {
+			return fmt.Errorf("sign %s: %w", cf.Path, err)
+		}
+	}
+	return nil
+}
+
+// ErrGPGNotFound and ErrRPMSignNotFound report that a signing tool is not on PATH.
+var (
+	ErrGPGNotFound     = errors.New("gpg not found")
+	ErrRPMSignNotFound = errors.New("rpmsign not found")
+)
+
+// CheckSigningTools verifies, before anything is written, that signing can run: gpg (and rpmsign
+// when rpmsign is set) must be on PATH and the secret key must resolve, either gpgKey or, when
+// it is empty, any key gpg would pick by default. homedir is used as GNUPGHOME when set.
+func CheckSigningTools(ctx context.Context, gpgKey, homedir string, rpmsign bool) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return ErrGPGNotFound
+	}
+	if rpmsign {
+		if _, err := exec.LookPath("rpmsign"); err != nil {
+			return ErrRPMSignNotFound
 		}
-		return fmt.Errorf("gpg sign failed: %w", err)
 	}
-	return r.backend.WriteFile(ctx, "repodata/repomd.xml.asc", out)
+	cmd := exec.CommandContext(ctx, "gpg", "--batch", "--with-colons", "--list-secret-keys")
+	if gpgKey != "" {
+		cmd.Args = append(cmd.Args, "--", gpgKey)
+	}
+	cmd.Env = (&Repo{GPGHomedir: homedir}).gpgEnv()
+	out, err := cmd.Output()
+	if err != nil || !bytes.Contains(out, []byte("sec:")) {
+		if gpgKey != "" {
+			return fmt.Errorf("gpg secret key %q not found", gpgKey)
+		}
+		return errors.New("no gpg secret key available")
+	}
+	return nil
+}
+
+// badSignatureError reports a signature gpg rejected, as opposed to a failure to run gpg.
+type badSignatureError struct {
+	detail string
+}
+
+func (e *badSignatureError) Error() string {
+	return "bad signature: " + e.detail
+}
+
+// verifyDetached checks an armored detached signature over data. With a keyring file, its keys
+// are imported into a throwaway gpg home so the user's keyring is neither used nor modified.
+func (r *Repo) verifyDetached(ctx context.Context, data, sig []byte, keyring string) error {
+	dir, err := os.MkdirTemp("", "rpmrepo-verify-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	dataPath := filepath.Join(dir, "data")
+	sigPath := filepath.Join(dir, "data.asc")
+	if err := os.WriteFile(dataPath, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(sigPath, sig, 0o600); err != nil {
+		return err
+	}
+
+	env := r.gpgEnv()
+	if keyring != "" {
+		home := filepath.Join(dir, "gnupg")
+		if err := os.Mkdir(home, 0o700); err != nil {
+			return err
+		}
+		env = append(os.Environ(), "GNUPGHOME="+home)
+		imp := exec.CommandContext(ctx, "gpg", "--batch", "--import", keyring)
+		imp.Env = env
+		if out, err := imp.CombinedOutput(); err != nil {
+			return fmt.Errorf("import keyring %s: %s", keyring, strings.TrimSpace(string(out)))
+		}
+	}
+	cmd := exec.CommandContext(ctx, "gpg", "--batch", "--verify", sigPath, dataPath)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			return &badSignatureError{detail: lastLine(string(out))}
+		}
+		return fmt.Errorf("gpg verify failed: %w", err)
+	}
+	return nil
+}
+
+// lastLine returns the last non-empty line of s, where gpg puts its verdict.
+func lastLine(s string) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// gpgPassphraseArgs returns gpg options for non-interactive passphrase entry, if configured.
+// Only the file path is passed; the passphrase itself never appears in arguments or logs.
+func (r *Repo) gpgPassphraseArgs() []string {
+	if r.GPGPassphraseFile == "" {
+		return nil
+	}
+	return []string{"--pinentry-mode", "loopback", "--passphrase-file", r.GPGPassphraseFile}
+}
+
+// gpgEnv returns the environment for signing subprocesses, or nil to inherit it unchanged.
+func (r *Repo) gpgEnv() []string {
+	if r.GPGHomedir == "" {
+		return nil
+	}
+	return append(os.Environ(), "GNUPGHOME="+r.GPGHomedir)
 }
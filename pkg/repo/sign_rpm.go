@@ -27,7 +27,15 @@ func (r *Repo) signRPM(ctx context.Context, rpmData []byte, gpgKey string) ([]by
 	if gpgKey != "" {
 		cmd.Args = append(cmd.Args, "--define", fmt.Sprintf("_gpg_name %s", gpgKey))
 	}
+	if r.GPGHomedir != "" {
+		// rpm passes %_gpg_path to gpg as --homedir, which would override GNUPGHOME.
+		cmd.Args = append(cmd.Args, "--define", fmt.Sprintf("_gpg_path %s", r.GPGHomedir))
+	}
+	if extra := r.gpgPassphraseArgs(); extra != nil {
+		cmd.Args = append(cmd.Args, "--define", "_gpg_sign_cmd_extra_args "+strings.Join(extra, " "))
+	}
 	cmd.Args = append(cmd.Args, tmpPath)
+	cmd.Env = r.gpgEnv()
 	out, err := cmd.CombinedOutput()
 	if err != nil {
 		return nil, fmt.Errorf("rpmsign failed: %s", strings.TrimSpace(string(out)))
@@ -0,0 +1,77 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestGPGSubprocessSettings(t *testing.T) {
+	r := New(newMemBackend())
+	if args := r.gpgPassphraseArgs(); args != nil {
+		t.Fatalf("expected no passphrase args, got %v", args)
+	}
+	if env := r.gpgEnv(); env != nil {
+		t.Fatalf("expected inherited environment")
+	}
+
+	r.GPGHomedir = "/tmp/gnupg"
+	r.GPGPassphraseFile = "/run/secrets/pass"
+	args := strings.Join(r.gpgPassphraseArgs(), " ")
+	if args != "--pinentry-mode loopback --passphrase-file /run/secrets/pass" {
+		t.Fatalf("unexpected passphrase args %q", args)
+	}
+	env := r.gpgEnv()
+	if len(env) == 0 || env[len(env)-1] != "GNUPGHOME=/tmp/gnupg" {
+		t.Fatalf("expected GNUPGHOME in env, got %v", env)
+	}
+}
+
+func TestCheckRepomdSignature(t *testing.T) {
+	ctx := context.Background()
+	r := newCheckFixture(t)
+	mb := r.backend.(*memBackend)
+
+	result := r.CheckDetailed(ctx, CheckOptions{RepomdSignature: true})
+	if result.Signature == nil || result.Signature.Status != SignatureMissing {
+		t.Fatalf("expected missing signature, got %+v", result.Signature)
+	}
+	if result.Failure() == nil {
+		t.Fatalf("expected missing signature to fail the check")
+	}
+
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg not installed")
+	}
+	home := t.TempDir()
+	gen := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "test@example.com", "default", "default", "never")
+	gen.Env = append(os.Environ(), "GNUPGHOME="+home)
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Skipf("gpg key generation unavailable: %s", out)
+	}
+	r.GPGHomedir = home
+	if err := r.signRepomd(ctx, mb.files["repodata/repomd.xml"], ""); err != nil {
+		t.Fatalf("signRepomd: %v", err)
+	}
+	result = r.CheckDetailed(ctx, CheckOptions{RepomdSignature: true})
+	if result.Err != nil || result.Signature.Status != SignatureValid {
+		t.Fatalf("expected valid signature, got %+v, %v", result.Signature, result.Err)
+	}
+
+	mb.files["repodata/repomd.xml"] = append(mb.files["repodata/repomd.xml"], ' ')
+	result = r.CheckDetailed(ctx, CheckOptions{RepomdSignature: true})
+	if result.Err != nil || result.Signature.Status != SignatureInvalid || result.Signature.Detail == "" {
+		t.Fatalf("expected invalid signature, got %+v, %v", result.Signature, result.Err)
+	}
+}
+
+func TestCheckSigningToolsMissingGPG(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+	err := CheckSigningTools(context.Background(), "", "", false)
+	if !errors.Is(err, ErrGPGNotFound) {
+		t.Fatalf("expected ErrGPGNotFound, got %v", err)
+	}
+}
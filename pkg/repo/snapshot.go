@@ -0,0 +1,116 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// SnapshotsDir is the directory, next to the one the main repodata lives in, that holds snapshots.
+const SnapshotsDir = "snapshots"
+
+// SnapshotResult describes a snapshot written (or, for a dry run, planned) by Snapshot.
+type SnapshotResult struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Revision string `json:"revision"`
+	Packages int    `json:"packages"`
+	DryRun   bool   `json:"dry_run,omitempty"`
+}
+
+// ValidateSnapshotName checks that name is usable as a single directory under SnapshotsDir.
+func ValidateSnapshotName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("invalid snapshot name %q: must be a plain directory name", name)
+	}
+	return nil
+}
+
+// Snapshot publishes the current package set as a separate, point-in-time repository under
+// snapshots/<name>/, for clients that pin to a known state. Its core metadata is regenerated with
+// location hrefs pointing back at the RPMs with "../", so no RPM is copied; other metadata (comps,
+// updateinfo, ...) is copied next to it. The snapshot keeps the current revision and is never
+// updated: an existing snapshot of the same name is an error.
+func (r *Repo) Snapshot(ctx context.Context, name string, signRepodata bool, gpgKey string, dryRun bool) (SnapshotResult, error) {
+	if err := ValidateSnapshotName(name); err != nil {
+		return SnapshotResult{}, err
+	}
+	root := path.Dir(r.backend.RepodataDir())
+	base := path.Join(root, SnapshotsDir, name)
+	repodataDir := path.Join(base, "repodata")
+	repomdPath := metadata.RepomdPath(repodataDir)
+	exists, err := r.backend.Exists(ctx, repomdPath)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	if exists {
+		return SnapshotResult{}, fmt.Errorf("snapshot %s already exists", name)
+	}
+
+	md, pkgs, checksumAlg, err := r.loadPackages(ctx)
+	if err != nil {
+		return SnapshotResult{}, err
+	}
+	result := SnapshotResult{Name: name, Path: base, Revision: md.Revision, Packages: len(pkgs), DryRun: dryRun}
+	if dryRun {
+		return result, nil
+	}
+
+	up := strings.Repeat("../", strings.Count(path.Join(SnapshotsDir, name), "/")+1)
+	sub := make([]metadata.Package, len(pkgs))
+	for i, p := range pkgs {
+		p.Location = up + p.Location
+		sub[i] = p
+	}
+	layout := r.layoutFor(md)
+	layout.Dir = repodataDir
+	now := time.Now().UTC()
+	coreFiles, err := metadata.BuildCoreFilesFromPackages(sub, normalizeChecksum(checksumAlg), layout, now)
+	if err != nil {
+		return SnapshotResult{}, fmt.Errorf("build core metadata: %w", err)
+	}
+	for _, cf := range coreFiles {
+		if err := r.backend.WriteFile(ctx, cf.Path, cf.Compressed); err != nil {
+			return SnapshotResult{}, fmt.Errorf("write %s: %w", cf.Path, err)
+		}
+	}
+	if err := r.signCoreFiles(ctx, coreFiles); err != nil {
+		return SnapshotResult{}, err
+	}
+	// Hrefs in the snapshot's repomd.xml are relative to the snapshot, like the package locations.
+	coreTypes := make(map[string]struct{}, len(coreFiles))
+	for i := range coreFiles {
+		coreFiles[i].Path = strings.TrimPrefix(coreFiles[i].Path, base+"/")
+		coreTypes[coreFiles[i].Type] = struct{}{}
+	}
+	snapMD, _ := assembleRepoMD(md, coreFiles, normalizeChecksum(checksumAlg), now, true)
+	snapMD.Revision = md.Revision
+	for i, d := range snapMD.Data {
+		if _, ok := coreTypes[d.Type]; ok {
+			continue
+		}
+		rel := strings.TrimPrefix(d.Location.Href, root+"/")
+		dst := path.Join(base, rel)
+		if err := r.backend.Copy(ctx, d.Location.Href, dst); err != nil {
+			return SnapshotResult{}, fmt.Errorf("copy %s: %w", d.Location.Href, err)
+		}
+		snapMD.Data[i].Location.Href = rel
+	}
+	repomd, err := metadata.MarshalRepoMD(snapMD)
+	if err != nil {
+		return SnapshotResult{}, fmt.Errorf("marshal repomd.xml: %w", err)
+	}
+	if err := r.backend.WriteFile(ctx, repomdPath, repomd); err != nil {
+		return SnapshotResult{}, fmt.Errorf("write %s: %w", repomdPath, err)
+	}
+	if signRepodata {
+		if err := r.signFile(ctx, repomdPath, repomd, gpgKey); err != nil {
+			return SnapshotResult{}, fmt.Errorf("sign repomd.xml: %w", err)
+		}
+	}
+	return result, nil
+}
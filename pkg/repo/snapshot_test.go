@@ -0,0 +1,64 @@
+package repo
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	mb := newMemBackend()
+	seedRepo(t, mb, []metadata.Package{
+		{Name: "foo", Arch: "x86_64", Version: "1.0", Release: "1", ChecksumType: "sha256", PkgID: "a", Location: "foo-1.0-1.x86_64.rpm"},
+	})
+	md, err := metadata.ParseRepoMD(mb.files["repodata/repomd.xml"])
+	if err != nil {
+		t.Fatalf("parse repomd: %v", err)
+	}
+	md.Data = append(md.Data, metadata.RepoData{Type: "group", Location: metadata.Location{Href: "repodata/comps.xml"}})
+	if mb.files["repodata/repomd.xml"], err = metadata.MarshalRepoMD(md); err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	mb.files["repodata/comps.xml"] = []byte("<comps/>")
+	repomd := string(mb.files["repodata/repomd.xml"])
+	r := New(mb)
+	r.logger = newTestLogger(t)
+
+	result, err := r.Snapshot(ctx, "2024-01-15", false, "", false)
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if result.Path != "snapshots/2024-01-15" || result.Packages != 1 || result.Revision != md.Revision {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	snapMD, err := metadata.ParseRepoMD(mb.files["snapshots/2024-01-15/repodata/repomd.xml"])
+	if err != nil {
+		t.Fatalf("snapshot repomd.xml: %v", err)
+	}
+	primary, _, _ := metadata.GetCoreData(snapMD)
+	d := *primary
+	d.Location.Href = "snapshots/2024-01-15/" + d.Location.Href
+	core, err := metadata.ReadAndVerifyCore(ctx, mb, d)
+	if err != nil {
+		t.Fatalf("snapshot primary: %v", err)
+	}
+	if !strings.Contains(string(core.Uncompressed), `href="../../foo-1.0-1.x86_64.rpm"`) {
+		t.Fatalf("snapshot primary does not reference the stored RPM:\n%s", core.Uncompressed)
+	}
+	if group := metadata.FindData(snapMD, "group"); group == nil || string(mb.files["snapshots/2024-01-15/"+group.Location.Href]) != "<comps/>" {
+		t.Fatalf("comps not carried into the snapshot: %+v", group)
+	}
+	if string(mb.files["repodata/repomd.xml"]) != repomd {
+		t.Fatalf("snapshot modified the main repomd.xml")
+	}
+
+	if _, err := r.Snapshot(ctx, "2024-01-15", false, "", false); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("expected an existing snapshot to be refused, got %v", err)
+	}
+	if _, err := r.Snapshot(ctx, "../x", false, "", false); err == nil {
+		t.Fatalf("expected an invalid name to be refused")
+	}
+}
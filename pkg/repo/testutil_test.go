@@ -1,9 +1,17 @@
 package repo
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"io"
 	"os"
 	"strings"
+	"testing"
+	"time"
+
+	"github.com/e2llm/rpmrepo-update/pkg/backend"
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
 )
 
 // memBackend is a simple in-memory backend for tests.
@@ -44,6 +52,18 @@ func (m *memBackend) DeleteFile(ctx context.Context, path string) error {
 	return nil
 }
 
+func (m *memBackend) Copy(ctx context.Context, srcPath, dstPath string) error {
+	return backend.ReadWriteCopy(ctx, m, srcPath, dstPath)
+}
+
+func (m *memBackend) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return backend.OpenReadFile(ctx, m, path)
+}
+
+func (m *memBackend) ReadRange(ctx context.Context, path string, offset, length int64) ([]byte, error) {
+	return backend.ReadFileRange(ctx, m, path, offset, length)
+}
+
 func (m *memBackend) Exists(ctx context.Context, path string) (bool, error) {
 	_, ok := m.files[path]
 	return ok, nil
@@ -63,3 +83,58 @@ func (m *memBackend) ListRPMs(ctx context.Context) ([]string, error) {
 }
 
 func (m *memBackend) RepoRoot() string { return "mem" }
+
+func (m *memBackend) RepodataDir() string { return "repodata" }
+
+// seedRepo writes core metadata and repomd.xml describing pkgs into the backend.
+func seedRepo(t *testing.T, m *memBackend, pkgs []metadata.Package) {
+	t.Helper()
+	now := time.Unix(0, 0)
+	core, err := metadata.BuildCoreFilesFromPackages(pkgs, "sha256", metadata.CoreLayout{Dir: "repodata"}, now)
+	if err != nil {
+		t.Fatalf("build core: %v", err)
+	}
+	repomdBytes, err := metadata.MarshalRepoMD(metadata.UpdateRepoMDWithCore(metadata.RepoMD{}, core, "sha256", now))
+	if err != nil {
+		t.Fatalf("marshal repomd: %v", err)
+	}
+	for _, cf := range core {
+		m.files[cf.Path] = cf.Compressed
+	}
+	m.files["repodata/repomd.xml"] = repomdBytes
+}
+
+// minimalRPM assembles the smallest RPM that InspectRPM accepts: a v3 lead, an empty signature
+// header, and a main header with only name, version, release, and arch (as in the inspector tests).
+func minimalRPM(name, version, release, arch string) []byte {
+	var buf bytes.Buffer
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xED, 0xAB, 0xEE, 0xDB, 3, 0})
+	buf.Write(lead)
+	writeHeader := func(values []string) {
+		var index, store bytes.Buffer
+		for i, v := range values {
+			entry := make([]byte, 16)
+			binary.BigEndian.PutUint32(entry[0:], uint32(1000+i)) // NAME, VERSION, RELEASE
+			if i == 3 {
+				binary.BigEndian.PutUint32(entry[0:], 1022) // ARCH
+			}
+			binary.BigEndian.PutUint32(entry[4:], 6) // string
+			binary.BigEndian.PutUint32(entry[8:], uint32(store.Len()))
+			binary.BigEndian.PutUint32(entry[12:], 1)
+			index.Write(entry)
+			store.WriteString(v)
+			store.WriteByte(0)
+		}
+		intro := make([]byte, 16)
+		copy(intro, []byte{0x8E, 0xAD, 0xE8, 0x01})
+		binary.BigEndian.PutUint32(intro[8:], uint32(len(values)))
+		binary.BigEndian.PutUint32(intro[12:], uint32(store.Len()))
+		buf.Write(intro)
+		buf.Write(index.Bytes())
+		buf.Write(store.Bytes())
+	}
+	writeHeader(nil)
+	writeHeader([]string{name, version, release, arch})
+	return buf.Bytes()
+}
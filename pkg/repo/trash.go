@@ -0,0 +1,98 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/e2llm/rpmrepo-update/pkg/metadata"
+)
+
+// DefaultTrashPrefix is the conventional trash directory. RPMs under it are never treated as part
+// of the repository, whether or not TrashPrefix is set.
+const DefaultTrashPrefix = ".trash"
+
+// ValidateTrashPrefix rejects trash directories outside the repository root or inside repodata.
+func ValidateTrashPrefix(prefix, repodataDir string) error {
+	if prefix == "" {
+		return nil
+	}
+	if err := metadata.ValidateHref(prefix); err != nil {
+		return fmt.Errorf("invalid trash prefix: %w", err)
+	}
+	clean := cleanLocationPrefix(prefix)
+	if clean == "" || clean == "." {
+		return fmt.Errorf("invalid trash prefix %q: must be a directory below the repo root", prefix)
+	}
+	if clean == repodataDir || strings.HasPrefix(clean, repodataDir+"/") {
+		return fmt.Errorf("invalid trash prefix %q: inside %s", prefix, repodataDir)
+	}
+	return nil
+}
+
+// deleteRPM removes an RPM file, or moves it under TrashPrefix (keeping its path) when set. RPMs in
+// the dedupe store are left alone.
+func (r *Repo) deleteRPM(ctx context.Context, storagePath string) error {
+	if r.inDedupeStore(storagePath) {
+		r.logger.Printf("info: keeping %s: content-addressed RPMs may be shared with other repos", storagePath)
+		return nil
+	}
+	if r.TrashPrefix == "" {
+		if err := r.backend.DeleteFile(ctx, storagePath); err != nil {
+			return fmt.Errorf("delete %s: %w", storagePath, err)
+		}
+		return nil
+	}
+	dst := path.Join(cleanLocationPrefix(r.TrashPrefix), storagePath)
+	if err := r.backend.Copy(ctx, storagePath, dst); err != nil {
+		return fmt.Errorf("trash %s: copy to %s: %w", storagePath, dst, err)
+	}
+	if err := r.backend.DeleteFile(ctx, storagePath); err != nil {
+		return fmt.Errorf("trash %s: delete original: %w", storagePath, err)
+	}
+	return nil
+}
+
+// inTrash reports whether rel lies under the default trash directory or TrashPrefix.
+func (r *Repo) inTrash(rel string) bool {
+	for _, dir := range []string{DefaultTrashPrefix, cleanLocationPrefix(r.TrashPrefix)} {
+		if dir != "" && strings.HasPrefix(rel, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// EmptyTrash permanently deletes the RPMs under TrashPrefix (DefaultTrashPrefix if unset) and
+// returns their paths. With dryRun, nothing is deleted.
+func (r *Repo) EmptyTrash(ctx context.Context, dryRun bool) ([]string, error) {
+	if r.backend == nil {
+		return nil, fmt.Errorf("backend is required")
+	}
+	dir := cleanLocationPrefix(r.TrashPrefix)
+	if dir == "" {
+		dir = DefaultTrashPrefix
+	}
+	rpms, err := r.backend.ListRPMs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list rpms: %w", err)
+	}
+	var trashed []string
+	for _, rel := range rpms {
+		if strings.HasPrefix(rel, dir+"/") {
+			trashed = append(trashed, rel)
+		}
+	}
+	sort.Strings(trashed)
+	if dryRun {
+		return trashed, nil
+	}
+	for i, rel := range trashed {
+		if err := r.backend.DeleteFile(ctx, rel); err != nil {
+			return trashed[:i], fmt.Errorf("delete %s: %w", rel, err)
+		}
+	}
+	return trashed, nil
+}